@@ -3,6 +3,7 @@
 package appenv
 
 import (
+	"os"
 	"sync"
 
 	"github.com/gorilla/sessions"
@@ -30,20 +31,36 @@ type AppEnv struct {
 	HostEnv int
 }
 
-// Config defines a structure to store global application settings.
+// Config defines a structure to store global application settings. The blog
+// name and base domain name are those of the default blog. Each request is
+// served with the configuration of its own blog, from GetEnvContext.
 type Config struct {
 	BlogName       string `envae:"blog_name"`
 	BaseDomainName string `envae:"base_domain_name"`
 
-	PostsPerPage      int `envae:"posts_per_page"`
-	FeedSize          int `envae:"feed_size"`
-	ExcerptCharLength int `envae:"excerpt_char_length"`
+	PostsPerPage      int `envae:"posts_per_page,default=5,min=1,max=100"`
+	FeedSize          int `envae:"feed_size,default=50,min=1,max=500"`
+	ExcerptCharLength int `envae:"excerpt_char_length,default=500,min=1,max=10000"`
+
+	// Post versions are pruned to the most recent VersionsKeepLast, every
+	// version from the last VersionsDailyAfterDays days, and one a day
+	// before that.
+	VersionsKeepLast       int `envae:"versions_keep_last,default=20,min=1,max=1000"`
+	VersionsDailyAfterDays int `envae:"versions_daily_after_days,default=30,min=1,max=3650"`
+
+	// Deleted posts, categories and images are kept in the trash for
+	// TrashDays days before they are purged.
+	TrashDays int `envae:"trash_days,default=30,min=1,max=3650"`
 
 	DateFormatForEditing string `envae:"date_format_for_editing"`
 	DateFormatShort      string `envae:"date_format_short"`
 	DateFormatFull       string `envae:"date_format_full"`
 
-	SessionStoreKey string `envae:"session_store_key"`
+	SessionStoreKey string `envae:"session_store_key,min=16,secret"`
+
+	AkismetKey         string   `envae:"akismet_key,optional,secret"`
+	SpamBlockedTerms   []string `envae:"spam_blocked_terms,optional"`
+	SpamBlockedDomains []string `envae:"spam_blocked_domains,optional"`
 
 	Template view.Template
 }
@@ -54,8 +71,28 @@ const (
 	EnvLive = iota
 )
 
-// Init creates the application settings struct.
+// configFileEnv names the environment variable which may hold the path of a
+// YAML, TOML or JSON configuration file, for running outside App Engine.
+// Environment variables take precedence over values in the file.
+const configFileEnv = "GOBLOGENGINE_CONFIG"
+
+// Init creates the application settings struct from the App Engine
+// environment, and the configuration file named by GOBLOGENGINE_CONFIG if set.
 func Init() error {
+	src := envae.Env()
+	if path := os.Getenv(configFileEnv); path != "" {
+		file, err := envae.File(path)
+		if err != nil {
+			return err
+		}
+		src = envae.Layered(src, file)
+	}
+	return InitFrom(src)
+}
+
+// InitFrom creates the application settings struct from the configuration
+// values in src.
+func InitFrom(src envae.Source) error {
 	var e AppEnv
 
 	if appengine.IsDevAppServer() {
@@ -66,12 +103,13 @@ func Init() error {
 
 	// Read the config from AppEngine settings and configure the view engine
 	// with default templates
-	err := envae.Populate(&e)
+	err := envae.PopulateFrom(&e, src)
 	if err != nil {
 		return err
 	}
 	e.View.SetTemplates(e.Config.Template.Root, e.Config.Template.Children)
 	e.View.SetDateFormat(e.Config.DateFormatFull)
+	e.View.SetBlogName(e.Config.BlogName)
 
 	e.FormDecoder = schema.NewDecoder()
 	e.FormDecoder.IgnoreUnknownKeys(true)
@@ -87,6 +125,15 @@ func setEnv(e *AppEnv) {
 	envMutex.Lock()
 	defer envMutex.Unlock()
 	env = *e
+	defaults = e.Config
+}
+
+// SetViewModifiers safely sets the functions which modify every view before
+// it is rendered.
+func SetViewModifiers(fn ...view.ModifyFunc) {
+	envMutex.Lock()
+	defer envMutex.Unlock()
+	env.View.SetModifiers(fn...)
 }
 
 // GetEnv safely returns a copy of the environment information.
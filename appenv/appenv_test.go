@@ -1,6 +1,7 @@
 package appenv
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -24,3 +25,53 @@ func TestGetEnvChecksMutex(t *testing.T) {
 		t.Errorf("Read permitted of environment global var while RW lock open. Elapsed: %d, Env: %v", telapsed/time.Second, &e)
 	}
 }
+
+func TestSettingsValidate(t *testing.T) {
+	tests := []struct {
+		s     Settings
+		field string
+	}{
+		{Settings{}, ""},
+		{Settings{PostsPerPage: 10, DateFormatForEditing: "2006-01-02T15:04", DateFormatShort: "Jan 2"}, ""},
+		{Settings{PostsPerPage: -1}, "PostsPerPage"},
+		{Settings{FeedSize: 501}, "FeedSize"},
+		{Settings{ExcerptCharLength: 10001}, "ExcerptCharLength"},
+		{Settings{VersionsKeepLast: -1}, "VersionsKeepLast"},
+		{Settings{VersionsDailyAfterDays: 3651}, "VersionsDailyAfterDays"},
+		{Settings{TrashDays: 3651}, "TrashDays"},
+		{Settings{DateFormatForEditing: "2006-01-02"}, "DateFormatForEditing"},
+		{Settings{DateFormatShort: "today"}, "DateFormatShort"},
+		{Settings{DateFormatFull: "now"}, "DateFormatFull"},
+	}
+
+	for _, tt := range tests {
+		errs := tt.s.Validate()
+		if tt.field == "" && len(errs) != 0 {
+			t.Errorf("Validate(%+v): have errors %v, need none", tt.s, errs)
+		}
+		if _, ok := errs[tt.field]; tt.field != "" && (!ok || len(errs) != 1) {
+			t.Errorf("Validate(%+v): have errors %v, need one for %s", tt.s, errs, tt.field)
+		}
+	}
+}
+
+func TestGetEnvContext(t *testing.T) {
+	setEnv(&AppEnv{Config: Config{BlogName: "Default", PostsPerPage: 5, FeedSize: 50}})
+
+	c := Defaults()
+	s := Settings{BlogName: "Override", FeedSize: 10}
+	s.Apply(&c)
+	ctx := WithConfig(context.Background(), c)
+
+	c = GetEnvContext(ctx).Config
+	if c.BlogName != "Override" || c.PostsPerPage != 5 || c.FeedSize != 10 {
+		t.Errorf("Have config %+v for the request, need name Override, 5 posts, feed 10", c)
+	}
+
+	if c := GetEnvContext(context.Background()).Config; c.BlogName != "Default" || c.FeedSize != 50 {
+		t.Errorf("Have config %+v without a blog configuration, need defaults", c)
+	}
+	if d := Defaults(); d.BlogName != "Default" {
+		t.Errorf("Have default blog name %s, need Default", d.BlogName)
+	}
+}
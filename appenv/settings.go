@@ -0,0 +1,156 @@
+package appenv
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/appengine"
+)
+
+// Settings holds the configuration values which can be changed from the
+// admin without redeploying. Each blog has its own settings. Zero values are
+// not overrides, and leave the value from app.yaml in place.
+type Settings struct {
+	BlogName               string
+	PostsPerPage           int
+	FeedSize               int
+	ExcerptCharLength      int
+	VersionsKeepLast       int
+	VersionsDailyAfterDays int
+	TrashDays              int
+	DateFormatForEditing   string
+	DateFormatShort        string
+	DateFormatFull         string
+}
+
+// Limits on the values of settings.
+const (
+	MaxBlogNameLength         = 100
+	MaxPostsPerPage           = 100
+	MaxFeedSize               = 500
+	MaxExcerptCharLength      = 10000
+	MaxVersionsKeepLast       = 1000
+	MaxVersionsDailyAfterDays = 3650
+	MaxTrashDays              = 3650
+)
+
+// defaults holds the configuration read from app.yaml.
+var defaults Config
+
+// Apply overrides the values in c with those set in s.
+func (s *Settings) Apply(c *Config) {
+	if s.BlogName != "" {
+		c.BlogName = s.BlogName
+	}
+	if s.PostsPerPage != 0 {
+		c.PostsPerPage = s.PostsPerPage
+	}
+	if s.FeedSize != 0 {
+		c.FeedSize = s.FeedSize
+	}
+	if s.ExcerptCharLength != 0 {
+		c.ExcerptCharLength = s.ExcerptCharLength
+	}
+	if s.VersionsKeepLast != 0 {
+		c.VersionsKeepLast = s.VersionsKeepLast
+	}
+	if s.VersionsDailyAfterDays != 0 {
+		c.VersionsDailyAfterDays = s.VersionsDailyAfterDays
+	}
+	if s.TrashDays != 0 {
+		c.TrashDays = s.TrashDays
+	}
+	if s.DateFormatForEditing != "" {
+		c.DateFormatForEditing = s.DateFormatForEditing
+	}
+	if s.DateFormatShort != "" {
+		c.DateFormatShort = s.DateFormatShort
+	}
+	if s.DateFormatFull != "" {
+		c.DateFormatFull = s.DateFormatFull
+	}
+}
+
+// Validate returns a message describing each invalid setting, keyed by field
+// name. The map is empty if all settings are valid.
+func (s *Settings) Validate() map[string]string {
+	errs := make(map[string]string)
+
+	if utf8.RuneCountInString(s.BlogName) > MaxBlogNameLength {
+		errs["BlogName"] = "The blog name is too long"
+	}
+	if s.PostsPerPage < 0 || s.PostsPerPage > MaxPostsPerPage {
+		errs["PostsPerPage"] = "Enter a number from 1 to 100, or leave blank"
+	}
+	if s.FeedSize < 0 || s.FeedSize > MaxFeedSize {
+		errs["FeedSize"] = "Enter a number from 1 to 500, or leave blank"
+	}
+	if s.ExcerptCharLength < 0 || s.ExcerptCharLength > MaxExcerptCharLength {
+		errs["ExcerptCharLength"] = "Enter a number from 1 to 10000, or leave blank"
+	}
+	if s.VersionsKeepLast < 0 || s.VersionsKeepLast > MaxVersionsKeepLast {
+		errs["VersionsKeepLast"] = "Enter a number from 1 to 1000, or leave blank"
+	}
+	if s.VersionsDailyAfterDays < 0 || s.VersionsDailyAfterDays > MaxVersionsDailyAfterDays {
+		errs["VersionsDailyAfterDays"] = "Enter a number from 1 to 3650, or leave blank"
+	}
+	if s.TrashDays < 0 || s.TrashDays > MaxTrashDays {
+		errs["TrashDays"] = "Enter a number from 1 to 3650, or leave blank"
+	}
+
+	// Dates for editing are parsed as well as displayed, so the format must
+	// include everything down to the minute.
+	ref := time.Date(2017, time.November, 28, 19, 42, 0, 0, time.UTC)
+	if f := s.DateFormatForEditing; f != "" {
+		t, err := time.Parse(f, ref.Format(f))
+		if err != nil || !t.Equal(ref) {
+			errs["DateFormatForEditing"] = "The format must include the year, month, day, hour and minute"
+		}
+	}
+	if f := s.DateFormatShort; f != "" && ref.Format(f) == f {
+		errs["DateFormatShort"] = "The format must include part of the date, such as 2006 or Jan"
+	}
+	if f := s.DateFormatFull; f != "" && ref.Format(f) == f {
+		errs["DateFormatFull"] = "The format must include part of the date, such as 2006 or Jan"
+	}
+
+	return errs
+}
+
+// Defaults returns the configuration read from app.yaml, without any settings
+// applied.
+func Defaults() Config {
+	envMutex.RLock()
+	defer envMutex.RUnlock()
+	return defaults
+}
+
+type configContextKey struct{}
+
+// WithConfig returns a copy of ctx carrying the configuration of the blog
+// serving a request, which GetEnvContext returns in place of the defaults.
+func WithConfig(ctx context.Context, c Config) context.Context {
+	return context.WithValue(ctx, configContextKey{}, c)
+}
+
+// NewContext returns the App Engine context for a request, keeping the
+// blog and configuration added to the request's context. Handlers use it in
+// place of appengine.NewContext, which on the first generation runtime
+// starts from an empty context and so would serve the default blog.
+func NewContext(r *http.Request) context.Context {
+	return appengine.WithContext(r.Context(), r)
+}
+
+// GetEnvContext safely returns a copy of the environment information with the
+// configuration carried by ctx, if any.
+func GetEnvContext(ctx context.Context) AppEnv {
+	e := GetEnv()
+	if c, ok := ctx.Value(configContextKey{}).(Config); ok {
+		e.Config = c
+		e.View.SetDateFormat(c.DateFormatFull)
+		e.View.SetBlogName(c.BlogName)
+	}
+	return e
+}
@@ -44,7 +44,7 @@ type generator struct {
 // Link represents a link element in the feed.
 type Link struct {
 	Rel  string `xml:"rel,attr"`
-	Type string `xml:"type,attr"`
+	Type string `xml:"type,attr,omitempty"`
 	Href string `xml:"href,attr"`
 }
 
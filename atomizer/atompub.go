@@ -0,0 +1,189 @@
+package atomizer
+
+// Types and helpers for the Atom Publishing Protocol.
+// See RFC5023: https://tools.ietf.org/html/rfc5023.
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Media types used by the Atom Publishing Protocol.
+const (
+	ServiceContentType = "application/atomsvc+xml"
+	EntryContentType   = "application/atom+xml;type=entry"
+	FeedContentType    = "application/atom+xml;type=feed"
+)
+
+// Service represents an AtomPub service document.
+type Service struct {
+	XMLName    xml.Name    `xml:"http://www.w3.org/2007/app service"`
+	Workspaces []Workspace `xml:"workspace"`
+}
+
+// Workspace represents a group of collections in a service document.
+type Workspace struct {
+	Title       string       `xml:"http://www.w3.org/2005/Atom title"`
+	Collections []Collection `xml:"collection"`
+}
+
+// Collection represents a collection in a service document.
+type Collection struct {
+	Href       string      `xml:"href,attr"`
+	Title      string      `xml:"http://www.w3.org/2005/Atom title"`
+	Accept     []string    `xml:"accept"`
+	Categories *Categories `xml:"categories,omitempty"`
+}
+
+// Categories represents the categories which may be used in a collection.
+type Categories struct {
+	Fixed      string     `xml:"fixed,attr,omitempty"`
+	Categories []Category `xml:"http://www.w3.org/2005/Atom category"`
+}
+
+// Category represents an Atom category element.
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// NewService returns a new Service with a single, empty workspace.
+func NewService(title string) *Service {
+	return &Service{
+		Workspaces: []Workspace{{Title: title}},
+	}
+}
+
+// AddCollection adds a collection to the first workspace of the Service.
+// Clients may only use the supplied categories if fixed is true.
+func (s *Service) AddCollection(href string, title string, accept []string,
+	categories []Category, fixed bool) {
+	c := Collection{
+		Href:   href,
+		Title:  title,
+		Accept: accept,
+	}
+	if categories != nil {
+		c.Categories = &Categories{Categories: categories, Fixed: "no"}
+		if fixed {
+			c.Categories.Fixed = "yes"
+		}
+	}
+	s.Workspaces[0].Collections = append(s.Workspaces[0].Collections, c)
+}
+
+// ToXML returns the service document.
+func (s Service) ToXML() ([]byte, error) {
+	return marshal(s)
+}
+
+// PubFeed represents an AtomPub collection feed.
+type PubFeed struct {
+	XMLName xml.Name   `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string     `xml:"title"`
+	Updated time.Time  `xml:"updated"`
+	ID      string     `xml:"id"`
+	Links   []Link     `xml:"link"`
+	Entries []PubEntry `xml:"entry"`
+}
+
+// NewPubFeed returns a new collection feed.
+func NewPubFeed(title string, id string, href string) *PubFeed {
+	return &PubFeed{
+		Title:   title,
+		Updated: time.Now(),
+		ID:      id,
+		Links: []Link{{
+			Rel:  "self",
+			Href: href,
+		}},
+	}
+}
+
+// ToXML returns the collection feed.
+func (f PubFeed) ToXML() ([]byte, error) {
+	return marshal(f)
+}
+
+// PubEntry represents a member entry of an AtomPub collection. Unlike Entry it
+// can hold several links, categories and the app:control element.
+type PubEntry struct {
+	XMLName    xml.Name   `xml:"http://www.w3.org/2005/Atom entry"`
+	Title      string     `xml:"title"`
+	ID         string     `xml:"id"`
+	Updated    time.Time  `xml:"updated"`
+	Published  *time.Time `xml:"published,omitempty"`
+	Edited     *time.Time `xml:"http://www.w3.org/2007/app edited,omitempty"`
+	Author     *Author    `xml:"author,omitempty"`
+	Links      []Link     `xml:"link"`
+	Categories []Category `xml:"category"`
+	Summary    string     `xml:"summary,omitempty"`
+	Content    PubContent `xml:"content"`
+	Control    *Control   `xml:"http://www.w3.org/2007/app control,omitempty"`
+}
+
+// PubContent represents the content of a member entry. Media link entries
+// reference their content with Src rather than including it.
+type PubContent struct {
+	Type string `xml:"type,attr,omitempty"`
+	Src  string `xml:"src,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+// Control represents the app:control element.
+type Control struct {
+	Draft string `xml:"draft,omitempty"`
+}
+
+// IsDraft returns true if the client has asked for the entry to be a draft.
+func (e *PubEntry) IsDraft() bool {
+	return e.Control != nil && e.Control.Draft == "yes"
+}
+
+// SetDraft sets the draft status of the entry.
+func (e *PubEntry) SetDraft(draft bool) {
+	if draft {
+		e.Control = &Control{Draft: "yes"}
+	} else {
+		e.Control = &Control{Draft: "no"}
+	}
+}
+
+// AddLink adds a link with the supplied relation to the entry.
+func (e *PubEntry) AddLink(rel string, contentType string, href string) {
+	e.Links = append(e.Links, Link{Rel: rel, Type: contentType, Href: href})
+}
+
+// ToXML returns the entry as a standalone XML document.
+func (e PubEntry) ToXML() ([]byte, error) {
+	return marshal(e)
+}
+
+// ParseEntry reads a member entry submitted by a client.
+//
+// TODO: Content of type xhtml is reduced to its text.
+func ParseEntry(r io.Reader) (*PubEntry, error) {
+	e := new(PubEntry)
+	if err := xml.NewDecoder(r).Decode(e); err != nil {
+		return nil, fmt.Errorf("atomizer: invalid entry: %v", err)
+	}
+	if e.XMLName.Space != xmlns || e.XMLName.Local != "entry" {
+		return nil, fmt.Errorf("atomizer: document is not an Atom entry")
+	}
+	return e, nil
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	x, err := xml.MarshalIndent(v, "", " ")
+	if err != nil {
+		return nil, fmt.Errorf("atomizer: xml marshaling error: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.Write(x)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+package atomizer_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"goblogengine/atomizer"
+)
+
+var entryText = `<?xml version="1.0" encoding="utf-8"?>
+<entry xmlns="http://www.w3.org/2005/Atom" xmlns:app="http://www.w3.org/2007/app">
+  <title>Atom-Powered Robots Run Amok</title>
+  <id>urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a</id>
+  <updated>2003-12-13T18:30:02Z</updated>
+  <author><name>John Doe</name></author>
+  <category term="robots"/>
+  <category term="news"/>
+  <content type="html">&lt;p&gt;Some text.&lt;/p&gt;</content>
+  <app:control><app:draft>yes</app:draft></app:control>
+</entry>`
+
+func TestParseEntry(t *testing.T) {
+	e, err := atomizer.ParseEntry(strings.NewReader(entryText))
+	if err != nil {
+		t.Fatalf("ParseEntry failed: %s", err)
+	}
+
+	if e.Title != "Atom-Powered Robots Run Amok" {
+		t.Errorf("Have title %s", e.Title)
+	}
+	if e.Content.Type != "html" || e.Content.Text != "<p>Some text.</p>" {
+		t.Errorf("Have content %v", e.Content)
+	}
+	if len(e.Categories) != 2 || e.Categories[1].Term != "news" {
+		t.Errorf("Have categories %v", e.Categories)
+	}
+	if !e.IsDraft() {
+		t.Error("Entry should be a draft")
+	}
+}
+
+func TestParseEntryRejectsFeed(t *testing.T) {
+	_, err := atomizer.ParseEntry(strings.NewReader(
+		`<feed xmlns="http://www.w3.org/2005/Atom"></feed>`))
+	if err == nil {
+		t.Error("ParseEntry accepted a feed document")
+	}
+}
+
+func TestEntryRoundTrip(t *testing.T) {
+	e := atomizer.PubEntry{
+		Title: "Round trip",
+		ID:    "tag:example.com,2017-01-01:post",
+		Categories: []atomizer.Category{
+			{Term: "foo"},
+		},
+		Content: atomizer.PubContent{Type: "text/markdown", Text: "*Hello*"},
+	}
+	e.AddLink("edit", "", "http://example.com/atompub/posts/round-trip")
+	e.SetDraft(false)
+
+	x, err := e.ToXML()
+	if err != nil {
+		t.Fatalf("ToXML failed: %s", err)
+	}
+
+	have, err := atomizer.ParseEntry(bytes.NewReader(x))
+	if err != nil {
+		t.Fatalf("ParseEntry failed on generated XML: %s\n%s", err, x)
+	}
+	if have.Title != e.Title || have.Content != e.Content || have.IsDraft() {
+		t.Errorf("Entry changed in round trip\nNeed: %v\nHave: %v", e, have)
+	}
+	if len(have.Links) != 1 || have.Links[0].Rel != "edit" {
+		t.Errorf("Have links %v", have.Links)
+	}
+}
+
+func TestServiceDocument(t *testing.T) {
+	s := atomizer.NewService("Test blog")
+	s.AddCollection("http://example.com/atompub/posts", "Posts",
+		[]string{atomizer.EntryContentType},
+		[]atomizer.Category{{Term: "foo"}}, false)
+	s.AddCollection("http://example.com/atompub/media", "Images",
+		[]string{"image/jpeg"}, nil, false)
+
+	x, err := s.ToXML()
+	if err != nil {
+		t.Fatalf("ToXML failed: %s", err)
+	}
+
+	have := string(x)
+	for _, need := range []string{
+		`<service xmlns="http://www.w3.org/2007/app">`,
+		`<collection href="http://example.com/atompub/posts">`,
+		`<accept>application/atom+xml;type=entry</accept>`,
+		`<categories fixed="no">`,
+		`<accept>image/jpeg</accept>`,
+	} {
+		if !strings.Contains(have, need) {
+			t.Errorf("Service document missing %s\n%s", need, have)
+		}
+	}
+}
@@ -18,6 +18,8 @@ type adminHomeViewModel struct {
 	CategoryCount int
 	ImageCount    int
 
+	PendingCommentCount int
+
 	AuditEvents []auditEventViewModel
 }
 
@@ -34,6 +36,7 @@ func (a *adminHomeViewModel) addStatistics(s *model.Statistics) {
 	a.AuthorCount = s.AuthorCount
 	a.CategoryCount = s.CategoryCount
 	a.ImageCount = s.ImageCount
+	a.PendingCommentCount = s.PendingCommentCount
 }
 
 func (a *adminHomeViewModel) addAuditEvents(evts []model.Audit) {
@@ -10,11 +10,25 @@ import (
 	"goblogengine/appenv"
 	"goblogengine/middleware/basehandler"
 	"goblogengine/model"
+	"goblogengine/workflow"
 )
 
 type adminPostListViewModel struct {
 	Posts  []postVersionListItemViewModel
 	Drafts []postVersionListItemViewModel
+
+	// States links to the list of versions in each workflow state. When
+	// State is set only the versions in that state are listed.
+	States     []postStateTabViewModel
+	State      string
+	StateLabel string
+	Versions   []postVersionListItemViewModel
+}
+
+type postStateTabViewModel struct {
+	Label  string
+	URL    string
+	Active bool
 }
 
 type postVersionListItemViewModel struct {
@@ -27,46 +41,126 @@ type postVersionListItemViewModel struct {
 	PostURL       string
 	Version       int
 	Published     bool
+	State         string
+	StateLabel    string
 	Categories    []categoryViewModel
+	AuthorName    string
+	ChangeNote    string
+	Label         string
+	Pinned        bool
+
+	// DiffURL compares the version with the one before it, and Restorable
+	// is true for versions older than the most recent.
+	DiffURL    string
+	Restorable bool
+}
+
+func newPostListItem(post *model.BlogPostVersion) postVersionListItemViewModel {
+	return postVersionListItemViewModel{
+		PostID:        post.PostID,
+		Version:       post.Version,
+		Title:         post.Title,
+		DateCreated:   post.DateCreated,
+		DatePublished: post.DatePublished,
+		EditURL:       fmt.Sprintf("/admin/post/edit/%s", post.Slug),
+		PostURL:       fmt.Sprintf("/post/%s", post.Slug),
+		PreviewURL: fmt.Sprintf("/admin/post/preview/%s/%d",
+			post.Slug, post.Version),
+		State:      post.EffectiveState(),
+		StateLabel: workflow.Label(post.EffectiveState()),
+		AuthorName: post.Author.DisplayName,
+		ChangeNote: post.ChangeNote,
+		Label:      post.Label,
+		Pinned:     post.Pinned,
+	}
+}
+
+// postVersionsInState returns the post versions in a workflow state. Versions
+// saved before the workflow have no stored state, so published versions are
+// found by whether they are published, and drafts are the posts whose most
+// recent version is a draft.
+func postVersionsInState(ctx context.Context, state string) ([]model.BlogPostVersion, error) {
+	switch state {
+	case workflow.Published:
+		return model.GetBlogPostLimit(ctx, 0, 0)
+	case workflow.Draft:
+		posts, err := model.GetAllBlogPost(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var drafts []model.BlogPostVersion
+		for i := range posts {
+			if posts[i].EffectiveState() == workflow.Draft {
+				drafts = append(drafts, posts[i])
+			}
+		}
+		return drafts, nil
+	}
+	return model.GetBlogPostVersionByState(ctx, state)
 }
 
-// AdminPostListGET displays a list of published and unpublished blog posts.
+// AdminPostListGET displays a list of published and unpublished blog posts,
+// or with a State parameter the post versions in that workflow state.
 func AdminPostListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	var viewModel = new(adminPostListViewModel)
 
-	postSlice, err := model.GetAllBlogPost(ctx)
-	if err != nil {
-		return basehandler.AppErrorf("Unable to retrieve post list",
-			http.StatusInternalServerError, err)
+	viewModel.State = r.FormValue("State")
+	if viewModel.State != "" && !workflow.Valid(viewModel.State) {
+		return basehandler.AppErrorf("Unknown state", http.StatusNotFound, nil)
+	}
+	viewModel.StateLabel = workflow.Label(viewModel.State)
+	viewModel.States = append(viewModel.States, postStateTabViewModel{
+		Label:  "All",
+		URL:    "/admin/post/list",
+		Active: viewModel.State == "",
+	})
+	for _, s := range workflow.States {
+		viewModel.States = append(viewModel.States, postStateTabViewModel{
+			Label:  workflow.Label(s),
+			URL:    "/admin/post/list?State=" + s,
+			Active: viewModel.State == s,
+		})
 	}
 
-	for _, post := range postSlice {
-		listItem := postVersionListItemViewModel{
-			PostID:        post.PostID,
-			Version:       post.Version,
-			Title:         post.Title,
-			DateCreated:   post.DateCreated,
-			DatePublished: post.DatePublished,
-			EditURL:       fmt.Sprintf("/admin/post/edit/%s", post.Slug),
-			PostURL:       fmt.Sprintf("/post/%s", post.Slug),
-			PreviewURL: fmt.Sprintf("/admin/post/preview/%s/%d",
-				post.Slug, post.Version),
+	if viewModel.State != "" {
+		versions, err := postVersionsInState(ctx, viewModel.State)
+		if err != nil {
+			return basehandler.AppErrorf("Unable to retrieve post list",
+				http.StatusInternalServerError, err)
+		}
+		for i := range versions {
+			listItem := newPostListItem(&versions[i])
+			listItem.EditURL = fmt.Sprintf("/admin/post/edit/%s?SelectedVersion=%d",
+				versions[i].Slug, versions[i].Version)
+			viewModel.Versions = append(viewModel.Versions, listItem)
+		}
+		sort.Slice(viewModel.Versions, func(i, j int) bool {
+			return viewModel.Versions[i].DateCreated.After(viewModel.Versions[j].DateCreated)
+		})
+	} else {
+		postSlice, err := model.GetAllBlogPost(ctx)
+		if err != nil {
+			return basehandler.AppErrorf("Unable to retrieve post list",
+				http.StatusInternalServerError, err)
 		}
 
-		if post.Published {
-			viewModel.Posts = append(viewModel.Posts, listItem)
-		} else {
-			viewModel.Drafts = append(viewModel.Drafts, listItem)
+		for i := range postSlice {
+			listItem := newPostListItem(&postSlice[i])
+			if postSlice[i].Published {
+				viewModel.Posts = append(viewModel.Posts, listItem)
+			} else {
+				viewModel.Drafts = append(viewModel.Drafts, listItem)
+			}
 		}
-	}
 
-	sort.Slice(viewModel.Posts, func(i, j int) bool {
-		return viewModel.Posts[i].DatePublished.After(viewModel.Posts[j].DatePublished)
-	})
+		sort.Slice(viewModel.Posts, func(i, j int) bool {
+			return viewModel.Posts[i].DatePublished.After(viewModel.Posts[j].DatePublished)
+		})
 
-	sort.Slice(viewModel.Drafts, func(i, j int) bool {
-		return viewModel.Drafts[i].DateCreated.After(viewModel.Drafts[j].DateCreated)
-	})
+		sort.Slice(viewModel.Drafts, func(i, j int) bool {
+			return viewModel.Drafts[i].DateCreated.After(viewModel.Drafts[j].DateCreated)
+		})
+	}
 
 	v := env.View.New("admin/postlist")
 	v.Data = viewModel
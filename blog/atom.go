@@ -10,7 +10,6 @@ import (
 
 	"github.com/russross/blackfriday"
 
-	"google.golang.org/appengine"
 	"google.golang.org/appengine/log"
 )
 
@@ -20,8 +19,8 @@ import (
 // TODO: Add some error middleware for XML / JSON handlers
 // TODO: Cache all the things: https://www.ctrl.blog/entry/feed-caching
 func AtomGET(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
-	env := appenv.GetEnv()
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
 
 	baseURL := "http://" + env.Config.BaseDomainName
 	feedURL := baseURL + "/atom"
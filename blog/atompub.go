@@ -0,0 +1,509 @@
+package blog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/atomizer"
+	"goblogengine/model"
+	"goblogengine/slug"
+	"goblogengine/webhook"
+
+	"goblogengine/external/github.com/gorilla/mux"
+
+	"google.golang.org/appengine/log"
+)
+
+// Content types which AtomPub clients may use for entry content. Plain text
+// and HTML are stored as they are, since both are valid Markdown.
+var atomPubContentTypes = map[string]bool{
+	"":              true,
+	"text":          true,
+	"html":          true,
+	"text/markdown": true,
+}
+
+// AtomPubServiceGET returns the AtomPub service document, which describes the
+// posts and media collections.
+func AtomPubServiceGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	if _, err := atomPubAuthorise(ctx, w, r, ""); err != nil {
+		return
+	}
+
+	cats, err := model.GetAllCategory(ctx)
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	var catList []atomizer.Category
+	for i := range cats {
+		catList = append(catList, atomizer.Category{Term: cats[i].Title})
+	}
+
+	s := atomizer.NewService(env.Config.BlogName)
+	s.AddCollection(baseURL+"/atompub/posts", "Posts",
+		[]string{atomizer.EntryContentType}, catList, false)
+	s.AddCollection(baseURL+"/atompub/media", "Images",
+		[]string{"image/jpeg"}, nil, false)
+
+	x, err := s.ToXML()
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	atomPubWrite(w, http.StatusOK, atomizer.ServiceContentType, x)
+}
+
+// AtomPubCollectionGET returns a feed of the latest version of every post.
+func AtomPubCollectionGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	if _, err := atomPubAuthorise(ctx, w, r, ""); err != nil {
+		return
+	}
+
+	posts, err := model.GetAllBlogPost(ctx)
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	f := atomizer.NewPubFeed(env.Config.BlogName, baseURL+"/atompub/posts",
+		baseURL+"/atompub/posts")
+	for i := range posts {
+		latest, err := model.GetLatestBlogPostVersion(ctx, posts[i].Slug)
+		if err != nil {
+			atomPubError(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+		f.Entries = append(f.Entries, atomPubEntry(baseURL, latest))
+	}
+
+	x, err := f.ToXML()
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	atomPubWrite(w, http.StatusOK, atomizer.FeedContentType, x)
+}
+
+// AtomPubCollectionPOST creates a new post from a submitted entry. The Slug
+// header, if supplied, is used as the post URL slug.
+func AtomPubCollectionPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	token, err := atomPubAuthorise(ctx, w, r, model.ScopeCreate)
+	if err != nil {
+		return
+	}
+
+	e, err := atomizer.ParseEntry(r.Body)
+	if err != nil {
+		atomPubError(ctx, w, http.StatusBadRequest, err)
+		return
+	}
+
+	entry := new(model.BlogPostVersion)
+	entry.DatePublished = time.Now()
+	if err := applyAtomPubEntry(entry, e); err != nil {
+		atomPubError(ctx, w, http.StatusBadRequest, err)
+		return
+	}
+
+	entry.Slug = slug.Make(r.Header.Get("Slug"))
+	if entry.Slug == "" {
+		entry.Slug = slug.Make(entry.Title)
+	}
+	if entry.Slug == "" {
+		atomPubError(ctx, w, http.StatusBadRequest,
+			errors.New("entry must have a title or slug"))
+		return
+	}
+
+	err = createPost(ctx, &env, entry, token.Author)
+	if err == model.ErrorPostSlugAlreadyExists {
+		atomPubError(ctx, w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a := model.NewAudit("Post created via AtomPub", entry.Title, token.Author)
+	a.Save(ctx)
+	fireSavedPostWebhooks(ctx, nil, entry)
+
+	memberURL := baseURL + "/atompub/posts/" + entry.Slug
+	w.Header().Set("Location", memberURL)
+	w.Header().Set("Content-Location", memberURL)
+	atomPubWriteEntry(ctx, w, http.StatusCreated, baseURL, entry)
+}
+
+// AtomPubEntryGET returns the latest version of a post as an entry. The ETag
+// header holds the version number, for use in a subsequent PUT.
+func AtomPubEntryGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	if _, err := atomPubAuthorise(ctx, w, r, ""); err != nil {
+		return
+	}
+
+	post, ok := atomPubFindPost(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == atomPubETag(post) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	atomPubWriteEntry(ctx, w, http.StatusOK, baseURL, post)
+}
+
+// AtomPubEntryPUT saves a submitted entry as a new version of a post. If the
+// client supplies an If-Match header which does not match the latest version
+// the edit is rejected, so that changes made elsewhere are not overwritten.
+func AtomPubEntryPUT(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	token, err := atomPubAuthorise(ctx, w, r, model.ScopeUpdate)
+	if err != nil {
+		return
+	}
+
+	latest, ok := atomPubFindPost(ctx, w, r)
+	if !ok || !atomPubCheckOwner(ctx, w, token, latest) {
+		return
+	}
+
+	if m := r.Header.Get("If-Match"); m != "" && m != "*" && m != atomPubETag(latest) {
+		atomPubError(ctx, w, http.StatusPreconditionFailed,
+			fmt.Errorf("post has been edited since version %s", m))
+		return
+	}
+
+	e, err := atomizer.ParseEntry(r.Body)
+	if err != nil {
+		atomPubError(ctx, w, http.StatusBadRequest, err)
+		return
+	}
+
+	entry := *latest
+	if err := applyAtomPubEntry(&entry, e); err != nil {
+		atomPubError(ctx, w, http.StatusBadRequest, err)
+		return
+	}
+	err = updatePost(ctx, latest, &entry, token.Author)
+	if err == model.ErrorVersionConflict {
+		atomPubError(ctx, w, http.StatusPreconditionFailed,
+			fmt.Errorf("post has been edited since version %d", latest.Version))
+		return
+	}
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a := model.NewAudit("Post updated via AtomPub", entry.Title, token.Author)
+	a.Save(ctx)
+	fireSavedPostWebhooks(ctx, latest, &entry)
+
+	atomPubWriteEntry(ctx, w, http.StatusOK, baseURL, &entry)
+}
+
+// AtomPubEntryDELETE moves a post to the trash, from which it can be
+// restored or deleted permanently.
+func AtomPubEntryDELETE(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+
+	token, err := atomPubAuthorise(ctx, w, r, model.ScopeDelete)
+	if err != nil {
+		return
+	}
+
+	post, ok := atomPubFindPost(ctx, w, r)
+	if !ok || !atomPubCheckOwner(ctx, w, token, post) {
+		return
+	}
+
+	if _, err := model.TrashBlogPost(ctx, post.PostID, token.Author); err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a := model.NewAudit("Post moved to trash via AtomPub", post.Title, token.Author)
+	a.Save(ctx)
+	firePostWebhook(ctx, webhook.EventPostDeleted, post)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// AtomPubMediaGET returns a feed of media link entries for all images.
+func AtomPubMediaGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	if _, err := atomPubAuthorise(ctx, w, r, ""); err != nil {
+		return
+	}
+
+	imgs, err := model.GetAllImage(ctx)
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	f := atomizer.NewPubFeed(env.Config.BlogName+" images",
+		baseURL+"/atompub/media", baseURL+"/atompub/media")
+	for i := range imgs {
+		f.Entries = append(f.Entries, atomPubMediaEntry(baseURL, &imgs[i]))
+	}
+
+	x, err := f.ToXML()
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	atomPubWrite(w, http.StatusOK, atomizer.FeedContentType, x)
+}
+
+// AtomPubMediaPOST saves the request body as an image and returns a media
+// link entry. The Slug header, if supplied, is used as the image name.
+func AtomPubMediaPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	token, err := atomPubAuthorise(ctx, w, r, model.ScopeMedia)
+	if err != nil {
+		return
+	}
+
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "image/jpeg") {
+		atomPubError(ctx, w, http.StatusUnsupportedMediaType,
+			fmt.Errorf("unsupported media type %s", ct))
+		return
+	}
+
+	img, err := saveImage(ctx, r.Body, &token.Author)
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	if name := r.Header.Get("Slug"); name != "" {
+		img.Name = name
+		if err := img.Save(ctx); err != nil {
+			atomPubError(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	a := model.NewAudit("Image uploaded via AtomPub", img.ID, token.Author)
+	a.Save(ctx)
+	fireImageWebhook(ctx, img)
+
+	e := atomPubMediaEntry(baseURL, img)
+	x, err := e.ToXML()
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", baseURL+"/atompub/media/"+img.ID)
+	atomPubWrite(w, http.StatusCreated, atomizer.EntryContentType, x)
+}
+
+// AtomPubMediaDELETE moves an image to the trash. The image is kept in Cloud
+// Storage until the trash is purged.
+func AtomPubMediaDELETE(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+
+	token, err := atomPubAuthorise(ctx, w, r, model.ScopeMedia)
+	if err != nil {
+		return
+	}
+
+	img, err := model.GetImageByID(ctx, mux.Vars(r)["imageid"])
+	if err != nil {
+		atomPubError(ctx, w, http.StatusNotFound, err)
+		return
+	}
+
+	if _, err := model.TrashImage(ctx, img, token.Author); err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a := model.NewAudit("Image moved to trash via AtomPub", img.ID, token.Author)
+	a.Save(ctx)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// atomPubAuthorise checks the access token supplied with the request and
+// writes an error response if it is missing, invalid or lacks the required
+// scope.
+func atomPubAuthorise(ctx context.Context, w http.ResponseWriter, r *http.Request, scope string) (*model.AccessToken, error) {
+	t, err := authoriseToken(ctx, requestToken(r), scope)
+	if err == model.ErrorNoMatchingAccessToken {
+		w.Header().Set("WWW-Authenticate", `Basic realm="AtomPub"`)
+		atomPubError(ctx, w, http.StatusUnauthorized, err)
+	} else if err == errorInsufficientScope {
+		atomPubError(ctx, w, http.StatusForbidden, err)
+	} else if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+	}
+	return t, err
+}
+
+// atomPubFindPost returns the latest version of the post named in the request
+// URL, or writes an error response if there isn't one.
+func atomPubFindPost(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.BlogPostVersion, bool) {
+	post, err := model.GetLatestBlogPostVersion(ctx, mux.Vars(r)["postslug"])
+	if err == model.ErrorNoMatchingPost {
+		atomPubError(ctx, w, http.StatusNotFound, err)
+		return nil, false
+	}
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return post, true
+}
+
+// atomPubCheckOwner reports whether the author the token was issued to may
+// change the post, writing an error response if not.
+func atomPubCheckOwner(ctx context.Context, w http.ResponseWriter, t *model.AccessToken, p *model.BlogPostVersion) bool {
+	ok, err := canChangePost(ctx, &t.Author, p.PostID)
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return false
+	}
+	if !ok {
+		atomPubError(ctx, w, http.StatusForbidden, errorNotPostOwner)
+	}
+	return ok
+}
+
+// atomPubETag returns an entity tag derived from the post version number.
+func atomPubETag(p *model.BlogPostVersion) string {
+	return strconv.Quote(strconv.Itoa(p.Version))
+}
+
+// atomPubEntry maps a BlogPostVersion onto a member entry.
+func atomPubEntry(baseURL string, p *model.BlogPostVersion) atomizer.PubEntry {
+	published := p.DatePublished
+	edited := p.DateCreated
+	e := atomizer.PubEntry{
+		Title:     p.Title,
+		ID:        p.PostID,
+		Updated:   p.DateCreated,
+		Published: &published,
+		Edited:    &edited,
+		Author: &atomizer.Author{
+			Name: p.Author.DisplayName,
+			URI:  fmt.Sprintf("%s/author/%s", baseURL, p.Author.Slug),
+		},
+		Content: atomizer.PubContent{
+			Type: "text/markdown",
+			Text: p.BodyMarkdown,
+		},
+	}
+	e.AddLink("edit", "", baseURL+"/atompub/posts/"+p.Slug)
+	e.AddLink("alternate", "text/html", baseURL+"/post/"+p.Slug)
+	for i := range p.Categories {
+		e.Categories = append(e.Categories, atomizer.Category{
+			Term: p.Categories[i].Title,
+		})
+	}
+	e.SetDraft(!p.Published)
+	return e
+}
+
+// atomPubMediaEntry returns a media link entry describing an image.
+func atomPubMediaEntry(baseURL string, img *model.Image) atomizer.PubEntry {
+	e := atomizer.PubEntry{
+		Title:   img.Name,
+		ID:      baseURL + img.LocalURL,
+		Updated: img.Added,
+		Content: atomizer.PubContent{
+			Type: "image/jpeg",
+			Src:  baseURL + img.LocalURL,
+		},
+	}
+	e.AddLink("edit", "", baseURL+"/atompub/media/"+img.ID)
+	e.AddLink("edit-media", "image/jpeg", baseURL+img.LocalURL)
+	return e
+}
+
+// applyAtomPubEntry maps a submitted entry onto a BlogPostVersion. The slug
+// and post ID are never changed.
+func applyAtomPubEntry(ver *model.BlogPostVersion, e *atomizer.PubEntry) error {
+	if !atomPubContentTypes[e.Content.Type] {
+		return fmt.Errorf("unsupported content type %s", e.Content.Type)
+	}
+
+	ver.Title = e.Title
+	ver.BodyMarkdown = e.Content.Text
+	ver.Published = !e.IsDraft()
+	if e.Published != nil && !e.Published.IsZero() {
+		ver.DatePublished = *e.Published
+	}
+
+	ver.Categories = nil
+	for i := range e.Categories {
+		c := strings.TrimSpace(e.Categories[i].Term)
+		if c == "" {
+			continue
+		}
+		ver.Categories = append(ver.Categories, model.Category{
+			Title: c,
+			Slug:  slug.Make(c),
+		})
+	}
+
+	return nil
+}
+
+func atomPubWriteEntry(ctx context.Context, w http.ResponseWriter, statusCode int, baseURL string, p *model.BlogPostVersion) {
+	x, err := atomPubEntry(baseURL, p).ToXML()
+	if err != nil {
+		atomPubError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("ETag", atomPubETag(p))
+	atomPubWrite(w, statusCode, atomizer.EntryContentType, x)
+}
+
+func atomPubWrite(w http.ResponseWriter, statusCode int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// atomPubError writes a plain text error response, logging server errors.
+func atomPubError(ctx context.Context, w http.ResponseWriter, statusCode int, err error) {
+	if statusCode == http.StatusInternalServerError {
+		log.Errorf(ctx, "AtomPub request failed: %v", err)
+	}
+	http.Error(w, err.Error(), statusCode)
+}
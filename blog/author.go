@@ -2,17 +2,26 @@ package blog
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"goblogengine/appenv"
 	"goblogengine/flash"
+	"goblogengine/middleware/auth"
 	"goblogengine/middleware/basehandler"
 	"goblogengine/model"
 	"goblogengine/slug"
 
+	"goblogengine/external/github.com/gorilla/mux"
+
 	"google.golang.org/appengine/user"
 )
 
+// invitationMaxAge is how long an invitation link can be used for.
+const invitationMaxAge = 7 * 24 * time.Hour
+
 type authorViewModel struct {
 	// Entity properties
 	Slug            string
@@ -20,6 +29,9 @@ type authorViewModel struct {
 	Email           string
 	GoogleAccountID string
 
+	Role     string
+	Disabled bool
+
 	// View properties
 	URL     string
 	Current bool
@@ -28,13 +40,82 @@ type authorViewModel struct {
 type authorInsertViewModel struct {
 	// Entity properties
 	DisplayName string
+
+	// View properties
+	Role      string
+	InvitedBy string
+}
+
+type invitationViewModel struct {
+	// Entity properties
+	Hash      string
+	Email     string
+	Role      string
+	InvitedBy string
+	Expires   time.Time
+
+	// View properties
+	Expired bool
 }
 
 type adminAuthorListViewModel struct {
-	Authors []authorViewModel
+	Authors     []authorViewModel
+	Invitations []invitationViewModel
+
+	// View properties
+	CanManage bool
+	Roles     []string
+
+	// New entity properties
+	Email string
+	Role  string
+}
+
+type adminAuthorEditViewModel struct {
+	Author authorViewModel
+
+	// Other authors the posts and images can be reassigned to
+	Others []authorViewModel
+
+	// Form properties
+	DisplayName string
+	Disabled    bool
+	To          string
+}
+
+// validRole returns true if role is one of the known roles.
+func validRole(role string) bool {
+	for _, known := range model.Roles {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledOwners returns the number of enabled owners among the authors.
+func enabledOwners(authors []model.Author) int {
+	n := 0
+	for i := range authors {
+		if authors[i].EffectiveRole() == model.RoleOwner && !authors[i].Disabled {
+			n++
+		}
+	}
+	return n
+}
+
+// findAuthor returns the author with the supplied email address, or nil.
+func findAuthor(authors []model.Author, email string) *model.Author {
+	for i := range authors {
+		if model.NormalEmail(authors[i].Email) == model.NormalEmail(email) {
+			return &authors[i]
+		}
+	}
+	return nil
 }
 
-// AdminAuthorListGET displays a list of registered Authors.
+// AdminAuthorListGET displays a list of registered Authors, and to owners the
+// pending invitations.
 func AdminAuthorListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	authors, err := model.GetAllAuthor(ctx)
 	if err != nil {
@@ -44,6 +125,9 @@ func AdminAuthorListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseW
 	a, _ := env.User.(*model.Author)
 
 	viewModel := new(adminAuthorListViewModel)
+	viewModel.CanManage = auth.Can(a, auth.ManageBlog)
+	viewModel.Roles = model.Roles
+	viewModel.Role = model.RoleAuthor
 	for i := range authors {
 		var current bool
 		if authors[i].GoogleAccountID == a.GoogleAccountID {
@@ -53,11 +137,30 @@ func AdminAuthorListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseW
 			DisplayName:     authors[i].DisplayName,
 			Email:           authors[i].Email,
 			GoogleAccountID: authors[i].GoogleAccountID,
+			Role:            authors[i].EffectiveRole(),
+			Disabled:        authors[i].Disabled,
 			Current:         current,
 			URL:             "/author/" + authors[i].Slug,
 		})
 	}
 
+	if viewModel.CanManage {
+		invitations, err := model.GetAllInvitation(ctx)
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		for i := range invitations {
+			viewModel.Invitations = append(viewModel.Invitations, invitationViewModel{
+				Hash:      invitations[i].Hash,
+				Email:     invitations[i].Email,
+				Role:      invitations[i].Role,
+				InvitedBy: invitations[i].Author.DisplayName,
+				Expires:   invitations[i].Expires,
+				Expired:   invitations[i].Expired(),
+			})
+		}
+	}
+
 	v := env.View.New("admin/authorlist")
 	v.Data = viewModel
 	if err = v.Render(ctx, w, r); err != nil {
@@ -67,9 +170,452 @@ func AdminAuthorListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseW
 	return nil
 }
 
+// AdminAuthorRolePOST changes the role of an author. A blog must always
+// have an owner, so the last one cannot be given another role.
+func AdminAuthorRolePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	email := r.PostFormValue("Email")
+	role := r.PostFormValue("Role")
+
+	if !validRole(role) {
+		return basehandler.AppErrorf("Unknown role", http.StatusBadRequest, nil)
+	}
+
+	authors, err := model.GetAllAuthor(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	target := findAuthor(authors, email)
+	if target == nil {
+		return basehandler.AppErrorf("Author not found", http.StatusNotFound, nil)
+	}
+	if target.EffectiveRole() == model.RoleOwner && !target.Disabled &&
+		role != model.RoleOwner && enabledOwners(authors) == 1 {
+		return basehandler.AppErrorf("The blog must keep at least one owner",
+			http.StatusBadRequest, nil)
+	}
+
+	if err := model.SetAuthorRole(ctx, target.Email, role); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Author role changed",
+		fmt.Sprintf("%s: %s to %s", target.DisplayName, target.EffectiveRole(), role), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s is now %s", target.DisplayName, role))
+	http.Redirect(w, r, "/admin/author/list", http.StatusFound)
+	return nil
+}
+
+// AdminAuthorInvitePOST invites someone to become an author with the chosen
+// role. The invitation link is displayed once in a flash message for the
+// owner to send on, and cannot be retrieved later.
+func AdminAuthorInvitePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminAuthorListViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	email := model.NormalEmail(viewModel.Email)
+	if email == "" {
+		return basehandler.AppErrorf("Please enter the email address of the Google account to invite",
+			http.StatusBadRequest, nil)
+	}
+	if !validRole(viewModel.Role) {
+		return basehandler.AppErrorf("Unknown role", http.StatusBadRequest, nil)
+	}
+
+	authors, err := model.GetAllAuthor(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if findAuthor(authors, email) != nil {
+		return basehandler.AppErrorf("That account is already an author of this blog",
+			http.StatusBadRequest, nil)
+	}
+	invitations, err := model.GetAllInvitation(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	for i := range invitations {
+		if invitations[i].Email == email && !invitations[i].Expired() {
+			return basehandler.AppErrorf("That account already has a pending invitation",
+				http.StatusBadRequest, nil)
+		}
+	}
+
+	inv, token, err := model.NewInvitation(email, viewModel.Role, invitationMaxAge, *author)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if _, err := inv.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Author invited", fmt.Sprintf("%s as %s", inv.Email, inv.Role), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf(
+		"Invitation link for %s: http://%s/admin/author/join/%s. Send it now, it will not be shown again.",
+		inv.Email, env.Config.BaseDomainName, token))
+	http.Redirect(w, r, "/admin/author/list", http.StatusFound)
+	return nil
+}
+
+// AdminInvitationDeletePOST withdraws an invitation.
+func AdminInvitationDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	hash := r.FormValue("Hash")
+
+	invitations, err := model.GetAllInvitation(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	var email string
+	for i := range invitations {
+		if invitations[i].Hash == hash {
+			email = invitations[i].Email
+		}
+	}
+	if email == "" {
+		return basehandler.AppErrorf("Invitation not found",
+			http.StatusNotFound, nil)
+	}
+
+	if err := model.DeleteInvitation(ctx, hash); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Author invitation withdrawn", email, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Invitation for %s withdrawn", email))
+	http.Redirect(w, r, "/admin/author/list", http.StatusFound)
+	return nil
+}
+
+// invitationForUser returns the invitation matching the token in the URL,
+// provided it is addressed to the logged in user, who is not yet an author.
+func invitationForUser(ctx context.Context, env appenv.AppEnv, r *http.Request) (*model.Invitation, *basehandler.AppError) {
+	if _, ok := env.User.(*model.Author); ok {
+		return nil, basehandler.AppErrorf("You are already an author of this blog",
+			http.StatusBadRequest, nil)
+	}
+
+	inv, err := model.GetInvitation(ctx, mux.Vars(r)["token"])
+	if err == model.ErrorNoMatchingInvitation {
+		return nil, basehandler.AppErrorf("Invitation not found",
+			http.StatusNotFound, err)
+	} else if err == model.ErrorInvitationExpired {
+		return nil, basehandler.AppErrorf("This invitation has expired, please ask for a new one",
+			http.StatusGone, err)
+	} else if err != nil {
+		return nil, basehandler.AppErrorDefault(err)
+	}
+
+	u := user.Current(ctx)
+	if u == nil || model.NormalEmail(u.Email) != model.NormalEmail(inv.Email) {
+		return nil, basehandler.AppErrorf("This invitation is for another Google account",
+			http.StatusForbidden, nil)
+	}
+	return inv, nil
+}
+
+// AdminAuthorJoinGET displays the form for accepting an invitation.
+func AdminAuthorJoinGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	inv, e := invitationForUser(ctx, env, r)
+	if e != nil {
+		return e
+	}
+
+	v := env.View.New("admin/authorinsert")
+	v.Data = authorInsertViewModel{
+		Role:      inv.Role,
+		InvitedBy: inv.Author.DisplayName,
+	}
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminAuthorJoinPOST accepts an invitation, registering the logged in user
+// as an author with the role they were invited to.
+func AdminAuthorJoinPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	inv, e := invitationForUser(ctx, env, r)
+	if e != nil {
+		return e
+	}
+
+	viewModel := new(authorInsertViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if strings.TrimSpace(viewModel.DisplayName) == "" {
+		return basehandler.AppErrorf("Please enter a display name",
+			http.StatusBadRequest, nil)
+	}
+
+	u, e := currentUser(ctx)
+	if e != nil {
+		return e
+	}
+	author := model.Author{
+		DisplayName:     viewModel.DisplayName,
+		Email:           u.Email,
+		GoogleAccountID: u.ID,
+		Slug:            slug.Make(viewModel.DisplayName),
+		Role:            inv.Role,
+	}
+	if _, err := author.Save(ctx); err == model.ErrorAuthorAlreadyExists {
+		return basehandler.AppErrorf("You are already an author of this blog",
+			http.StatusBadRequest, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if err := model.DeleteInvitation(ctx, inv.Hash); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Author joined",
+		fmt.Sprintf("As %s, invited by %s", inv.Role, inv.Author.DisplayName), author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, "Author registered")
+	http.Redirect(w, r, "/admin", http.StatusFound)
+
+	return nil
+}
+
+// AdminAuthorEditGET displays the form for editing, disabling or deleting an
+// author.
+func AdminAuthorEditGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	authors, err := model.GetAllAuthor(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	target := findAuthor(authors, r.FormValue("Email"))
+	if target == nil {
+		return basehandler.AppErrorf("Author not found", http.StatusNotFound, nil)
+	}
+
+	a, _ := env.User.(*model.Author)
+
+	viewModel := new(adminAuthorEditViewModel)
+	viewModel.Author = authorViewModel{
+		DisplayName: target.DisplayName,
+		Email:       target.Email,
+		Role:        target.EffectiveRole(),
+		Disabled:    target.Disabled,
+		Current:     target.GoogleAccountID == a.GoogleAccountID,
+	}
+	viewModel.DisplayName = target.DisplayName
+	viewModel.Disabled = target.Disabled
+	for i := range authors {
+		if authors[i].GoogleAccountID == target.GoogleAccountID {
+			continue
+		}
+		viewModel.Others = append(viewModel.Others, authorViewModel{
+			DisplayName: authors[i].DisplayName,
+			Email:       authors[i].Email,
+		})
+	}
+
+	v := env.View.New("admin/authoredit")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// removableAuthor returns the author with the email address in the form,
+// refusing if they are the logged in author or the last enabled owner, who
+// cannot be disabled or deleted.
+func removableAuthor(authors []model.Author, env appenv.AppEnv, r *http.Request) (*model.Author, *basehandler.AppError) {
+	target := findAuthor(authors, r.FormValue("Email"))
+	if target == nil {
+		return nil, basehandler.AppErrorf("Author not found", http.StatusNotFound, nil)
+	}
+	if a, _ := env.User.(*model.Author); target.GoogleAccountID == a.GoogleAccountID {
+		return nil, basehandler.AppErrorf("You cannot disable or delete yourself",
+			http.StatusBadRequest, nil)
+	}
+	if target.EffectiveRole() == model.RoleOwner && !target.Disabled && enabledOwners(authors) == 1 {
+		return nil, basehandler.AppErrorf("The blog must keep at least one owner",
+			http.StatusBadRequest, nil)
+	}
+	return target, nil
+}
+
+// AdminAuthorEditPOST changes an author's display name, and disables or
+// enables them. Disabled authors keep their posts but can no longer use the
+// admin or their access tokens. Posts, pages and images keep a copy of their
+// author, which is refreshed with the new display name.
+func AdminAuthorEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminAuthorEditViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if strings.TrimSpace(viewModel.DisplayName) == "" {
+		return basehandler.AppErrorf("Please enter a display name",
+			http.StatusBadRequest, nil)
+	}
+
+	authors, err := model.GetAllAuthor(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	target := findAuthor(authors, r.FormValue("Email"))
+	if target == nil {
+		return basehandler.AppErrorf("Author not found", http.StatusNotFound, nil)
+	}
+	if viewModel.Disabled && !target.Disabled {
+		if _, e := removableAuthor(authors, env, r); e != nil {
+			return e
+		}
+	}
+
+	updated := *target
+	updated.DisplayName = viewModel.DisplayName
+	updated.Disabled = viewModel.Disabled
+	if err := updated.Update(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if updated.DisplayName != target.DisplayName {
+		if n, err := model.ReassignAuthor(ctx, *target, updated); err != nil {
+			return basehandler.AppErrorf(fmt.Sprintf(
+				"Updated %d items before failing, please save the author again", n),
+				http.StatusInternalServerError, err)
+		}
+	}
+
+	author, _ := env.User.(*model.Author)
+	action := "Author updated"
+	if updated.Disabled && !target.Disabled {
+		action = "Author disabled"
+	} else if !updated.Disabled && target.Disabled {
+		action = "Author enabled"
+	}
+	a := model.NewAudit(action, updated.DisplayName, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s: %s", action, updated.DisplayName))
+	http.Redirect(w, r, "/admin/author/list", http.StatusFound)
+	return nil
+}
+
+// AdminAuthorDeletePOST deletes an author, after reassigning their posts,
+// pages and images to another author, and revokes their access tokens.
+func AdminAuthorDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	authors, err := model.GetAllAuthor(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	target, e := removableAuthor(authors, env, r)
+	if e != nil {
+		return e
+	}
+	to := findAuthor(authors, r.FormValue("To"))
+	if to == nil || to.GoogleAccountID == target.GoogleAccountID {
+		return basehandler.AppErrorf("Please choose another author to reassign the posts and images to",
+			http.StatusBadRequest, nil)
+	}
+
+	author, _ := env.User.(*model.Author)
+	n, err := model.ReassignAuthor(ctx, *target, *to)
+	if err != nil {
+		a := model.NewAudit("Author reassignment failed",
+			fmt.Sprintf("%s, %d items reassigned to %s", target.DisplayName, n, to.DisplayName), *author)
+		a.Save(ctx)
+		return basehandler.AppErrorf(fmt.Sprintf(
+			"Reassigned %d items before failing, please delete the author again to finish", n),
+			http.StatusInternalServerError, err)
+	}
+
+	tokens, err := model.GetAccessTokenByAuthor(ctx, *target)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	for i := range tokens {
+		if err := model.DeleteAccessToken(ctx, tokens[i].Hash); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+	}
+
+	if err := model.DeleteAuthor(ctx, target.Email); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Author deleted",
+		fmt.Sprintf("%s, %d items reassigned to %s", target.DisplayName, n, to.DisplayName), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s deleted, their posts and images now belong to %s",
+		target.DisplayName, to.DisplayName))
+	http.Redirect(w, r, "/admin/author/list", http.StatusFound)
+	return nil
+}
+
+// currentUser returns the logged in Google user, refusing the request if
+// nobody is logged in.
+func currentUser(ctx context.Context) (*user.User, *basehandler.AppError) {
+	u := user.Current(ctx)
+	if u == nil {
+		return nil, basehandler.AppErrorf("Not logged in",
+			http.StatusUnauthorized, nil)
+	}
+	return u, nil
+}
+
+// requireFirstAuthor refuses to register an author for a blog which already
+// has authors, so that admins can only register themselves on new blogs.
+// Others join a blog by accepting an invitation from one of its owners.
+// Registration checks again as the author is saved.
+func requireFirstAuthor(ctx context.Context) *basehandler.AppError {
+	n, err := model.GetAuthorCount(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if n > 0 {
+		return errorBlogHasAuthors(nil)
+	}
+	return nil
+}
+
+func errorBlogHasAuthors(err error) *basehandler.AppError {
+	return basehandler.AppErrorf("This blog already has authors, please ask an owner for an invitation",
+		http.StatusForbidden, err)
+}
+
 // AdminAuthorInsertPOST handles the new author form submission.
 func AdminAuthorInsertPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
-	u := user.Current(ctx)
+	if e := requireFirstAuthor(ctx); e != nil {
+		return e
+	}
+	u, e := currentUser(ctx)
+	if e != nil {
+		return e
+	}
 	viewModel := new(authorInsertViewModel)
 
 	if err := r.ParseForm(); err != nil {
@@ -84,9 +630,12 @@ func AdminAuthorInsertPOST(ctx context.Context, env appenv.AppEnv, w http.Respon
 		Email:           u.Email,
 		GoogleAccountID: u.ID,
 		Slug:            slug.Make(viewModel.DisplayName),
+		Role:            model.RoleOwner,
 	}
-	_, err := author.Save(ctx)
-	if err != nil {
+	_, err := author.SaveFirst(ctx)
+	if err == model.ErrorBlogHasAuthors {
+		return errorBlogHasAuthors(err)
+	} else if err != nil {
 		return basehandler.AppErrorDefault(err)
 	}
 
@@ -101,6 +650,12 @@ func AdminAuthorInsertPOST(ctx context.Context, env appenv.AppEnv, w http.Respon
 
 // AdminAuthorInsertGET displays the create author form.
 func AdminAuthorInsertGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	if _, e := currentUser(ctx); e != nil {
+		return e
+	}
+	if e := requireFirstAuthor(ctx); e != nil {
+		return e
+	}
 	v := env.View.New("admin/authorinsert")
 	if err := v.Render(ctx, w, r); err != nil {
 		return basehandler.AppErrorDefault(err)
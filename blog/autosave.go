@@ -0,0 +1,151 @@
+package blog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+
+	"google.golang.org/appengine/log"
+)
+
+// autosaveViewModel is the editor form as sent by the autosave script.
+type autosaveViewModel struct {
+	PostID         string
+	BaseVersion    int
+	Title          string
+	Slug           string
+	BannerImageURL string
+	BodyMarkdown   string
+	CategoryList   string
+	DatePublished  string
+	CommentsClosed bool
+}
+
+type autosaveResultViewModel struct {
+	Saved       time.Time
+	SavedText   string
+	NewPost     bool
+	BaseVersion int
+}
+
+// addAutosave restores the author's working copy of the post being edited
+// into the form, if there is one, along with the version it was based on. If
+// the author chose a version to edit the form is left alone, and they are
+// told the working copy can be restored.
+func (vm *blogPostEditViewModel) addAutosave(ctx context.Context, a *model.Author, versionSelected bool) error {
+	as, err := model.GetAutosave(ctx, a.GoogleAccountID, vm.PostID)
+	if err == model.ErrorNoMatchingAutosave {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	vm.AutosaveVersion = as.BaseVersion
+	vm.AutosavedAt = as.Saved
+	if versionSelected {
+		vm.AutosavePending = true
+		return nil
+	}
+
+	vm.Autosaved = true
+	if as.PostID != "" {
+		vm.BaseVersion = as.BaseVersion
+	}
+	vm.Title = as.Title
+	vm.Slug = as.Slug
+	vm.BannerImageURL = as.BannerImageURL
+	vm.BodyMarkdown = as.BodyMarkdown
+	vm.CategoryList = as.CategoryList
+	vm.DatePublished = as.DatePublished
+	vm.CommentsClosed = as.CommentsClosed
+	return nil
+}
+
+// discardAutosave discards an author's working copy of a post once a version
+// has been saved. Failures are logged, as the version is already saved.
+func discardAutosave(ctx context.Context, a *model.Author, postID string) {
+	if err := model.DeleteAutosave(ctx, a.GoogleAccountID, postID); err != nil {
+		log.Errorf(ctx, "Unable to discard autosave of %s: %v", postID, err)
+	}
+}
+
+// AdminPostAutosavePOST stores the author's working copy of a post from the
+// editor, replacing any previous one, and responds with JSON.
+func AdminPostAutosavePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(autosaveViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorf("Invalid autosave", http.StatusBadRequest, err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	if viewModel.PostID != "" {
+		if e := checkPostOwner(ctx, author, viewModel.PostID); e != nil {
+			return e
+		}
+	}
+
+	as := &model.Autosave{
+		AuthorID:       author.GoogleAccountID,
+		PostID:         viewModel.PostID,
+		BaseVersion:    viewModel.BaseVersion,
+		Title:          viewModel.Title,
+		Slug:           viewModel.Slug,
+		BannerImageURL: viewModel.BannerImageURL,
+		BodyMarkdown:   viewModel.BodyMarkdown,
+		CategoryList:   viewModel.CategoryList,
+		DatePublished:  viewModel.DatePublished,
+		CommentsClosed: viewModel.CommentsClosed,
+	}
+	if _, err := as.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	json, err := json.Marshal(autosaveResultViewModel{
+		Saved:       as.Saved,
+		SavedText:   as.Saved.Format(env.Config.DateFormatFull),
+		NewPost:     as.PostID == "",
+		BaseVersion: as.BaseVersion,
+	})
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	w.Write(json)
+
+	return nil
+}
+
+// AdminPostAutosaveDiscardPOST discards the author's working copy of a post
+// and returns to the editor.
+func AdminPostAutosaveDiscardPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+	author, _ := env.User.(*model.Author)
+
+	if err := model.DeleteAutosave(ctx, author.GoogleAccountID, id); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	redirectURL := "/admin/post/add"
+	if id != "" {
+		post, err := model.GetLatestBlogPostVersionByID(ctx, id)
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		redirectURL = fmt.Sprintf("/admin/post/edit/%s", post.Slug)
+	}
+
+	flash.AddFlash(w, r, "Unsaved changes discarded")
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	return nil
+}
@@ -1,6 +1,7 @@
 package blog
 
 import (
+	"goblogengine/appenv"
 	"goblogengine/middleware/auth"
 	"goblogengine/middleware/basehandler"
 	"goblogengine/middleware/flashes"
@@ -13,9 +14,41 @@ func Init(r *mux.Router) {
 	r.HandleFunc("/", basehandler.MakeHandler(auth.AddInfo(HomeGET)))
 
 	r.HandleFunc("/page/{pagenumber}", basehandler.MakeHandler(auth.AddInfo(HomeGET)))
-	r.HandleFunc("/post/{postslug}", basehandler.MakeHandler(auth.AddInfo(PostGET)))
+	r.HandleFunc("/category/{categoryslug}", basehandler.MakeHandler(auth.AddInfo(HomeGET)))
+	r.HandleFunc("/category/{categoryslug}/page/{pagenumber}", basehandler.MakeHandler(auth.AddInfo(HomeGET)))
+	r.HandleFunc("/post/{postslug}", basehandler.MakeHandler(auth.AddInfo(flashes.Add(PostGET))))
+	r.HandleFunc("/preview/{linkid}/{expires}/{signature}", basehandler.MakeHandler(auth.AddInfo(flashes.Add(PreviewGET)))).Methods("GET")
+	r.HandleFunc("/post/{postslug}/comment", basehandler.MakeHandler(auth.AddInfo(CommentPOST))).Methods("POST")
 	r.HandleFunc("/image/{imageid}", basehandler.MakeHandler(auth.AddInfo(ServeImageGET)))
 	r.HandleFunc("/atom", AtomGET)
+	r.HandleFunc("/comments/atom", CommentsAtomGET)
+
+	r.HandleFunc("/micropub", MicropubGET).Methods("GET")
+	r.HandleFunc("/micropub", MicropubPOST).Methods("POST")
+	r.HandleFunc("/micropub/media", MicropubMediaPOST).Methods("POST")
+
+	r.HandleFunc("/atompub", AtomPubServiceGET).Methods("GET")
+	r.HandleFunc("/atompub/posts", AtomPubCollectionGET).Methods("GET")
+	r.HandleFunc("/atompub/posts", AtomPubCollectionPOST).Methods("POST")
+	r.HandleFunc("/atompub/posts/{postslug}", AtomPubEntryGET).Methods("GET")
+	r.HandleFunc("/atompub/posts/{postslug}", AtomPubEntryPUT).Methods("PUT")
+	r.HandleFunc("/atompub/posts/{postslug}", AtomPubEntryDELETE).Methods("DELETE")
+	r.HandleFunc("/atompub/media", AtomPubMediaGET).Methods("GET")
+	r.HandleFunc("/atompub/media", AtomPubMediaPOST).Methods("POST")
+	r.HandleFunc("/atompub/media/{imageid}", AtomPubMediaDELETE).Methods("DELETE")
+
+	r.HandleFunc("/webmention", WebmentionPOST).Methods("POST")
+
+	r.HandleFunc("/xmlrpc", XMLRPCPOST).Methods("POST")
+	r.HandleFunc("/rsd.xml", RSDGET).Methods("GET")
+
+	r.HandleFunc(webhookTaskPath, WebhookTaskPOST).Methods("POST")
+	r.HandleFunc(webmentionVerifyTaskPath, WebmentionVerifyTaskPOST).Methods("POST")
+	r.HandleFunc(webmentionSendTaskPath, WebmentionSendTaskPOST).Methods("POST")
+	r.HandleFunc(publishScheduledTaskPath, PublishScheduledTaskGET).Methods("GET")
+	r.HandleFunc(pruneVersionsTaskPath, PruneVersionsTaskGET).Methods("GET")
+	r.HandleFunc(checkPublishedTaskPath, CheckPublishedTaskGET).Methods("GET")
+	r.HandleFunc(purgeTrashTaskPath, PurgeTrashTaskGET).Methods("GET")
 
 	r.HandleFunc("/admin", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminHomeGET))))).Methods("GET")
 
@@ -25,33 +58,109 @@ func Init(r *mux.Router) {
 
 	r.HandleFunc("/admin/post/edit/{postslug}", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminPostEditGET))))).Methods("GET")
 	r.HandleFunc("/admin/post/edit/{postslug}", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminPostEditPOST))))).Methods("POST")
-	r.HandleFunc("/admin/post/publish", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminPostPublishPOST))))).Methods("POST")
-	r.HandleFunc("/admin/post/unpublish", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminPostUnpublishPOST))))).Methods("POST")
-	r.HandleFunc("/admin/post/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminPostDeletePOST))))).Methods("POST")
+	r.HandleFunc("/admin/post/publish", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.PublishPosts, flashes.Add(AdminPostPublishPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/review", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, flashes.Add(AdminPostReviewPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/unpublish", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.PublishPosts, flashes.Add(AdminPostUnpublishPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.PublishPosts, flashes.Add(AdminPostDeletePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/diff/{postslug}", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminPostDiffGET))))).Methods("GET")
+	r.HandleFunc("/admin/post/restore", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, flashes.Add(AdminPostRestorePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/label", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, flashes.Add(AdminPostLabelPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/retention", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminPostRetentionGET)))))).Methods("GET")
+	r.HandleFunc("/admin/post/retention", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminPostRetentionPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/autosave", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, AdminPostAutosavePOST))))).Methods("POST")
+	r.HandleFunc("/admin/post/autosave/discard", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, flashes.Add(AdminPostAutosaveDiscardPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/previewlink", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, flashes.Add(AdminPreviewLinkPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/previewlink/revoke", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, flashes.Add(AdminPreviewLinkRevokePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/post/lock", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, AdminPostLockPOST))))).Methods("POST")
+	r.HandleFunc("/admin/post/unlock", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.WriteDrafts, AdminPostUnlockPOST))))).Methods("POST")
 	r.HandleFunc("/admin/post/preview/{postslug}/{version}", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminPreviewPostVersionGET))))).Methods("GET")
 
+	r.HandleFunc("/admin/page/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPageListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/page/add", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPageEditGET)))))).Methods("GET")
+	r.HandleFunc("/admin/page/add", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPageEditPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/page/edit/{pageid}", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPageEditGET)))))).Methods("GET")
+	r.HandleFunc("/admin/page/edit/{pageid}", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPageEditPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/page/publish", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPagePublishPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/page/unpublish", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPageUnpublishPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/page/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPageDeletePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/page/preview/{pageid}/{version}", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminPagePreviewGET)))))).Methods("GET")
+
+	r.HandleFunc("/admin/menu/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminMenuListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/menu/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminMenuListPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/menu/move", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminMenuMovePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/menu/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminMenuDeletePOST)))))).Methods("POST")
+
 	r.HandleFunc("/admin/author/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminAuthorListGET))))).Methods("GET")
+	r.HandleFunc("/admin/author/role", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminAuthorRolePOST)))))).Methods("POST")
 	r.HandleFunc("/admin/author/add", basehandler.MakeHandler(auth.AddInfo(flashes.Add(AdminAuthorInsertGET)))).Methods("GET")
 	r.HandleFunc("/admin/author/add", basehandler.MakeHandler(auth.AddInfo(flashes.Add(AdminAuthorInsertPOST)))).Methods("POST")
+	r.HandleFunc("/admin/author/join/{token}", basehandler.MakeHandler(auth.AddInfo(flashes.Add(AdminAuthorJoinGET)))).Methods("GET")
+	r.HandleFunc("/admin/author/join/{token}", basehandler.MakeHandler(auth.AddInfo(flashes.Add(AdminAuthorJoinPOST)))).Methods("POST")
+	r.HandleFunc("/admin/author/invite", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminAuthorInvitePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/author/invite/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminInvitationDeletePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/author/edit", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminAuthorEditGET)))))).Methods("GET")
+	r.HandleFunc("/admin/author/edit", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminAuthorEditPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/author/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminAuthorDeletePOST)))))).Methods("POST")
+
+	r.HandleFunc("/admin/token/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminTokenListGET))))).Methods("GET")
+	r.HandleFunc("/admin/token/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminTokenListPOST))))).Methods("POST")
+	r.HandleFunc("/admin/token/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminTokenDeletePOST))))).Methods("POST")
+
+	r.HandleFunc("/admin/webhook/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminWebhookListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/webhook/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminWebhookListPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/webhook/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminWebhookDeletePOST)))))).Methods("POST")
+
+	r.HandleFunc("/admin/comment/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminCommentListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/comment/moderate", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminCommentModeratePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/webmention/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminWebmentionListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/webmention/moderate", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminWebmentionModeratePOST)))))).Methods("POST")
+
+	r.HandleFunc("/admin/redirect/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminRedirectListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/redirect/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminRedirectListPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/redirect/import", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminRedirectImportPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/redirect/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(AdminRedirectDeletePOST)))))).Methods("POST")
+
+	r.HandleFunc("/admin/image/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.UploadImages, flashes.Add(AdminImageListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/image/list.json", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.UploadImages, flashes.Add(AdminImageListJSGET)))))).Methods("GET")
+	r.HandleFunc("/admin/image/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.UploadImages, flashes.Add(AdminImageListPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/image/update", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.UploadImages, AdminImageUpdateJSPOST))))).Methods("POST")
+	r.HandleFunc("/admin/image/upload", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.UploadImages, AdminImageUploadJSPOST))))).Methods("POST")
+	r.HandleFunc("/admin/image/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, AdminImageDeletePOST))))).Methods("POST")
+	r.HandleFunc("/admin/image/deleteall", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, AdminImageDeleteAllPOST))))).Methods("POST")
+
+	r.HandleFunc("/admin/category/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(CategoryListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/category/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(CategoryListPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/trash", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.PublishPosts, flashes.Add(AdminTrashGET)))))).Methods("GET")
+	r.HandleFunc("/admin/trash/restore", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.PublishPosts, flashes.Add(AdminTrashRestorePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/trash/purge", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminTrashPurgePOST)))))).Methods("POST")
+	r.HandleFunc("/admin/category/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageContent, flashes.Add(CategoryDeletePOST)))))).Methods("POST")
+
+	r.HandleFunc("/admin/blog/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminBlogListGET)))))).Methods("GET")
+	r.HandleFunc("/admin/blog/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminBlogListPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/blog/update", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminBlogUpdatePOST)))))).Methods("POST")
+
+	r.HandleFunc("/admin/settings", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminSettingsGET)))))).Methods("GET")
+	r.HandleFunc("/admin/settings", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminSettingsPOST)))))).Methods("POST")
+
+	r.HandleFunc("/admin/diagnostics", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminDiagnosticsGET)))))).Methods("GET")
+	r.HandleFunc("/admin/diagnostics/published", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminCheckPublishedPOST)))))).Methods("POST")
 
-	r.HandleFunc("/admin/image/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminImageListGET))))).Methods("GET")
-	r.HandleFunc("/admin/image/list.json", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminImageListJSGET))))).Methods("GET")
-	r.HandleFunc("/admin/image/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminImageListPOST))))).Methods("POST")
-	r.HandleFunc("/admin/image/update", basehandler.MakeHandler(auth.AddInfo(auth.Require(AdminImageUpdateJSPOST)))).Methods("POST")
-	r.HandleFunc("/admin/image/upload", basehandler.MakeHandler(auth.AddInfo(auth.Require(AdminImageUploadJSPOST)))).Methods("POST")
-	r.HandleFunc("/admin/image/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(AdminImageDeletePOST)))).Methods("POST")
-	r.HandleFunc("/admin/image/deleteall", basehandler.MakeHandler(auth.AddInfo(auth.Require(AdminImageDeleteAllPOST)))).Methods("POST")
+	r.HandleFunc("/admin/data", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminDataGET)))))).Methods("GET")
+	r.HandleFunc("/admin/data", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminImportPostsPOST)))))).Methods("POST")
+	r.HandleFunc("/admin/data/export", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminExportPostsPOST)))))).Methods("POST")
 
-	r.HandleFunc("/admin/category/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(CategoryListGET))))).Methods("GET")
-	r.HandleFunc("/admin/category/list", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(CategoryListPOST))))).Methods("POST")
-	r.HandleFunc("/admin/category/delete", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(CategoryDeletePOST))))).Methods("POST")
+	r.HandleFunc("/admin/reset", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminResetGET)))))).Methods("GET")
+	r.HandleFunc("/admin/reset", basehandler.MakeHandler(auth.AddInfo(auth.Require(auth.Permit(auth.ManageBlog, flashes.Add(AdminResetPOST)))))).Methods("POST")
 
-	r.HandleFunc("/admin/data", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminDataGET))))).Methods("GET")
-	r.HandleFunc("/admin/data", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminImportPostsPOST))))).Methods("POST")
-	r.HandleFunc("/admin/data/export", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminExportPostsPOST))))).Methods("POST")
+	appenv.SetViewModifiers(addMenu)
 
-	r.HandleFunc("/admin/reset", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminResetGET))))).Methods("GET")
-	r.HandleFunc("/admin/reset", basehandler.MakeHandler(auth.AddInfo(auth.Require(flashes.Add(AdminResetPOST))))).Methods("POST")
+	notFoundHandler = redirectOrNotFound(basehandler.MakeHandler(NotFound))
+	r.NotFoundHandler = notFoundHandler
 
-	r.NotFoundHandler = basehandler.MakeHandler(NotFound)
+	// Static pages are served at top-level slugs, so must be routed after
+	// everything else and may not use the first segment of any other route.
+	if err := reservePageSlugs(r); err != nil {
+		panic(err)
+	}
+	r.HandleFunc("/{pageslug}", basehandler.MakeHandler(auth.AddInfo(flashes.Add(PageGET)))).Methods("GET")
 }
@@ -77,12 +77,18 @@ func CategoryListPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWri
 		return basehandler.AppErrorDefault(err)
 	}
 
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Category added", cat.Title, *author)
+	a.Save(ctx)
+	fireCategoryWebhook(ctx, "added", cat)
+
 	flash.AddFlash(w, r, "Category added")
 	http.Redirect(w, r, "/admin/category/list", http.StatusFound)
 	return nil
 }
 
-// CategoryDeletePOST deletes a catgory and removes it from all posts.
+// CategoryDeletePOST moves a category to the trash. Posts keep their copies
+// of it.
 func CategoryDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	slug := r.FormValue("Slug")
 	if len(slug) == 0 {
@@ -91,12 +97,19 @@ func CategoryDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseW
 			nil)
 	}
 
-	err := model.DeleteCategory(ctx, slug)
-	if err != nil {
+	author, _ := env.User.(*model.Author)
+	t, err := model.TrashCategory(ctx, slug, *author)
+	if err == model.ErrorNoMatchingCategory {
+		return basehandler.AppErrorf("Category not found", http.StatusNotFound, err)
+	} else if err != nil {
 		return basehandler.AppErrorDefault(err)
 	}
 
-	flash.AddFlash(w, r, "Category deleted")
+	a := model.NewAudit("Category moved to trash", slug, *author)
+	a.Save(ctx)
+	fireCategoryWebhook(ctx, "deleted", model.Category{Slug: slug})
+
+	addUndo(w, r, t, fmt.Sprintf("Category %s moved to trash", t.Title))
 	http.Redirect(w, r, "/admin/category/list", http.StatusFound)
 	return nil
 }
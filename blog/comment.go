@@ -0,0 +1,347 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/russross/blackfriday"
+
+	"goblogengine/appenv"
+	"goblogengine/atomizer"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/sanitize"
+
+	"goblogengine/external/github.com/gorilla/mux"
+
+	"google.golang.org/appengine/log"
+)
+
+// Limits on the length of submitted comment fields.
+const (
+	commentMaxName = 100
+	commentMaxBody = 5000
+)
+
+// commentStatuses lists the moderation states in the order they are shown in
+// admin.
+var commentStatuses = []string{
+	model.CommentPending,
+	model.CommentApproved,
+	model.CommentSpam,
+	model.CommentRejected,
+}
+
+// commentActions maps moderation form actions onto comment states.
+var commentActions = map[string]string{
+	"approve": model.CommentApproved,
+	"reject":  model.CommentRejected,
+	"spam":    model.CommentSpam,
+}
+
+type commentViewModel struct {
+	// Entity properties
+	ID         string
+	AuthorName string
+	AuthorURL  string
+	Status     string
+	PostTitle  string
+
+	// View properties
+	BodyHTML       template.HTML
+	Created        string
+	CreatedDisplay string
+	URL            string
+	PostURL        string
+}
+
+func (vm *commentViewModel) fromEntity(c *model.Comment, datefFull string, datefShort string) {
+	vm.ID = c.ID
+	vm.AuthorName = c.AuthorName
+	vm.AuthorURL = c.AuthorURL
+	vm.Status = c.Status
+	vm.PostTitle = c.PostTitle
+	vm.BodyHTML = template.HTML(renderComment(c.BodyMarkdown))
+	vm.Created = c.Created.Format(datefFull)
+	vm.CreatedDisplay = c.Created.Format(datefShort)
+	vm.PostURL = fmt.Sprintf("/post/%s", c.PostSlug)
+	vm.URL = fmt.Sprintf("%s#comment-%s", vm.PostURL, c.ID)
+}
+
+// renderComment converts comment Markdown to HTML, removing any markup which
+// is not safe to display.
+func renderComment(md string) string {
+	return sanitize.HTML(string(blackfriday.MarkdownCommon([]byte(md))))
+}
+
+type commentFormViewModel struct {
+	// Entity properties
+	AuthorName   string
+	AuthorEmail  string
+	AuthorURL    string
+	BodyMarkdown string
+
+	// Spam checks: Nickname is hidden from readers so only bots fill it in,
+	// and Rendered is the Unix time the form was displayed.
+	Nickname string
+	Rendered int64
+}
+
+// validate returns a message describing the first problem with the form, or
+// an empty string if it is valid.
+func (vm *commentFormViewModel) validate() string {
+	vm.AuthorName = strings.TrimSpace(vm.AuthorName)
+	vm.AuthorEmail = strings.TrimSpace(vm.AuthorEmail)
+	vm.AuthorURL = strings.TrimSpace(vm.AuthorURL)
+	vm.BodyMarkdown = strings.TrimSpace(vm.BodyMarkdown)
+
+	switch {
+	case vm.AuthorName == "":
+		return "Please enter your name"
+	case len(vm.AuthorName) > commentMaxName:
+		return "Your name is too long"
+	case vm.BodyMarkdown == "":
+		return "Please enter a comment"
+	case len(vm.BodyMarkdown) > commentMaxBody:
+		return fmt.Sprintf("Comments are limited to %d characters", commentMaxBody)
+	case vm.AuthorEmail != "" && !strings.Contains(vm.AuthorEmail, "@"):
+		return "Please enter a valid email address"
+	}
+
+	if vm.AuthorURL != "" {
+		u, err := url.Parse(vm.AuthorURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "Your website must be an http or https URL"
+		}
+	}
+	return ""
+}
+
+type commentStatusViewModel struct {
+	Name    string
+	Count   int
+	URL     string
+	Current bool
+}
+
+type adminCommentListViewModel struct {
+	Status   string
+	Statuses []commentStatusViewModel
+	Comments []commentViewModel
+}
+
+// CommentPOST handles a comment form submission. Comments from readers are
+// held for moderation, or marked as spam if the spam classifier says so;
+// comments from logged in authors are approved immediately.
+func CommentPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	post, err := model.GetBlogPostBySlug(ctx, mux.Vars(r)["postslug"])
+	if err != nil {
+		return basehandler.AppErrorf("Post not found", http.StatusNotFound, err)
+	}
+	if post.CommentsClosed {
+		return basehandler.AppErrorf("Comments are closed on this post",
+			http.StatusForbidden, nil)
+	}
+
+	viewModel := new(commentFormViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	postURL := fmt.Sprintf("/post/%s", post.Slug)
+
+	author, isAuthor := env.User.(*model.Author)
+	if isAuthor {
+		viewModel.AuthorName = author.DisplayName
+		viewModel.AuthorEmail = author.Email
+	}
+	if msg := viewModel.validate(); msg != "" {
+		flash.AddFlash(w, r, msg)
+		http.Redirect(w, r, postURL+"#comment-form", http.StatusFound)
+		return nil
+	}
+
+	c, err := model.NewComment(post)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	c.AuthorName = viewModel.AuthorName
+	c.AuthorEmail = viewModel.AuthorEmail
+	c.AuthorURL = viewModel.AuthorURL
+	c.BodyMarkdown = viewModel.BodyMarkdown
+	c.IPAddress = r.RemoteAddr
+	c.UserAgent = r.UserAgent()
+	if isAuthor {
+		c.Status = model.CommentApproved
+	} else {
+		item := commentSpamItem(ctx, c)
+		item.Referrer = r.Referer()
+		item.FromForm = true
+		item.Honeypot = viewModel.Nickname
+		if viewModel.Rendered > 0 {
+			item.FormAge = time.Since(time.Unix(viewModel.Rendered, 0))
+		}
+		item.Recent = recentSubmissions(ctx, c.IPAddress)
+		if classifySpam(ctx, env, item).Spam {
+			c.Status = model.CommentSpam
+		}
+	}
+
+	if _, err := c.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if c.Status == model.CommentApproved {
+		flash.AddFlash(w, r, "Comment posted")
+		http.Redirect(w, r, postURL+"#comment-"+c.ID, http.StatusFound)
+	} else {
+		flash.AddFlash(w, r, "Thanks, your comment will appear once it has been approved")
+		http.Redirect(w, r, postURL+"#comments", http.StatusFound)
+	}
+	return nil
+}
+
+// AdminCommentListGET displays the comments in a moderation state, pending
+// by default.
+func AdminCommentListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminCommentListViewModel)
+	viewModel.Status = r.FormValue("Status")
+	if viewModel.Status == "" {
+		viewModel.Status = model.CommentPending
+	}
+
+	for _, s := range commentStatuses {
+		n, err := model.GetCommentCountByStatus(ctx, s)
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		viewModel.Statuses = append(viewModel.Statuses, commentStatusViewModel{
+			Name:    s,
+			Count:   n,
+			URL:     "/admin/comment/list?Status=" + s,
+			Current: s == viewModel.Status,
+		})
+	}
+
+	comments, err := model.GetCommentByStatus(ctx, viewModel.Status, 100)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	for i := range comments {
+		c := new(commentViewModel)
+		c.fromEntity(&comments[i], env.Config.DateFormatFull, env.Config.DateFormatShort)
+		viewModel.Comments = append(viewModel.Comments, *c)
+	}
+
+	v := env.View.New("admin/commentlist")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminCommentModeratePOST approves, rejects, marks as spam or deletes
+// comments. A RowAction of the form "action:ID" acts on a single comment,
+// otherwise Action is applied to every selected ID. Marking comments as spam,
+// or approving spam, trains the spam classifier.
+func AdminCommentModeratePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	action := r.PostFormValue("Action")
+	ids := r.PostForm["ID"]
+	if row := r.PostFormValue("RowAction"); row != "" {
+		parts := strings.SplitN(row, ":", 2)
+		if len(parts) != 2 {
+			return basehandler.AppErrorf("Invalid moderation request",
+				http.StatusBadRequest, nil)
+		}
+		action, ids = parts[0], []string{parts[1]}
+	}
+	if len(ids) == 0 {
+		flash.AddFlash(w, r, "No comments selected")
+		http.Redirect(w, r, "/admin/comment/list?Status="+r.PostFormValue("Status"), http.StatusFound)
+		return nil
+	}
+
+	var err error
+	if action == "delete" {
+		err = model.DeleteComment(ctx, ids)
+	} else if status, ok := commentActions[action]; ok {
+		trainCommentSpam(ctx, env, ids, status)
+		err = model.SetCommentStatus(ctx, ids, status)
+	} else {
+		return basehandler.AppErrorf("Invalid moderation action",
+			http.StatusBadRequest, nil)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Comments moderated",
+		fmt.Sprintf("%s %d comment(s)", action, len(ids)), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%d comment(s) updated", len(ids)))
+	http.Redirect(w, r, "/admin/comment/list?Status="+r.PostFormValue("Status"), http.StatusFound)
+	return nil
+}
+
+// CommentsAtomGET returns an Atom feed of recently approved comments.
+func CommentsAtomGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+
+	baseURL := "http://" + env.Config.BaseDomainName
+	feedURL := baseURL + "/comments/atom"
+
+	comments, err := model.GetCommentByStatus(ctx, model.CommentApproved, env.Config.FeedSize)
+	if err != nil {
+		log.Errorf(ctx, "Failure getting comments for atom feed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	f := atomizer.NewFeed(
+		"Comments on "+env.Config.BlogName,
+		"",
+		feedURL,
+		"",
+		baseURL,
+		feedURL)
+
+	for _, c := range comments {
+		f.AddEntry(
+			fmt.Sprintf("%s on %s", c.AuthorName, c.PostTitle),
+			fmt.Sprintf("%s/post/%s#comment-%s", baseURL, c.PostSlug, c.ID),
+			c.PostID+"#comment-"+c.ID,
+			c.Created,
+			c.Created,
+			c.AuthorName,
+			c.AuthorURL,
+			"",
+			renderComment(c.BodyMarkdown))
+	}
+
+	a, err := f.ToAtom()
+	if err != nil {
+		log.Errorf(ctx, "Failure building comments atom feed: %v", err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/atom+xml")
+	w.Write(a)
+}
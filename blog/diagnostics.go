@@ -0,0 +1,145 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"goblogengine/appenv"
+	"goblogengine/envae"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+// checkPublishedTaskPath is requested by cron to repair posts with more than
+// one published version.
+const checkPublishedTaskPath = "/tasks/checkpublished"
+
+// consistencyAuthor is recorded in the audit log as repairing posts.
+var consistencyAuthor = model.Author{DisplayName: "Consistency check"}
+
+type configValueViewModel struct {
+	Key        string
+	Value      string
+	Default    string
+	Secret     bool
+	Overridden bool
+}
+
+type adminDiagnosticsViewModel struct {
+	BlogID    string
+	AppID     string
+	VersionID string
+	DevServer bool
+	Config    []configValueViewModel
+}
+
+// AdminDiagnosticsGET displays the effective configuration, with secrets
+// redacted, noting which values are overridden by the site settings.
+func AdminDiagnosticsGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	effective, err := envae.Dump(env)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	b, err := getBlog(ctx, model.BlogID(ctx))
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	defaults, err := envae.Dump(blogConfig(b))
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	defaultValues := make(map[string]string)
+	for _, d := range defaults {
+		defaultValues[d.Key] = d.Value
+	}
+
+	viewModel := new(adminDiagnosticsViewModel)
+	viewModel.BlogID = b.ID
+	viewModel.AppID = appengine.AppID(ctx)
+	viewModel.VersionID = appengine.VersionID(ctx)
+	viewModel.DevServer = env.HostEnv == appenv.EnvDev
+	for _, v := range effective {
+		d, hasDefault := defaultValues[v.Key]
+		viewModel.Config = append(viewModel.Config, configValueViewModel{
+			Key:        v.Key,
+			Value:      v.Value,
+			Default:    d,
+			Secret:     v.Secret,
+			Overridden: hasDefault && d != v.Value,
+		})
+	}
+
+	v := env.View.New("admin/diagnostics")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// inconsistencySummary describes the posts found by the consistency check.
+func inconsistencySummary(found []model.PublishInconsistency) string {
+	var posts []string
+	for _, f := range found {
+		versions := make([]string, len(f.Published))
+		for i, v := range f.Published {
+			versions[i] = fmt.Sprint(v)
+		}
+		posts = append(posts, fmt.Sprintf("%s (versions %s published, keeping %d)",
+			f.Title, strings.Join(versions, ", "), f.Kept))
+	}
+	return strings.Join(posts, "; ")
+}
+
+// AdminCheckPublishedPOST checks that no post has more than one published
+// version, repairing any found if Repair is set.
+func AdminCheckPublishedPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	repair := r.FormValue("Repair") == "true"
+	found, err := model.CheckPublishedBlogPosts(ctx, repair)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	switch {
+	case len(found) == 0:
+		flash.AddFlash(w, r, "Every post has at most one published version")
+	case repair:
+		author, _ := env.User.(*model.Author)
+		a := model.NewAudit("Published versions repaired", inconsistencySummary(found), *author)
+		a.Save(ctx)
+		flash.AddFlash(w, r, fmt.Sprintf("Repaired %d posts: %s", len(found), inconsistencySummary(found)))
+	default:
+		flash.AddFlash(w, r, fmt.Sprintf("%d posts need repair: %s", len(found), inconsistencySummary(found)))
+	}
+	http.Redirect(w, r, "/admin/diagnostics", http.StatusFound)
+	return nil
+}
+
+// CheckPublishedTaskGET is run by cron to repair the posts of every blog
+// which have more than one published version.
+func CheckPublishedTaskGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+
+	err := forEachBlog(ctx, func(bctx context.Context, config appenv.Config) {
+		found, err := model.CheckPublishedBlogPosts(bctx, true)
+		if err != nil {
+			log.Errorf(ctx, "Unable to check published posts of blog %s: %v", model.BlogID(bctx), err)
+		}
+		if len(found) > 0 {
+			log.Warningf(ctx, "Repaired published posts of blog %s: %s", model.BlogID(bctx), inconsistencySummary(found))
+			a := model.NewAudit("Published versions repaired", inconsistencySummary(found), consistencyAuthor)
+			a.Save(bctx)
+		}
+	})
+	if err != nil {
+		log.Errorf(ctx, "Unable to list blogs: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
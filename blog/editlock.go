@@ -0,0 +1,144 @@
+package blog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+)
+
+type editorViewModel struct {
+	DisplayName string
+	Email       string
+	Opened      time.Time
+	OpenedText  string
+}
+
+// editLockResultViewModel answers the editor's heartbeat with the others
+// editing the post, and the most recent version so that the editor can warn
+// of a conflict before saving.
+type editLockResultViewModel struct {
+	Editors       []editorViewModel
+	LatestVersion int
+	LatestAuthor  string
+}
+
+// conflictViewModel describes a version saved by someone else since the
+// author began editing, and how the author's changes differ from it.
+type conflictViewModel struct {
+	BaseVersion int
+	Latest      postVersionListItemViewModel
+	Fields      []fieldDiffViewModel
+	CompareURL  string
+}
+
+// otherEditors returns the authors other than a who have the post open.
+func otherEditors(ctx context.Context, env *appenv.AppEnv, a *model.Author, postID string) ([]editorViewModel, error) {
+	locks, err := model.GetEditLockByPostID(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+	var editors []editorViewModel
+	for i := range locks {
+		if locks[i].AuthorID == a.GoogleAccountID {
+			continue
+		}
+		editors = append(editors, editorViewModel{
+			DisplayName: locks[i].Author.DisplayName,
+			Email:       locks[i].Author.Email,
+			Opened:      locks[i].Opened,
+			OpenedText:  locks[i].Opened.Format(env.Config.DateFormatFull),
+		})
+	}
+	return editors, nil
+}
+
+// addEditLock takes the author's lock on the post being edited and lists
+// the others editing it.
+func (vm *blogPostEditViewModel) addEditLock(ctx context.Context, env *appenv.AppEnv, a *model.Author) error {
+	if _, err := model.RenewEditLock(ctx, vm.PostID, *a); err != nil {
+		return err
+	}
+	editors, err := otherEditors(ctx, env, a, vm.PostID)
+	vm.Editors = editors
+	return err
+}
+
+// addConflict describes the version saved since the author began editing,
+// and the differences between it and the author's changes. The form is then
+// based on that version, so that saving again keeps the author's changes.
+func (vm *blogPostEditViewModel) addConflict(ctx context.Context, mine *model.BlogPostVersion) error {
+	latest, err := model.GetLatestBlogPostVersionByID(ctx, mine.PostID)
+	if err != nil {
+		return err
+	}
+
+	vm.Conflict = &conflictViewModel{
+		BaseVersion: vm.BaseVersion,
+		Latest:      newPostListItem(latest),
+		CompareURL: fmt.Sprintf("/admin/post/diff/%s?From=%d&To=%d",
+			latest.Slug, vm.BaseVersion, latest.Version),
+	}
+	for _, f := range diffVersions(latest, mine) {
+		if f.Changed {
+			vm.Conflict.Fields = append(vm.Conflict.Fields, f)
+		}
+	}
+	vm.BaseVersion = latest.Version
+	return nil
+}
+
+// AdminPostLockPOST takes or renews the author's advisory lock on a post,
+// and responds with JSON describing the others editing it and its most
+// recent version. The editor calls it as a heartbeat.
+func AdminPostLockPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
+
+	latest, err := model.GetLatestBlogPostVersionByID(ctx, id)
+	if err == model.ErrorNoMatchingPost {
+		return basehandler.AppErrorf("Post not found", http.StatusNotFound, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if _, err := model.RenewEditLock(ctx, id, *author); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	viewModel := editLockResultViewModel{
+		LatestVersion: latest.Version,
+		LatestAuthor:  latest.Author.DisplayName,
+	}
+	viewModel.Editors, err = otherEditors(ctx, &env, author, id)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	json, err := json.Marshal(viewModel)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	w.Write(json)
+
+	return nil
+}
+
+// AdminPostUnlockPOST releases the author's lock on a post when they leave
+// the editor.
+func AdminPostUnlockPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	author, _ := env.User.(*model.Author)
+	if err := model.DeleteEditLock(ctx, author.GoogleAccountID, r.FormValue("PostID")); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 )
 
 type homeViewModel struct {
+	CategoryTitle     string
 	Posts             []postDisplayViewModel
 	PostCount         int
 	CurrentPageNumber int
@@ -36,7 +37,8 @@ type postListViewModel struct {
 	Posts []postDisplayViewModel
 }
 
-// HomeGET displays a paginated list of blog posts.
+// HomeGET displays a paginated list of blog posts, or of the posts in a
+// category if the route has a category slug.
 func HomeGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	vars := mux.Vars(r)
 	var viewModel = new(homeViewModel)
@@ -62,6 +64,27 @@ func HomeGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *h
 	sort.Slice(blogPosts, func(i, j int) bool {
 		return blogPosts[i].DatePublished.After(blogPosts[j].DatePublished)
 	})
+
+	categories, err := model.GetAllCategory(ctx)
+	if err != nil {
+		return basehandler.AppErrorf("Failed getting categories",
+			http.StatusInternalServerError, err)
+	}
+
+	var basePath string
+	if slug, ok := vars["categoryslug"]; ok {
+		for _, category := range categories {
+			if category.Slug == slug {
+				viewModel.CategoryTitle = category.Title
+			}
+		}
+		if viewModel.CategoryTitle == "" {
+			notFoundHandler.ServeHTTP(w, r)
+			return nil
+		}
+		blogPosts = postsInCategory(blogPosts, slug)
+		basePath = fmt.Sprintf("/category/%s", slug)
+	}
 	postCount := len(blogPosts)
 	pageCount := int(math.Ceil(float64(postCount) / float64(env.Config.PostsPerPage)))
 	postOffset = env.Config.PostsPerPage * (pageNum - 1)
@@ -71,14 +94,14 @@ func HomeGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *h
 		n := i + 1
 		viewModel.PageNumbers = append(viewModel.PageNumbers, pageNumbersViewModel{
 			PageNumber: n,
-			URL:        fmt.Sprintf("/page/%d", n),
+			URL:        fmt.Sprintf("%s/page/%d", basePath, n),
 		})
 	}
 	if pageNum > 1 {
-		viewModel.PreviousPageURL = fmt.Sprintf("/page/%d", pageNum-1)
+		viewModel.PreviousPageURL = fmt.Sprintf("%s/page/%d", basePath, pageNum-1)
 	}
 	if pageNum < pageCount {
-		viewModel.NextPageURL = fmt.Sprintf("/page/%d", pageNum+1)
+		viewModel.NextPageURL = fmt.Sprintf("%s/page/%d", basePath, pageNum+1)
 	}
 	viewModel.CurrentPageNumber = pageNum
 
@@ -89,6 +112,11 @@ func HomeGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *h
 			env.Config.DateFormatFull,
 			env.Config.DateFormatShort,
 			env.Config.ExcerptCharLength)
+		n, err := model.GetCommentCountByPostID(ctx, p.PostID)
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		p.CommentCount = n
 		viewModel.Posts = append(viewModel.Posts, *p)
 	}
 	viewModel.PostCount = len(viewModel.Posts)
@@ -105,11 +133,6 @@ func HomeGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *h
 		})
 	}
 
-	categories, err := model.GetAllCategory(ctx)
-	if err != nil {
-		return basehandler.AppErrorf("Failed getting categories",
-			http.StatusInternalServerError, err)
-	}
 	for _, category := range categories {
 		viewModel.Categories = append(viewModel.Categories, categoryViewModel{
 			Title: category.Title,
@@ -125,3 +148,18 @@ func HomeGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *h
 
 	return nil
 }
+
+// postsInCategory returns the posts which are in the category with the
+// supplied slug, keeping their order.
+func postsInCategory(posts []model.BlogPostVersion, slug string) []model.BlogPostVersion {
+	var in []model.BlogPostVersion
+	for i := range posts {
+		for _, c := range posts[i].Categories {
+			if c.Slug == slug {
+				in = append(in, posts[i])
+				break
+			}
+		}
+	}
+	return in
+}
@@ -149,11 +149,15 @@ func AdminImageListPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseW
 			return basehandler.AppErrorDefault(err)
 		}
 
-		_, err = saveImage(ctx, file, author)
+		img, err := saveImage(ctx, file, author)
 		if err != nil {
 			return basehandler.AppErrorDefault(err)
 		}
 
+		a := model.NewAudit("Image uploaded", img.ID, *author)
+		a.Save(ctx)
+		fireImageWebhook(ctx, img)
+
 		count++
 	}
 
@@ -178,6 +182,10 @@ func AdminImageUploadJSPOST(ctx context.Context, env appenv.AppEnv, w http.Respo
 		return basehandler.AppErrorDefault(err)
 	}
 
+	a := model.NewAudit("Image uploaded", metadata.ID, *author)
+	a.Save(ctx)
+	fireImageWebhook(ctx, metadata)
+
 	viewModel := new(imageViewModel)
 	viewModel.fromEntity(env.Config.BaseDomainName, metadata, ImgURLLocal)
 
@@ -223,7 +231,8 @@ func AdminImageUpdateJSPOST(ctx context.Context, env appenv.AppEnv, w http.Respo
 
 }
 
-// AdminImageDeletePOST deletes the specified image from Google Cloud Storage.
+// AdminImageDeletePOST moves the specified image to the trash. It is deleted
+// from Google Cloud Storage when the trash is purged.
 func AdminImageDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	id := r.PostFormValue("id")
 	if id == "" {
@@ -231,31 +240,59 @@ func AdminImageDeletePOST(ctx context.Context, env appenv.AppEnv, w http.Respons
 		return basehandler.AppErrorf("Invalid image delete request",
 			http.StatusBadRequest, nil)
 	}
-	img := model.Image{ID: id}
-	err := csimg.Delete(ctx, img.ID)
-	img.Delete(ctx)
+	img, err := model.GetImageByID(ctx, id)
+	if err != nil {
+		return basehandler.AppErrorf("Image not found", http.StatusNotFound, err)
+	}
+	author, _ := env.User.(*model.Author)
+	t, err := model.TrashImage(ctx, img, *author)
 	if err != nil {
 		return basehandler.AppErrorDefault(err)
 	}
 
-	flash.AddFlash(w, r, fmt.Sprintf("Image with ID %s deleted", id))
+	a := model.NewAudit("Image moved to trash", t.Title, *author)
+	a.Save(ctx)
+
+	addUndo(w, r, t, fmt.Sprintf("Image %s moved to trash", t.Title))
 	http.Redirect(w, r, "/admin/image/list", http.StatusFound)
 	return nil
 }
 
-// AdminImageDeleteAllPOST deletes all blog images from Google Cloud Storage.
+// AdminImageDeleteAllPOST moves all blog images to the trash. They are
+// deleted from Google Cloud Storage when the trash is purged.
 func AdminImageDeleteAllPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
-	count, err := csimg.DeleteAll(ctx)
+	author, _ := env.User.(*model.Author)
+	t, count, err := model.TrashAllImage(ctx, *author)
 	if err != nil {
 		return basehandler.AppErrorDefault(err)
 	}
 
-	model.DeleteAllImage(ctx)
-	if err != nil {
-		return basehandler.AppErrorDefault(err)
+	if t == nil {
+		flash.AddFlash(w, r, "There are no images to delete")
+	} else {
+		a := model.NewAudit("Images moved to trash", t.Title, *author)
+		a.Save(ctx)
+		addUndo(w, r, t, fmt.Sprintf("%d image(s) moved to trash", count))
 	}
-
-	flash.AddFlash(w, r, fmt.Sprintf("%d image(s) deleted", count))
 	http.Redirect(w, r, "/admin/image/list", http.StatusFound)
 	return nil
 }
+
+// deleteAllImages deletes the images of the current blog from Google Cloud
+// Storage and the datastore, leaving those of other blogs in the bucket.
+func deleteAllImages(ctx context.Context) (int, error) {
+	imgs, err := model.GetAllImage(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range imgs {
+		if err := csimg.Delete(ctx, imgs[i].ID); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, model.DeleteAllImage(ctx)
+}
@@ -0,0 +1,429 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/menu"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/view"
+
+	"google.golang.org/appengine/log"
+)
+
+// menusMaxAge is how long an instance uses a blog's built menu before
+// rebuilding it, so that changes saved by other instances are picked up.
+const menusMaxAge = 30 * time.Second
+
+// builtMenu is the navigation menu of a blog, ready to display.
+type builtMenu struct {
+	nodes  []menu.Node
+	loaded time.Time
+}
+
+var menusMutex sync.Mutex
+var menus = make(map[string]*builtMenu)
+
+type menuItemViewModel struct {
+	// Entity properties
+	ID     string
+	Title  string
+	Kind   string
+	Target string
+
+	// View properties
+	URL    string
+	Depth  int
+	Hidden bool
+}
+
+type menuPageViewModel struct {
+	ID    string
+	Title string
+}
+
+type adminMenuListViewModel struct {
+	Items      []menuItemViewModel
+	Pages      []menuPageViewModel
+	Categories []categoryViewModel
+
+	// New entity properties
+	ParentID     string
+	Title        string
+	Kind         string
+	PageID       string
+	CategorySlug string
+	URL          string
+}
+
+// addMenu is a view.ModifyFunc which adds the site navigation menu to every
+// public view. Failures are logged and the view is rendered without a menu.
+func addMenu(w http.ResponseWriter, r *http.Request, v *view.Info) {
+	if strings.HasPrefix(r.URL.Path, "/admin") {
+		return
+	}
+	ctx := appenv.NewContext(r)
+
+	nodes, err := blogMenu(ctx)
+	if err != nil {
+		log.Errorf(ctx, "Unable to load menu: %v", err)
+		return
+	}
+	if len(nodes) > 0 {
+		v.SetMenu(nodes)
+	}
+}
+
+// blogMenu returns the navigation menu of the current blog, building it if
+// this instance has no recent copy. Should rebuilding fail, the previous
+// copy is used.
+func blogMenu(ctx context.Context) ([]menu.Node, error) {
+	id := model.BlogID(ctx)
+	menusMutex.Lock()
+	bm, ok := menus[id]
+	menusMutex.Unlock()
+	if ok && time.Since(bm.loaded) < menusMaxAge {
+		return bm.nodes, nil
+	}
+
+	nodes, err := buildMenu(ctx)
+	if err != nil {
+		if ok {
+			log.Errorf(ctx, "Unable to rebuild menu: %v", err)
+			return bm.nodes, nil
+		}
+		return nil, err
+	}
+
+	menusMutex.Lock()
+	menus[id] = &builtMenu{nodes: nodes, loaded: time.Now()}
+	menusMutex.Unlock()
+	return nodes, nil
+}
+
+// forgetMenu makes this instance rebuild the navigation menu of the current
+// blog on the next request, after the menu or a page has been changed.
+func forgetMenu(ctx context.Context) {
+	menusMutex.Lock()
+	defer menusMutex.Unlock()
+	delete(menus, model.BlogID(ctx))
+}
+
+// buildMenu loads the menu items of the current blog and builds the menu
+// from those which link somewhere.
+func buildMenu(ctx context.Context) ([]menu.Node, error) {
+	items, err := model.GetAllMenuItem(ctx)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	urls, err := menuItemURLs(ctx, items, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var visible []menu.Item
+	for i := range items {
+		if url := urls[items[i].ID]; url != "" {
+			visible = append(visible, menu.Item{
+				ID:       items[i].ID,
+				ParentID: items[i].ParentID,
+				Title:    items[i].Title,
+				URL:      url,
+				Position: items[i].Position,
+			})
+		}
+	}
+	return menu.Build(visible), nil
+}
+
+// menuItemURLs returns the URL each menu item links to, keyed by item ID.
+// Links to pages which are not published have no URL if published is true,
+// and links to pages which no longer exist never do.
+func menuItemURLs(ctx context.Context, items []model.MenuItem, published bool) (map[string]string, error) {
+	pages := make(map[string]model.Page)
+	for i := range items {
+		if items[i].Kind != model.MenuItemPage {
+			continue
+		}
+		all, err := model.GetAllPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range all {
+			if p.Published || !published {
+				pages[p.PageID] = p
+			}
+		}
+		break
+	}
+
+	urls := make(map[string]string)
+	for _, mi := range items {
+		switch mi.Kind {
+		case model.MenuItemPage:
+			if p, ok := pages[mi.Target]; ok {
+				urls[mi.ID] = fmt.Sprintf("/%s", p.Slug)
+			}
+		case model.MenuItemCategory:
+			urls[mi.ID] = fmt.Sprintf("/category/%s", mi.Target)
+		case model.MenuItemURL:
+			urls[mi.ID] = mi.Target
+		}
+	}
+	return urls, nil
+}
+
+func menuItems(items []model.MenuItem) []menu.Item {
+	mis := make([]menu.Item, len(items))
+	for i := range items {
+		mis[i] = menu.Item{
+			ID:       items[i].ID,
+			ParentID: items[i].ParentID,
+			Title:    items[i].Title,
+			Position: items[i].Position,
+		}
+	}
+	return mis
+}
+
+// AdminMenuListGET displays the site navigation menu and a form for adding
+// links to it.
+func AdminMenuListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	items, err := model.GetAllMenuItem(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	published, err := menuItemURLs(ctx, items, true)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	urls, err := menuItemURLs(ctx, items, false)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	byID := make(map[string]model.MenuItem)
+	for _, mi := range items {
+		byID[mi.ID] = mi
+	}
+
+	viewModel := new(adminMenuListViewModel)
+	viewModel.Kind = model.MenuItemPage
+	for _, n := range menu.Flatten(menu.Build(menuItems(items))) {
+		mi := byID[n.ID]
+		viewModel.Items = append(viewModel.Items, menuItemViewModel{
+			ID:     mi.ID,
+			Title:  mi.Title,
+			Kind:   mi.Kind,
+			Target: mi.Target,
+			URL:    urls[mi.ID],
+			Depth:  n.Depth,
+			Hidden: published[mi.ID] == "",
+		})
+	}
+
+	pages, err := model.GetAllPage(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	for i := range pages {
+		viewModel.Pages = append(viewModel.Pages, menuPageViewModel{
+			ID:    pages[i].PageID,
+			Title: pages[i].Title,
+		})
+	}
+
+	cats, err := model.GetAllCategory(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	for i := range cats {
+		viewModel.Categories = append(viewModel.Categories, categoryViewModel{
+			Title: cats[i].Title,
+			Slug:  cats[i].Slug,
+		})
+	}
+
+	v := env.View.New("admin/menulist")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminMenuListPOST adds a link to the end of the menu or of a submenu.
+func AdminMenuListPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminMenuListViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	title := strings.TrimSpace(viewModel.Title)
+	var target string
+	switch viewModel.Kind {
+	case model.MenuItemPage:
+		p, err := model.GetLatestPageVersionByID(ctx, viewModel.PageID)
+		if err == model.ErrorNoMatchingPage {
+			return basehandler.AppErrorf("Please choose a page",
+				http.StatusBadRequest, err)
+		}
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		target = p.PageID
+		if title == "" {
+			title = p.Title
+		}
+	case model.MenuItemCategory:
+		target = viewModel.CategorySlug
+		if target == "" {
+			return basehandler.AppErrorf("Please choose a category",
+				http.StatusBadRequest, nil)
+		}
+	case model.MenuItemURL:
+		target = strings.TrimSpace(viewModel.URL)
+		if !strings.HasPrefix(target, "/") &&
+			!strings.HasPrefix(target, "http://") &&
+			!strings.HasPrefix(target, "https://") {
+			return basehandler.AppErrorf("Links must start with /, http:// or https://",
+				http.StatusBadRequest, nil)
+		}
+	default:
+		return basehandler.AppErrorf("Unknown kind of link",
+			http.StatusBadRequest, nil)
+	}
+	if title == "" {
+		return basehandler.AppErrorf("Please enter a title for the link",
+			http.StatusBadRequest, nil)
+	}
+
+	items, err := model.GetAllMenuItem(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	position := 0
+	parentFound := viewModel.ParentID == ""
+	for _, mi := range items {
+		if mi.ParentID == viewModel.ParentID && mi.Position >= position {
+			position = mi.Position + 1
+		}
+		if mi.ID == viewModel.ParentID {
+			parentFound = true
+		}
+	}
+	if !parentFound {
+		return basehandler.AppErrorf("Parent menu item not found",
+			http.StatusBadRequest, nil)
+	}
+
+	mi, err := model.NewMenuItem(viewModel.ParentID, title, viewModel.Kind, target, position)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if _, err := mi.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetMenu(ctx)
+
+	a := model.NewAudit("Menu item added", mi.Title, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s added to the menu", mi.Title))
+	http.Redirect(w, r, "/admin/menu/list", http.StatusFound)
+	return nil
+}
+
+// AdminMenuMovePOST moves a menu item up or down among its siblings.
+func AdminMenuMovePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	offset := 1
+	if r.FormValue("Direction") == "up" {
+		offset = -1
+	}
+
+	items, err := model.GetAllMenuItem(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	changed, ok := menu.Move(menuItems(items), r.FormValue("ID"), offset)
+	if !ok {
+		return basehandler.AppErrorf("Menu item not found",
+			http.StatusNotFound, nil)
+	}
+
+	positions := make(map[string]int)
+	for _, c := range changed {
+		positions[c.ID] = c.Position
+	}
+	var updated []model.MenuItem
+	for _, mi := range items {
+		if p, ok := positions[mi.ID]; ok {
+			mi.Position = p
+			updated = append(updated, mi)
+		}
+	}
+	if err := model.SaveMenuItems(ctx, updated); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetMenu(ctx)
+
+	http.Redirect(w, r, "/admin/menu/list", http.StatusFound)
+	return nil
+}
+
+// AdminMenuDeletePOST removes a menu item along with its submenu.
+func AdminMenuDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	mi, err := model.GetMenuItem(ctx, r.FormValue("ID"))
+	if err == model.ErrorNoMatchingMenuItem {
+		return basehandler.AppErrorf("Menu item not found",
+			http.StatusNotFound, nil)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	items, err := model.GetAllMenuItem(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	ids := []string{mi.ID}
+	seen := map[string]bool{mi.ID: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range items {
+			if child.ParentID == ids[i] && !seen[child.ID] {
+				seen[child.ID] = true
+				ids = append(ids, child.ID)
+			}
+		}
+	}
+	if err := model.DeleteMenuItems(ctx, ids); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetMenu(ctx)
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Menu item deleted", mi.Title, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s removed from the menu", mi.Title))
+	http.Redirect(w, r, "/admin/menu/list", http.StatusFound)
+	return nil
+}
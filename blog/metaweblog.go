@@ -0,0 +1,476 @@
+package blog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/model"
+	"goblogengine/slug"
+	"goblogengine/webhook"
+	"goblogengine/xmlrpc"
+
+	"google.golang.org/appengine/log"
+)
+
+// XML-RPC fault codes. These follow the codes used by WordPress so that
+// clients show sensible messages.
+const (
+	faultBadRequest     = 400
+	faultInsufficient   = 401
+	faultAuthentication = 403
+	faultNotFound       = 404
+	faultConflict       = 409
+	faultMediaType      = 415
+	faultInternal       = 500
+	faultNoSuchMethod   = -32601
+)
+
+// metaWeblogMethod implements a single XML-RPC method. Returned errors which
+// are not an *xmlrpc.Fault are logged and reported as an internal fault.
+type metaWeblogMethod func(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error)
+
+var metaWeblogMethods = map[string]metaWeblogMethod{
+	"blogger.getUsersBlogs":     bloggerGetUsersBlogs,
+	"blogger.deletePost":        bloggerDeletePost,
+	"metaWeblog.newPost":        metaWeblogNewPost,
+	"metaWeblog.editPost":       metaWeblogEditPost,
+	"metaWeblog.getPost":        metaWeblogGetPost,
+	"metaWeblog.getRecentPosts": metaWeblogGetRecentPosts,
+	"metaWeblog.newMediaObject": metaWeblogNewMediaObject,
+	"metaWeblog.getCategories":  metaWeblogGetCategories,
+}
+
+// XMLRPCPOST handles MetaWeblog and Blogger API calls. Clients authenticate
+// with the author's email address as the username and an access token as the
+// password.
+func XMLRPCPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+
+	c, err := xmlrpc.ParseMethodCall(r.Body)
+	if err != nil {
+		metaWeblogWrite(ctx, w, nil, xmlrpc.Faultf(faultBadRequest, "%v", err))
+		return
+	}
+
+	m, ok := metaWeblogMethods[c.MethodName]
+	if !ok {
+		metaWeblogWrite(ctx, w, nil,
+			xmlrpc.Faultf(faultNoSuchMethod, "unsupported method %s", c.MethodName))
+		return
+	}
+
+	v, err := m(ctx, env, c)
+	metaWeblogWrite(ctx, w, v, err)
+}
+
+// RSDGET returns a Really Simple Discovery document so that clients can find
+// the XML-RPC endpoint from the blog home page.
+func RSDGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	w.Header().Set("Content-Type", "application/rsd+xml; charset=utf-8")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<rsd version="1.0" xmlns="http://archipelago.phrasewise.com/rsd">
+  <service>
+    <engineName>GoBlogEngine</engineName>
+    <homePageLink>%[1]s/</homePageLink>
+    <apis>
+      <api name="MetaWeblog" preferred="true" apiLink="%[1]s/xmlrpc" blogID="%[2]s" />
+      <api name="Blogger" preferred="false" apiLink="%[1]s/xmlrpc" blogID="%[2]s" />
+    </apis>
+  </service>
+</rsd>`, baseURL, slug.Make(env.Config.BlogName))
+}
+
+// bloggerGetUsersBlogs(appkey, username, password) lists the single blog.
+func bloggerGetUsersBlogs(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error) {
+	if _, err := metaWeblogAuthorise(ctx, c, 1, ""); err != nil {
+		return nil, err
+	}
+
+	baseURL := "http://" + env.Config.BaseDomainName
+	return []interface{}{
+		map[string]interface{}{
+			"blogid":   slug.Make(env.Config.BlogName),
+			"blogName": env.Config.BlogName,
+			"url":      baseURL + "/",
+			"xmlrpc":   baseURL + "/xmlrpc",
+			"isAdmin":  true,
+		},
+	}, nil
+}
+
+// bloggerDeletePost(appkey, postid, username, password, publish) moves a post
+// to the trash.
+func bloggerDeletePost(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error) {
+	t, err := metaWeblogAuthorise(ctx, c, 2, model.ScopeDelete)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := metaWeblogFindPost(ctx, c, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := metaWeblogCheckOwner(ctx, t, latest); err != nil {
+		return nil, err
+	}
+
+	if _, err := model.TrashBlogPost(ctx, latest.PostID, t.Author); err != nil {
+		return nil, err
+	}
+
+	a := model.NewAudit("Post moved to trash via XML-RPC", latest.Title, t.Author)
+	a.Save(ctx)
+	firePostWebhook(ctx, webhook.EventPostDeleted, latest)
+
+	return true, nil
+}
+
+// metaWeblogNewPost(blogid, username, password, struct, publish) creates a
+// post and returns its ID, which is the post slug.
+func metaWeblogNewPost(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error) {
+	t, err := metaWeblogAuthorise(ctx, c, 1, model.ScopeCreate)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.Struct(3)
+	if err != nil {
+		return nil, err
+	}
+	publish, err := c.Bool(4)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := new(model.BlogPostVersion)
+	entry.DatePublished = time.Now()
+	applyMetaWeblogStruct(entry, s)
+	entry.Published = publish
+
+	for _, k := range []string{"wp_slug", "mt_basename"} {
+		if v, ok := s[k].(string); ok && entry.Slug == "" {
+			entry.Slug = slug.Make(v)
+		}
+	}
+	if entry.Slug == "" {
+		entry.Slug = slug.Make(entry.Title)
+	}
+	if entry.Slug == "" {
+		return nil, xmlrpc.Faultf(faultBadRequest, "post must have a title or slug")
+	}
+
+	err = createPost(ctx, &env, entry, t.Author)
+	if err == model.ErrorPostSlugAlreadyExists {
+		return nil, xmlrpc.Faultf(faultConflict, "slug %s already in use", entry.Slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := model.NewAudit("Post created via XML-RPC", entry.Title, t.Author)
+	a.Save(ctx)
+	fireSavedPostWebhooks(ctx, nil, entry)
+
+	return entry.Slug, nil
+}
+
+// metaWeblogEditPost(postid, username, password, struct, publish) saves a new
+// version of a post. Fields missing from the struct keep their current
+// values.
+func metaWeblogEditPost(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error) {
+	t, err := metaWeblogAuthorise(ctx, c, 1, model.ScopeUpdate)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := metaWeblogFindPost(ctx, c, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := metaWeblogCheckOwner(ctx, t, latest); err != nil {
+		return nil, err
+	}
+	s, err := c.Struct(3)
+	if err != nil {
+		return nil, err
+	}
+	publish, err := c.Bool(4)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := *latest
+	applyMetaWeblogStruct(&entry, s)
+	entry.Published = publish
+	err = updatePost(ctx, latest, &entry, t.Author)
+	if err == model.ErrorVersionConflict {
+		return nil, xmlrpc.Faultf(faultConflict, "post %s has been edited since it was read", latest.Slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := model.NewAudit("Post updated via XML-RPC", entry.Title, t.Author)
+	a.Save(ctx)
+	fireSavedPostWebhooks(ctx, latest, &entry)
+
+	return true, nil
+}
+
+// metaWeblogGetPost(postid, username, password) returns the latest version of
+// a post.
+func metaWeblogGetPost(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error) {
+	if _, err := metaWeblogAuthorise(ctx, c, 1, ""); err != nil {
+		return nil, err
+	}
+	latest, err := metaWeblogFindPost(ctx, c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return metaWeblogStruct("http://"+env.Config.BaseDomainName, latest), nil
+}
+
+// metaWeblogGetRecentPosts(blogid, username, password, numberOfPosts) returns
+// the latest version of the most recently published posts, drafts included.
+func metaWeblogGetRecentPosts(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error) {
+	if _, err := metaWeblogAuthorise(ctx, c, 1, ""); err != nil {
+		return nil, err
+	}
+	n, err := c.Int(3)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := model.GetAllBlogPost(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].DatePublished.After(posts[j].DatePublished)
+	})
+	if n > 0 && n < len(posts) {
+		posts = posts[:n]
+	}
+
+	baseURL := "http://" + env.Config.BaseDomainName
+	list := []interface{}{}
+	for i := range posts {
+		latest, err := model.GetLatestBlogPostVersion(ctx, posts[i].Slug)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, metaWeblogStruct(baseURL, latest))
+	}
+	return list, nil
+}
+
+// metaWeblogNewMediaObject(blogid, username, password, struct) saves an
+// uploaded image and returns its URL.
+func metaWeblogNewMediaObject(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error) {
+	t, err := metaWeblogAuthorise(ctx, c, 1, model.ScopeMedia)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.Struct(3)
+	if err != nil {
+		return nil, err
+	}
+
+	if mt, _ := s["type"].(string); mt != "" && mt != "image/jpeg" {
+		return nil, xmlrpc.Faultf(faultMediaType, "unsupported media type %s", mt)
+	}
+	bits, ok := s["bits"].([]byte)
+	if !ok || len(bits) == 0 {
+		return nil, xmlrpc.Faultf(faultBadRequest, "bits are required")
+	}
+
+	img, err := saveImage(ctx, bytes.NewReader(bits), &t.Author)
+	if err != nil {
+		return nil, err
+	}
+	if name, _ := s["name"].(string); name != "" {
+		img.Name = name
+		if err := img.Save(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	a := model.NewAudit("Image uploaded via XML-RPC", img.ID, t.Author)
+	a.Save(ctx)
+	fireImageWebhook(ctx, img)
+
+	return map[string]interface{}{
+		"id":   img.ID,
+		"file": img.Name,
+		"url":  "http://" + env.Config.BaseDomainName + img.LocalURL,
+		"type": "image/jpeg",
+	}, nil
+}
+
+// metaWeblogGetCategories(blogid, username, password) lists the blog
+// categories.
+func metaWeblogGetCategories(ctx context.Context, env appenv.AppEnv, c *xmlrpc.MethodCall) (interface{}, error) {
+	if _, err := metaWeblogAuthorise(ctx, c, 1, ""); err != nil {
+		return nil, err
+	}
+
+	cats, err := model.GetAllCategory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := "http://" + env.Config.BaseDomainName
+	list := []interface{}{}
+	for i := range cats {
+		list = append(list, map[string]interface{}{
+			"categoryId":   cats[i].Slug,
+			"categoryName": cats[i].Title,
+			"title":        cats[i].Title,
+			"description":  cats[i].Title,
+			"htmlUrl":      baseURL + "/",
+			"rssUrl":       baseURL + "/atom",
+		})
+	}
+	return list, nil
+}
+
+// metaWeblogAuthorise checks the username and password parameters, which
+// start at index i. The password is an access token, and the username must
+// be the email address of the author the token was issued to. If scope is not
+// empty the token must have been granted that scope.
+func metaWeblogAuthorise(ctx context.Context, c *xmlrpc.MethodCall, i int, scope string) (*model.AccessToken, error) {
+	username, err := c.String(i)
+	if err != nil {
+		return nil, err
+	}
+	password, err := c.String(i + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := authoriseToken(ctx, password, scope)
+	if err == model.ErrorNoMatchingAccessToken {
+		return nil, xmlrpc.Faultf(faultAuthentication, "incorrect username or password")
+	}
+	if err == errorInsufficientScope {
+		return nil, xmlrpc.Faultf(faultInsufficient, "token does not have the %s scope", scope)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if model.NormalEmail(t.Author.Email) != model.NormalEmail(username) {
+		return nil, xmlrpc.Faultf(faultAuthentication, "incorrect username or password")
+	}
+	return t, nil
+}
+
+// metaWeblogFindPost returns the latest version of the post whose ID is
+// parameter i.
+func metaWeblogFindPost(ctx context.Context, c *xmlrpc.MethodCall, i int) (*model.BlogPostVersion, error) {
+	postid, err := c.String(i)
+	if err != nil {
+		return nil, err
+	}
+	post, err := model.GetLatestBlogPostVersion(ctx, postid)
+	if err == model.ErrorNoMatchingPost {
+		return nil, xmlrpc.Faultf(faultNotFound, "post %s not found", postid)
+	}
+	return post, err
+}
+
+// metaWeblogCheckOwner returns a fault unless the author the token was issued
+// to may change the post.
+func metaWeblogCheckOwner(ctx context.Context, t *model.AccessToken, p *model.BlogPostVersion) error {
+	ok, err := canChangePost(ctx, &t.Author, p.PostID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return xmlrpc.Faultf(faultAuthentication, "only the post's author or an editor can change it")
+	}
+	return nil
+}
+
+// metaWeblogStruct maps a BlogPostVersion onto a MetaWeblog post struct.
+func metaWeblogStruct(baseURL string, p *model.BlogPostVersion) map[string]interface{} {
+	cats := []interface{}{}
+	for i := range p.Categories {
+		cats = append(cats, p.Categories[i].Title)
+	}
+	status := "publish"
+	if !p.Published {
+		status = "draft"
+	}
+	link := fmt.Sprintf("%s/post/%s", baseURL, p.Slug)
+
+	return map[string]interface{}{
+		"postid":      p.Slug,
+		"title":       p.Title,
+		"description": p.BodyMarkdown,
+		"categories":  cats,
+		"dateCreated": p.DatePublished,
+		"link":        link,
+		"permaLink":   link,
+		"userid":      p.Author.Slug,
+		"wp_slug":     p.Slug,
+		"post_status": status,
+	}
+}
+
+// applyMetaWeblogStruct maps the fields present in a MetaWeblog post struct
+// onto a BlogPostVersion. The slug and post ID are never changed.
+func applyMetaWeblogStruct(ver *model.BlogPostVersion, s map[string]interface{}) {
+	if v, ok := s["title"].(string); ok {
+		ver.Title = v
+	}
+	if v, ok := s["description"].(string); ok {
+		ver.BodyMarkdown = v
+	}
+	if v, ok := s["dateCreated"].(time.Time); ok && !v.IsZero() {
+		ver.DatePublished = v
+	}
+
+	if v, ok := s["categories"].([]interface{}); ok {
+		ver.Categories = nil
+		for i := range v {
+			c, _ := v[i].(string)
+			c = strings.TrimSpace(c)
+			if c == "" {
+				continue
+			}
+			ver.Categories = append(ver.Categories, model.Category{
+				Title: c,
+				Slug:  slug.Make(c),
+			})
+		}
+	}
+}
+
+// metaWeblogWrite writes a method response, or a fault if err is not nil.
+// Unexpected errors are logged and reported without detail.
+func metaWeblogWrite(ctx context.Context, w http.ResponseWriter, v interface{}, err error) {
+	var body []byte
+	if err == nil {
+		body, err = xmlrpc.MarshalResponse(v)
+	}
+	if err != nil {
+		f, ok := err.(*xmlrpc.Fault)
+		if !ok {
+			log.Errorf(ctx, "XML-RPC request failed: %v", err)
+			f = xmlrpc.Faultf(faultInternal, "internal error")
+		}
+		body = xmlrpc.MarshalFault(f)
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write(body)
+}
@@ -0,0 +1,467 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/micropub"
+	"goblogengine/model"
+	"goblogengine/slug"
+	"goblogengine/webhook"
+	"goblogengine/workflow"
+
+	"google.golang.org/appengine/log"
+)
+
+// MicropubGET answers Micropub queries: q=config, q=source, q=category and
+// q=syndicate-to.
+func MicropubGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	token, err := micropubAuthorise(ctx, micropubToken(r), "")
+	if err != nil {
+		micropubError(ctx, w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	switch q.Get("q") {
+	case "config":
+		cats, err := micropubCategories(ctx)
+		if err != nil {
+			micropubError(ctx, w, err)
+			return
+		}
+		micropub.WriteJSON(w, http.StatusOK, micropub.Config{
+			MediaEndpoint: baseURL + "/micropub/media",
+			SyndicateTo:   []struct{}{},
+			Categories:    cats,
+		})
+	case "syndicate-to":
+		micropub.WriteJSON(w, http.StatusOK, map[string][]struct{}{
+			"syndicate-to": {},
+		})
+	case "category":
+		cats, err := micropubCategories(ctx)
+		if err != nil {
+			micropubError(ctx, w, err)
+			return
+		}
+		micropub.WriteJSON(w, http.StatusOK, map[string][]string{
+			"categories": cats,
+		})
+	case "source":
+		post, err := micropubSourcePost(ctx, token, q.Get("url"))
+		if err != nil {
+			micropubError(ctx, w, err)
+			return
+		}
+		props := micropubProperties(baseURL, post)
+		micropub.WriteJSON(w, http.StatusOK,
+			micropub.NewSource(props, q["properties[]"]))
+	default:
+		micropubError(ctx, w, micropub.ErrorInvalidRequest("unsupported query"))
+	}
+}
+
+// MicropubPOST handles Micropub create, update, delete and undelete requests.
+// Every change creates a new BlogPostVersion so the version history of the
+// post is preserved.
+//
+// Micropub deletes unpublish the post rather than removing it so that they
+// can be reversed with an undelete, which publishes the version that was
+// published when the post was deleted.
+func MicropubPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	req, err := micropub.ParseRequest(r)
+	if err != nil {
+		micropubError(ctx, w, err)
+		return
+	}
+
+	token, err := micropubAuthorise(ctx, req.AccessToken, req.RequiredScope())
+	if err != nil {
+		micropubError(ctx, w, err)
+		return
+	}
+
+	switch req.Action {
+	case micropub.ActionCreate:
+		err = micropubCreate(ctx, env, w, r, req, token)
+	case micropub.ActionUpdate:
+		err = micropubUpdate(ctx, baseURL, w, req, token)
+	case micropub.ActionDelete, micropub.ActionUndelete:
+		err = micropubDelete(ctx, w, req, token)
+	}
+	if err != nil {
+		micropubError(ctx, w, err)
+	}
+}
+
+// MicropubMediaPOST handles an image upload to the Micropub media endpoint.
+func MicropubMediaPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+	baseURL := "http://" + env.Config.BaseDomainName
+
+	t, err := micropubAuthorise(ctx, micropubToken(r), model.ScopeMedia)
+	if err != nil {
+		micropubError(ctx, w, err)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		micropubError(ctx, w, micropub.ErrorInvalidRequest("file is required"))
+		return
+	}
+	defer file.Close()
+
+	img, err := saveImage(ctx, file, &t.Author)
+	if err != nil {
+		micropubError(ctx, w, err)
+		return
+	}
+
+	a := model.NewAudit("Image uploaded via Micropub", img.ID, t.Author)
+	a.Save(ctx)
+	fireImageWebhook(ctx, img)
+
+	w.Header().Set("Location", baseURL+img.LocalURL)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func micropubCreate(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request, req *micropub.Request, token *model.AccessToken) error {
+	if req.Type != "h-entry" {
+		return micropub.ErrorInvalidRequest("only h-entry is supported")
+	}
+
+	// Photos may be uploaded with the request rather than via the media
+	// endpoint
+	if r.MultipartForm != nil {
+		for _, name := range []string{"photo", "photo[]"} {
+			for _, fh := range r.MultipartForm.File[name] {
+				f, err := fh.Open()
+				if err != nil {
+					return err
+				}
+				img, err := saveImage(ctx, f, &token.Author)
+				f.Close()
+				if err != nil {
+					return err
+				}
+				req.Properties["photo"] = append(req.Properties["photo"],
+					"http://"+env.Config.BaseDomainName+img.LocalURL)
+			}
+		}
+	}
+
+	entry := new(model.BlogPostVersion)
+	entry.DatePublished = time.Now()
+	entry.Published = true
+	if err := applyMicropubProperties(entry, req.Properties); err != nil {
+		return err
+	}
+
+	entry.Slug = slug.Make(req.Properties.String("mp-slug"))
+	if entry.Slug == "" {
+		entry.Slug = slug.Make(entry.Title)
+	}
+	if entry.Slug == "" {
+		entry.Slug = entry.DatePublished.Format("2006-01-02-150405")
+	}
+
+	err := createPost(ctx, &env, entry, token.Author)
+	if err == model.ErrorPostSlugAlreadyExists {
+		return micropub.ErrorInvalidRequest("slug already in use")
+	}
+	if err != nil {
+		return err
+	}
+
+	a := model.NewAudit("Post created via Micropub", entry.Title, token.Author)
+	a.Save(ctx)
+	fireSavedPostWebhooks(ctx, nil, entry)
+
+	w.Header().Set("Location",
+		fmt.Sprintf("http://%s/post/%s", env.Config.BaseDomainName, entry.Slug))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func micropubUpdate(ctx context.Context, baseURL string, w http.ResponseWriter, req *micropub.Request, token *model.AccessToken) error {
+	latest, err := micropubFindPost(ctx, req.URL)
+	if err != nil {
+		return err
+	}
+	if err := micropubCheckOwner(ctx, token, latest); err != nil {
+		return err
+	}
+
+	props := req.Apply(micropubProperties(baseURL, latest))
+
+	entry := *latest
+	if err := applyMicropubProperties(&entry, props); err != nil {
+		return err
+	}
+	err = updatePost(ctx, latest, &entry, token.Author)
+	if err == model.ErrorVersionConflict {
+		return micropub.ErrorInvalidRequest("post has been edited since it was read")
+	}
+	if err != nil {
+		return err
+	}
+
+	a := model.NewAudit("Post updated via Micropub", entry.Title, token.Author)
+	a.Save(ctx)
+	fireSavedPostWebhooks(ctx, latest, &entry)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func micropubDelete(ctx context.Context, w http.ResponseWriter, req *micropub.Request, token *model.AccessToken) error {
+	latest, err := micropubFindPost(ctx, req.URL)
+	if err != nil {
+		return err
+	}
+	if err := micropubCheckOwner(ctx, token, latest); err != nil {
+		return err
+	}
+
+	post := latest
+	var action, event string
+	if req.Action == micropub.ActionDelete {
+		action = "Post deleted via Micropub"
+		event = webhook.EventPostUnpublished
+		err = model.UnpublishBlogPost(ctx, latest.PostID)
+	} else {
+		action = "Post undeleted via Micropub"
+		event = webhook.EventPostPublished
+		post, err = micropubDeletedVersion(ctx, latest.PostID)
+		if err != nil {
+			return err
+		}
+		err = model.PublishBlogPostVersion(ctx, post.PostID, post.Version)
+	}
+	if te, ok := err.(*workflow.TransitionError); ok {
+		return micropub.ErrorInvalidRequest(te.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	a := model.NewAudit(action, post.Title, token.Author)
+	a.Save(ctx)
+	firePostWebhook(ctx, event, post)
+	if event == webhook.EventPostPublished {
+		queueWebmentions(ctx, post)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// micropubDeletedVersion returns the version of a deleted post which an
+// undelete restores: the one which was published when the post was deleted.
+func micropubDeletedVersion(ctx context.Context, id string) (*model.BlogPostVersion, error) {
+	if _, err := model.GetPublishedBlogPostByID(ctx, id); err == nil {
+		return nil, micropub.ErrorInvalidRequest("post is not deleted")
+	} else if err != model.ErrorNoMatchingPost {
+		return nil, err
+	}
+
+	ver, err := model.GetLastUnpublishedBlogPostVersion(ctx, id)
+	if err == model.ErrorNoMatchingPost {
+		return nil, micropub.ErrorInvalidRequest("post has no deleted version to restore")
+	}
+	return ver, err
+}
+
+// micropubToken returns the access token supplied with a Micropub request,
+// which clients may also send as the access_token parameter.
+func micropubToken(r *http.Request) string {
+	if token := requestToken(r); token != "" {
+		return token
+	}
+	return r.FormValue("access_token")
+}
+
+// micropubAuthorise returns the AccessToken matching the supplied value. If
+// scope is not empty the token must have been granted that scope.
+func micropubAuthorise(ctx context.Context, token string, scope string) (*model.AccessToken, error) {
+	if token == "" {
+		return nil, micropub.ErrorUnauthorized("no access token supplied")
+	}
+
+	t, err := model.GetAccessToken(ctx, token)
+	if err == model.ErrorNoMatchingAccessToken {
+		return nil, micropub.ErrorForbidden("invalid access token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if scope != "" && !t.HasScope(scope) {
+		return nil, micropub.ErrorInsufficientScope(scope)
+	}
+	err = refreshTokenAuthor(ctx, t, scope)
+	if err == model.ErrorNoMatchingAccessToken {
+		return nil, micropub.ErrorForbidden("invalid access token")
+	}
+	if err == errorInsufficientScope {
+		return nil, micropub.ErrorInsufficientScope(scope)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// micropubFindPost returns the most recent version of the post at the
+// supplied URL.
+func micropubFindPost(ctx context.Context, postURL string) (*model.BlogPostVersion, error) {
+	u, err := url.Parse(postURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/post/") {
+		return nil, micropub.ErrorInvalidRequest("url is not a post")
+	}
+
+	post, err := model.GetLatestBlogPostVersion(ctx, strings.TrimPrefix(u.Path, "/post/"))
+	if err == model.ErrorNoMatchingPost {
+		return nil, micropub.ErrorNotFound("post not found")
+	}
+	return post, err
+}
+
+// micropubSourcePost returns the version of a post described by a q=source
+// query. Tokens which may update the post see its latest version, drafts
+// included; other tokens only see the published version.
+func micropubSourcePost(ctx context.Context, t *model.AccessToken, postURL string) (*model.BlogPostVersion, error) {
+	latest, err := micropubFindPost(ctx, postURL)
+	if err != nil {
+		return nil, err
+	}
+	if t.HasScope(model.ScopeUpdate) {
+		ok, err := canChangePost(ctx, &t.Author, latest.PostID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return latest, nil
+		}
+	}
+
+	post, err := model.GetPublishedBlogPostByID(ctx, latest.PostID)
+	if err == model.ErrorNoMatchingPost {
+		return nil, micropub.ErrorNotFound("post not found")
+	}
+	return post, err
+}
+
+// micropubCheckOwner returns an error unless the author the token was issued
+// to may change the post.
+func micropubCheckOwner(ctx context.Context, t *model.AccessToken, p *model.BlogPostVersion) error {
+	ok, err := canChangePost(ctx, &t.Author, p.PostID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return micropub.ErrorForbidden("only the post's author or an editor can change it")
+	}
+	return nil
+}
+
+func micropubCategories(ctx context.Context) ([]string, error) {
+	cats, err := model.GetAllCategory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	titles := []string{}
+	for i := range cats {
+		titles = append(titles, cats[i].Title)
+	}
+	return titles, nil
+}
+
+// micropubProperties maps a BlogPostVersion onto h-entry properties.
+func micropubProperties(baseURL string, p *model.BlogPostVersion) micropub.Properties {
+	props := make(micropub.Properties)
+	props.Set("name", p.Title)
+	props.Set("content", p.BodyMarkdown)
+	props.Set("photo", p.BannerImageURL)
+	props.Set("published", p.DatePublished.Format(time.RFC3339))
+	props.Set("url", fmt.Sprintf("%s/post/%s", baseURL, p.Slug))
+	for i := range p.Categories {
+		props["category"] = append(props["category"], p.Categories[i].Title)
+	}
+	if p.Published {
+		props.Set("post-status", "published")
+	} else {
+		props.Set("post-status", "draft")
+	}
+	return props
+}
+
+// applyMicropubProperties maps h-entry properties onto a BlogPostVersion. The
+// slug and post ID are never changed.
+func applyMicropubProperties(ver *model.BlogPostVersion, props micropub.Properties) error {
+	ver.Title = props.String("name")
+	ver.BodyMarkdown = props.Content()
+
+	ver.BannerImageURL = ""
+	if photos := props["photo"]; len(photos) > 0 {
+		switch p := photos[0].(type) {
+		case string:
+			ver.BannerImageURL = p
+		case map[string]interface{}:
+			ver.BannerImageURL, _ = p["value"].(string)
+		}
+	}
+
+	if published := props.String("published"); published != "" {
+		d, err := time.Parse(time.RFC3339, published)
+		if err != nil {
+			return micropub.ErrorInvalidRequest("invalid published date")
+		}
+		ver.DatePublished = d
+	}
+
+	switch props.String("post-status") {
+	case "draft":
+		ver.Published = false
+	case "published":
+		ver.Published = true
+	}
+
+	ver.Categories = nil
+	for _, c := range props.Strings("category") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		ver.Categories = append(ver.Categories, model.Category{
+			Title: c,
+			Slug:  slug.Make(c),
+		})
+	}
+
+	return nil
+}
+
+// micropubError writes a Micropub error response, logging unexpected errors.
+func micropubError(ctx context.Context, w http.ResponseWriter, err error) {
+	if _, ok := err.(*micropub.Error); !ok {
+		log.Errorf(ctx, "Micropub request failed: %v", err)
+	}
+	micropub.WriteError(w, err)
+}
@@ -12,3 +12,9 @@ import (
 func NotFound(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	return basehandler.AppErrorf("Page not found", http.StatusNotFound, nil)
 }
+
+// Gone displays a "Page removed" message for pages which have been
+// deliberately removed.
+func Gone(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	return basehandler.AppErrorf("Page removed", http.StatusGone, nil)
+}
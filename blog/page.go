@@ -0,0 +1,461 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/russross/blackfriday"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/slug"
+
+	"goblogengine/external/github.com/gorilla/mux"
+)
+
+// reservedPageSlugs holds the first path segment of every built-in route,
+// which pages may not use as their slug. It is filled in by Init.
+var reservedPageSlugs = map[string]bool{
+	"static": true,
+}
+
+// reservedPageSlugPrefixes are prefixes which pages may not use, because
+// app.yaml sends every path starting with them to a login-protected handler.
+var reservedPageSlugPrefixes = []string{"admin"}
+
+// pageSlugReserved reports whether a page may not use a slug.
+func pageSlugReserved(s string) bool {
+	if reservedPageSlugs[s] {
+		return true
+	}
+	for _, prefix := range reservedPageSlugPrefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// notFoundHandler handles requests for post and page slugs which match no
+// post or page.
+var notFoundHandler http.Handler
+
+// reservePageSlugs records the first path segment of each route registered
+// with r so that pages cannot shadow them.
+func reservePageSlugs(r *mux.Router) error {
+	return r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		segment := strings.SplitN(strings.TrimPrefix(tpl, "/"), "/", 2)[0]
+		if segment != "" && !strings.HasPrefix(segment, "{") {
+			reservedPageSlugs[segment] = true
+		}
+		return nil
+	})
+}
+
+// pageDisplayViewModel represents the user facing data for displaying a page.
+type pageDisplayViewModel struct {
+	// Entity properties
+	Slug  string
+	Title string
+
+	// View properties
+	URL      string
+	BodyHTML template.HTML
+	EditURL  string
+}
+
+func (vm *pageDisplayViewModel) fromEntity(p *model.Page) {
+	vm.Slug = p.Slug
+	vm.Title = p.Title
+	vm.URL = fmt.Sprintf("/%s", p.Slug)
+	vm.EditURL = fmt.Sprintf("/admin/page/edit/%s", p.PageID)
+	vm.BodyHTML = template.HTML(blackfriday.MarkdownCommon([]byte(p.BodyMarkdown)))
+}
+
+// PageGET displays a published page. Requests for a slug the page used to
+// have are permanently redirected to its current slug, and requests matching
+// no page are passed to the not found handler so that redirect rules apply.
+func PageGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	vars := mux.Vars(r)
+
+	page, err := model.GetPageBySlug(ctx, vars["pageslug"])
+	if err == model.ErrorNoMatchingPage {
+		if current := currentPageSlug(ctx, vars["pageslug"]); current != "" {
+			http.Redirect(w, r, fmt.Sprintf("/%s", current), http.StatusMovedPermanently)
+			return nil
+		}
+		notFoundHandler.ServeHTTP(w, r)
+		return nil
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	viewModel := new(pageDisplayViewModel)
+	viewModel.fromEntity(page)
+
+	v := env.View.New("page")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// currentPageSlug returns the slug of the published version of the page which
+// has used slug, or an empty string if there is no such page or it is already
+// published under slug.
+func currentPageSlug(ctx context.Context, slug string) string {
+	id, err := model.GetPageIDBySlug(ctx, slug)
+	if err != nil {
+		return ""
+	}
+	page, err := model.GetPublishedPageByID(ctx, id)
+	if err != nil || page.Slug == slug {
+		return ""
+	}
+	return page.Slug
+}
+
+// AdminPageListGET displays a list of published and unpublished pages.
+func AdminPageListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminPostListViewModel)
+
+	pages, err := model.GetAllPage(ctx)
+	if err != nil {
+		return basehandler.AppErrorf("Unable to retrieve page list",
+			http.StatusInternalServerError, err)
+	}
+
+	for _, p := range pages {
+		listItem := pageVersionListItem(&p)
+		if p.Published {
+			viewModel.Posts = append(viewModel.Posts, listItem)
+		} else {
+			viewModel.Drafts = append(viewModel.Drafts, listItem)
+		}
+	}
+
+	sort.Slice(viewModel.Posts, func(i, j int) bool {
+		return viewModel.Posts[i].Title < viewModel.Posts[j].Title
+	})
+
+	sort.Slice(viewModel.Drafts, func(i, j int) bool {
+		return viewModel.Drafts[i].DateCreated.After(viewModel.Drafts[j].DateCreated)
+	})
+
+	v := env.View.New("admin/pagelist")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+func pageVersionListItem(p *model.Page) postVersionListItemViewModel {
+	return postVersionListItemViewModel{
+		PostID:      p.PageID,
+		Version:     p.Version,
+		Title:       p.Title,
+		DateCreated: p.DateCreated,
+		EditURL:     fmt.Sprintf("/admin/page/edit/%s?SelectedVersion=%d", p.PageID, p.Version),
+		PreviewURL:  fmt.Sprintf("/admin/page/preview/%s/%d", p.PageID, p.Version),
+		PostURL:     fmt.Sprintf("/%s", p.Slug),
+		Published:   p.Published,
+	}
+}
+
+func (vm *blogPostEditViewModel) addPageVersions(pages []model.Page) {
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Version < pages[j].Version
+	})
+	for i := range pages {
+		vm.Versions = append(vm.Versions, pageVersionListItem(&pages[i]))
+	}
+	vm.VersionCount = len(vm.Versions)
+}
+
+// addPreviousPageSlugs lists the slugs the page has used other than the one
+// being edited.
+func (vm *blogPostEditViewModel) addPreviousPageSlugs(pages []model.Page) {
+	seen := map[string]bool{vm.Slug: true}
+	for i := range pages {
+		if !seen[pages[i].Slug] {
+			seen[pages[i].Slug] = true
+			vm.PreviousSlugs = append(vm.PreviousSlugs, pages[i].Slug)
+		}
+	}
+}
+
+// AdminPageEditGET displays the page editor, for a new page or for a version
+// of an existing one.
+func AdminPageEditGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := newPageEditViewModel()
+
+	if id, valid := mux.Vars(r)["pageid"]; valid { // editing existing page
+		if err := r.ParseForm(); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		if err := env.FormDecoder.Decode(viewModel, r.Form); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+
+		pages, err := model.GetPageVersionByID(ctx, id)
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		if len(pages) == 0 {
+			return basehandler.AppErrorf("Page not found", http.StatusNotFound, nil)
+		}
+		viewModel.addPageVersions(pages)
+
+		// Edit the selected version, or the most recent if none is selected
+		versionToEdit := &pages[len(pages)-1]
+		if _, valid := r.Form["SelectedVersion"]; valid {
+			for i := range pages {
+				if pages[i].Version == viewModel.SelectedVersion {
+					versionToEdit = &pages[i]
+					break
+				}
+			}
+		}
+		viewModel.PostID = versionToEdit.PageID
+		viewModel.Slug = versionToEdit.Slug
+		viewModel.Title = versionToEdit.Title
+		viewModel.BodyMarkdown = versionToEdit.BodyMarkdown
+		viewModel.Version = versionToEdit.Version
+		viewModel.addPreviousPageSlugs(pages)
+
+	} else {
+		viewModel.NewPost = true
+	}
+
+	v := env.View.New("admin/postedit", "admin/_diff")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminPageEditPOST handles a page edit form submission, adding a new version
+// of the page.
+func AdminPageEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := newPageEditViewModel()
+	viewModel.ValidationErrors = make(map[string]string)
+
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.Form); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	if strings.TrimSpace(viewModel.Title) == "" {
+		viewModel.ValidationErrors["Title"] = "Please enter a title"
+	}
+	if viewModel.Slug == "" {
+		viewModel.Slug = viewModel.Title
+	}
+	viewModel.Slug = slug.Make(viewModel.Slug)
+	if pageSlugReserved(viewModel.Slug) {
+		viewModel.ValidationErrors["Slug"] = "That URL is used by the blog, try another"
+	}
+	if viewModel.PostID == "" {
+		viewModel.NewPost = true
+		id, err := model.NewPageID()
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		viewModel.PostID = id
+	}
+
+	page := &model.Page{
+		PageID:       viewModel.PostID,
+		Slug:         viewModel.Slug,
+		Title:        viewModel.Title,
+		BodyMarkdown: viewModel.BodyMarkdown,
+		DateCreated:  time.Now(),
+		Published:    viewModel.PublishImmediately,
+		Author:       *author,
+	}
+
+	if len(viewModel.ValidationErrors) == 0 {
+		_, err := page.Save(ctx, viewModel.NewPost)
+		if err == model.ErrorPageSlugAlreadyExists {
+			viewModel.ValidationErrors["Slug"] = "That custom URL is already in use, try another"
+		} else if err != nil {
+			return basehandler.AppErrorf("Unable to save page",
+				http.StatusInternalServerError, err)
+		}
+	}
+
+	if len(viewModel.ValidationErrors) > 0 {
+		if !viewModel.NewPost {
+			pages, err := model.GetPageVersionByID(ctx, viewModel.PostID)
+			if err != nil {
+				return basehandler.AppErrorDefault(err)
+			}
+			viewModel.addPageVersions(pages)
+		}
+
+		v := env.View.New("admin/postedit", "admin/_diff")
+		v.Data = viewModel
+		if err := v.Render(ctx, w, r); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		return nil
+	}
+
+	forgetMenu(ctx)
+
+	a := model.NewAudit("Page saved", page.Title, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, "Page updated")
+	http.Redirect(w, r, fmt.Sprintf("/admin/page/edit/%s", page.PageID), http.StatusFound)
+
+	return nil
+}
+
+// AdminPagePublishPOST publishes a page with a specified ID and version.
+func AdminPagePublishPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+
+	version, err := strconv.Atoi(r.FormValue("Version"))
+	if err != nil {
+		return basehandler.AppErrorf("Invalid version number",
+			http.StatusBadRequest, err)
+	}
+
+	page, err := model.GetPageVersion(ctx, id, version)
+	if err == model.ErrorNoMatchingPage {
+		return basehandler.AppErrorf("Page not found", http.StatusNotFound, err)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if err := model.PublishPageVersion(ctx, id, version); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetMenu(ctx)
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Page published", page.Title, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s published", page.Title))
+	http.Redirect(w, r, "/admin/page/list", http.StatusFound)
+
+	return nil
+}
+
+// AdminPageUnpublishPOST unpublishes a page with a supplied ID.
+func AdminPageUnpublishPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+
+	page, err := model.GetLatestPageVersionByID(ctx, id)
+	if err == model.ErrorNoMatchingPage {
+		return basehandler.AppErrorf("Page not found", http.StatusNotFound, err)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if err := model.UnpublishPage(ctx, id); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetMenu(ctx)
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Page unpublished", page.Title, *author)
+	a.Save(ctx)
+
+	continueURL := r.FormValue("ContinueURL")
+	if !strings.HasPrefix(continueURL, "/admin/") {
+		continueURL = "/admin/page/list"
+	}
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s unpublished", page.Title))
+	http.Redirect(w, r, continueURL, http.StatusFound)
+
+	return nil
+}
+
+// AdminPageDeletePOST deletes every version of a page with the supplied ID.
+func AdminPageDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+
+	page, err := model.GetLatestPageVersionByID(ctx, id)
+	if err == model.ErrorNoMatchingPage {
+		return basehandler.AppErrorf("Page not found", http.StatusNotFound, err)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if err := model.DeletePage(ctx, id); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetMenu(ctx)
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Page deleted", page.Title, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s deleted", page.Title))
+	http.Redirect(w, r, "/admin/page/list", http.StatusFound)
+
+	return nil
+}
+
+// AdminPagePreviewGET displays a version of a page which may not have been
+// published.
+func AdminPagePreviewGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	vars := mux.Vars(r)
+
+	version, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		return basehandler.AppErrorf("Invalid version number",
+			http.StatusBadRequest, err)
+	}
+
+	page, err := model.GetPageVersion(ctx, vars["pageid"], version)
+	if err != nil {
+		return basehandler.AppErrorf("Specified version not found",
+			http.StatusNotFound, err)
+	}
+
+	viewModel := new(pageDisplayViewModel)
+	viewModel.fromEntity(page)
+
+	v := env.View.New("page")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
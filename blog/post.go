@@ -5,6 +5,7 @@ import (
 	"html/template"
 	"net/http"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -29,6 +30,8 @@ type postDisplayViewModel struct {
 	DatePublished  string
 	Published      bool
 	Categories     []categoryViewModel
+	PostID         string
+	CommentsClosed bool
 
 	// Sub entity properties
 	AuthorName string
@@ -41,6 +44,17 @@ type postDisplayViewModel struct {
 	DatePublishedDisplayFull string
 	AuthorURL                string
 	EditURL                  string
+	CommentCount             int
+	CommentsURL              string
+	CommentFormURL           string
+	CommentFormRendered      int64
+	Comments                 []commentViewModel
+	Mentions                 []webmentionViewModel
+
+	// DraftPreview is true when a version is shown through a preview link.
+	DraftPreview   bool
+	PreviewVersion int
+	PreviewExpires string
 }
 
 func (vm *postDisplayViewModel) fromEntity(p *model.BlogPostVersion, datefFull string, datefShort string, excerptLen int) {
@@ -53,6 +67,10 @@ func (vm *postDisplayViewModel) fromEntity(p *model.BlogPostVersion, datefFull s
 	vm.AuthorName = p.Author.DisplayName
 	vm.AuthorURL = fmt.Sprintf("/author/%s", p.Author.Slug)
 	vm.EditURL = fmt.Sprintf("/admin/post/edit/%s", p.Slug)
+	vm.PostID = p.PostID
+	vm.CommentsClosed = p.CommentsClosed
+	vm.CommentsURL = vm.URL + "#comments"
+	vm.CommentFormURL = vm.URL + "/comment"
 
 	bHTML := template.HTML(blackfriday.MarkdownCommon([]byte(p.BodyMarkdown)))
 	vm.BodyHTML = bHTML
@@ -84,13 +102,27 @@ func (vm *postDisplayViewModel) fromEntity(p *model.BlogPostVersion, datefFull s
 	vm.BodyShortHTML = eHTML
 }
 
-// PostGET displays a single post.
+// PostGET displays a single post. Requests for a slug the post used to have
+// are permanently redirected to its current slug, and requests for slugs
+// matching no post are passed to notFoundHandler, so that redirect rules can
+// match them.
 func PostGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	vars := mux.Vars(r)
 
 	post, err := model.GetBlogPostBySlug(ctx, vars["postslug"])
-	if err != nil {
-		return basehandler.AppErrorf("Post not found", http.StatusNotFound, err)
+	if err == model.ErrorNoMatchingPost {
+		current, err := currentPostSlug(ctx, vars["postslug"])
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		if current != "" {
+			http.Redirect(w, r, fmt.Sprintf("/post/%s", current), http.StatusMovedPermanently)
+			return nil
+		}
+		notFoundHandler.ServeHTTP(w, r)
+		return nil
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
 	}
 
 	viewModel := new(postDisplayViewModel)
@@ -100,6 +132,28 @@ func PostGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *h
 		env.Config.DateFormatShort,
 		env.Config.ExcerptCharLength)
 
+	comments, err := model.GetCommentByPostID(ctx, post.PostID)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	for i := range comments {
+		c := new(commentViewModel)
+		c.fromEntity(&comments[i], env.Config.DateFormatFull, env.Config.DateFormatShort)
+		viewModel.Comments = append(viewModel.Comments, *c)
+	}
+	viewModel.CommentCount = len(viewModel.Comments)
+	viewModel.CommentFormRendered = time.Now().Unix()
+
+	mentions, err := model.GetWebmentionByPostID(ctx, post.PostID)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	for i := range mentions {
+		m := new(webmentionViewModel)
+		m.fromEntity(&mentions[i], env.Config.DateFormatFull, env.Config.DateFormatShort)
+		viewModel.Mentions = append(viewModel.Mentions, *m)
+	}
+
 	v := env.View.New("post")
 	v.Data = viewModel
 	if err := v.Render(ctx, w, r); err != nil {
@@ -108,3 +162,25 @@ func PostGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *h
 
 	return nil
 }
+
+// currentPostSlug returns the slug of the published version of the post which
+// has used slug, or an empty string if there is no such post or it is already
+// published under slug.
+func currentPostSlug(ctx context.Context, slug string) (string, error) {
+	id, err := model.GetPostIDBySlug(ctx, slug)
+	if err == model.ErrorNoMatchingPost {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	post, err := model.GetPublishedBlogPostByID(ctx, id)
+	if err == model.ErrorNoMatchingPost {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if post.Slug == slug {
+		return "", nil
+	}
+	return post.Slug, nil
+}
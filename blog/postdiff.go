@@ -0,0 +1,219 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/worddiff"
+
+	"goblogengine/external/github.com/gorilla/mux"
+)
+
+type postDiffViewModel struct {
+	PostID   string
+	Title    string
+	Slug     string
+	Versions []postVersionListItemViewModel
+	From     postVersionListItemViewModel
+	To       postVersionListItemViewModel
+
+	// Inline is true when the changes are shown in a single column rather
+	// than side by side.
+	Inline        bool
+	SideBySideURL string
+	InlineURL     string
+
+	Fields  []fieldDiffViewModel
+	Changed bool
+}
+
+// fieldDiffViewModel is the difference in one field between two versions.
+// Old and New are the field in each version with the deleted and inserted
+// text marked, and Inline has both.
+type fieldDiffViewModel struct {
+	Name    string
+	Changed bool
+	Old     []diffSpanViewModel
+	New     []diffSpanViewModel
+	Inline  []diffSpanViewModel
+}
+
+type diffSpanViewModel struct {
+	Text     string
+	Inserted bool
+	Deleted  bool
+}
+
+func newFieldDiff(name, old, new string) fieldDiffViewModel {
+	ops := worddiff.Words(old, new)
+	fd := fieldDiffViewModel{Name: name, Changed: worddiff.Changed(ops)}
+	for _, op := range ops {
+		span := diffSpanViewModel{
+			Text:     op.Text,
+			Inserted: op.Kind == worddiff.Insert,
+			Deleted:  op.Kind == worddiff.Delete,
+		}
+		fd.Inline = append(fd.Inline, span)
+		if !span.Inserted {
+			fd.Old = append(fd.Old, span)
+		}
+		if !span.Deleted {
+			fd.New = append(fd.New, span)
+		}
+	}
+	return fd
+}
+
+func categoryTitles(cats []model.Category) string {
+	var titles []string
+	for i := range cats {
+		titles = append(titles, cats[i].Title)
+	}
+	return strings.Join(titles, ", ")
+}
+
+// diffVersions returns the differences between two versions of a post, in
+// the order the fields appear in the editor.
+func diffVersions(from, to *model.BlogPostVersion) []fieldDiffViewModel {
+	return []fieldDiffViewModel{
+		newFieldDiff("Title", from.Title, to.Title),
+		newFieldDiff("URL", from.Slug, to.Slug),
+		newFieldDiff("Categories", categoryTitles(from.Categories), categoryTitles(to.Categories)),
+		newFieldDiff("Banner image", from.BannerImageURL, to.BannerImageURL),
+		newFieldDiff("Body", from.BodyMarkdown, to.BodyMarkdown),
+	}
+}
+
+// AdminPostDiffGET displays the differences between two versions of a post.
+// From and To are the version numbers to compare, by default the most recent
+// version and the one before it. Mode=inline shows the changes in a single
+// column.
+func AdminPostDiffGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	vars := mux.Vars(r)
+	id, err := model.GetPostIDBySlug(ctx, vars["postslug"])
+	if err == model.ErrorNoMatchingPost {
+		return basehandler.AppErrorf("Post not found", http.StatusNotFound, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
+
+	versions, err := model.GetBlogPostVersionByID(ctx, id)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if len(versions) == 0 {
+		return basehandler.AppErrorf("Post not found", http.StatusNotFound, nil)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	find := func(param string, def int) (*model.BlogPostVersion, *basehandler.AppError) {
+		num := def
+		if s := r.FormValue(param); s != "" {
+			if num, err = strconv.Atoi(s); err != nil {
+				return nil, basehandler.AppErrorf("Invalid version", http.StatusBadRequest, err)
+			}
+		}
+		for i := range versions {
+			if versions[i].Version == num {
+				return &versions[i], nil
+			}
+		}
+		return nil, basehandler.AppErrorf("Version not found", http.StatusNotFound, nil)
+	}
+	latest := len(versions) - 1
+	to, e := find("To", versions[latest].Version)
+	if e != nil {
+		return e
+	}
+	previous := versions[0].Version
+	for i := range versions {
+		if versions[i].Version < to.Version {
+			previous = versions[i].Version
+		}
+	}
+	from, e := find("From", previous)
+	if e != nil {
+		return e
+	}
+
+	viewModel := &postDiffViewModel{
+		PostID: id,
+		Title:  versions[latest].Title,
+		Slug:   versions[latest].Slug,
+		From:   newPostListItem(from),
+		To:     newPostListItem(to),
+		Inline: r.FormValue("Mode") == "inline",
+		Fields: diffVersions(from, to),
+	}
+	for i := range versions {
+		viewModel.Versions = append(viewModel.Versions, newPostListItem(&versions[i]))
+	}
+	for _, f := range viewModel.Fields {
+		viewModel.Changed = viewModel.Changed || f.Changed
+	}
+	diffURL := fmt.Sprintf("/admin/post/diff/%s?From=%d&To=%d",
+		viewModel.Slug, from.Version, to.Version)
+	viewModel.SideBySideURL = diffURL
+	viewModel.InlineURL = diffURL + "&Mode=inline"
+
+	v := env.View.New("admin/postdiff", "admin/_diff")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorf("", http.StatusInternalServerError, err)
+	}
+
+	return nil
+}
+
+// AdminPostRestorePOST adds a new draft version of a post which copies an
+// earlier version. The change note defaults to naming the restored version.
+func AdminPostRestorePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+	note := strings.TrimSpace(r.FormValue("ChangeNote"))
+
+	versionNum, err := strconv.Atoi(r.FormValue("Version"))
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
+
+	if note == "" {
+		note = fmt.Sprintf("Restored from version %d", versionNum)
+	}
+	post, err := model.RestoreBlogPostVersion(ctx, id, versionNum, *author, note)
+	if err == model.ErrorPostSlugAlreadyExists {
+		return basehandler.AppErrorf("The version's URL is now used by another post",
+			http.StatusConflict, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	discardAutosave(ctx, author, id)
+
+	a := model.NewAudit("Post version restored",
+		fmt.Sprintf("%s, version %d as version %d", post.Title, versionNum, post.Version), *author)
+	a.Save(ctx)
+	fireSavedPostWebhooks(ctx, nil, post)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Version %d restored as version %d", versionNum, post.Version))
+	redirectURL := fmt.Sprintf("/admin/post/edit/%s?SelectedVersion=%d", post.Slug, post.Version)
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	return nil
+}
@@ -11,10 +11,13 @@ import (
 
 	"goblogengine/appenv"
 	"goblogengine/flash"
+	"goblogengine/middleware/auth"
 	"goblogengine/middleware/basehandler"
 	"goblogengine/model"
 	"goblogengine/slug"
 	"goblogengine/taguri"
+	"goblogengine/webhook"
+	"goblogengine/workflow"
 
 	"goblogengine/external/github.com/gorilla/mux"
 )
@@ -25,6 +28,14 @@ type blogPostEditViewModel struct {
 	Versions      []postVersionListItemViewModel
 	AllCategories []string
 	NewPost       bool
+	PreviousSlugs []string
+
+	// IsPage is true when the editor is used for a static page rather than a
+	// post. AdminURL and PublicURLPrefix locate the handlers and public URLs
+	// of whichever is being edited.
+	IsPage          bool
+	AdminURL        string
+	PublicURLPrefix string
 
 	// Entity properties
 	PostID         string
@@ -35,6 +46,10 @@ type blogPostEditViewModel struct {
 	DatePublished  string
 	Published      bool
 	Version        int
+	CommentsClosed bool
+	ChangeNote     string
+	Label          string
+	Pinned         bool
 
 	// Computed entity properties
 	CategoryList string
@@ -42,11 +57,56 @@ type blogPostEditViewModel struct {
 	// View properties
 	PublishImmediately bool
 	SelectedVersion    int
+	CanPublish         bool
 	ValidationErrors   map[string]string
+
+	// Workflow of the selected version, for posts
+	State         string
+	StateLabel    string
+	Actions       []workflowActionViewModel
+	ReviewerEmail string
+	Reviewers     []authorViewModel
+	Reviews       []reviewViewModel
+
+	// The author's autosaved working copy of the post, for posts. Autosaved
+	// is true when it has been restored into the form, and AutosavePending
+	// when it has not because a version was chosen.
+	Autosaved       bool
+	AutosavePending bool
+	AutosaveVersion int
+	AutosavedAt     time.Time
+
+	// BaseVersion is the most recent version of the post when editing
+	// began. Saving is refused with a Conflict if a newer version has been
+	// saved since. Editors are the other authors with the post open.
+	BaseVersion int
+	Conflict    *conflictViewModel
+	Editors     []editorViewModel
+
+	// Signed links to preview versions of the post without logging in, for
+	// posts.
+	PreviewLinks    []previewLinkViewModel
+	PreviewLinkDays []int
+}
+
+func newPostEditViewModel() *blogPostEditViewModel {
+	return &blogPostEditViewModel{
+		AdminURL:        "/admin/post",
+		PublicURLPrefix: "/post/",
+	}
+}
+
+func newPageEditViewModel() *blogPostEditViewModel {
+	return &blogPostEditViewModel{
+		IsPage:          true,
+		AdminURL:        "/admin/page",
+		PublicURLPrefix: "/",
+		CanPublish:      true,
+	}
 }
 
 func (vm *blogPostEditViewModel) addBlogPostVersions(posts []model.BlogPostVersion) {
-	for _, p := range posts {
+	for n, p := range posts {
 		vmp := postVersionListItemViewModel{
 			PostID:        p.PostID,
 			Version:       p.Version,
@@ -57,6 +117,17 @@ func (vm *blogPostEditViewModel) addBlogPostVersions(posts []model.BlogPostVersi
 			PreviewURL:    fmt.Sprintf("/admin/post/preview/%s/%d", p.Slug, p.Version),
 			PostURL:       fmt.Sprintf("/post/%s", p.Slug),
 			Published:     p.Published,
+			State:         p.EffectiveState(),
+			StateLabel:    workflow.Label(p.EffectiveState()),
+			AuthorName:    p.Author.DisplayName,
+			ChangeNote:    p.ChangeNote,
+			Label:         p.Label,
+			Pinned:        p.Pinned,
+			Restorable:    n < len(posts)-1,
+		}
+		if n > 0 {
+			vmp.DiffURL = fmt.Sprintf("/admin/post/diff/%s?From=%d&To=%d",
+				p.Slug, posts[n-1].Version, p.Version)
 		}
 
 		for i := range p.Categories {
@@ -78,6 +149,9 @@ func (vm *blogPostEditViewModel) addVersionToEdit(env *appenv.AppEnv, ver *model
 	vm.BannerImageURL = ver.BannerImageURL
 	vm.BodyMarkdown = ver.BodyMarkdown
 	vm.Version = ver.Version
+	vm.CommentsClosed = ver.CommentsClosed
+	vm.Label = ver.Label
+	vm.Pinned = ver.Pinned
 	vm.DatePublished = ver.DatePublished.Format(env.Config.DateFormatForEditing)
 
 	if len(ver.Categories) > 0 {
@@ -100,6 +174,56 @@ func (vm *blogPostEditViewModel) addCategories(ctx context.Context) error {
 	return nil
 }
 
+// addPreviousSlugs lists the slugs the post has used other than the one
+// being edited.
+func (vm *blogPostEditViewModel) addPreviousSlugs(ctx context.Context) error {
+	slugs, err := model.GetPostSlugByPostID(ctx, vm.PostID)
+	if err != nil {
+		return err
+	}
+	for i := range slugs {
+		if slugs[i].Slug != vm.Slug {
+			vm.PreviousSlugs = append(vm.PreviousSlugs, slugs[i].Slug)
+		}
+	}
+	return nil
+}
+
+// canChangePost reports whether an author may change the post with the
+// supplied ID. A post belongs to the author of its first version, whoever has
+// edited it since, and only they or an author who can edit all posts may
+// change it.
+func canChangePost(ctx context.Context, a *model.Author, id string) (bool, error) {
+	versions, err := model.GetBlogPostVersionByID(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if len(versions) == 0 {
+		return true, nil
+	}
+	first := &versions[0]
+	for i := range versions {
+		if versions[i].Version < first.Version {
+			first = &versions[i]
+		}
+	}
+	return auth.CanChangePost(a, first), nil
+}
+
+// checkPostOwner refuses to let an author change a post which is not theirs
+// unless their role allows them to edit all posts.
+func checkPostOwner(ctx context.Context, a *model.Author, id string) *basehandler.AppError {
+	ok, err := canChangePost(ctx, a, id)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if !ok {
+		return basehandler.AppErrorf("You can only change your own posts",
+			http.StatusForbidden, errorNotPostOwner)
+	}
+	return nil
+}
+
 // TODO: front and back end validation for the new post form
 func (vm *blogPostEditViewModel) validate() bool {
 	vm.ValidationErrors = make(map[string]string)
@@ -109,9 +233,12 @@ func (vm *blogPostEditViewModel) validate() bool {
 
 // AdminPostEditGET displays the edit post page
 func AdminPostEditGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
-	viewModel := new(blogPostEditViewModel)
+	viewModel := newPostEditViewModel()
 	vars := mux.Vars(r)
 
+	author, _ := env.User.(*model.Author)
+	viewModel.CanPublish = auth.Can(author, auth.PublishPosts)
+
 	if err := viewModel.addCategories(ctx); err != nil {
 		return basehandler.AppErrorDefault(err)
 	}
@@ -124,17 +251,32 @@ func AdminPostEditGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWri
 			return basehandler.AppErrorDefault(err)
 		}
 
-		postSlice, err := model.GetBlogPostVersionBySlug(ctx, postSlug)
+		id, err := model.GetPostIDBySlug(ctx, postSlug)
+		if err == model.ErrorNoMatchingPost {
+			return basehandler.AppErrorf("Post not found", http.StatusNotFound, err)
+		}
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		postSlice, err := model.GetBlogPostVersionByID(ctx, id)
 		if err != nil {
 			return basehandler.AppErrorDefault(err)
 		}
 		if len(postSlice) == 0 {
 			return basehandler.AppErrorf("Post not found", http.StatusInternalServerError, nil)
 		}
+		if e := checkPostOwner(ctx, author, id); e != nil {
+			return e
+		}
 		sort.Slice(postSlice, func(i, j int) bool {
 			return postSlice[i].DateCreated.Before(postSlice[j].DateCreated)
 		})
 		viewModel.addBlogPostVersions(postSlice)
+		for i := range postSlice {
+			if postSlice[i].Version > viewModel.BaseVersion {
+				viewModel.BaseVersion = postSlice[i].Version
+			}
+		}
 
 		// Copy the selected version's entity values directly into the view model for editing
 		// if no version is selected, use the most recent version
@@ -150,13 +292,34 @@ func AdminPostEditGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWri
 			versionToEdit = &postSlice[len(postSlice)-1]
 		}
 		viewModel.addVersionToEdit(&env, versionToEdit)
+		if err := viewModel.addWorkflow(ctx, author, versionToEdit); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+
+		if err := viewModel.addPreviousSlugs(ctx); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+
+		_, versionSelected := r.Form["SelectedVersion"]
+		if err := viewModel.addAutosave(ctx, author, versionSelected); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		if err := viewModel.addEditLock(ctx, &env, author); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		if err := viewModel.addPreviewLinks(ctx, &env); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
 
 	} else {
 		viewModel.NewPost = true
 		viewModel.DatePublished = time.Now().Format(env.Config.DateFormatForEditing)
+		if err := viewModel.addAutosave(ctx, author, false); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
 	}
 
-	v := env.View.New("admin/postedit")
+	v := env.View.New("admin/postedit", "admin/_diff")
 	v.Data = viewModel
 	if err := v.Render(ctx, w, r); err != nil {
 		return basehandler.AppErrorf("", http.StatusInternalServerError, err)
@@ -168,7 +331,7 @@ func AdminPostEditGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWri
 // AdminPostEditPOST handles a post edit form submission
 // TODO: form validation improvements
 func AdminPostEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
-	viewModel := new(blogPostEditViewModel)
+	viewModel := newPostEditViewModel()
 	viewModel.ValidationErrors = make(map[string]string)
 
 	if err := r.ParseForm(); err != nil {
@@ -183,22 +346,28 @@ func AdminPostEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWr
 		return basehandler.AppErrorf("Not logged in",
 			http.StatusInternalServerError, nil)
 	}
+	viewModel.CanPublish = auth.Can(author, auth.PublishPosts)
+	if viewModel.PublishImmediately && !viewModel.CanPublish {
+		return basehandler.AppErrorf("Your role does not allow publishing",
+			http.StatusForbidden, nil)
+	}
+	if viewModel.PostID != "" {
+		if e := checkPostOwner(ctx, author, viewModel.PostID); e != nil {
+			return e
+		}
+	}
 
 	pubDate, err := time.Parse(env.Config.DateFormatForEditing, viewModel.DatePublished)
 	if err != nil {
 		viewModel.ValidationErrors["DatePublished"] = "Invalid date"
 	}
+	if viewModel.Slug == "" {
+		viewModel.Slug = slug.Make(viewModel.Title)
+	}
+	autosaveID := viewModel.PostID
 	if viewModel.PostID == "" {
 		viewModel.NewPost = true
-		if viewModel.Slug == "" {
-			viewModel.Slug = slug.Make(viewModel.Title)
-		}
-		tag := taguri.Make(pubDate,
-			env.Config.BaseDomainName,
-			"",
-			slug.Make(env.Config.BlogName),
-			viewModel.Slug)
-		viewModel.PostID = tag
+		viewModel.PostID = newPostID(&env, pubDate, viewModel.Slug)
 	}
 	entry := new(model.BlogPostVersion)
 	entry.Slug = viewModel.Slug
@@ -209,20 +378,35 @@ func AdminPostEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWr
 	entry.DatePublished = pubDate
 	entry.DateCreated = time.Now()
 	entry.Published = viewModel.PublishImmediately
+	entry.CommentsClosed = viewModel.CommentsClosed
+	entry.ChangeNote = strings.TrimSpace(viewModel.ChangeNote)
 	entry.Author = *author
-	cats := strings.Split(viewModel.CategoryList, ",")
-	for i := range cats {
+	for _, title := range strings.Split(viewModel.CategoryList, ",") {
+		title = strings.TrimSpace(title)
 		c := model.Category{
-			Title: cats[i],
-			Slug:  slug.Make(cats[i]),
+			Title: title,
+			Slug:  slug.Make(title),
+		}
+		if c.Title == "" || c.Slug == "" {
+			continue
 		}
 		entry.Categories = append(entry.Categories, c)
 	}
 
 	if len(viewModel.ValidationErrors) == 0 {
-		_, err := entry.Save(ctx, viewModel.NewPost)
+		var err error
+		if !viewModel.NewPost {
+			_, err = entry.SaveFrom(ctx, viewModel.BaseVersion)
+		} else {
+			_, err = entry.Save(ctx, viewModel.NewPost)
+		}
 		if err == model.ErrorPostSlugAlreadyExists {
 			viewModel.ValidationErrors["Slug"] = "That custom URL is already in use, try another"
+		} else if err == model.ErrorVersionConflict {
+			viewModel.ValidationErrors["BaseVersion"] = "A newer version has been saved since you began editing"
+			if err := viewModel.addConflict(ctx, entry); err != nil {
+				return basehandler.AppErrorDefault(err)
+			}
 		} else if err != nil {
 			return basehandler.AppErrorf(
 				"Unable to save blog post",
@@ -236,7 +420,7 @@ func AdminPostEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWr
 			return basehandler.AppErrorDefault(err)
 		}
 
-		posts, err := model.GetBlogPostVersionBySlug(ctx, viewModel.Slug)
+		posts, err := model.GetBlogPostVersionByID(ctx, viewModel.PostID)
 		if err != nil {
 			return basehandler.AppErrorDefault(err)
 		}
@@ -244,8 +428,14 @@ func AdminPostEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWr
 			return posts[i].DateCreated.Before(posts[j].DateCreated)
 		})
 		viewModel.addBlogPostVersions(posts)
+		if !viewModel.NewPost {
+			viewModel.Editors, err = otherEditors(ctx, &env, author, viewModel.PostID)
+			if err != nil {
+				return basehandler.AppErrorDefault(err)
+			}
+		}
 
-		v := env.View.New("admin/postedit")
+		v := env.View.New("admin/postedit", "admin/_diff")
 		v.Data = viewModel
 		if err := v.Render(ctx, w, r); err != nil {
 			return basehandler.AppErrorDefault(err)
@@ -253,6 +443,12 @@ func AdminPostEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWr
 		return nil
 	}
 
+	discardAutosave(ctx, author, autosaveID)
+
+	a := model.NewAudit("Post saved", entry.Title, *author)
+	a.Save(ctx)
+	fireSavedPostWebhooks(ctx, nil, entry)
+
 	flash.AddFlash(w, r, "Post updated")
 	redirectURL := fmt.Sprintf("/admin/post/edit/%s", entry.Slug)
 	http.Redirect(w, r, redirectURL, http.StatusFound)
@@ -260,8 +456,51 @@ func AdminPostEditPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWr
 	return nil
 }
 
+// newPostID returns the tag URI which identifies a new post with the supplied
+// publication date and slug.
+func newPostID(env *appenv.AppEnv, published time.Time, postSlug string) string {
+	return taguri.Make(published,
+		env.Config.BaseDomainName,
+		"",
+		slug.Make(env.Config.BlogName),
+		postSlug)
+}
+
+// createPost saves entry as the first version of a new post by author, as
+// publishing clients do. Returns model.ErrorPostSlugAlreadyExists if its slug
+// is in use.
+func createPost(ctx context.Context, env *appenv.AppEnv, entry *model.BlogPostVersion, author model.Author) error {
+	entry.PostID = newPostID(env, entry.DatePublished, entry.Slug)
+	entry.DateCreated = time.Now()
+	entry.Author = author
+	_, err := entry.Save(ctx, true)
+	return err
+}
+
+// updatePost saves entry, a changed copy of the latest version of a post, as
+// a new version by author, as publishing clients do. If entry is no longer
+// published the post is unpublished as it is saved. Like a restored version,
+// the new version has no reviewer, change note or label and is not pinned.
+// Returns model.ErrorVersionConflict if another version has been saved since
+// latest was read.
+func updatePost(ctx context.Context, latest *model.BlogPostVersion, entry *model.BlogPostVersion, author model.Author) error {
+	entry.DateCreated = time.Now()
+	entry.Author = author
+	entry.ReviewerEmail = ""
+	entry.ChangeNote = ""
+	entry.Label = ""
+	entry.Pinned = false
+
+	var err error
+	if latest.Published && !entry.Published {
+		_, err = entry.SaveUnpublishedFrom(ctx, latest.Version)
+	} else {
+		_, err = entry.SaveFrom(ctx, latest.Version)
+	}
+	return err
+}
+
 // AdminPostUnpublishPOST unpublishes a post with a supplied ID.
-// TODO: verify that the author owns the post they are unpublishing.
 func AdminPostUnpublishPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	id := r.FormValue("PostID")
 	postTitle := r.FormValue("PostTitle")
@@ -269,11 +508,24 @@ func AdminPostUnpublishPOST(ctx context.Context, env appenv.AppEnv, w http.Respo
 	// TODO: only accept relative URLs or store in session
 	editURL := r.FormValue("ContinueURL")
 
-	err := model.UnpublishBlogPost(ctx, id)
+	post, err := model.GetLatestBlogPostVersionByID(ctx, id)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
+
+	err = model.UnpublishBlogPost(ctx, id)
 	if err != nil {
 		return basehandler.AppErrorDefault(err)
 	}
 
+	a := model.NewAudit("Post unpublished", post.Title, *author)
+	a.Save(ctx)
+	firePostWebhook(ctx, webhook.EventPostUnpublished, post)
+
 	fmsg := fmt.Sprintf("%s unpublished", postTitle)
 	flash.AddFlash(w, r, fmsg)
 	http.Redirect(w, r, editURL, http.StatusFound)
@@ -281,18 +533,30 @@ func AdminPostUnpublishPOST(ctx context.Context, env appenv.AppEnv, w http.Respo
 	return nil
 }
 
-// AdminPostDeletePOST deletes a post with the supplied ID.
+// AdminPostDeletePOST moves the post with the supplied ID to the trash.
 func AdminPostDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
 	id := r.FormValue("PostID")
 	postTitle := r.FormValue("PostTitle")
 
-	err := model.DeleteBlogPost(ctx, id)
+	post, err := model.GetLatestBlogPostVersionByID(ctx, id)
 	if err != nil {
 		return basehandler.AppErrorDefault(err)
 	}
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
 
-	fmsg := fmt.Sprintf("%s deleted", postTitle)
-	flash.AddFlash(w, r, fmsg)
+	t, err := model.TrashBlogPost(ctx, id, *author)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Post moved to trash", post.Title, *author)
+	a.Save(ctx)
+	firePostWebhook(ctx, webhook.EventPostDeleted, post)
+
+	addUndo(w, r, t, fmt.Sprintf("%s moved to trash", postTitle))
 	http.Redirect(w, r, "/admin/post/list", http.StatusFound)
 
 	return nil
@@ -309,10 +573,27 @@ func AdminPostPublishPOST(ctx context.Context, env appenv.AppEnv, w http.Respons
 		return basehandler.AppErrorDefault(err)
 	}
 
-	err = model.PublishBlogPostVersion(ctx, id, versionNum)
+	post, err := model.GetBlogPostVersion(ctx, id, versionNum)
 	if err != nil {
 		return basehandler.AppErrorDefault(err)
 	}
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
+
+	err = model.PublishBlogPostVersion(ctx, id, versionNum)
+	if te, ok := err.(*workflow.TransitionError); ok {
+		return basehandler.AppErrorf(strings.TrimPrefix(te.Error(), "workflow: "),
+			http.StatusBadRequest, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Post published", post.Title, *author)
+	a.Save(ctx)
+	firePostWebhook(ctx, webhook.EventPostPublished, post)
+	queueWebmentions(ctx, post)
 
 	fmsg := fmt.Sprintf("%s published", postTitle)
 	flash.AddFlash(w, r, fmsg)
@@ -22,7 +22,12 @@ func AdminPreviewPostVersionGET(ctx context.Context, env appenv.AppEnv, w http.R
 			http.StatusBadRequest, err)
 	}
 
-	post, err := model.GetBlogPostVersion(ctx, vars["postslug"], int(version))
+	id, err := model.GetPostIDBySlug(ctx, vars["postslug"])
+	if err != nil {
+		return basehandler.AppErrorf("Post not found", http.StatusNotFound, err)
+	}
+
+	post, err := model.GetBlogPostVersion(ctx, id, int(version))
 	if err != nil {
 		return basehandler.AppErrorf("Specified version not found",
 			http.StatusNotFound, err)
@@ -0,0 +1,197 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/signedlink"
+
+	"goblogengine/external/github.com/gorilla/mux"
+)
+
+// previewLinkDays are the numbers of days for which a preview link can be
+// made valid.
+var previewLinkDays = []int{1, 3, 7, 30}
+
+type previewLinkViewModel struct {
+	ID         string
+	Version    int
+	AuthorName string
+	Created    time.Time
+	Expires    time.Time
+	Expired    bool
+	URL        string
+}
+
+// previewLinkURL returns the signed URL of a preview link. Links are signed
+// with the session store key, so changing it invalidates every link.
+func previewLinkURL(env *appenv.AppEnv, l *model.PreviewLink) string {
+	expires := l.Expires.Unix()
+	return fmt.Sprintf("http://%s/preview/%s/%d/%s", env.Config.BaseDomainName,
+		l.ID, expires, signedlink.Sign(env.Config.SessionStoreKey, l.ID, expires))
+}
+
+// addPreviewLinks lists the links to preview versions of the post being
+// edited.
+func (vm *blogPostEditViewModel) addPreviewLinks(ctx context.Context, env *appenv.AppEnv) error {
+	links, err := model.GetPreviewLinkByPostID(ctx, vm.PostID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range links {
+		vm.PreviewLinks = append(vm.PreviewLinks, previewLinkViewModel{
+			ID:         links[i].ID,
+			Version:    links[i].Version,
+			AuthorName: links[i].Author.DisplayName,
+			Created:    links[i].Created,
+			Expires:    links[i].Expires,
+			Expired:    links[i].Expired(now),
+			URL:        previewLinkURL(env, &links[i]),
+		})
+	}
+	vm.PreviewLinkDays = previewLinkDays
+	return nil
+}
+
+// AdminPreviewLinkPOST creates a link which lets anyone holding it preview a
+// version of a post without logging in, for the chosen number of days.
+func AdminPreviewLinkPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+	version, err := strconv.Atoi(r.FormValue("Version"))
+	if err != nil {
+		return basehandler.AppErrorf("Invalid version number", http.StatusBadRequest, err)
+	}
+	days, err := strconv.Atoi(r.FormValue("Days"))
+	if err != nil {
+		return basehandler.AppErrorf("Invalid number of days", http.StatusBadRequest, err)
+	}
+	valid := false
+	for _, d := range previewLinkDays {
+		valid = valid || d == days
+	}
+	if !valid {
+		return basehandler.AppErrorf("Invalid number of days", http.StatusBadRequest, nil)
+	}
+
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
+	post, err := model.GetBlogPostVersion(ctx, id, version)
+	if err != nil {
+		return basehandler.AppErrorf("Specified version not found", http.StatusNotFound, err)
+	}
+
+	l, err := model.NewPreviewLink(id, version, time.Duration(days)*24*time.Hour, *author)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if _, err := l.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Preview link created",
+		fmt.Sprintf("%s, version %d, until %s", post.Title, version,
+			l.Expires.Format(env.Config.DateFormatFull)), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Preview link for version %d: %s", version, previewLinkURL(&env, l)))
+	redirectURL := fmt.Sprintf("/admin/post/edit/%s?SelectedVersion=%d", post.Slug, version)
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	return nil
+}
+
+// AdminPreviewLinkRevokePOST revokes a preview link before it expires.
+func AdminPreviewLinkRevokePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	l, err := model.GetPreviewLink(ctx, r.FormValue("ID"))
+	if err == model.ErrorNoMatchingPreviewLink {
+		return basehandler.AppErrorf("Preview link not found", http.StatusNotFound, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, l.PostID); e != nil {
+		return e
+	}
+	post, err := model.GetLatestBlogPostVersionByID(ctx, l.PostID)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if err := model.DeletePreviewLink(ctx, l.ID); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Preview link revoked",
+		fmt.Sprintf("%s, version %d, created by %s", post.Title, l.Version, l.Author.DisplayName), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Preview link for version %d revoked", l.Version))
+	http.Redirect(w, r, fmt.Sprintf("/admin/post/edit/%s", post.Slug), http.StatusFound)
+	return nil
+}
+
+// PreviewGET displays a version of a post to anyone holding a signed preview
+// link which has neither expired nor been revoked. The preview is marked as
+// a draft, and is not to be indexed or cached.
+func PreviewGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	vars := mux.Vars(r)
+	expires, err := strconv.ParseInt(vars["expires"], 10, 64)
+	if err != nil {
+		return basehandler.AppErrorf("Preview not found", http.StatusNotFound, err)
+	}
+	err = signedlink.Verify(env.Config.SessionStoreKey, vars["linkid"], expires, vars["signature"], time.Now())
+	if err == signedlink.ErrExpired {
+		return basehandler.AppErrorf("This preview link has expired", http.StatusGone, err)
+	} else if err != nil {
+		return basehandler.AppErrorf("Preview not found", http.StatusNotFound, err)
+	}
+
+	l, err := model.GetPreviewLink(ctx, vars["linkid"])
+	if err == model.ErrorNoMatchingPreviewLink {
+		return basehandler.AppErrorf("This preview link has been revoked", http.StatusGone, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if l.Expires.Unix() != expires {
+		return basehandler.AppErrorf("Preview not found", http.StatusNotFound, nil)
+	}
+
+	post, err := model.GetBlogPostVersion(ctx, l.PostID, l.Version)
+	if err != nil {
+		return basehandler.AppErrorf("This version of the post no longer exists",
+			http.StatusGone, err)
+	}
+
+	viewModel := new(postDisplayViewModel)
+	viewModel.fromEntity(
+		post,
+		env.Config.DateFormatFull,
+		env.Config.DateFormatShort,
+		env.Config.ExcerptCharLength)
+	viewModel.DraftPreview = true
+	viewModel.PreviewVersion = l.Version
+	viewModel.PreviewExpires = l.Expires.Format(env.Config.DateFormatFull)
+	viewModel.CommentsClosed = true
+
+	v := env.View.New("post")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/redirect"
+
+	"google.golang.org/appengine/log"
+)
+
+// redirectsMaxAge is how long an instance uses a blog's compiled redirect
+// rules before reloading them, so that changes saved by other instances are
+// picked up.
+const redirectsMaxAge = 30 * time.Second
+
+// redirectRules are the compiled redirect rules of a blog.
+type redirectRules struct {
+	set    *redirect.Set
+	loaded time.Time
+}
+
+var redirectsMutex sync.Mutex
+var redirects = make(map[string]*redirectRules)
+
+type redirectViewModel struct {
+	// Entity properties
+	ID      string
+	From    string
+	To      string
+	Status  int
+	Pattern bool
+	Created time.Time
+	Hits    int
+	LastHit time.Time
+}
+
+type adminRedirectListViewModel struct {
+	Redirects []redirectViewModel
+
+	// New entity properties
+	From    string
+	To      string
+	Status  int
+	Pattern bool
+}
+
+// AdminRedirectListGET displays the redirect rules and how often they have
+// been used.
+func AdminRedirectListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	rds, err := model.GetAllRedirect(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	hits, err := model.GetAllRedirectHit(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	viewModel := new(adminRedirectListViewModel)
+	viewModel.Status = http.StatusMovedPermanently
+	for i := range rds {
+		viewModel.Redirects = append(viewModel.Redirects, redirectViewModel{
+			ID:      rds[i].ID,
+			From:    rds[i].From,
+			To:      rds[i].To,
+			Status:  rds[i].Status,
+			Pattern: rds[i].Pattern,
+			Created: rds[i].Created,
+			Hits:    hits[rds[i].ID].Hits,
+			LastHit: hits[rds[i].ID].LastHit,
+		})
+	}
+
+	v := env.View.New("admin/redirectlist")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminRedirectListPOST adds a redirect rule.
+func AdminRedirectListPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminRedirectListViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	rule := redirect.Rule{
+		From:    strings.TrimSpace(viewModel.From),
+		To:      strings.TrimSpace(viewModel.To),
+		Status:  viewModel.Status,
+		Pattern: viewModel.Pattern,
+	}
+	if err := rule.Validate(); err != nil {
+		return basehandler.AppErrorf(err.Error(), http.StatusBadRequest, err)
+	}
+
+	rd, err := model.NewRedirect(rule.From, rule.To, rule.Status, rule.Pattern, *author)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if _, err := rd.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetRedirects(ctx)
+
+	a := model.NewAudit("Redirect added", rd.From, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Redirect from %s added", rd.From))
+	http.Redirect(w, r, "/admin/redirect/list", http.StatusFound)
+	return nil
+}
+
+// AdminRedirectImportPOST adds the redirect rules in an uploaded CSV file.
+// No rules are added unless every line is valid.
+func AdminRedirectImportPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	f, _, err := r.FormFile("importfile")
+	if err == http.ErrMissingFile {
+		return basehandler.AppErrorf("Please choose a file to import",
+			http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	defer f.Close()
+
+	rules, err := redirect.ParseCSV(f)
+	if err != nil {
+		return basehandler.AppErrorf(fmt.Sprintf("Unable to import redirects: %v", err),
+			http.StatusBadRequest, err)
+	}
+
+	rds := make([]model.Redirect, len(rules))
+	for i, rule := range rules {
+		rd, err := model.NewRedirect(rule.From, rule.To, rule.Status, rule.Pattern, *author)
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		rds[i] = *rd
+	}
+	if err := model.SaveRedirects(ctx, rds); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetRedirects(ctx)
+
+	a := model.NewAudit("Redirects imported", fmt.Sprintf("%d redirect(s)", len(rds)), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%d redirect(s) imported", len(rds)))
+	http.Redirect(w, r, "/admin/redirect/list", http.StatusFound)
+	return nil
+}
+
+// AdminRedirectDeletePOST removes a redirect rule.
+func AdminRedirectDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	rd, err := model.GetRedirect(ctx, r.FormValue("ID"))
+	if err == model.ErrorNoMatchingRedirect {
+		return basehandler.AppErrorf("Redirect not found",
+			http.StatusNotFound, nil)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if err := model.DeleteRedirect(ctx, rd.ID); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetRedirects(ctx)
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Redirect deleted", rd.From, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Redirect from %s deleted", rd.From))
+	http.Redirect(w, r, "/admin/redirect/list", http.StatusFound)
+	return nil
+}
+
+// redirectOrNotFound returns a handler for requests which match no route. It
+// applies the first matching redirect rule, and passes requests matching no
+// rule to notFound.
+func redirectOrNotFound(notFound http.Handler) http.Handler {
+	gone := basehandler.MakeHandler(Gone)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := appenv.NewContext(r)
+
+		rule, to, ok := matchRedirect(ctx, r.URL.Path)
+		if !ok {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+
+		if err := model.RecordRedirectHit(ctx, rule.ID); err != nil {
+			log.Warningf(ctx, "Unable to record redirect hit: %v", err)
+		}
+		if rule.Status == http.StatusGone {
+			gone.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, to, rule.Status)
+	})
+}
+
+// matchRedirect returns the redirect rule matching path, if any. Failures
+// are logged and treated as no match.
+func matchRedirect(ctx context.Context, path string) (redirect.Rule, string, bool) {
+	set, err := blogRedirects(ctx)
+	if err != nil {
+		log.Errorf(ctx, "Unable to load redirects: %v", err)
+		return redirect.Rule{}, "", false
+	}
+	return set.Match(path)
+}
+
+// blogRedirects returns the compiled redirect rules of the current blog,
+// loading them if they have not been loaded recently. If reloading fails the
+// previous rules are kept.
+func blogRedirects(ctx context.Context) (*redirect.Set, error) {
+	id := model.BlogID(ctx)
+	redirectsMutex.Lock()
+	rr, ok := redirects[id]
+	redirectsMutex.Unlock()
+	if ok && time.Since(rr.loaded) < redirectsMaxAge {
+		return rr.set, nil
+	}
+
+	set, err := compileRedirects(ctx)
+	if err != nil {
+		if ok {
+			log.Errorf(ctx, "Unable to reload redirects: %v", err)
+			return rr.set, nil
+		}
+		return nil, err
+	}
+
+	redirectsMutex.Lock()
+	redirects[id] = &redirectRules{set: set, loaded: time.Now()}
+	redirectsMutex.Unlock()
+	return set, nil
+}
+
+// forgetRedirects makes this instance reload the redirect rules of the
+// current blog on the next request, after they have been changed.
+func forgetRedirects(ctx context.Context) {
+	redirectsMutex.Lock()
+	defer redirectsMutex.Unlock()
+	delete(redirects, model.BlogID(ctx))
+}
+
+// compileRedirects loads and compiles the redirect rules of the current
+// blog.
+func compileRedirects(ctx context.Context) (*redirect.Set, error) {
+	rds, err := model.GetAllRedirect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]redirect.Rule, len(rds))
+	for i := range rds {
+		rules[i] = redirect.Rule{
+			ID:      rds[i].ID,
+			From:    rds[i].From,
+			To:      rds[i].To,
+			Status:  rds[i].Status,
+			Pattern: rds[i].Pattern,
+		}
+	}
+	return redirect.Compile(rules)
+}
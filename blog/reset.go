@@ -7,7 +7,6 @@ import (
 	"google.golang.org/appengine/log"
 
 	"goblogengine/appenv"
-	"goblogengine/csimg"
 	"goblogengine/flash"
 	"goblogengine/middleware/basehandler"
 	"goblogengine/model"
@@ -34,12 +33,95 @@ func AdminResetPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWrite
 		errors = append(errors, err)
 	}
 
-	err = model.DeleteAllImage(ctx)
+	err = model.DeleteAllAccessToken(ctx)
 	if err != nil {
 		errors = append(errors, err)
 	}
 
-	_, err = csimg.DeleteAll(ctx)
+	err = model.DeleteAllInvitation(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllPostSlug(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllPage(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllMenuItem(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	forgetMenu(ctx)
+
+	err = model.DeleteAllSettings(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	forgetSites()
+
+	err = model.DeleteAllComment(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllReview(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllAutosave(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllEditLock(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllPreviewLink(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllWebmention(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllSpamDomain(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllRedirect(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+	forgetRedirects(ctx)
+
+	err = model.DeleteAllWebhook(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = model.DeleteAllWebhookDelivery(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	_, err = deleteAllImages(ctx)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	err = emptyTrash(ctx)
 	if err != nil {
 		errors = append(errors, err)
 	}
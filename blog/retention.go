@@ -0,0 +1,170 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/retention"
+
+	"google.golang.org/appengine/log"
+)
+
+// pruneVersionsTaskPath is requested by cron to prune old post versions.
+const pruneVersionsTaskPath = "/tasks/pruneversions"
+
+// retentionAuthor is recorded in the audit log as pruning versions.
+var retentionAuthor = model.Author{DisplayName: "Version retention"}
+
+type adminRetentionViewModel struct {
+	KeepLast       int
+	DailyAfterDays int
+	VersionCount   int
+	PostCount      int
+	Versions       []postVersionListItemViewModel
+}
+
+// retentionPolicy returns the version retention policy of a blog.
+func retentionPolicy(c appenv.Config) retention.Policy {
+	return retention.Days(c.VersionsKeepLast, c.VersionsDailyAfterDays)
+}
+
+// prunedSummary describes the versions pruned for the audit log.
+func prunedSummary(pruned []model.BlogPostVersion) string {
+	posts := make(map[string]bool)
+	for i := range pruned {
+		posts[pruned[i].PostID] = true
+	}
+	return fmt.Sprintf("%d versions of %d posts", len(pruned), len(posts))
+}
+
+// AdminPostRetentionGET lists the post versions which the retention policy
+// would prune now, without pruning them.
+func AdminPostRetentionGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	pruned, err := model.PruneBlogPostVersions(ctx, retentionPolicy(env.Config), time.Now(), true)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	count, err := model.GetBlogPostVersionCount(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	viewModel := &adminRetentionViewModel{
+		KeepLast:       env.Config.VersionsKeepLast,
+		DailyAfterDays: env.Config.VersionsDailyAfterDays,
+		VersionCount:   count,
+	}
+	posts := make(map[string]bool)
+	for i := range pruned {
+		posts[pruned[i].PostID] = true
+		viewModel.Versions = append(viewModel.Versions, newPostListItem(&pruned[i]))
+	}
+	viewModel.PostCount = len(posts)
+	sort.Slice(viewModel.Versions, func(i, j int) bool {
+		a, b := viewModel.Versions[i], viewModel.Versions[j]
+		if a.PostID != b.PostID {
+			return a.Title < b.Title
+		}
+		return a.Version < b.Version
+	})
+
+	v := env.View.New("admin/retention")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorf("", http.StatusInternalServerError, err)
+	}
+
+	return nil
+}
+
+// AdminPostRetentionPOST prunes the post versions which the retention policy
+// does not keep, without waiting for the daily task.
+func AdminPostRetentionPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	author, _ := env.User.(*model.Author)
+
+	pruned, err := model.PruneBlogPostVersions(ctx, retentionPolicy(env.Config), time.Now(), false)
+	if len(pruned) > 0 {
+		a := model.NewAudit("Post versions pruned", prunedSummary(pruned), *author)
+		a.Save(ctx)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	flash.AddFlash(w, r, fmt.Sprintf("Pruned %s", prunedSummary(pruned)))
+	http.Redirect(w, r, "/admin/post/retention", http.StatusFound)
+	return nil
+}
+
+// AdminPostLabelPOST labels or pins a version of a post, so that it is never
+// pruned, or removes its label and pin.
+func AdminPostLabelPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+	label := strings.TrimSpace(r.FormValue("Label"))
+	pinned := r.FormValue("Pinned") == "true"
+
+	versionNum, err := strconv.Atoi(r.FormValue("Version"))
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
+
+	post, err := model.LabelBlogPostVersion(ctx, id, versionNum, label, pinned)
+	if err == model.ErrorNoMatchingPost {
+		return basehandler.AppErrorf("Version not found", http.StatusNotFound, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	details := fmt.Sprintf("%s, version %d", post.Title, post.Version)
+	switch {
+	case label != "" && pinned:
+		details += fmt.Sprintf(" labelled %q and pinned", label)
+	case label != "":
+		details += fmt.Sprintf(" labelled %q", label)
+	case pinned:
+		details += " pinned"
+	default:
+		details += " unlabelled"
+	}
+	a := model.NewAudit("Post version labelled", details, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Version %d updated", post.Version))
+	redirectURL := fmt.Sprintf("/admin/post/edit/%s?SelectedVersion=%d", post.Slug, post.Version)
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	return nil
+}
+
+// PruneVersionsTaskGET is run by cron to prune the post versions of every
+// blog which its retention policy does not keep.
+func PruneVersionsTaskGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+
+	err := forEachBlog(ctx, func(bctx context.Context, config appenv.Config) {
+		pruned, err := model.PruneBlogPostVersions(bctx, retentionPolicy(config), time.Now(), false)
+		if err != nil {
+			log.Errorf(ctx, "Unable to prune post versions of blog %s: %v", model.BlogID(bctx), err)
+		}
+		if len(pruned) > 0 {
+			a := model.NewAudit("Post versions pruned", prunedSummary(pruned), retentionAuthor)
+			a.Save(bctx)
+		}
+	})
+	if err != nil {
+		log.Errorf(ctx, "Unable to list blogs: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"goblogengine/appenv"
 	"goblogengine/csimg"
 	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
 	"net/http"
 
 	uuid "github.com/satori/go.uuid"
@@ -23,6 +24,13 @@ func ServeImageGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter
 			err)
 	}
 
+	// Images are stored in one bucket for all blogs
+	if _, err := model.GetImageByID(ctx, id); err != nil {
+		return basehandler.AppErrorf("Image not found",
+			http.StatusNotFound,
+			err)
+	}
+
 	img, err := csimg.Read(ctx, id)
 	if err != nil {
 		return basehandler.AppErrorDefault(err)
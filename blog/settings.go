@@ -0,0 +1,177 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+
+	"github.com/gorilla/schema"
+)
+
+type adminSettingsViewModel struct {
+	// Entity properties
+	appenv.Settings
+
+	// View properties
+	Defaults         appenv.Config
+	Updated          time.Time
+	UpdatedBy        string
+	ValidationErrors map[string]string
+}
+
+// settingsFromEntity converts the stored settings to those applied to the
+// environment.
+func settingsFromEntity(s *model.Settings) appenv.Settings {
+	return appenv.Settings{
+		BlogName:               s.BlogName,
+		PostsPerPage:           s.PostsPerPage,
+		FeedSize:               s.FeedSize,
+		ExcerptCharLength:      s.ExcerptCharLength,
+		VersionsKeepLast:       s.VersionsKeepLast,
+		VersionsDailyAfterDays: s.VersionsDailyAfterDays,
+		TrashDays:              s.TrashDays,
+		DateFormatForEditing:   s.DateFormatForEditing,
+		DateFormatShort:        s.DateFormatShort,
+		DateFormatFull:         s.DateFormatFull,
+	}
+}
+
+// settingsChanges describes the differences between two sets of settings for
+// the audit log.
+func settingsChanges(from appenv.Settings, to appenv.Settings) string {
+	var changes []string
+	change := func(name string, a interface{}, b interface{}) {
+		if a != b {
+			changes = append(changes, fmt.Sprintf("%s: %v to %v", name, a, b))
+		}
+	}
+	change("Blog name", from.BlogName, to.BlogName)
+	change("Posts per page", from.PostsPerPage, to.PostsPerPage)
+	change("Feed size", from.FeedSize, to.FeedSize)
+	change("Excerpt length", from.ExcerptCharLength, to.ExcerptCharLength)
+	change("Versions kept", from.VersionsKeepLast, to.VersionsKeepLast)
+	change("Days of versions kept in full", from.VersionsDailyAfterDays, to.VersionsDailyAfterDays)
+	change("Days kept in trash", from.TrashDays, to.TrashDays)
+	change("Editing date format", from.DateFormatForEditing, to.DateFormatForEditing)
+	change("Short date format", from.DateFormatShort, to.DateFormatShort)
+	change("Full date format", from.DateFormatFull, to.DateFormatFull)
+
+	if len(changes) == 0 {
+		return "No changes"
+	}
+	return strings.Join(changes, "; ")
+}
+
+// AdminSettingsGET displays the site settings form.
+func AdminSettingsGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	s, err := model.GetSettings(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	b, err := getBlog(ctx, model.BlogID(ctx))
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	viewModel := new(adminSettingsViewModel)
+	viewModel.Settings = settingsFromEntity(s)
+	viewModel.Defaults = blogConfig(b)
+	viewModel.Updated = s.Updated
+	viewModel.UpdatedBy = s.Author.DisplayName
+
+	v := env.View.New("admin/settings")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminSettingsPOST validates and saves the site settings, and applies them
+// from the next request.
+func AdminSettingsPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminSettingsViewModel)
+	viewModel.ValidationErrors = make(map[string]string)
+
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		merr, ok := err.(schema.MultiError)
+		if !ok {
+			return basehandler.AppErrorDefault(err)
+		}
+		for field := range merr {
+			viewModel.ValidationErrors[field] = "Enter a whole number, or leave blank"
+		}
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	viewModel.BlogName = strings.TrimSpace(viewModel.BlogName)
+	for field, msg := range viewModel.Settings.Validate() {
+		if _, invalid := viewModel.ValidationErrors[field]; !invalid {
+			viewModel.ValidationErrors[field] = msg
+		}
+	}
+
+	if len(viewModel.ValidationErrors) > 0 {
+		b, err := getBlog(ctx, model.BlogID(ctx))
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		viewModel.Defaults = blogConfig(b)
+		v := env.View.New("admin/settings")
+		v.Data = viewModel
+		if err := v.Render(ctx, w, r); err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		return nil
+	}
+
+	previous, err := model.GetSettings(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	s := &model.Settings{
+		BlogName:               viewModel.BlogName,
+		PostsPerPage:           viewModel.PostsPerPage,
+		FeedSize:               viewModel.FeedSize,
+		ExcerptCharLength:      viewModel.ExcerptCharLength,
+		VersionsKeepLast:       viewModel.VersionsKeepLast,
+		VersionsDailyAfterDays: viewModel.VersionsDailyAfterDays,
+		TrashDays:              viewModel.TrashDays,
+		DateFormatForEditing:   viewModel.DateFormatForEditing,
+		DateFormatShort:        viewModel.DateFormatShort,
+		DateFormatFull:         viewModel.DateFormatFull,
+		Updated:                time.Now(),
+		Author:                 *author,
+	}
+	if _, err := s.Save(ctx); err != nil {
+		return basehandler.AppErrorf("Unable to save settings",
+			http.StatusInternalServerError, err)
+	}
+	forgetSites()
+
+	a := model.NewAudit("Settings changed",
+		settingsChanges(settingsFromEntity(previous), viewModel.Settings), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, "Settings saved")
+	http.Redirect(w, r, "/admin/settings", http.StatusFound)
+	return nil
+}
@@ -0,0 +1,420 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/slug"
+
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// blogHeader carries the ID of the blog a task was queued for, as tasks are
+// requested on the application's own host name rather than the blog's.
+const blogHeader = "X-Goblogengine-Blog"
+
+// siteMaxAge is how long an instance uses a blog's configuration before
+// reloading it, so that changes saved by other instances are picked up.
+const siteMaxAge = 30 * time.Second
+
+// site is the blog served on a host name, with its configuration.
+type site struct {
+	blogID string
+	config appenv.Config
+	loaded time.Time
+}
+
+var sitesMutex sync.Mutex
+var sites = make(map[string]*site)
+
+// ResolveBlog returns a handler which serves each request with the blog for
+// its host name, or for tasks the blog they were queued for. On the live
+// server, requests for host names which serve no blog are redirected to the
+// base domain name. The development server serves the default blog instead.
+func ResolveBlog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := appenv.NewContext(r)
+
+		var s *site
+		var err error
+		if strings.HasPrefix(r.URL.Path, "/tasks/") {
+			s, err = siteForBlog(ctx, r.Header.Get(blogHeader))
+		} else {
+			s, err = siteForHost(ctx, hostName(r.Host))
+		}
+
+		if err == model.ErrorNoMatchingBlog {
+			if appenv.GetEnv().HostEnv == appenv.EnvLive {
+				redirectURL := "https://" + appenv.Defaults().BaseDomainName + r.URL.RequestURI()
+				http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
+				log.Infof(ctx, "Redirecting to %s. Original Host:%s", redirectURL, r.Host)
+				return
+			}
+			s, err = siteForBlog(ctx, model.DefaultBlogID)
+		}
+		if err != nil {
+			log.Errorf(ctx, "Unable to find the blog for %s: %v", r.Host, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		ctx = model.WithBlog(ctx, s.blogID)
+		ctx = appenv.WithConfig(ctx, s.config)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// hostName returns the lower case host name, without any port, from a
+// request's Host header.
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// siteForHost returns the blog served on a host name. The default blog is
+// served on the base domain name unless another blog has claimed it.
+func siteForHost(ctx context.Context, host string) (*site, error) {
+	return cachedSite(ctx, "host:"+host, func() (*model.Blog, error) {
+		b, err := model.GetBlogByHost(ctx, host)
+		if err == model.ErrorNoMatchingBlog && host == hostName(appenv.Defaults().BaseDomainName) {
+			return getBlog(ctx, model.DefaultBlogID)
+		}
+		return b, err
+	})
+}
+
+// siteForBlog returns the blog with the supplied ID, or the default blog if
+// the ID is empty.
+func siteForBlog(ctx context.Context, id string) (*site, error) {
+	if id == "" {
+		id = model.DefaultBlogID
+	}
+	return cachedSite(ctx, "blog:"+id, func() (*model.Blog, error) {
+		return getBlog(ctx, id)
+	})
+}
+
+// forEachBlog calls fn with a context for each blog, carrying the blog and
+// its configuration, starting with the default blog. Blogs which cannot be
+// loaded are logged and skipped.
+func forEachBlog(ctx context.Context, fn func(ctx context.Context, config appenv.Config)) error {
+	blogs, err := model.GetAllBlog(ctx)
+	if err != nil {
+		return err
+	}
+	ids := []string{model.DefaultBlogID}
+	for i := range blogs {
+		if blogs[i].ID != model.DefaultBlogID {
+			ids = append(ids, blogs[i].ID)
+		}
+	}
+
+	for _, id := range ids {
+		s, err := siteForBlog(ctx, id)
+		if err != nil {
+			log.Errorf(ctx, "Unable to load blog %s: %v", id, err)
+			continue
+		}
+		fn(appenv.WithConfig(model.WithBlog(ctx, id), s.config), s.config)
+	}
+	return nil
+}
+
+// cachedSite returns the site stored under key, loading it with load if it
+// has not been loaded recently. If reloading fails the previous site is kept.
+func cachedSite(ctx context.Context, key string, load func() (*model.Blog, error)) (*site, error) {
+	sitesMutex.Lock()
+	s, ok := sites[key]
+	sitesMutex.Unlock()
+	if ok && time.Since(s.loaded) < siteMaxAge {
+		return s, nil
+	}
+
+	b, err := load()
+	if err == nil {
+		var c appenv.Config
+		c, err = blogSettingsConfig(model.WithBlog(ctx, b.ID), b)
+		if err == nil {
+			s = &site{blogID: b.ID, config: c, loaded: time.Now()}
+		}
+	}
+	if err != nil {
+		if ok && err != model.ErrorNoMatchingBlog {
+			log.Errorf(ctx, "Unable to reload blog configuration: %v", err)
+			return s, nil
+		}
+		return nil, err
+	}
+
+	sitesMutex.Lock()
+	sites[key] = s
+	sitesMutex.Unlock()
+	return s, nil
+}
+
+// forgetSites makes this instance reload the blog configurations on the next
+// request, after they have been changed.
+func forgetSites() {
+	sitesMutex.Lock()
+	defer sitesMutex.Unlock()
+	sites = make(map[string]*site)
+}
+
+// getBlog returns the Blog with the supplied ID. The default blog needs no
+// Blog entity, so an empty one is returned if it has not been saved.
+func getBlog(ctx context.Context, id string) (*model.Blog, error) {
+	b, err := model.GetBlog(ctx, id)
+	if err == model.ErrorNoMatchingBlog && id == model.DefaultBlogID {
+		return &model.Blog{ID: id}, nil
+	}
+	return b, err
+}
+
+// blogConfig returns the configuration of a blog before its settings are
+// applied. Its name and first host name replace those from app.yaml.
+func blogConfig(b *model.Blog) appenv.Config {
+	c := appenv.Defaults()
+	if b.Name != "" {
+		c.BlogName = b.Name
+	}
+	if len(b.Hosts) > 0 {
+		c.BaseDomainName = b.Hosts[0]
+	}
+	return c
+}
+
+// blogSettingsConfig returns the configuration of a blog with its settings
+// applied. ctx must be scoped to the blog.
+func blogSettingsConfig(ctx context.Context, b *model.Blog) (appenv.Config, error) {
+	c := blogConfig(b)
+	s, err := model.GetSettings(ctx)
+	if err != nil {
+		return c, err
+	}
+	settings := settingsFromEntity(s)
+	settings.Apply(&c)
+	return c, nil
+}
+
+// newTask returns a POST task for the current blog.
+func newTask(ctx context.Context, path string, params map[string][]string) *taskqueue.Task {
+	t := taskqueue.NewPOSTTask(path, params)
+	t.Header.Set(blogHeader, model.BlogID(ctx))
+	return t
+}
+
+type blogViewModel struct {
+	// Entity properties
+	ID    string
+	Name  string
+	Hosts []string
+
+	// View properties
+	AdminURL string
+	Current  bool
+}
+
+type adminBlogListViewModel struct {
+	Blogs []blogViewModel
+
+	// Current blog properties
+	Name  string
+	Hosts string
+
+	// New entity properties
+	NewID    string
+	NewName  string
+	NewHosts string
+}
+
+// parseHosts splits a list of host names separated by commas or white space,
+// rejecting any which include a scheme, path or port.
+func parseHosts(s string) ([]string, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+	var hosts []string
+	for _, h := range fields {
+		h = strings.ToLower(h)
+		if strings.ContainsAny(h, "/:@?#") {
+			return nil, fmt.Errorf("%s is not a host name", h)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// AdminBlogListGET displays the blogs the current author belongs to, and
+// forms to change the current blog and to add another.
+func AdminBlogListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	ids, err := model.GetBlogIDsByAuthorEmail(ctx, author.Email)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	viewModel := new(adminBlogListViewModel)
+	for _, id := range ids {
+		b, err := getBlog(ctx, id)
+		if err == model.ErrorNoMatchingBlog {
+			continue
+		} else if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+
+		current := b.ID == model.BlogID(ctx)
+		if current {
+			viewModel.Name = b.Name
+			viewModel.Hosts = strings.Join(b.Hosts, "\n")
+		}
+		viewModel.Blogs = append(viewModel.Blogs, blogViewModel{
+			ID:       b.ID,
+			Name:     blogConfig(b).BlogName,
+			Hosts:    b.Hosts,
+			AdminURL: "//" + blogConfig(b).BaseDomainName + "/admin",
+			Current:  current,
+		})
+	}
+
+	v := env.View.New("admin/bloglist")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminBlogListPOST adds a blog, with the current author as its first
+// author.
+func AdminBlogListPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminBlogListViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	id := slug.Make(viewModel.NewID)
+	if id == "" {
+		return basehandler.AppErrorf("Please enter an ID for the blog",
+			http.StatusBadRequest, nil)
+	}
+	hosts, err := parseHosts(viewModel.NewHosts)
+	if err != nil {
+		return basehandler.AppErrorf(err.Error(), http.StatusBadRequest, err)
+	}
+	if len(hosts) == 0 {
+		return basehandler.AppErrorf("Please enter at least one host name for the blog",
+			http.StatusBadRequest, nil)
+	}
+
+	b := &model.Blog{
+		ID:      id,
+		Name:    strings.TrimSpace(viewModel.NewName),
+		Hosts:   hosts,
+		Created: time.Now(),
+	}
+	if _, err := b.Save(ctx, true); err == model.ErrorBlogIDAlreadyExists {
+		return basehandler.AppErrorf("A blog with that ID already exists",
+			http.StatusBadRequest, err)
+	} else if err == model.ErrorBlogHostInUse {
+		return basehandler.AppErrorf("One of the host names already serves another blog",
+			http.StatusBadRequest, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	blogCtx := model.WithBlog(ctx, b.ID)
+	first := *author
+	first.Role = model.RoleOwner
+	if _, err := first.Save(blogCtx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetSites()
+
+	a := model.NewAudit("Blog added", b.ID, *author)
+	a.Save(ctx)
+	a = model.NewAudit("Blog created", "From blog "+model.BlogID(ctx), *author)
+	a.Save(blogCtx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Blog %s added", b.ID))
+	http.Redirect(w, r, "/admin/blog/list", http.StatusFound)
+	return nil
+}
+
+// AdminBlogUpdatePOST changes the name and host names of the current blog.
+// The default blog may have no host names, and is then served on the base
+// domain name.
+func AdminBlogUpdatePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminBlogListViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	b, err := getBlog(ctx, model.BlogID(ctx))
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	hosts, err := parseHosts(viewModel.Hosts)
+	if err != nil {
+		return basehandler.AppErrorf(err.Error(), http.StatusBadRequest, err)
+	}
+	if len(hosts) == 0 && b.ID != model.DefaultBlogID {
+		return basehandler.AppErrorf("Please enter at least one host name for the blog",
+			http.StatusBadRequest, nil)
+	}
+
+	b.Name = strings.TrimSpace(viewModel.Name)
+	b.Hosts = hosts
+	if b.Created.IsZero() {
+		b.Created = time.Now()
+	}
+	if _, err := b.Save(ctx, false); err == model.ErrorBlogHostInUse {
+		return basehandler.AppErrorf("One of the host names already serves another blog",
+			http.StatusBadRequest, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	forgetSites()
+
+	a := model.NewAudit("Blog changed", strings.Join(b.Hosts, ", "), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, "Blog saved")
+	http.Redirect(w, r, "/admin/blog/list", http.StatusFound)
+	return nil
+}
@@ -0,0 +1,38 @@
+package blog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+)
+
+func TestResolveBlogReachesHandler(t *testing.T) {
+	sitesMutex.Lock()
+	sites["host:other.example.com"] = &site{
+		blogID: "other",
+		config: appenv.Config{BlogName: "Other"},
+		loaded: time.Now(),
+	}
+	sitesMutex.Unlock()
+	defer forgetSites()
+
+	var blogID, blogName string
+	h := ResolveBlog(basehandler.MakeHandler(func(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+		blogID = model.BlogID(ctx)
+		blogName = env.Config.BlogName
+		return nil
+	}))
+
+	r := httptest.NewRequest("GET", "http://Other.example.com:8080/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if blogID != "other" || blogName != "Other" {
+		t.Errorf("Have blog %q named %q in the handler, need other named Other", blogID, blogName)
+	}
+}
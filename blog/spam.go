@@ -0,0 +1,154 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/model"
+	"goblogengine/spam"
+
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/urlfetch"
+)
+
+// spamRecentWindow is the period over which submissions from an IP address
+// are counted.
+const spamRecentWindow = time.Hour
+
+// spamClassifier returns the classifier for reader submissions: the built-in
+// scorer, combined with Akismet when a key is configured.
+func spamClassifier(ctx context.Context, env appenv.AppEnv) spam.Classifier {
+	s := spam.NewScorer()
+	s.BlockedTerms = env.Config.SpamBlockedTerms
+	s.BlockedDomains = env.Config.SpamBlockedDomains
+	s.Reputation = spamReputation{}
+
+	if env.Config.AkismetKey == "" {
+		return s
+	}
+	return spam.Multi{s, &spam.Akismet{
+		HTTPClient: urlfetch.Client(ctx),
+		Key:        env.Config.AkismetKey,
+		Blog:       "http://" + env.Config.BaseDomainName,
+	}}
+}
+
+// classifySpam checks an item for spam. Classifier failures are logged and
+// the result from any classifiers which succeeded is used.
+func classifySpam(ctx context.Context, env appenv.AppEnv, item *spam.Item) spam.Result {
+	res, err := spamClassifier(ctx, env).Check(ctx, item)
+	if err != nil {
+		log.Warningf(ctx, "Spam check failed: %v", err)
+	}
+	if res.Spam {
+		log.Infof(ctx, "Classified %s from %s as spam: %v", item.Kind, item.IPAddress, res.Reasons)
+	}
+	return res
+}
+
+// recentSubmissions counts a submission from an IP address and returns the
+// number made in the current window, or zero if it cannot be counted.
+func recentSubmissions(ctx context.Context, ip string) int {
+	window := time.Now().Unix() / int64(spamRecentWindow/time.Second)
+	n, err := memcache.Increment(ctx, fmt.Sprintf("spam-recent:%s:%d", ip, window), 1, 0)
+	if err != nil {
+		log.Warningf(ctx, "Unable to count submissions from %s: %v", ip, err)
+		return 0
+	}
+	return int(n)
+}
+
+// commentSpamItem describes a stored comment for the spam classifier.
+func commentSpamItem(ctx context.Context, c *model.Comment) *spam.Item {
+	return &spam.Item{
+		Kind:        spam.KindComment,
+		AuthorName:  c.AuthorName,
+		AuthorEmail: c.AuthorEmail,
+		AuthorURL:   c.AuthorURL,
+		Content:     c.BodyMarkdown,
+		Permalink:   fmt.Sprintf("http://%s/post/%s", appenv.GetEnvContext(ctx).Config.BaseDomainName, c.PostSlug),
+		IPAddress:   c.IPAddress,
+		UserAgent:   c.UserAgent,
+	}
+}
+
+// trainCommentSpam teaches the spam classifier from a moderator moving
+// comments to a new state. Comments newly marked as spam are trained as
+// spam, and spam comments which are approved are trained as ham. Failures
+// are logged rather than returned so that they never prevent moderation.
+func trainCommentSpam(ctx context.Context, env appenv.AppEnv, ids []string, status string) {
+	if status != model.CommentSpam && status != model.CommentApproved {
+		return
+	}
+
+	c := spamClassifier(ctx, env)
+	for _, id := range ids {
+		comment, err := model.GetComment(ctx, id)
+		if err != nil {
+			log.Warningf(ctx, "Unable to load comment %s for spam training: %v", id, err)
+			continue
+		}
+
+		isSpam := status == model.CommentSpam
+		if isSpam == (comment.Status == model.CommentSpam) {
+			continue
+		}
+		if err := c.Train(ctx, commentSpamItem(ctx, comment), isSpam); err != nil {
+			log.Warningf(ctx, "Spam training failed for comment %s: %v", id, err)
+		}
+	}
+}
+
+// webmentionSpamItem describes a stored mention for the spam classifier.
+func webmentionSpamItem(m *model.Webmention) *spam.Item {
+	return &spam.Item{
+		Kind:      spam.KindWebmention,
+		AuthorURL: m.Source,
+		Content:   m.Title,
+		Permalink: m.Target,
+		IPAddress: m.IPAddress,
+	}
+}
+
+// trainWebmentionSpam teaches the spam classifier from a moderator moving
+// mentions to a new state, in the same way as trainCommentSpam.
+func trainWebmentionSpam(ctx context.Context, env appenv.AppEnv, ids []string, status string) {
+	if status != model.WebmentionSpam && status != model.WebmentionVerified {
+		return
+	}
+
+	c := spamClassifier(ctx, env)
+	for _, id := range ids {
+		m, err := model.GetWebmention(ctx, id)
+		if err != nil {
+			log.Warningf(ctx, "Unable to load webmention %s for spam training: %v", id, err)
+			continue
+		}
+
+		isSpam := status == model.WebmentionSpam
+		if isSpam == (m.Status == model.WebmentionSpam) {
+			continue
+		}
+		if err := c.Train(ctx, webmentionSpamItem(m), isSpam); err != nil {
+			log.Warningf(ctx, "Spam training failed for webmention %s: %v", id, err)
+		}
+	}
+}
+
+// spamReputation stores the scorer's domain reputation in the datastore.
+type spamReputation struct{}
+
+func (spamReputation) Get(ctx context.Context, domain string) (int, int, error) {
+	d, err := model.GetSpamDomain(ctx, domain)
+	if err != nil {
+		return 0, 0, err
+	}
+	return d.Spam, d.Ham, nil
+}
+
+func (spamReputation) Record(ctx context.Context, domains []string, spam bool) error {
+	return model.RecordSpamDomain(ctx, domains, spam)
+}
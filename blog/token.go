@@ -0,0 +1,211 @@
+package blog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/micropub"
+	"goblogengine/middleware/auth"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+)
+
+var errorInsufficientScope = errors.New("blog: access token does not have the required scope")
+
+var errorNotPostOwner = errors.New("blog: only the post's author or an editor can change it")
+
+// scopePermissions maps each token scope to the permission the token's
+// author needs to use it.
+var scopePermissions = map[string]auth.Permission{
+	model.ScopeCreate: auth.PublishPosts,
+	model.ScopeUpdate: auth.PublishPosts,
+	model.ScopeDelete: auth.PublishPosts,
+	model.ScopeMedia:  auth.UploadImages,
+}
+
+type accessTokenViewModel struct {
+	// Entity properties
+	Hash     string
+	Name     string
+	Scope    []string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+type adminTokenListViewModel struct {
+	Tokens    []accessTokenViewModel
+	AllScopes []string
+
+	// New entity properties
+	Name  string
+	Scope []string
+}
+
+// AdminTokenListGET displays the access tokens issued to the current author.
+func AdminTokenListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	tokens, err := model.GetAccessTokenByAuthor(ctx, *author)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	viewModel := new(adminTokenListViewModel)
+	viewModel.AllScopes = []string{
+		model.ScopeCreate,
+		model.ScopeUpdate,
+		model.ScopeDelete,
+		model.ScopeMedia,
+	}
+	for i := range tokens {
+		viewModel.Tokens = append(viewModel.Tokens, accessTokenViewModel{
+			Hash:     tokens[i].Hash,
+			Name:     tokens[i].Name,
+			Scope:    tokens[i].Scope,
+			Created:  tokens[i].Created,
+			LastUsed: tokens[i].LastUsed,
+		})
+	}
+
+	v := env.View.New("admin/tokenlist")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminTokenListPOST issues a new access token to the current author. The
+// token is displayed once in a flash message and cannot be retrieved later.
+func AdminTokenListPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminTokenListViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	if viewModel.Name == "" || len(viewModel.Scope) == 0 {
+		return basehandler.AppErrorf("A token needs a name and at least one scope",
+			http.StatusBadRequest, nil)
+	}
+
+	t, token, err := model.NewAccessToken(viewModel.Name, viewModel.Scope, *author)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if _, err := t.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Access token issued", t.Name, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf(
+		"Token for %s: %s. Copy it now, it will not be shown again.",
+		t.Name, token))
+	http.Redirect(w, r, "/admin/token/list", http.StatusFound)
+	return nil
+}
+
+// AdminTokenDeletePOST revokes one of the current author's access tokens.
+func AdminTokenDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	hash := r.FormValue("Hash")
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	tokens, err := model.GetAccessTokenByAuthor(ctx, *author)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	var name string
+	for i := range tokens {
+		if tokens[i].Hash == hash {
+			name = tokens[i].Name
+		}
+	}
+	if name == "" {
+		return basehandler.AppErrorf("Token not found",
+			http.StatusNotFound, nil)
+	}
+
+	if err := model.DeleteAccessToken(ctx, hash); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Access token revoked", name, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Token %s revoked", name))
+	http.Redirect(w, r, "/admin/token/list", http.StatusFound)
+	return nil
+}
+
+// requestToken returns the access token supplied with a request, either as a
+// bearer token or as the password of HTTP basic authentication. Basic
+// authentication lets clients which only support usernames and passwords use
+// a token in the same way as an application password.
+func requestToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return micropub.BearerToken(r)
+}
+
+// authoriseToken returns the AccessToken matching the supplied value. If
+// scope is not empty the token must have been granted that scope.
+func authoriseToken(ctx context.Context, token string, scope string) (*model.AccessToken, error) {
+	t, err := model.GetAccessToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" && !t.HasScope(scope) {
+		return nil, errorInsufficientScope
+	}
+	if err := refreshTokenAuthor(ctx, t, scope); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// refreshTokenAuthor replaces the copy of the author stored with a token by
+// the current one, so that tokens follow changes to the author's role, and
+// checks that the role allows the scope. Tokens of authors who have been
+// removed or disabled are no longer valid.
+func refreshTokenAuthor(ctx context.Context, t *model.AccessToken, scope string) error {
+	a, err := model.GetAuthorByEmail(ctx, t.Author.Email)
+	if err == model.ErrorNoMatchingAuthor || (err == nil && a.Disabled) {
+		return model.ErrorNoMatchingAccessToken
+	}
+	if err != nil {
+		return err
+	}
+	t.Author = *a
+
+	if p, ok := scopePermissions[scope]; ok && !auth.Can(a, p) {
+		return errorInsufficientScope
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/csimg"
+	"goblogengine/flash"
+	"goblogengine/middleware/auth"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/webhook"
+
+	"google.golang.org/appengine/log"
+)
+
+// purgeTrashTaskPath is requested by cron to purge items kept in the trash
+// for longer than the configured number of days.
+const purgeTrashTaskPath = "/tasks/purgetrash"
+
+// trashAuthor is recorded in the audit log as purging the trash.
+var trashAuthor = model.Author{DisplayName: "Trash"}
+
+var trashTypeLabels = map[string]string{
+	model.TrashTypePost:     "Post",
+	model.TrashTypeCategory: "Category",
+	model.TrashTypeImages:   "Images",
+}
+
+type trashItemViewModel struct {
+	ID         string
+	TypeLabel  string
+	Title      string
+	AuthorName string
+	Deleted    time.Time
+	Purged     time.Time
+}
+
+type adminTrashViewModel struct {
+	Items    []trashItemViewModel
+	Days     int
+	CanPurge bool
+}
+
+// trashPurgeTime returns when an item in the trash is purged.
+func trashPurgeTime(config appenv.Config, t *model.TrashItem) time.Time {
+	return t.Deleted.AddDate(0, 0, config.TrashDays)
+}
+
+// canRestore reports whether an author may restore an item from the trash.
+// Authors may restore the posts they deleted, and otherwise need the role to
+// delete what is in the item.
+func canRestore(a *model.Author, t *model.TrashItem) bool {
+	if t.Type == model.TrashTypePost {
+		return t.Author.GoogleAccountID == a.GoogleAccountID || auth.Can(a, auth.EditAllPosts)
+	}
+	return auth.Can(a, auth.ManageContent)
+}
+
+// addUndo adds a flash message saying what was moved to the trash, with a
+// button to restore it.
+func addUndo(w http.ResponseWriter, r *http.Request, t *model.TrashItem, msg string) {
+	flash.AddUndo(w, r, flash.Undo{Message: msg, URL: "/admin/trash/restore", ID: t.ID})
+}
+
+// purgeTrashItem permanently deletes an item in the trash, deleting any
+// images in it from Cloud Storage first. Images already missing from Cloud
+// Storage are logged and skipped.
+func purgeTrashItem(ctx context.Context, t *model.TrashItem) error {
+	if t.Type == model.TrashTypeImages {
+		imgs, err := model.GetTrashedImage(ctx, t.ID)
+		if err != nil {
+			return err
+		}
+		for i := range imgs {
+			if err := csimg.Delete(ctx, imgs[i].ID); err != nil {
+				log.Warningf(ctx, "Unable to delete image %s from Cloud Storage: %v", imgs[i].ID, err)
+			}
+		}
+	}
+	return model.PurgeTrashItem(ctx, t.ID)
+}
+
+// emptyTrash permanently deletes everything in the trash.
+func emptyTrash(ctx context.Context) error {
+	items, err := model.GetAllTrashItem(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		if err := purgeTrashItem(ctx, &items[i]); err != nil {
+			return err
+		}
+	}
+	return model.DeleteAllTrash(ctx)
+}
+
+// AdminTrashGET lists the items in the trash which the author may restore.
+func AdminTrashGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	items, err := model.GetAllTrashItem(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	viewModel := &adminTrashViewModel{
+		Days:     env.Config.TrashDays,
+		CanPurge: auth.Can(author, auth.ManageBlog),
+	}
+	for i := range items {
+		if !canRestore(author, &items[i]) {
+			continue
+		}
+		viewModel.Items = append(viewModel.Items, trashItemViewModel{
+			ID:         items[i].ID,
+			TypeLabel:  trashTypeLabels[items[i].Type],
+			Title:      items[i].Title,
+			AuthorName: items[i].Author.DisplayName,
+			Deleted:    items[i].Deleted,
+			Purged:     trashPurgeTime(env.Config, &items[i]),
+		})
+	}
+
+	v := env.View.New("admin/trash")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminTrashRestorePOST restores an item from the trash and returns to where
+// it belongs.
+func AdminTrashRestorePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	t, err := model.GetTrashItem(ctx, r.FormValue("ID"))
+	if err == model.ErrorNoMatchingTrashItem {
+		return basehandler.AppErrorf("Item not found in the trash", http.StatusNotFound, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	if !canRestore(author, t) {
+		return basehandler.AppErrorf("You cannot restore this item", http.StatusForbidden, nil)
+	}
+
+	if _, err := model.RestoreTrashItem(ctx, t.ID); err == model.ErrorTrashConflict {
+		return basehandler.AppErrorf(fmt.Sprintf("%s cannot be restored, as its URL or title is now used by something else", t.Title),
+			http.StatusConflict, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Restored from trash", fmt.Sprintf("%s: %s", trashTypeLabels[t.Type], t.Title), *author)
+	a.Save(ctx)
+
+	redirectURL := "/admin/trash"
+	switch t.Type {
+	case model.TrashTypePost:
+		if post, err := model.GetLatestBlogPostVersionByID(ctx, t.ItemID); err == nil {
+			redirectURL = fmt.Sprintf("/admin/post/edit/%s", post.Slug)
+		}
+		if post, err := model.GetPublishedBlogPostByID(ctx, t.ItemID); err == nil {
+			firePostWebhook(ctx, webhook.EventPostPublished, post)
+		}
+	case model.TrashTypeCategory:
+		redirectURL = "/admin/category/list"
+		fireCategoryWebhook(ctx, "added", model.Category{Slug: t.ItemID, Title: t.Title})
+	case model.TrashTypeImages:
+		redirectURL = "/admin/image/list"
+	}
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s restored", t.Title))
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	return nil
+}
+
+// AdminTrashPurgePOST permanently deletes an item in the trash.
+func AdminTrashPurgePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	t, err := model.GetTrashItem(ctx, r.FormValue("ID"))
+	if err == model.ErrorNoMatchingTrashItem {
+		return basehandler.AppErrorf("Item not found in the trash", http.StatusNotFound, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if err := purgeTrashItem(ctx, t); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Deleted permanently", fmt.Sprintf("%s: %s", trashTypeLabels[t.Type], t.Title), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%s deleted permanently", t.Title))
+	http.Redirect(w, r, "/admin/trash", http.StatusFound)
+	return nil
+}
+
+// PurgeTrashTaskGET is run by cron to purge the items of every blog kept in
+// the trash for longer than its configured number of days.
+func PurgeTrashTaskGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+
+	err := forEachBlog(ctx, func(bctx context.Context, config appenv.Config) {
+		items, err := model.GetAllTrashItem(bctx)
+		if err != nil {
+			log.Errorf(ctx, "Unable to list trash of blog %s: %v", model.BlogID(bctx), err)
+			return
+		}
+		now := time.Now()
+		for i := range items {
+			if trashPurgeTime(config, &items[i]).After(now) {
+				continue
+			}
+			if err := purgeTrashItem(bctx, &items[i]); err != nil {
+				log.Errorf(ctx, "Unable to purge %s from trash of blog %s: %v", items[i].ID, model.BlogID(bctx), err)
+				continue
+			}
+			a := model.NewAudit("Deleted permanently",
+				fmt.Sprintf("%s: %s", trashTypeLabels[items[i].Type], items[i].Title), trashAuthor)
+			a.Save(bctx)
+		}
+	})
+	if err != nil {
+		log.Errorf(ctx, "Unable to list blogs: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
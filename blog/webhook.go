@@ -0,0 +1,300 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/webhook"
+
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+)
+
+const webhookTaskPath = "/tasks/webhook"
+
+type webhookViewModel struct {
+	// Entity properties
+	ID      string
+	URL     string
+	Secret  string
+	Events  []string
+	Created time.Time
+}
+
+type webhookDeliveryViewModel struct {
+	// Entity properties
+	URL         string
+	Event       string
+	Created     time.Time
+	Attempts    int
+	LastAttempt time.Time
+	StatusCode  int
+	Error       string
+	Delivered   bool
+}
+
+type adminWebhookListViewModel struct {
+	Webhooks   []webhookViewModel
+	Deliveries []webhookDeliveryViewModel
+	AllEvents  []string
+
+	// New entity properties
+	URL    string
+	Events []string
+}
+
+// AdminWebhookListGET displays the registered webhooks and recent deliveries.
+func AdminWebhookListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	hooks, err := model.GetAllWebhook(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	deliveries, err := model.GetWebhookDeliveryTail(ctx)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	viewModel := new(adminWebhookListViewModel)
+	viewModel.AllEvents = webhook.AllEvents
+	for i := range hooks {
+		viewModel.Webhooks = append(viewModel.Webhooks, webhookViewModel{
+			ID:      hooks[i].ID,
+			URL:     hooks[i].URL,
+			Secret:  hooks[i].Secret,
+			Events:  hooks[i].Events,
+			Created: hooks[i].Created,
+		})
+	}
+	for i := range deliveries {
+		viewModel.Deliveries = append(viewModel.Deliveries, webhookDeliveryViewModel{
+			URL:         deliveries[i].URL,
+			Event:       deliveries[i].Event,
+			Created:     deliveries[i].Created,
+			Attempts:    deliveries[i].Attempts,
+			LastAttempt: deliveries[i].LastAttempt,
+			StatusCode:  deliveries[i].StatusCode,
+			Error:       deliveries[i].Error,
+			Delivered:   deliveries[i].Delivered,
+		})
+	}
+
+	v := env.View.New("admin/webhooklist")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminWebhookListPOST registers a new webhook.
+func AdminWebhookListPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminWebhookListViewModel)
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if err := env.FormDecoder.Decode(viewModel, r.PostForm); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, ok := env.User.(*model.Author)
+	if !ok {
+		return basehandler.AppErrorf("Not logged in",
+			http.StatusInternalServerError, nil)
+	}
+
+	u, err := url.Parse(viewModel.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return basehandler.AppErrorf("A webhook needs an http or https URL",
+			http.StatusBadRequest, err)
+	}
+	if len(viewModel.Events) == 0 {
+		return basehandler.AppErrorf("A webhook needs at least one event",
+			http.StatusBadRequest, nil)
+	}
+
+	h, err := model.NewWebhook(u.String(), viewModel.Events, *author)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	if _, err := h.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	a := model.NewAudit("Webhook added", h.URL, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Webhook for %s added", h.URL))
+	http.Redirect(w, r, "/admin/webhook/list", http.StatusFound)
+	return nil
+}
+
+// AdminWebhookDeletePOST removes a webhook. Deliveries already queued for it
+// are abandoned.
+func AdminWebhookDeletePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	h, err := model.GetWebhook(ctx, r.FormValue("ID"))
+	if err == model.ErrorNoMatchingWebhook {
+		return basehandler.AppErrorf("Webhook not found",
+			http.StatusNotFound, nil)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	if err := model.DeleteWebhook(ctx, h.ID); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Webhook deleted", h.URL, *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("Webhook for %s deleted", h.URL))
+	http.Redirect(w, r, "/admin/webhook/list", http.StatusFound)
+	return nil
+}
+
+// WebhookTaskPOST is run by the task queue to attempt a delivery. It always
+// responds with 200 so that the task queue does not retry; retries are added
+// by webhook.Attempt with their own backoff.
+func WebhookTaskPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+
+	attempt, err := strconv.Atoi(r.FormValue("Attempt"))
+	if err != nil {
+		log.Errorf(ctx, "Invalid webhook task attempt: %v", err)
+		return
+	}
+
+	d, err := model.GetWebhookDelivery(ctx, r.FormValue("DeliveryID"))
+	if err != nil {
+		log.Errorf(ctx, "Unable to load webhook delivery: %v", err)
+		return
+	}
+	h, err := model.GetWebhook(ctx, d.HookID)
+	if err == model.ErrorNoMatchingWebhook {
+		log.Infof(ctx, "Webhook %s deleted, abandoning delivery %s", d.HookID, d.ID)
+		return
+	}
+	if err != nil {
+		log.Errorf(ctx, "Unable to load webhook: %v", err)
+		return
+	}
+
+	status, err := webhook.Attempt(ctx, urlfetch.Client(ctx), taskQueue{},
+		webhook.Delivery{
+			ID:     d.ID,
+			URL:    h.URL,
+			Secret: h.Secret,
+			Event:  d.Event,
+			Body:   []byte(d.Payload),
+		}, attempt)
+	if err != nil {
+		log.Warningf(ctx, "Webhook delivery %s attempt %d failed: %v", d.ID, attempt, err)
+	}
+
+	d.RecordAttempt(status, err)
+	if _, err := d.Save(ctx); err != nil {
+		log.Errorf(ctx, "Unable to save webhook delivery: %v", err)
+	}
+}
+
+// taskQueue adds webhook tasks to the App Engine default task queue.
+type taskQueue struct{}
+
+func (taskQueue) Add(ctx context.Context, t webhook.Task, delay time.Duration) error {
+	task := newTask(ctx, webhookTaskPath, url.Values{
+		"DeliveryID": {t.DeliveryID},
+		"Attempt":    {strconv.Itoa(t.Attempt)},
+	})
+	task.Delay = delay
+	_, err := taskqueue.Add(ctx, task, "")
+	return err
+}
+
+// fireWebhook queues a delivery of an event to every webhook subscribed to
+// it. Failures are logged rather than returned so that they never prevent
+// the action which raised the event.
+func fireWebhook(ctx context.Context, event string, data map[string]string) {
+	hooks, err := model.GetWebhookByEvent(ctx, event)
+	if err != nil {
+		log.Errorf(ctx, "Unable to load webhooks for %s: %v", event, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload, err := webhook.NewPayload(event, data)
+	if err != nil {
+		log.Errorf(ctx, "Unable to create webhook payload: %v", err)
+		return
+	}
+
+	for i := range hooks {
+		d, err := model.NewWebhookDelivery(&hooks[i], event, payload)
+		if err == nil {
+			_, err = d.Save(ctx)
+		}
+		if err == nil {
+			err = taskQueue{}.Add(ctx, webhook.Task{DeliveryID: d.ID, Attempt: 1}, 0)
+		}
+		if err != nil {
+			log.Errorf(ctx, "Unable to queue webhook for %s: %v", hooks[i].URL, err)
+		}
+	}
+}
+
+// firePostWebhook fires an event describing a version of a post.
+func firePostWebhook(ctx context.Context, event string, p *model.BlogPostVersion) {
+	baseURL := "http://" + appenv.GetEnvContext(ctx).Config.BaseDomainName
+	fireWebhook(ctx, event, map[string]string{
+		"post_id": p.PostID,
+		"slug":    p.Slug,
+		"title":   p.Title,
+		"version": strconv.Itoa(p.Version),
+		"url":     fmt.Sprintf("%s/post/%s", baseURL, p.Slug),
+	})
+}
+
+// fireSavedPostWebhooks fires the events for a newly saved version of a post,
+// and queues webmentions if it was published. Previous is the version it
+// replaced, or nil if the post is new or the previously published version
+// was left published.
+func fireSavedPostWebhooks(ctx context.Context, previous *model.BlogPostVersion, p *model.BlogPostVersion) {
+	firePostWebhook(ctx, webhook.EventVersionCreated, p)
+	if p.Published {
+		firePostWebhook(ctx, webhook.EventPostPublished, p)
+		queueWebmentions(ctx, p)
+	} else if previous != nil && previous.Published {
+		firePostWebhook(ctx, webhook.EventPostUnpublished, p)
+	}
+}
+
+// fireImageWebhook fires the image uploaded event.
+func fireImageWebhook(ctx context.Context, img *model.Image) {
+	baseURL := "http://" + appenv.GetEnvContext(ctx).Config.BaseDomainName
+	fireWebhook(ctx, webhook.EventImageUploaded, map[string]string{
+		"id":  img.ID,
+		"url": baseURL + img.LocalURL,
+	})
+}
+
+// fireCategoryWebhook fires the category changed event. Change is either
+// "added" or "deleted".
+func fireCategoryWebhook(ctx context.Context, change string, cat model.Category) {
+	fireWebhook(ctx, webhook.EventCategoryChanged, map[string]string{
+		"change": change,
+		"slug":   cat.Slug,
+		"title":  cat.Title,
+	})
+}
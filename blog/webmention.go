@@ -0,0 +1,296 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/russross/blackfriday"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/webmention"
+
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+)
+
+const (
+	webmentionVerifyTaskPath = "/tasks/webmention/verify"
+	webmentionSendTaskPath   = "/tasks/webmention/send"
+)
+
+// webmentionStatuses lists the verification states in the order they are
+// shown in admin.
+var webmentionStatuses = []string{
+	model.WebmentionPending,
+	model.WebmentionVerified,
+	model.WebmentionSpam,
+	model.WebmentionRejected,
+}
+
+// webmentionActions maps moderation form actions onto mention states.
+var webmentionActions = map[string]string{
+	"approve": model.WebmentionVerified,
+	"reject":  model.WebmentionRejected,
+	"spam":    model.WebmentionSpam,
+}
+
+type webmentionViewModel struct {
+	// Entity properties
+	ID     string
+	Source string
+	Title  string
+	Status string
+
+	// View properties
+	Host            string
+	Received        string
+	ReceivedDisplay string
+	PostURL         string
+}
+
+func (vm *webmentionViewModel) fromEntity(m *model.Webmention, datefFull string, datefShort string) {
+	vm.ID = m.ID
+	vm.Source = m.Source
+	vm.Title = m.Title
+	if u, err := url.Parse(m.Source); err == nil {
+		vm.Host = u.Host
+	}
+	vm.Received = m.Received.Format(datefFull)
+	vm.ReceivedDisplay = m.Received.Format(datefShort)
+	vm.PostURL = fmt.Sprintf("/post/%s", m.PostSlug)
+}
+
+type adminWebmentionListViewModel struct {
+	Status      string
+	Statuses    []commentStatusViewModel
+	Webmentions []webmentionViewModel
+}
+
+// WebmentionPOST receives a Webmention. The target must be a published post;
+// the mention is stored as pending and verified by a queued task.
+func WebmentionPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+
+	source, target, err := webmention.ParseRequest(r, env.Config.BaseDomainName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, _ := url.Parse(target)
+	slug := strings.TrimPrefix(u.Path, "/post/")
+	if slug == u.Path || slug == "" || strings.Contains(slug, "/") {
+		http.Error(w, "Target is not a post", http.StatusBadRequest)
+		return
+	}
+	post, err := model.GetBlogPostBySlug(ctx, slug)
+	if err != nil {
+		http.Error(w, "Target post not found", http.StatusBadRequest)
+		return
+	}
+
+	m := model.NewWebmention(post, source, target)
+	m.IPAddress = r.RemoteAddr
+	if _, err := m.Save(ctx); err != nil {
+		log.Errorf(ctx, "Unable to save webmention: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	task := newTask(ctx, webmentionVerifyTaskPath, url.Values{"ID": {m.ID}})
+	if _, err := taskqueue.Add(ctx, task, ""); err != nil {
+		log.Errorf(ctx, "Unable to queue webmention verification: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// WebmentionVerifyTaskPOST is run by the task queue to check that the source
+// of a received mention links to its target. Mentions which do not are
+// rejected and verified mentions are checked for spam; other failures respond
+// with an error so that the task is retried.
+func WebmentionVerifyTaskPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+
+	m, err := model.GetWebmention(ctx, r.FormValue("ID"))
+	if err != nil {
+		log.Errorf(ctx, "Unable to load webmention: %v", err)
+		return
+	}
+
+	c := &webmention.Client{HTTPClient: urlfetch.Client(ctx)}
+	s, err := c.Verify(m.Source, m.Target)
+	switch err {
+	case nil:
+		m.Status = model.WebmentionVerified
+		m.Title = s.Title
+		m.Verified = time.Now()
+		if classifySpam(ctx, env, webmentionSpamItem(m)).Spam {
+			m.Status = model.WebmentionSpam
+		}
+	case webmention.ErrorNoLink, webmention.ErrorSourceGone:
+		log.Infof(ctx, "Rejecting webmention from %s: %v", m.Source, err)
+		m.Status = model.WebmentionRejected
+	default:
+		log.Warningf(ctx, "Unable to verify webmention from %s: %v", m.Source, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := m.Save(ctx); err != nil {
+		log.Errorf(ctx, "Unable to save webmention: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// AdminWebmentionListGET displays the received mentions in a verification
+// state, pending by default.
+func AdminWebmentionListGET(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	viewModel := new(adminWebmentionListViewModel)
+	viewModel.Status = r.FormValue("Status")
+	if viewModel.Status == "" {
+		viewModel.Status = model.WebmentionPending
+	}
+
+	for _, s := range webmentionStatuses {
+		n, err := model.GetWebmentionCountByStatus(ctx, s)
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		viewModel.Statuses = append(viewModel.Statuses, commentStatusViewModel{
+			Name:    s,
+			Count:   n,
+			URL:     "/admin/webmention/list?Status=" + s,
+			Current: s == viewModel.Status,
+		})
+	}
+
+	ms, err := model.GetWebmentionByStatus(ctx, viewModel.Status, 100)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	for i := range ms {
+		m := new(webmentionViewModel)
+		m.fromEntity(&ms[i], env.Config.DateFormatFull, env.Config.DateFormatShort)
+		viewModel.Webmentions = append(viewModel.Webmentions, *m)
+	}
+
+	v := env.View.New("admin/webmentionlist")
+	v.Data = viewModel
+	if err := v.Render(ctx, w, r); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	return nil
+}
+
+// AdminWebmentionModeratePOST approves, rejects, marks as spam or deletes
+// received mentions, in the same way as AdminCommentModeratePOST. Marking
+// mentions as spam, or approving spam, trains the spam classifier.
+func AdminWebmentionModeratePOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	if err := r.ParseForm(); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	listURL := "/admin/webmention/list?Status=" + url.QueryEscape(r.PostFormValue("Status"))
+	action := r.PostFormValue("Action")
+	ids := r.PostForm["ID"]
+	if row := r.PostFormValue("RowAction"); row != "" {
+		parts := strings.SplitN(row, ":", 2)
+		if len(parts) != 2 {
+			return basehandler.AppErrorf("Invalid moderation request",
+				http.StatusBadRequest, nil)
+		}
+		action, ids = parts[0], []string{parts[1]}
+	}
+	if len(ids) == 0 {
+		flash.AddFlash(w, r, "No webmentions selected")
+		http.Redirect(w, r, listURL, http.StatusFound)
+		return nil
+	}
+
+	var err error
+	if action == "delete" {
+		err = model.DeleteWebmention(ctx, ids)
+	} else if status, ok := webmentionActions[action]; ok {
+		trainWebmentionSpam(ctx, env, ids, status)
+		err = model.SetWebmentionStatus(ctx, ids, status)
+	} else {
+		return basehandler.AppErrorf("Invalid moderation action",
+			http.StatusBadRequest, nil)
+	}
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	author, _ := env.User.(*model.Author)
+	a := model.NewAudit("Webmentions moderated",
+		fmt.Sprintf("%s %d webmention(s)", action, len(ids)), *author)
+	a.Save(ctx)
+
+	flash.AddFlash(w, r, fmt.Sprintf("%d webmention(s) updated", len(ids)))
+	http.Redirect(w, r, listURL, http.StatusFound)
+	return nil
+}
+
+// queueWebmentions queues a task to notify the pages linked to from a newly
+// published version of a post. Failures are logged rather than returned so
+// that they never prevent publishing.
+func queueWebmentions(ctx context.Context, p *model.BlogPostVersion) {
+	task := newTask(ctx, webmentionSendTaskPath, url.Values{
+		"PostID":  {p.PostID},
+		"Version": {strconv.Itoa(p.Version)},
+	})
+	if _, err := taskqueue.Add(ctx, task, ""); err != nil {
+		log.Errorf(ctx, "Unable to queue webmentions for %s: %v", p.Slug, err)
+	}
+}
+
+// WebmentionSendTaskPOST is run by the task queue to send a Webmention to
+// every page linked to from a post which accepts them. Pages on this site
+// and failed notifications are skipped.
+func WebmentionSendTaskPOST(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+	env := appenv.GetEnvContext(ctx)
+
+	version, err := strconv.Atoi(r.FormValue("Version"))
+	if err != nil {
+		log.Errorf(ctx, "Invalid webmention task version: %v", err)
+		return
+	}
+	p, err := model.GetBlogPostVersion(ctx, r.FormValue("PostID"), version)
+	if err != nil {
+		log.Errorf(ctx, "Unable to load post for webmentions: %v", err)
+		return
+	}
+
+	source := fmt.Sprintf("http://%s/post/%s", env.Config.BaseDomainName, p.Slug)
+	body := string(blackfriday.MarkdownCommon([]byte(p.BodyMarkdown)))
+
+	c := &webmention.Client{HTTPClient: urlfetch.Client(ctx)}
+	for _, target := range webmention.Links(body, source) {
+		if u, err := url.Parse(target); err != nil || strings.EqualFold(u.Host, env.Config.BaseDomainName) {
+			continue
+		}
+		err := c.Notify(source, target)
+		if err == webmention.ErrorNoEndpoint {
+			continue
+		}
+		if err != nil {
+			log.Warningf(ctx, "Unable to send webmention to %s: %v", target, err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package blog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goblogengine/appenv"
+	"goblogengine/flash"
+	"goblogengine/middleware/auth"
+	"goblogengine/middleware/basehandler"
+	"goblogengine/model"
+	"goblogengine/webhook"
+	"goblogengine/workflow"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/mail"
+)
+
+// publishScheduledTaskPath is requested by cron to publish scheduled posts.
+const publishScheduledTaskPath = "/tasks/publishscheduled"
+
+// WorkflowNotifier is told whenever a post version moves between workflow
+// states. It emails those concerned by default, and may be replaced, for
+// example by a workflow.Multi which also posts to a chat service.
+var WorkflowNotifier workflow.Notifier = mailNotifier{}
+
+// actionLabels name the buttons which move a version to each state. Some
+// moves back are named after where the version is coming from.
+var actionLabels = map[string]string{
+	workflow.Draft:            "Return to draft",
+	workflow.InReview:         "Submit for review",
+	workflow.ChangesRequested: "Request changes",
+	workflow.Approved:         "Approve",
+	workflow.Scheduled:        "Schedule",
+	workflow.Published:        "Publish",
+}
+
+type workflowActionViewModel struct {
+	State string
+	Label string
+}
+
+type reviewViewModel struct {
+	Version    int
+	AuthorName string
+	Body       string
+	StateLabel string
+	Created    time.Time
+}
+
+// canMoveVersion reports whether an author may move a version of a post
+// they are allowed to change to a state. Only authors who can edit all posts
+// review, and then only versions model.BlogPostVersion.CanReview allows,
+// which the model checks again as the version moves. Scheduling and
+// publishing need PublishPosts, as does unpublishing.
+func canMoveVersion(a *model.Author, ver *model.BlogPostVersion, to string) bool {
+	switch to {
+	case workflow.ChangesRequested, workflow.Approved:
+		if ver.EffectiveState() == workflow.InReview {
+			return auth.Can(a, auth.EditAllPosts) && ver.CanReview(a)
+		}
+		return auth.Can(a, auth.PublishPosts)
+	case workflow.Scheduled, workflow.Published:
+		return auth.Can(a, auth.PublishPosts)
+	}
+	return auth.Can(a, auth.WriteDrafts)
+}
+
+// workflowActions returns the moves the author may make with a version.
+func workflowActions(a *model.Author, ver *model.BlogPostVersion) []workflowActionViewModel {
+	var actions []workflowActionViewModel
+	from := ver.EffectiveState()
+	for _, to := range workflow.Next(from) {
+		if !canMoveVersion(a, ver, to) {
+			continue
+		}
+		label := actionLabels[to]
+		if from == workflow.Published {
+			label = "Unpublish"
+		} else if from == workflow.Scheduled && to == workflow.Approved {
+			label = "Cancel schedule"
+		}
+		actions = append(actions, workflowActionViewModel{State: to, Label: label})
+	}
+	return actions
+}
+
+// addWorkflow adds the selected version's state, the moves the author may
+// make with it, those who can review it and the post's reviews.
+func (vm *blogPostEditViewModel) addWorkflow(ctx context.Context, a *model.Author, ver *model.BlogPostVersion) error {
+	vm.State = ver.EffectiveState()
+	vm.StateLabel = workflow.Label(vm.State)
+	vm.Actions = workflowActions(a, ver)
+	vm.ReviewerEmail = ver.ReviewerEmail
+
+	authors, err := model.GetAllAuthor(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range authors {
+		if authors[i].Disabled || !auth.Can(&authors[i], auth.EditAllPosts) ||
+			authors[i].GoogleAccountID == ver.Author.GoogleAccountID {
+			continue
+		}
+		vm.Reviewers = append(vm.Reviewers, authorViewModel{
+			DisplayName: authors[i].DisplayName,
+			Email:       authors[i].Email,
+		})
+	}
+
+	reviews, err := model.GetReviewByPostID(ctx, ver.PostID)
+	if err != nil {
+		return err
+	}
+	for i := range reviews {
+		vm.Reviews = append(vm.Reviews, reviewViewModel{
+			Version:    reviews[i].Version,
+			AuthorName: reviews[i].Author.DisplayName,
+			Body:       reviews[i].BodyMarkdown,
+			StateLabel: workflow.Label(reviews[i].State),
+			Created:    reviews[i].Created,
+		})
+	}
+	return nil
+}
+
+// AdminPostReviewPOST moves a version of a post between workflow states,
+// optionally with a review comment, or adds a comment without moving it.
+func AdminPostReviewPOST(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter, r *http.Request) *basehandler.AppError {
+	id := r.FormValue("PostID")
+	to := r.FormValue("State")
+	comment := strings.TrimSpace(r.FormValue("Comment"))
+
+	versionNum, err := strconv.Atoi(r.FormValue("Version"))
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	post, err := model.GetBlogPostVersion(ctx, id, versionNum)
+	if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+	author, _ := env.User.(*model.Author)
+	if e := checkPostOwner(ctx, author, id); e != nil {
+		return e
+	}
+
+	if to == "" && comment == "" {
+		return basehandler.AppErrorf("Please enter a comment", http.StatusBadRequest, nil)
+	}
+	if to != "" && (!workflow.Valid(to) || !canMoveVersion(author, post, to)) {
+		return basehandler.AppErrorf("Your role does not allow this",
+			http.StatusForbidden, nil)
+	}
+
+	var from string
+	switch to {
+	case "":
+		from = post.EffectiveState()
+	case workflow.InReview:
+		var reviewer *model.Author
+		reviewer, err = model.GetAuthorByEmail(ctx, r.FormValue("Reviewer"))
+		if err == model.ErrorNoMatchingAuthor || (err == nil &&
+			(reviewer.Disabled || !auth.Can(reviewer, auth.EditAllPosts))) {
+			return basehandler.AppErrorf("Please choose an editor to review the post",
+				http.StatusBadRequest, err)
+		} else if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		post, from, err = model.SubmitBlogPostVersion(ctx, id, versionNum, reviewer.Email)
+	case workflow.Scheduled:
+		var at time.Time
+		at, err = time.ParseInLocation(env.Config.DateFormatForEditing, r.FormValue("ScheduleAt"), time.UTC)
+		if err != nil {
+			return basehandler.AppErrorf("Please enter the time to publish the post",
+				http.StatusBadRequest, err)
+		}
+		post, from, err = model.ScheduleBlogPostVersion(ctx, id, versionNum, at)
+	default:
+		post, from, err = model.TransitionBlogPostVersion(ctx, id, versionNum, to, author)
+	}
+	if te, ok := err.(*workflow.TransitionError); ok {
+		return basehandler.AppErrorf(strings.TrimPrefix(te.Error(), "workflow: "),
+			http.StatusBadRequest, err)
+	} else if err == model.ErrorNotReviewer {
+		return basehandler.AppErrorf("Only the post's reviewer may review this version",
+			http.StatusForbidden, err)
+	} else if err == model.ErrorScheduleInPast {
+		return basehandler.AppErrorf("The time to publish the post has already passed",
+			http.StatusBadRequest, err)
+	} else if err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	rv := model.NewReview(post, comment, to, *author)
+	if _, err := rv.Save(ctx); err != nil {
+		return basehandler.AppErrorDefault(err)
+	}
+
+	fmsg := "Comment added"
+	if to != "" {
+		fmsg = fmt.Sprintf("%s moved to %s", post.Title, strings.ToLower(workflow.Label(to)))
+		a := model.NewAudit("Post moved to "+strings.ToLower(workflow.Label(to)), post.Title, *author)
+		a.Save(ctx)
+		firePostStateWebhooks(ctx, from, post)
+		notifyWorkflow(ctx, workflow.Event{
+			PostID:        post.PostID,
+			Version:       post.Version,
+			Title:         post.Title,
+			URL:           fmt.Sprintf("http://%s/admin/post/edit/%s?SelectedVersion=%d", env.Config.BaseDomainName, post.Slug, post.Version),
+			From:          from,
+			To:            to,
+			Comment:       comment,
+			ActorName:     author.DisplayName,
+			ActorEmail:    author.Email,
+			AuthorEmail:   post.Author.Email,
+			ReviewerEmail: post.ReviewerEmail,
+		})
+	}
+
+	flash.AddFlash(w, r, fmsg)
+	redirectURL := fmt.Sprintf("/admin/post/edit/%s?SelectedVersion=%d", post.Slug, post.Version)
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	return nil
+}
+
+// firePostStateWebhooks fires the events for a version which has moved from
+// one state to another, and queues webmentions if it was published.
+func firePostStateWebhooks(ctx context.Context, from string, p *model.BlogPostVersion) {
+	if p.Published {
+		firePostWebhook(ctx, webhook.EventPostPublished, p)
+		queueWebmentions(ctx, p)
+	} else if from == workflow.Published {
+		firePostWebhook(ctx, webhook.EventPostUnpublished, p)
+	}
+}
+
+// notifyWorkflow tells WorkflowNotifier about an event. Failures are logged
+// rather than returned so that they never prevent the move.
+func notifyWorkflow(ctx context.Context, e workflow.Event) {
+	if err := WorkflowNotifier.Notify(ctx, e); err != nil {
+		log.Errorf(ctx, "Unable to send notification of %s moving to %s: %v", e.Title, e.To, err)
+	}
+}
+
+// mailNotifier emails those concerned with a workflow event.
+type mailNotifier struct{}
+
+func (mailNotifier) Notify(ctx context.Context, e workflow.Event) error {
+	to := e.Recipients()
+	if len(to) == 0 {
+		return nil
+	}
+
+	actor := e.ActorName
+	if actor == "" {
+		actor = "The scheduler"
+	}
+	body := fmt.Sprintf("%s moved version %d of %q from %s to %s.\n",
+		actor, e.Version, e.Title,
+		strings.ToLower(workflow.Label(e.From)), strings.ToLower(workflow.Label(e.To)))
+	if e.Comment != "" {
+		body += "\n" + e.Comment + "\n"
+	}
+	body += "\n" + e.URL + "\n"
+
+	env := appenv.GetEnvContext(ctx)
+	return mail.Send(ctx, &mail.Message{
+		Sender:  fmt.Sprintf("%s <noreply@%s.appspotmail.com>", env.Config.BlogName, appengine.AppID(ctx)),
+		To:      to,
+		Subject: fmt.Sprintf("%s: %s", workflow.Label(e.To), e.Title),
+		Body:    body,
+	})
+}
+
+// PublishScheduledTaskGET is run by cron to publish the scheduled posts of
+// every blog which are due.
+func PublishScheduledTaskGET(w http.ResponseWriter, r *http.Request) {
+	ctx := appenv.NewContext(r)
+
+	err := forEachBlog(ctx, func(bctx context.Context, config appenv.Config) {
+		published, err := model.PublishDueBlogPostVersions(bctx, time.Now())
+		if err != nil {
+			log.Errorf(ctx, "Unable to publish scheduled posts of blog %s: %v", model.BlogID(bctx), err)
+		}
+		for i := range published {
+			p := &published[i]
+			a := model.NewAudit("Post published on schedule", p.Title, p.Author)
+			a.Save(bctx)
+			firePostStateWebhooks(bctx, workflow.Scheduled, p)
+			notifyWorkflow(bctx, workflow.Event{
+				PostID:      p.PostID,
+				Version:     p.Version,
+				Title:       p.Title,
+				URL:         fmt.Sprintf("http://%s/post/%s", config.BaseDomainName, p.Slug),
+				From:        workflow.Scheduled,
+				To:          workflow.Published,
+				AuthorEmail: p.Author.Email,
+			})
+		}
+	})
+	if err != nil {
+		log.Errorf(ctx, "Unable to list blogs: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
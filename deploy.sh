@@ -13,6 +13,9 @@ if echo "$answer" | grep -iq "^y" ;then
     echo "Deploying indexes..."
     gcloud app deploy --quiet main/index.yaml
     echo
+    echo "Deploying cron jobs..."
+    gcloud app deploy --quiet main/cron.yaml
+    echo
     echo "Deploying application..."
     gcloud app deploy --quiet main
     echo
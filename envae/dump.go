@@ -0,0 +1,121 @@
+package envae
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Redacted replaces the values of secret keys in the output of Dump.
+const Redacted = "[redacted]"
+
+// Value is a single populated configuration value.
+type Value struct {
+	Key    string
+	Field  string
+	Value  string
+	Secret bool
+}
+
+// Dump lists the current values of the tagged fields of a configuration
+// struct, or pointer to one, in the format they would be configured in. The
+// values of keys tagged secret are replaced with Redacted unless they are
+// empty.
+func Dump(config interface{}) ([]Value, error) {
+	rv := reflect.ValueOf(config)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("envae: cannot dump %s, need a struct", rv.Kind())
+	}
+
+	var vals []Value
+	if err := dumpFields(rv, &vals); err != nil {
+		return nil, err
+	}
+	sort.Slice(vals, func(i, j int) bool {
+		return vals[i].Key < vals[j].Key
+	})
+	return vals, nil
+}
+
+func dumpFields(v reflect.Value, vals *[]Value) error {
+	t := v.Type()
+
+	for i := 0; i < v.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		fval := v.Field(i)
+
+		tag := field.Tag.Get(tagPrefix)
+		if tag == "" {
+			if fval.Kind() == reflect.Struct {
+				if err := dumpFields(fval, vals); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		opts, err := parseTag(tag)
+		if err != nil {
+			return &KeyError{Key: opts.key, Field: field.Name, Err: err}
+		}
+		val := Value{Key: opts.key, Field: field.Name, Secret: opts.secret}
+		val.Value = formatValue(fval)
+		if opts.secret && val.Value != "" {
+			val.Value = Redacted
+		}
+		*vals = append(*vals, val)
+	}
+	return nil
+}
+
+// formatValue is the reverse of setValue.
+func formatValue(v reflect.Value) string {
+	var m encoding.TextMarshaler
+	if v.CanAddr() {
+		m, _ = v.Addr().Interface().(encoding.TextMarshaler)
+	} else {
+		m, _ = v.Interface().(encoding.TextMarshaler)
+	}
+	if m != nil {
+		if b, err := m.MarshalText(); err == nil {
+			return string(b)
+		}
+	}
+
+	switch v.Type() {
+	case durationType:
+		return time.Duration(v.Int()).String()
+	case locationType:
+		if v.IsNil() {
+			return ""
+		}
+		return v.Interface().(*time.Location).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		items := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			items = append(items, formatValue(iter.Key())+":"+formatValue(iter.Value()))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -6,14 +6,38 @@
 // using reflection. This package does both. It's written as a learning
 // exercise. Do not use it - there are better ways of managing your App Engine
 // application's configuration.
+//
+// Fields are tagged with the name of a configuration value, optionally
+// followed by comma separated options:
+//
+//	FeedSize int            `envae:"feed_size,default=50,min=1,max=500"`
+//	Akismet  string         `envae:"akismet_key,optional,secret"`
+//	Theme    string         `envae:"theme,regex=^[a-z]+$"`
+//
+// A value with a default, or marked optional, may be left out of the
+// configuration. Min and max limit numbers, and the length of strings, slices
+// and maps. Regex must match the whole of a string, or of each string in a
+// slice, and must be the last option as it may itself contain commas. Secret
+// values are redacted by Dump.
+//
+// Besides strings, bools, integers and floats, fields may be time.Duration,
+// *time.Location, or implement encoding.TextUnmarshaler. Slices are written as
+// comma separated lists, and maps as comma separated key:value pairs.
+//
+// Values are read from environment variables by default, or from any Source,
+// such as a file, command line flags or a Map, composed with Layered.
 package envae
 
 import (
+	"encoding"
 	"errors"
+	"fmt"
 	"os"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ErrorMissingConfValue is returned if a configuration value defined in the
@@ -26,72 +50,329 @@ var ErrorInvalidConfValue = errors.New("envae: invalid configuration value")
 
 const tagPrefix = "envae"
 
+// KeyError describes a problem with a single configuration value. Err is
+// ErrorMissingConfValue, or wraps ErrorInvalidConfValue with the reason the
+// value is invalid.
+type KeyError struct {
+	Key   string
+	Field string
+	Err   error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("envae: %s: %s", e.Key, strings.TrimPrefix(e.Err.Error(), "envae: "))
+}
+
+// Unwrap returns the underlying error.
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// Errors lists every missing or invalid value found by Populate.
+type Errors []*KeyError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = strings.TrimPrefix(e[i].Error(), "envae: ")
+	}
+	return fmt.Sprintf("envae: %d configuration problem(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the errors is target, so that errors.Is can test
+// for ErrorMissingConfValue or ErrorInvalidConfValue.
+func (e Errors) Is(target error) bool {
+	for i := range e {
+		if errors.Is(e[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrorInvalidConfValue, fmt.Sprintf(format, args...))
+}
+
 // Populate accepts a pointer to a suitably tagged struct and fills it with
 // matching values from the App Engine configuration as specified in app.yaml.
 //
 // Structs should be tagged in the format: envae:config_value. There is no
 // hierarchical structure within the configuration file but structs nested by
 // value at any depth can be used provided they are tagged correctly.
+//
+// Every field is populated even if some values are missing or invalid, and
+// the returned error is then of type Errors, listing each of them.
 func Populate(config interface{}) error {
+	return PopulateFrom(config, Env())
+}
+
+// PopulateFrom is like Populate but reads values from src, such as a file or
+// several sources layered together.
+func PopulateFrom(config interface{}, src Source) error {
 	rv := reflect.ValueOf(config)
-	if rv.Kind() != reflect.Ptr {
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
 		return errors.New("envae: interface must be a pointer to struct")
 	}
 	confval := rv.Elem()
-	return fillFields(confval)
+
+	var errs Errors
+	fillFields(confval, src, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
-func fillFields(v reflect.Value) error {
+func fillFields(v reflect.Value, src Source, errs *Errors) {
 	t := v.Type()
 
 	for i := 0; i < v.NumField(); i++ {
-		// Configuration structs can be nested
-		fval := v.Field(i)
-		if fval.Kind() == reflect.Struct {
-			fillFields(fval)
+		field := t.Field(i)
+		if field.PkgPath != "" { // unexported
 			continue
 		}
+		fval := v.Field(i)
 
-		field := t.Field(i)
 		tag := field.Tag.Get(tagPrefix)
-		if tag != "" {
-			err := setFromEnv(&fval, tag)
-			if err != nil {
-				return err
+		if tag == "" {
+			// Configuration structs can be nested
+			if fval.Kind() == reflect.Struct {
+				fillFields(fval, src, errs)
 			}
+			continue
+		}
+
+		opts, err := parseTag(tag)
+		if err == nil {
+			err = setFromSource(src, fval, opts)
+		}
+		if err != nil {
+			*errs = append(*errs, &KeyError{Key: opts.key, Field: field.Name, Err: err})
 		}
 	}
+}
 
-	return nil
+// tagOptions holds the parsed contents of an envae struct tag.
+type tagOptions struct {
+	key        string
+	def        string
+	hasDefault bool
+	optional   bool
+	secret     bool
+	min, max   *float64
+	regex      *regexp.Regexp
+}
+
+func parseTag(tag string) (tagOptions, error) {
+	var opts tagOptions
+	parts := strings.Split(tag, ",")
+	opts.key = strings.TrimSpace(parts[0])
+
+	for i := 1; i < len(parts); i++ {
+		name, val, _ := strings.Cut(parts[i], "=")
+		name = strings.TrimSpace(name)
+		switch name {
+		case "optional":
+			opts.optional = true
+		case "secret":
+			opts.secret = true
+		case "default":
+			opts.def, opts.hasDefault = val, true
+		case "min", "max":
+			n, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return opts, fmt.Errorf("envae: invalid %s option %q", name, val)
+			}
+			if name == "min" {
+				opts.min = &n
+			} else {
+				opts.max = &n
+			}
+		case "regex":
+			pattern := strings.Join(append([]string{val}, parts[i+1:]...), ",")
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return opts, fmt.Errorf("envae: invalid regex option: %v", err)
+			}
+			opts.regex = re
+			i = len(parts)
+		default:
+			return opts, fmt.Errorf("envae: unknown tag option %q", name)
+		}
+	}
+	return opts, nil
 }
 
 var lookupEnv = os.LookupEnv // allows mocking
 
-func setFromEnv(v *reflect.Value, key string) error {
-	val, set := lookupEnv(key)
-	if set == false {
-		return ErrorMissingConfValue
+func setFromSource(src Source, v reflect.Value, opts tagOptions) error {
+	val, set := src.Lookup(opts.key)
+	if !set {
+		switch {
+		case opts.hasDefault:
+			val = opts.def
+		case opts.optional:
+			return nil
+		default:
+			return ErrorMissingConfValue
+		}
+	}
+
+	if err := setValue(v, val); err != nil {
+		return err
+	}
+	return validate(v, opts)
+}
+
+var (
+	durationType       = reflect.TypeOf(time.Duration(0))
+	locationType       = reflect.TypeOf((*time.Location)(nil))
+	textUnmarshalerTyp = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// setValue converts val to the type of v and stores it.
+func setValue(v reflect.Value, val string) error {
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerTyp) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(val)); err != nil {
+			return invalid("%v", err)
+		}
+		return nil
+	}
+
+	switch v.Type() {
+	case durationType:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return invalid("%q is not a duration", val)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case locationType:
+		loc, err := time.LoadLocation(val)
+		if err != nil {
+			return invalid("%q is not a time zone", val)
+		}
+		v.Set(reflect.ValueOf(loc))
+		return nil
 	}
 
 	switch v.Kind() {
 	case reflect.String:
 		v.SetString(val)
-	case reflect.Int:
-		converted, err := strconv.ParseInt(val, 10, 0)
-		if err != nil {
-			return ErrorInvalidConfValue
-		}
-		v.SetInt(converted)
 	case reflect.Bool:
 		converted, err := strconv.ParseBool(val)
 		if err != nil {
-			return ErrorInvalidConfValue
+			return invalid("%q is not true or false", val)
 		}
 		v.SetBool(converted)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		converted, err := strconv.ParseInt(strings.TrimSpace(val), 10, v.Type().Bits())
+		if err != nil {
+			return invalid("%q is not an integer", val)
+		}
+		v.SetInt(converted)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		converted, err := strconv.ParseUint(strings.TrimSpace(val), 10, v.Type().Bits())
+		if err != nil {
+			return invalid("%q is not a positive integer", val)
+		}
+		v.SetUint(converted)
+	case reflect.Float32, reflect.Float64:
+		converted, err := strconv.ParseFloat(strings.TrimSpace(val), v.Type().Bits())
+		if err != nil {
+			return invalid("%q is not a number", val)
+		}
+		v.SetFloat(converted)
 	case reflect.Slice:
-		// TODO: check this is a slice of strings
-		strs := strings.Split(val, ",")
-		v.Set(reflect.ValueOf(strs))
+		items := splitList(val)
+		if len(items) == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		s := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i := range items {
+			if err := setValue(s.Index(i), items[i]); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, item := range splitList(val) {
+			k, e, found := strings.Cut(item, ":")
+			if !found {
+				return invalid("%q is not a key:value pair", item)
+			}
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := setValue(key, strings.TrimSpace(k)); err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(elem, strings.TrimSpace(e)); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	default:
+		return invalid("unsupported field type %s", v.Type())
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list. An empty string is an empty list.
+func splitList(val string) []string {
+	if strings.TrimSpace(val) == "" {
+		return nil
+	}
+	items := strings.Split(val, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// validate checks a populated value against the min, max and regex options.
+func validate(v reflect.Value, opts tagOptions) error {
+	var n float64
+	measure := "value"
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String, reflect.Slice, reflect.Map:
+		n = float64(v.Len())
+		measure = "length"
+	}
+	if opts.min != nil && n < *opts.min {
+		return invalid("%s %v is less than %v", measure, n, *opts.min)
+	}
+	if opts.max != nil && n > *opts.max {
+		return invalid("%s %v is more than %v", measure, n, *opts.max)
+	}
+
+	if opts.regex != nil {
+		var strs []string
+		switch {
+		case v.Kind() == reflect.String:
+			strs = []string{v.String()}
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+			for i := 0; i < v.Len(); i++ {
+				strs = append(strs, v.Index(i).String())
+			}
+		default:
+			return invalid("regex option applies only to strings")
+		}
+		for _, s := range strs {
+			if !opts.regex.MatchString(s) {
+				return invalid("%q does not match %s", s, opts.regex)
+			}
+		}
 	}
 
 	return nil
@@ -1,9 +1,14 @@
 package envae
 
 import (
+	"errors"
+	"flag"
+	"net"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 var testVals = map[string]string{
@@ -54,6 +59,115 @@ func TestPopulate(t *testing.T) {
 
 }
 
+type richConfig struct {
+	Ratio    float64        `envae:"ratio"`
+	Timeout  time.Duration  `envae:"timeout"`
+	Zone     *time.Location `envae:"zone"`
+	Ports    []int          `envae:"ports"`
+	Weights  map[string]int `envae:"weights"`
+	IP       net.IP         `envae:"ip"`
+	FeedSize int            `envae:"feed_size,default=50,min=1"`
+	Akismet  string         `envae:"akismet_key,optional"`
+	Empty    []string       `envae:"empty"`
+	Theme    string         `envae:"theme,regex=[a-z]{1,3},x"`
+	Nest     moreRichConfig
+	Ignored  map[string]int
+}
+
+type moreRichConfig struct {
+	Retries uint `envae:"retries,max=5"`
+}
+
+func TestPopulateTypesAndOptions(t *testing.T) {
+	lookupEnv = func(key string) (string, bool) {
+		v, ok := map[string]string{
+			"ratio":   "0.25",
+			"timeout": "1m30s",
+			"zone":    "UTC",
+			"ports":   "80, 443",
+			"weights": "a:1,b: 2",
+			"ip":      "192.0.2.1",
+			"empty":   "",
+			"theme":   "ab,x",
+			"retries": "3",
+		}[key]
+		return v, ok
+	}
+
+	conf := &richConfig{}
+	if err := Populate(conf); err != nil {
+		t.Fatalf("Populate failed: %s", err)
+	}
+
+	need := richConfig{
+		Ratio:    0.25,
+		Timeout:  90 * time.Second,
+		Zone:     time.UTC,
+		Ports:    []int{80, 443},
+		Weights:  map[string]int{"a": 1, "b": 2},
+		IP:       net.ParseIP("192.0.2.1"),
+		FeedSize: 50,
+		Theme:    "ab,x",
+		Nest:     moreRichConfig{Retries: 3},
+	}
+	if !reflect.DeepEqual(*conf, need) {
+		t.Errorf("Have config %+v, need %+v", *conf, need)
+	}
+}
+
+func TestPopulateReportsEveryProblem(t *testing.T) {
+	lookupEnv = func(key string) (string, bool) {
+		v, ok := map[string]string{
+			"ratio":     "lots",
+			"timeout":   "1m",
+			"zone":      "Nowhere/Special",
+			"ports":     "80,http",
+			"weights":   "a",
+			"ip":        "192.0.2.1",
+			"feed_size": "0",
+			"empty":     "",
+			"theme":     "abcd",
+			"retries":   "6",
+		}[key]
+		return v, ok
+	}
+
+	err := Populate(&richConfig{})
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Have error %v, need Errors", err)
+	}
+
+	var have []string
+	for _, e := range errs {
+		have = append(have, e.Key)
+		if !errors.Is(e, ErrorInvalidConfValue) {
+			t.Errorf("Have error %v for %s, need invalid value", e, e.Key)
+		}
+	}
+	need := []string{"ratio", "zone", "ports", "weights", "feed_size", "theme", "retries"}
+	if !reflect.DeepEqual(have, need) {
+		t.Errorf("Have problems with %v, need %v", have, need)
+	}
+	if !strings.Contains(err.Error(), "feed_size") {
+		t.Errorf("Have message %q, need it to name feed_size", err)
+	}
+}
+
+func TestPopulateReportsMissingKeys(t *testing.T) {
+	lookupEnv = func(key string) (string, bool) { return "", false }
+
+	err := Populate(&correctConfig{})
+	if !errors.Is(err, ErrorMissingConfValue) {
+		t.Fatalf("Have error %v, need missing value", err)
+	}
+	for _, key := range []string{"string_config_val", "int_config_val", "nested_string_config_val"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Have message %q, need it to name %s", err, key)
+		}
+	}
+}
+
 func TestNonPointerValuesShouldBeRejected(t *testing.T) {
 	conf := correctConfig{}
 	err := Populate(conf)
@@ -89,3 +203,110 @@ func TestNonStructShouldBeRejected(t *testing.T) {
 // 	}
 // 	log.Print(err)
 // }
+
+func TestFile(t *testing.T) {
+	need := Map{
+		"string_config_val":        "string conf val",
+		"int_config_val":           "10",
+		"string_slice_config_val":  "foo,bar",
+		"nested_string_config_val": "nested string conf val",
+	}
+
+	for _, path := range []string{"testdata/config.yaml", "testdata/config.toml", "testdata/config.json"} {
+		have, err := File(path)
+		if err != nil {
+			t.Errorf("File(%s) failed: %s", path, err)
+			continue
+		}
+		delete(have, "runtime")
+		if !reflect.DeepEqual(have, need) {
+			t.Errorf("File(%s): have %v, need %v", path, have, need)
+		}
+	}
+
+	if _, err := File("testdata/config.ini"); err != ErrorUnknownFormat {
+		t.Errorf("File(config.ini): have error %v, need %v", err, ErrorUnknownFormat)
+	}
+}
+
+func TestPopulateFromLayeredSources(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("int_config_val", 1, "")
+	fs.String("string_config_val", "flag default", "")
+	if err := fs.Parse([]string{"-int_config_val=42"}); err != nil {
+		t.Fatal(err)
+	}
+	file, err := File("testdata/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &correctConfig{}
+	src := Layered(Flags(fs), Map{"string_config_val": "from map"}, file)
+	if err := PopulateFrom(conf, src); err != nil {
+		t.Fatalf("PopulateFrom failed: %s", err)
+	}
+
+	if conf.TestInt != 42 || conf.TestString != "from map" || conf.Nest.TestNestedString != "nested string conf val" {
+		t.Errorf("Have config %+v, need int from flags, string from map and the rest from the file", *conf)
+	}
+}
+
+type secretConfig struct {
+	Name    string            `envae:"name"`
+	Key     string            `envae:"session_store_key,secret"`
+	Unset   string            `envae:"akismet_key,optional,secret"`
+	Timeout time.Duration     `envae:"timeout"`
+	Zone    *time.Location    `envae:"zone"`
+	Weights map[string]int    `envae:"weights"`
+	Tags    []string          `envae:"tags"`
+	IP      net.IP            `envae:"ip"`
+	Extra   map[string]string `envae:"extra,optional"`
+}
+
+func TestDump(t *testing.T) {
+	conf := secretConfig{
+		Name:    "blog",
+		Key:     "hunter2",
+		Timeout: time.Minute,
+		Zone:    time.UTC,
+		Weights: map[string]int{"b": 2, "a": 1},
+		Tags:    []string{"x", "y"},
+		IP:      net.ParseIP("192.0.2.1"),
+	}
+
+	have, err := Dump(conf)
+	if err != nil {
+		t.Fatalf("Dump failed: %s", err)
+	}
+	need := []Value{
+		{Key: "akismet_key", Field: "Unset", Secret: true},
+		{Key: "extra", Field: "Extra"},
+		{Key: "ip", Field: "IP", Value: "192.0.2.1"},
+		{Key: "name", Field: "Name", Value: "blog"},
+		{Key: "session_store_key", Field: "Key", Value: Redacted, Secret: true},
+		{Key: "tags", Field: "Tags", Value: "x,y"},
+		{Key: "timeout", Field: "Timeout", Value: "1m0s"},
+		{Key: "weights", Field: "Weights", Value: "a:1,b:2"},
+		{Key: "zone", Field: "Zone", Value: "UTC"},
+	}
+	if !reflect.DeepEqual(have, need) {
+		t.Errorf("Have dump %+v, need %+v", have, need)
+	}
+
+	// Dumped values can be read back in
+	m := make(Map)
+	for _, v := range have {
+		if !v.Secret {
+			m[v.Key] = v.Value
+		}
+	}
+	var back secretConfig
+	if err := PopulateFrom(&back, Layered(Map{"session_store_key": "hunter2"}, m)); err != nil {
+		t.Fatalf("PopulateFrom dump failed: %s", err)
+	}
+	back.Extra = nil
+	if !reflect.DeepEqual(back, conf) {
+		t.Errorf("Have config %+v read from dump, need %+v", back, conf)
+	}
+}
@@ -0,0 +1,258 @@
+package envae
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Source looks up configuration values by key.
+type Source interface {
+	Lookup(key string) (string, bool)
+}
+
+// SourceFunc adapts a lookup function to a Source.
+type SourceFunc func(key string) (string, bool)
+
+// Lookup calls f(key).
+func (f SourceFunc) Lookup(key string) (string, bool) {
+	return f(key)
+}
+
+// Env returns a Source reading environment variables, which is where App
+// Engine puts the env_variables from app.yaml.
+func Env() Source {
+	return SourceFunc(func(key string) (string, bool) {
+		return lookupEnv(key)
+	})
+}
+
+// Map is a Source holding values in memory, for tests and for values read
+// from files.
+type Map map[string]string
+
+// Lookup returns the value stored for key.
+func (m Map) Lookup(key string) (string, bool) {
+	v, ok := m[key]
+	return v, ok
+}
+
+// Layered returns a Source which looks up each key in sources in turn, so
+// that earlier sources take precedence over later ones.
+func Layered(sources ...Source) Source {
+	return SourceFunc(func(key string) (string, bool) {
+		for _, s := range sources {
+			if v, ok := s.Lookup(key); ok {
+				return v, true
+			}
+		}
+		return "", false
+	})
+}
+
+// Flags returns a Source holding the flags in fs which were set on the
+// command line, keyed by flag name. Flags left at their default values are
+// not included, so that they do not override later sources.
+func Flags(fs *flag.FlagSet) Source {
+	m := make(Map)
+	fs.Visit(func(f *flag.Flag) {
+		m[f.Name] = f.Value.String()
+	})
+	return m
+}
+
+// ErrorUnknownFormat is returned by File for files which are not YAML, TOML or
+// JSON.
+var ErrorUnknownFormat = errors.New("envae: unknown configuration file format")
+
+// File reads configuration values from a YAML, TOML or JSON file, chosen by
+// the file's extension. Sections and nested objects are flattened, so keys
+// must be unique across the whole file. Lists are joined with commas.
+//
+// Only the simple, flat subset of YAML and TOML needed for configuration is
+// understood: key and value pairs, sections, and lists of plain values.
+func File(path string) (Map, error) {
+	var parse func(io.Reader) (Map, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		parse = parseYAML
+	case ".toml":
+		parse = parseTOML
+	case ".json":
+		parse = parseJSON
+	default:
+		return nil, ErrorUnknownFormat
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("envae: %s: %v", path, err)
+	}
+	return m, nil
+}
+
+func parseYAML(in io.Reader) (Map, error) {
+	m := make(Map)
+	var listKey string
+	var list []string
+
+	endList := func() {
+		if listKey != "" && list != nil {
+			m[listKey] = strings.Join(list, ",")
+		}
+		listKey, list = "", nil
+	}
+
+	s := bufio.NewScanner(in)
+	for line := 1; s.Scan(); line++ {
+		text := stripComment(s.Text())
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item outside a list", line)
+			}
+			list = append(list, unquote(strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))))
+			continue
+		}
+
+		key, val, found := strings.Cut(trimmed, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key: value", line)
+		}
+		endList()
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if val == "" {
+			// A section or the start of a list
+			listKey = key
+			continue
+		}
+		m[key] = unquote(val)
+	}
+	endList()
+
+	return m, s.Err()
+}
+
+func parseTOML(in io.Reader) (Map, error) {
+	m := make(Map)
+
+	s := bufio.NewScanner(in)
+	for line := 1; s.Scan(); line++ {
+		trimmed := strings.TrimSpace(stripComment(s.Text()))
+		if trimmed == "" || strings.HasPrefix(trimmed, "[") && !strings.Contains(trimmed, "=") {
+			continue
+		}
+
+		key, val, found := strings.Cut(trimmed, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		key, val = unquote(strings.TrimSpace(key)), strings.TrimSpace(val)
+
+		if strings.HasPrefix(val, "[") && strings.HasSuffix(val, "]") {
+			var items []string
+			for _, item := range strings.Split(strings.Trim(val, "[]"), ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, unquote(item))
+				}
+			}
+			m[key] = strings.Join(items, ",")
+			continue
+		}
+		m[key] = unquote(val)
+	}
+
+	return m, s.Err()
+}
+
+func parseJSON(in io.Reader) (Map, error) {
+	var obj map[string]interface{}
+	if err := json.NewDecoder(in).Decode(&obj); err != nil {
+		return nil, err
+	}
+	m := make(Map)
+	flattenJSON(m, obj)
+	return m, nil
+}
+
+func flattenJSON(m Map, obj map[string]interface{}) {
+	for k, v := range obj {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flattenJSON(m, v)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i := range v {
+				items[i] = jsonString(v[i])
+			}
+			m[k] = strings.Join(items, ",")
+		default:
+			m[k] = jsonString(v)
+		}
+	}
+}
+
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// stripComment removes a # comment which is not inside quotes.
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote removes the quotes around a single or double quoted string.
+func unquote(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	switch {
+	case s[0] == '"' && s[len(s)-1] == '"':
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+		return s[1 : len(s)-1]
+	case s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
@@ -2,22 +2,35 @@
 package flash
 
 import (
+	"encoding/gob"
 	"fmt"
 	"goblogengine/appenv"
 	"net/http"
 
-	"google.golang.org/appengine"
 	"google.golang.org/appengine/log"
 )
 
 const defaultSessionName = "session"
 
+const undoKey = "undo"
+
+// Undo is a flash message offering to undo an action by POSTing ID to URL.
+type Undo struct {
+	Message string
+	URL     string
+	ID      string
+}
+
+func init() {
+	gob.Register(Undo{})
+}
+
 // AddFlash adds a flash to the session
 func AddFlash(w http.ResponseWriter, r *http.Request, f string) error {
 	env := appenv.GetEnv()
 	session, err := env.SessionStore.Get(r, defaultSessionName)
 	if err != nil {
-		ctx := appengine.NewContext(r)
+		ctx := appenv.NewContext(r)
 		log.Warningf(ctx, "Invalid session cookie. Using new session: %s", err)
 	}
 	session.AddFlash(f)
@@ -34,7 +47,7 @@ func ReadFlashes(w http.ResponseWriter, r *http.Request) ([]string, error) {
 	env := appenv.GetEnv()
 	session, err := env.SessionStore.Get(r, defaultSessionName)
 	if err != nil {
-		ctx := appengine.NewContext(r)
+		ctx := appenv.NewContext(r)
 		log.Warningf(ctx, "Invalid session cookie. Using new session: %s", err)
 	}
 
@@ -53,3 +66,43 @@ func ReadFlashes(w http.ResponseWriter, r *http.Request) ([]string, error) {
 
 	return msgs, nil
 }
+
+// AddUndo adds a flash message with an undo button to the session, replacing
+// any previous one.
+func AddUndo(w http.ResponseWriter, r *http.Request, u Undo) error {
+	env := appenv.GetEnv()
+	session, err := env.SessionStore.Get(r, defaultSessionName)
+	if err != nil {
+		ctx := appenv.NewContext(r)
+		log.Warningf(ctx, "Invalid session cookie. Using new session: %s", err)
+	}
+	session.Values[undoKey] = u
+	err = session.Save(r, w)
+	if err != nil {
+		return fmt.Errorf("flash: error saving session: %s", err.Error())
+	}
+
+	return nil
+}
+
+// ReadUndo returns the flash message with an undo button, removing it from
+// the session, or nil if there is none.
+func ReadUndo(w http.ResponseWriter, r *http.Request) (*Undo, error) {
+	env := appenv.GetEnv()
+	session, err := env.SessionStore.Get(r, defaultSessionName)
+	if err != nil {
+		ctx := appenv.NewContext(r)
+		log.Warningf(ctx, "Invalid session cookie. Using new session: %s", err)
+	}
+
+	u, ok := session.Values[undoKey].(Undo)
+	if !ok {
+		return nil, nil
+	}
+	delete(session.Values, undoKey)
+	if err := session.Save(r, w); err != nil {
+		return nil, fmt.Errorf("flash: error saving session: %s", err.Error())
+	}
+
+	return &u, nil
+}
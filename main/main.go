@@ -20,7 +20,7 @@ func main() {
 	// Set the routes for the HTTP server
 	r := mux.NewRouter()
 	blog.Init(r)
-	http.Handle("/", r)
+	http.Handle("/", blog.ResolveBlog(r))
 
 	appengine.Main()
 }
@@ -0,0 +1,126 @@
+// Package menu arranges navigation links into a tree of nested submenus.
+package menu
+
+import (
+	"sort"
+)
+
+// Item is a single link in a menu. Items with an empty ParentID are at the
+// top level, and items are ordered among their siblings by Position.
+type Item struct {
+	ID       string
+	ParentID string
+	Title    string
+	URL      string
+	Position int
+}
+
+// Node is an Item placed in the menu tree.
+type Node struct {
+	ID       string
+	Title    string
+	URL      string
+	Depth    int
+	Children []Node
+}
+
+// Build arranges items into a tree. Items whose parent is missing, including
+// those whose ancestors form a loop, are left out along with their children.
+func Build(items []Item) []Node {
+	children := make(map[string][]Item)
+	for _, it := range items {
+		children[it.ParentID] = append(children[it.ParentID], it)
+	}
+	for id := range children {
+		sortItems(children[id])
+	}
+	return build(children, "", 0)
+}
+
+func build(children map[string][]Item, parent string, depth int) []Node {
+	var nodes []Node
+	for _, it := range children[parent] {
+		nodes = append(nodes, Node{
+			ID:       it.ID,
+			Title:    it.Title,
+			URL:      it.URL,
+			Depth:    depth,
+			Children: build(children, it.ID, depth+1),
+		})
+	}
+	return nodes
+}
+
+// sortItems orders sibling items by position, breaking ties by ID so that
+// the order is stable.
+func sortItems(items []Item) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Position != items[j].Position {
+			return items[i].Position < items[j].Position
+		}
+		return items[i].ID < items[j].ID
+	})
+}
+
+// Flatten lists the nodes of a tree depth first, each followed by its
+// children.
+func Flatten(nodes []Node) []Node {
+	var flat []Node
+	for _, n := range nodes {
+		flat = append(flat, n)
+		flat = append(flat, Flatten(n.Children)...)
+	}
+	return flat
+}
+
+// Move moves the item with the supplied ID up (by a negative offset) or down
+// among its siblings, and renumbers the siblings' positions from zero. It
+// returns the items whose position changed, and false if there is no such
+// item.
+func Move(items []Item, id string, offset int) ([]Item, bool) {
+	var parent string
+	found := false
+	for _, it := range items {
+		if it.ID == id {
+			parent, found = it.ParentID, true
+			break
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	var siblings []Item
+	for _, it := range items {
+		if it.ParentID == parent {
+			siblings = append(siblings, it)
+		}
+	}
+	sortItems(siblings)
+
+	for i := range siblings {
+		if siblings[i].ID != id {
+			continue
+		}
+		j := i + offset
+		if j < 0 {
+			j = 0
+		}
+		if j > len(siblings)-1 {
+			j = len(siblings) - 1
+		}
+		moved := siblings[i]
+		siblings = append(siblings[:i], siblings[i+1:]...)
+		siblings = append(siblings[:j], append([]Item{moved}, siblings[j:]...)...)
+		break
+	}
+
+	var changed []Item
+	for i := range siblings {
+		if siblings[i].Position != i {
+			siblings[i].Position = i
+			changed = append(changed, siblings[i])
+		}
+	}
+	return changed, true
+}
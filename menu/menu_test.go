@@ -0,0 +1,75 @@
+package menu_test
+
+import (
+	"reflect"
+	"testing"
+
+	"goblogengine/menu"
+)
+
+var items = []menu.Item{
+	{ID: "about", Title: "About", URL: "/about", Position: 1},
+	{ID: "home", Title: "Home", URL: "/", Position: 0},
+	{ID: "cv", ParentID: "about", Title: "CV", URL: "/cv", Position: 0},
+	{ID: "talks", ParentID: "cv", Title: "Talks", URL: "/talks", Position: 0},
+	{ID: "orphan", ParentID: "missing", Title: "Orphan", URL: "/orphan"},
+	{ID: "loop1", ParentID: "loop2", Title: "Loop 1", URL: "/loop1"},
+	{ID: "loop2", ParentID: "loop1", Title: "Loop 2", URL: "/loop2"},
+}
+
+func TestBuild(t *testing.T) {
+	have := menu.Build(items)
+	need := []menu.Node{
+		{ID: "home", Title: "Home", URL: "/"},
+		{ID: "about", Title: "About", URL: "/about", Children: []menu.Node{
+			{ID: "cv", Title: "CV", URL: "/cv", Depth: 1, Children: []menu.Node{
+				{ID: "talks", Title: "Talks", URL: "/talks", Depth: 2},
+			}},
+		}},
+	}
+	if !reflect.DeepEqual(have, need) {
+		t.Errorf("Have tree %+v, need %+v", have, need)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	var have []string
+	for _, n := range menu.Flatten(menu.Build(items)) {
+		have = append(have, n.ID)
+	}
+	need := []string{"home", "about", "cv", "talks"}
+	if !reflect.DeepEqual(have, need) {
+		t.Errorf("Have order %v, need %v", have, need)
+	}
+}
+
+func TestMove(t *testing.T) {
+	siblings := []menu.Item{
+		{ID: "a", Position: 0},
+		{ID: "b", Position: 1},
+		{ID: "c", Position: 5},
+		{ID: "x", ParentID: "a", Position: 0},
+	}
+
+	tests := []struct {
+		id      string
+		offset  int
+		changed []menu.Item
+	}{
+		{"c", -1, []menu.Item{{ID: "c", Position: 1}, {ID: "b", Position: 2}}},
+		{"a", -1, []menu.Item{{ID: "c", Position: 2}}},
+		{"a", 1, []menu.Item{{ID: "b", Position: 0}, {ID: "a", Position: 1}, {ID: "c", Position: 2}}},
+		{"x", 1, nil},
+	}
+
+	for _, tt := range tests {
+		have, ok := menu.Move(siblings, tt.id, tt.offset)
+		if !ok || !reflect.DeepEqual(have, tt.changed) {
+			t.Errorf("Move(%s, %d): have %+v, need %+v", tt.id, tt.offset, have, tt.changed)
+		}
+	}
+
+	if _, ok := menu.Move(siblings, "missing", 1); ok {
+		t.Error("Move(missing): have ok, need not found")
+	}
+}
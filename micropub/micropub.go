@@ -0,0 +1,397 @@
+// Package micropub parses requests and builds responses for the W3C Micropub
+// publishing protocol. It knows nothing about how posts are stored; callers
+// map the parsed properties onto their own data structures.
+//
+// See https://www.w3.org/TR/micropub/.
+//
+// TODO: Support the "mp-syndicate-to" and "syndicate-to" extensions
+package micropub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Actions which can be requested by a Micropub client.
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionUndelete = "undelete"
+)
+
+// maxBodySize limits the size of JSON request bodies, 1 MB.
+const maxBodySize = 1 << 20
+
+// Properties holds microformats2 properties. Every property can have multiple
+// values and each value is usually a string, but may also be a nested object
+// such as {"html": "..."} for content.
+type Properties map[string][]interface{}
+
+// Request represents a parsed Micropub request.
+type Request struct {
+	Action string
+	Type   string
+	URL    string
+
+	// Properties is set for create requests.
+	Properties Properties
+
+	// Replace, Add and Delete are set for update requests. DeleteProperties
+	// lists properties which should be removed entirely, Delete lists specific
+	// values to remove from a property.
+	Replace          Properties
+	Add              Properties
+	Delete           Properties
+	DeleteProperties []string
+
+	AccessToken string
+}
+
+// Error represents a Micropub error response.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	StatusCode  int    `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "micropub: " + e.Code
+	}
+	return fmt.Sprintf("micropub: %s: %s", e.Code, e.Description)
+}
+
+// ErrorInvalidRequest returns an error indicating a malformed request.
+func ErrorInvalidRequest(desc string) *Error {
+	return &Error{"invalid_request", desc, http.StatusBadRequest}
+}
+
+// ErrorUnauthorized returns an error indicating a missing or invalid token.
+func ErrorUnauthorized(desc string) *Error {
+	return &Error{"unauthorized", desc, http.StatusUnauthorized}
+}
+
+// ErrorForbidden returns an error indicating the user is not permitted to
+// perform the request.
+func ErrorForbidden(desc string) *Error {
+	return &Error{"forbidden", desc, http.StatusForbidden}
+}
+
+// ErrorInsufficientScope returns an error indicating the token does not grant
+// the scope required for the request.
+func ErrorInsufficientScope(scope string) *Error {
+	return &Error{"insufficient_scope",
+		fmt.Sprintf("scope %q required", scope), http.StatusUnauthorized}
+}
+
+// ErrorNotFound returns an error indicating the target post does not exist.
+func ErrorNotFound(desc string) *Error {
+	return &Error{"invalid_request", desc, http.StatusNotFound}
+}
+
+// ParseRequest parses a Micropub POST request. Both form-encoded (including
+// multipart) and JSON request bodies are supported.
+func ParseRequest(r *http.Request) (*Request, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var req *Request
+	var err error
+	if mt == "application/json" {
+		req, err = parseJSON(r)
+	} else {
+		req, err = parseForm(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if token := BearerToken(r); token != "" {
+		req.AccessToken = token
+	} else if token := r.URL.Query().Get("access_token"); token != "" {
+		req.AccessToken = token
+	}
+
+	if req.Action != ActionCreate && req.URL == "" {
+		return nil, ErrorInvalidRequest("url is required for " + req.Action)
+	}
+
+	return req, nil
+}
+
+// BearerToken returns the access token supplied in the Authorization header
+// as a bearer token, or "" if there is none.
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+func parseForm(r *http.Request) (*Request, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil &&
+		err != http.ErrNotMultipart {
+		return nil, ErrorInvalidRequest(err.Error())
+	}
+
+	req := &Request{
+		Action:      r.PostForm.Get("action"),
+		URL:         r.PostForm.Get("url"),
+		AccessToken: r.PostForm.Get("access_token"),
+	}
+	if req.Action == "" {
+		req.Action = ActionCreate
+	}
+	if req.Action != ActionCreate {
+		// Updates can only be expressed as JSON
+		if req.Action == ActionUpdate {
+			return nil, ErrorInvalidRequest("update requests must be JSON")
+		}
+		return req, checkAction(req.Action)
+	}
+
+	h := r.PostForm.Get("h")
+	if h == "" {
+		h = "entry"
+	}
+	req.Type = "h-" + h
+
+	req.Properties = make(Properties)
+	for k, vals := range r.PostForm {
+		name := strings.TrimSuffix(k, "[]")
+		if name == "h" || name == "access_token" || name == "action" {
+			continue
+		}
+		for _, v := range vals {
+			req.Properties[name] = append(req.Properties[name], v)
+		}
+	}
+
+	return req, nil
+}
+
+func parseJSON(r *http.Request) (*Request, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	if err != nil {
+		return nil, ErrorInvalidRequest(err.Error())
+	}
+
+	var raw struct {
+		Type       []string        `json:"type"`
+		Properties Properties      `json:"properties"`
+		Action     string          `json:"action"`
+		URL        string          `json:"url"`
+		Replace    Properties      `json:"replace"`
+		Add        Properties      `json:"add"`
+		Delete     json.RawMessage `json:"delete"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, ErrorInvalidRequest("malformed JSON: " + err.Error())
+	}
+
+	req := &Request{
+		Action:     raw.Action,
+		URL:        raw.URL,
+		Properties: raw.Properties,
+		Replace:    raw.Replace,
+		Add:        raw.Add,
+	}
+	if req.Action == "" {
+		req.Action = ActionCreate
+	}
+	if err := checkAction(req.Action); err != nil {
+		return nil, err
+	}
+
+	if req.Action == ActionCreate {
+		if len(raw.Type) == 0 {
+			return nil, ErrorInvalidRequest("type is required")
+		}
+		req.Type = raw.Type[0]
+		if req.Properties == nil {
+			req.Properties = make(Properties)
+		}
+	}
+
+	// Delete is either a list of property names or an object of values
+	if len(raw.Delete) > 0 {
+		var names []string
+		if err := json.Unmarshal(raw.Delete, &names); err == nil {
+			req.DeleteProperties = names
+		} else if err := json.Unmarshal(raw.Delete, &req.Delete); err != nil {
+			return nil, ErrorInvalidRequest("delete must be an array or object")
+		}
+	}
+
+	return req, nil
+}
+
+func checkAction(action string) error {
+	switch action {
+	case ActionCreate, ActionUpdate, ActionDelete, ActionUndelete:
+		return nil
+	}
+	return ErrorInvalidRequest("unsupported action " + action)
+}
+
+// RequiredScope returns the token scope needed to carry out the request.
+// Scopes are named after the action they allow, and delete and undelete
+// requests both require the delete scope.
+func (req *Request) RequiredScope() string {
+	if req.Action == ActionUndelete {
+		return ActionDelete
+	}
+	return req.Action
+}
+
+// Apply carries out the replace, add and delete operations of an update
+// request against a copy of the supplied properties and returns the result.
+func (req *Request) Apply(p Properties) Properties {
+	out := make(Properties, len(p))
+	for k, v := range p {
+		out[k] = append([]interface{}(nil), v...)
+	}
+
+	for k, v := range req.Replace {
+		out[k] = append([]interface{}(nil), v...)
+	}
+	for k, v := range req.Add {
+		out[k] = append(out[k], v...)
+	}
+	for _, k := range req.DeleteProperties {
+		delete(out, k)
+	}
+	for k, remove := range req.Delete {
+		var kept []interface{}
+		for _, v := range out[k] {
+			if !containsValue(remove, v) {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(out, k)
+		} else {
+			out[k] = kept
+		}
+	}
+
+	return out
+}
+
+func containsValue(vals []interface{}, v interface{}) bool {
+	for i := range vals {
+		if fmt.Sprint(vals[i]) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the first value of a property as a string, or an empty
+// string if the property is not set or is not a string.
+func (p Properties) String(name string) string {
+	vals := p[name]
+	if len(vals) == 0 {
+		return ""
+	}
+	s, _ := vals[0].(string)
+	return s
+}
+
+// Strings returns all string values of a property.
+func (p Properties) Strings(name string) []string {
+	var strs []string
+	for _, v := range p[name] {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// Content returns the first value of the content property. Content may be a
+// plain string or an object with an "html" or "value" key.
+func (p Properties) Content() string {
+	vals := p["content"]
+	if len(vals) == 0 {
+		return ""
+	}
+	switch c := vals[0].(type) {
+	case string:
+		return c
+	case map[string]interface{}:
+		if html, ok := c["html"].(string); ok {
+			return html
+		}
+		if text, ok := c["value"].(string); ok {
+			return text
+		}
+	}
+	return ""
+}
+
+// Set replaces all values of a property with the supplied strings. Empty
+// strings are ignored and a property with no values is removed.
+func (p Properties) Set(name string, vals ...string) {
+	delete(p, name)
+	for _, v := range vals {
+		if v != "" {
+			p[name] = append(p[name], v)
+		}
+	}
+}
+
+// Source is the response body for a q=source query.
+type Source struct {
+	Type       []string   `json:"type"`
+	Properties Properties `json:"properties"`
+}
+
+// NewSource returns a Source of type h-entry containing the requested
+// properties. If no properties are requested, all are returned.
+func NewSource(p Properties, requested []string) Source {
+	s := Source{Type: []string{"h-entry"}, Properties: p}
+	if len(requested) > 0 {
+		s.Properties = make(Properties)
+		for _, name := range requested {
+			if vals, ok := p[name]; ok {
+				s.Properties[name] = vals
+			}
+		}
+	}
+	return s
+}
+
+// Config is the response body for a q=config query.
+type Config struct {
+	MediaEndpoint string     `json:"media-endpoint,omitempty"`
+	SyndicateTo   []struct{} `json:"syndicate-to"`
+	Categories    []string   `json:"categories,omitempty"`
+}
+
+// WriteJSON writes v to the response as JSON with the supplied status code.
+func WriteJSON(w http.ResponseWriter, statusCode int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("micropub: json marshaling error: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(b)
+	return err
+}
+
+// WriteError writes a Micropub error response. Errors which are not of type
+// *Error are reported as an internal server error.
+func WriteError(w http.ResponseWriter, err error) error {
+	merr, ok := err.(*Error)
+	if !ok {
+		merr = &Error{"server_error", "", http.StatusInternalServerError}
+	}
+	return WriteJSON(w, merr.StatusCode, merr)
+}
@@ -0,0 +1,163 @@
+package micropub_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"goblogengine/micropub"
+)
+
+// readRequest loads a recorded HTTP request from the testdata directory.
+func readRequest(t *testing.T, name string) *http.Request {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := http.ReadRequest(bufio.NewReader(f))
+	if err != nil {
+		t.Fatalf("Unable to read recorded request %s: %s", name, err)
+	}
+	return r
+}
+
+func TestParseCreateForm(t *testing.T) {
+	req, err := micropub.ParseRequest(readRequest(t, "create_form.http"))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %s", err)
+	}
+
+	if req.Action != micropub.ActionCreate || req.Type != "h-entry" {
+		t.Errorf("Have action %s type %s, need create h-entry", req.Action, req.Type)
+	}
+	if req.AccessToken != "xyz123" {
+		t.Errorf("Have token %s, need xyz123", req.AccessToken)
+	}
+	if have := req.Properties.String("name"); have != "Hello world" {
+		t.Errorf("Have name %s, need Hello world", have)
+	}
+	if have := req.Properties.Content(); have != "Some *markdown* text" {
+		t.Errorf("Have content %s", have)
+	}
+	need := []string{"foo", "bar baz"}
+	if have := req.Properties.Strings("category"); !reflect.DeepEqual(have, need) {
+		t.Errorf("Have categories %v, need %v", have, need)
+	}
+	if have := req.Properties.String("mp-slug"); have != "hello" {
+		t.Errorf("Have slug %s, need hello", have)
+	}
+}
+
+func TestParseCreateJSON(t *testing.T) {
+	req, err := micropub.ParseRequest(readRequest(t, "create_json.http"))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %s", err)
+	}
+
+	if have := req.Properties.Content(); have != "<p>Some <b>HTML</b></p>" {
+		t.Errorf("HTML content not extracted, have %s", have)
+	}
+	if have := req.Properties.String("post-status"); have != "draft" {
+		t.Errorf("Have post-status %s, need draft", have)
+	}
+	if req.RequiredScope() != micropub.ActionCreate {
+		t.Errorf("Create request should require create scope")
+	}
+}
+
+func TestParseAndApplyUpdate(t *testing.T) {
+	req, err := micropub.ParseRequest(readRequest(t, "update_json.http"))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %s", err)
+	}
+	if req.URL != "https://blog.example.com/post/hello" {
+		t.Errorf("Have URL %s", req.URL)
+	}
+
+	before := micropub.Properties{
+		"name":     {"Hello world"},
+		"content":  {"Original content"},
+		"category": {"foo", "bar"},
+	}
+	have := req.Apply(before)
+	need := micropub.Properties{
+		"name":     {"Hello world"},
+		"content":  {"Replaced content"},
+		"category": {"bar", "added"},
+	}
+	if !reflect.DeepEqual(have, need) {
+		t.Errorf("Update applied incorrectly\nNeed: %v\nHave: %v", need, have)
+	}
+	if before.String("content") != "Original content" {
+		t.Error("Apply modified the original properties")
+	}
+}
+
+func TestApplyDeleteProperty(t *testing.T) {
+	req, err := micropub.ParseRequest(readRequest(t, "update_delete_property.http"))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %s", err)
+	}
+
+	have := req.Apply(micropub.Properties{
+		"name":     {"Hello world"},
+		"category": {"foo"},
+	})
+	if _, ok := have["category"]; ok {
+		t.Error("Category property was not deleted")
+	}
+}
+
+func TestParseDeleteForm(t *testing.T) {
+	req, err := micropub.ParseRequest(readRequest(t, "delete_form.http"))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %s", err)
+	}
+	if req.Action != micropub.ActionDelete {
+		t.Errorf("Have action %s, need delete", req.Action)
+	}
+	if req.AccessToken != "abc987" {
+		t.Errorf("Token in form body not read, have %s", req.AccessToken)
+	}
+	if req.RequiredScope() != micropub.ActionDelete {
+		t.Errorf("Delete request should require delete scope")
+	}
+}
+
+func TestFormUpdateRejected(t *testing.T) {
+	_, err := micropub.ParseRequest(readRequest(t, "update_form.http"))
+	merr, ok := err.(*micropub.Error)
+	if !ok || merr.Code != "invalid_request" {
+		t.Errorf("Form encoded update should be rejected, have %v", err)
+	}
+}
+
+func TestNewSourceFiltersProperties(t *testing.T) {
+	p := micropub.Properties{
+		"name":    {"Hello"},
+		"content": {"World"},
+	}
+	s := micropub.NewSource(p, []string{"name"})
+	if len(s.Properties) != 1 || s.Properties.String("name") != "Hello" {
+		t.Errorf("Source not filtered, have %v", s.Properties)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	micropub.WriteError(w, micropub.ErrorInsufficientScope("create"))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Have status %d, need %d", w.Code, http.StatusUnauthorized)
+	}
+	need := `{"error":"insufficient_scope","error_description":"scope \"create\" required"}`
+	if have := w.Body.String(); have != need {
+		t.Errorf("Have body %s, need %s", have, need)
+	}
+}
@@ -10,8 +10,9 @@ import (
 	"google.golang.org/appengine/user"
 )
 
-// Require reqires the logged in user to have an associated author and
-// redirects them to the Welcome page if they do not.
+// Require reqires the logged in user to be an enabled author of the current
+// blog. A blog with no authors yet redirects them to the Welcome page to
+// register as its first author, and any other blog refuses them.
 func Require(fn func(context.Context, appenv.AppEnv, http.ResponseWriter,
 	*http.Request) *basehandler.AppError) basehandler.HTTPHandler {
 	return func(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter,
@@ -21,9 +22,22 @@ func Require(fn func(context.Context, appenv.AppEnv, http.ResponseWriter,
 			return basehandler.AppErrorf("Not logged in",
 				http.StatusUnauthorized, nil)
 		}
-		_, ok := u.(*model.Author)
+		a, ok := u.(*model.Author)
+		if ok && a.Disabled {
+			return basehandler.AppErrorf("Your author account has been disabled",
+				http.StatusForbidden, nil)
+		}
 		if !ok {
+			n, err := model.GetAuthorCount(ctx)
+			if err != nil {
+				return basehandler.AppErrorDefault(err)
+			}
+			if n > 0 {
+				return basehandler.AppErrorf("You are not an author of this blog",
+					http.StatusForbidden, nil)
+			}
 			http.Redirect(w, r, "/admin/author/add", http.StatusFound)
+			return nil
 		}
 		return fn(ctx, env, w, r)
 	}
@@ -49,3 +63,70 @@ func AddInfo(fn func(context.Context, appenv.AppEnv, http.ResponseWriter,
 		return fn(ctx, env, w, r)
 	}
 }
+
+// Permission is something only authors with certain roles may do.
+type Permission int
+
+// Permissions granted to the author roles.
+const (
+	// WriteDrafts allows saving unpublished versions of one's own posts.
+	WriteDrafts Permission = iota
+	// UploadImages allows adding images to the library.
+	UploadImages
+	// PublishPosts allows publishing, unpublishing and deleting one's own
+	// posts.
+	PublishPosts
+	// EditAllPosts allows editing, publishing and deleting anyone's posts.
+	EditAllPosts
+	// ManageContent allows managing pages, the menu, categories, comments,
+	// redirects and images.
+	ManageContent
+	// ManageBlog allows managing authors, settings, webhooks and the blog
+	// itself, importing and exporting posts and resetting the blog.
+	ManageBlog
+)
+
+var rolePermissions = map[string][]Permission{
+	model.RoleOwner:       {WriteDrafts, UploadImages, PublishPosts, EditAllPosts, ManageContent, ManageBlog},
+	model.RoleEditor:      {WriteDrafts, UploadImages, PublishPosts, EditAllPosts, ManageContent},
+	model.RoleAuthor:      {WriteDrafts, UploadImages, PublishPosts},
+	model.RoleContributor: {WriteDrafts, UploadImages},
+}
+
+// Can reports whether the author's role grants the permission.
+func Can(a *model.Author, p Permission) bool {
+	if a == nil {
+		return false
+	}
+	for _, granted := range rolePermissions[a.EffectiveRole()] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// CanChangePost reports whether the author may change a post, which they may
+// do to their own posts, or to anyone's if they can edit all posts. To
+// publish, unpublish or delete the post they also need PublishPosts.
+func CanChangePost(a *model.Author, p *model.BlogPostVersion) bool {
+	if a == nil {
+		return false
+	}
+	return Can(a, EditAllPosts) || p.Author.GoogleAccountID == a.GoogleAccountID
+}
+
+// Permit refuses the request unless the logged in author has the permission.
+// It must be used inside Require.
+func Permit(p Permission, fn func(context.Context, appenv.AppEnv, http.ResponseWriter,
+	*http.Request) *basehandler.AppError) basehandler.HTTPHandler {
+	return func(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter,
+		r *http.Request) *basehandler.AppError {
+		a, _ := env.User.(*model.Author)
+		if !Can(a, p) {
+			return basehandler.AppErrorf("Your role does not allow this",
+				http.StatusForbidden, nil)
+		}
+		return fn(ctx, env, w, r)
+	}
+}
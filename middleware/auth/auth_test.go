@@ -0,0 +1,56 @@
+package auth_test
+
+import (
+	"testing"
+
+	"goblogengine/middleware/auth"
+	"goblogengine/model"
+)
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		role string
+		p    auth.Permission
+		need bool
+	}{
+		{"", auth.ManageBlog, true},
+		{model.RoleOwner, auth.ManageBlog, true},
+		{model.RoleEditor, auth.ManageBlog, false},
+		{model.RoleEditor, auth.EditAllPosts, true},
+		{model.RoleAuthor, auth.PublishPosts, true},
+		{model.RoleAuthor, auth.ManageContent, false},
+		{model.RoleContributor, auth.WriteDrafts, true},
+		{model.RoleContributor, auth.PublishPosts, false},
+		{"unknown", auth.WriteDrafts, false},
+	}
+
+	for _, test := range tests {
+		a := &model.Author{Role: test.role}
+		if have := auth.Can(a, test.p); have != test.need {
+			t.Errorf("Can(%q, %v) need %v have %v", test.role, test.p, test.need, have)
+		}
+	}
+
+	if auth.Can(nil, auth.WriteDrafts) {
+		t.Error("Can allowed a nil author")
+	}
+}
+
+func TestCanChangePost(t *testing.T) {
+	p := &model.BlogPostVersion{Author: model.Author{GoogleAccountID: "1"}}
+	tests := []struct {
+		a    *model.Author
+		need bool
+	}{
+		{&model.Author{GoogleAccountID: "1", Role: model.RoleContributor}, true},
+		{&model.Author{GoogleAccountID: "2", Role: model.RoleAuthor}, false},
+		{&model.Author{GoogleAccountID: "2", Role: model.RoleEditor}, true},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if have := auth.CanChangePost(test.a, p); have != test.need {
+			t.Errorf("CanChangePost(%v) need %v have %v", test.a, test.need, have)
+		}
+	}
+}
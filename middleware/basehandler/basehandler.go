@@ -8,10 +8,7 @@ import (
 
 	"goblogengine/appenv"
 
-	"google.golang.org/appengine"
 	"google.golang.org/appengine/log"
-
-	"strings"
 )
 
 // HTTPHandler is the type used to adapt blog handlers to Gorilla Mux.
@@ -21,20 +18,8 @@ type HTTPHandler func(context.Context, appenv.AppEnv, http.ResponseWriter, *http
 // MakeHandler returns a function that can be passed to an HTTP router.
 func MakeHandler(fn func(context.Context, appenv.AppEnv, http.ResponseWriter, *http.Request) *AppError) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := appengine.NewContext(r)
-		env := appenv.GetEnv()
-
-		if env.HostEnv == appenv.EnvLive {
-			host := strings.ToLower(r.URL.Host)
-			if host != env.Config.BaseDomainName {
-				path := r.URL.Path
-				redirectURL := fmt.Sprintf("https://%s%s",
-					env.Config.BaseDomainName, path)
-				http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
-				log.Infof(ctx, "Redirecting to %s. Original Host:%s, Path:%s",
-					redirectURL, host, path)
-			}
-		}
+		ctx := appenv.NewContext(r)
+		env := appenv.GetEnvContext(ctx)
 
 		if e := fn(ctx, env, w, r); e != nil {
 			applicationError(ctx, w, r, e)
@@ -8,7 +8,8 @@ import (
 	"net/http"
 )
 
-// Add adds flash messages from the session to the view data.
+// Add adds flash messages, and any undo message, from the session to the view
+// data.
 func Add(fn func(context.Context, appenv.AppEnv, http.ResponseWriter,
 	*http.Request) *basehandler.AppError) basehandler.HTTPHandler {
 	return func(ctx context.Context, env appenv.AppEnv, w http.ResponseWriter,
@@ -20,6 +21,13 @@ func Add(fn func(context.Context, appenv.AppEnv, http.ResponseWriter,
 		for i := range flashes {
 			env.View.AddFlash(flashes[i])
 		}
+		undo, err := flash.ReadUndo(w, r)
+		if err != nil {
+			return basehandler.AppErrorDefault(err)
+		}
+		if undo != nil {
+			env.View.SetUndo(undo)
+		}
 
 		return fn(ctx, env, w, r)
 	}
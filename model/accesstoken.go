@@ -0,0 +1,201 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const accessTokenKind = "AccessToken"
+const accessTokenUseKind = "AccessTokenUse"
+
+// accessTokenUseInterval is how often the time an AccessToken is used is
+// recorded.
+const accessTokenUseInterval = time.Hour
+
+// Scopes which can be granted to an AccessToken.
+const (
+	ScopeCreate = "create"
+	ScopeUpdate = "update"
+	ScopeDelete = "delete"
+	ScopeMedia  = "media"
+)
+
+// ErrorNoMatchingAccessToken is returned when a supplied token does not match
+// any AccessToken in the datastore.
+var ErrorNoMatchingAccessToken = errors.New("model: no access token matching supplied value")
+
+// AccessToken represents a token issued to an Author for use by external
+// publishing clients. Only a hash of the token is stored; the token itself is
+// shown to the Author once when it is created.
+type AccessToken struct {
+	Hash     string
+	Name     string
+	Scope    []string
+	Author   Author
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// accessTokenUse records when an AccessToken was last used. Clients use
+// their token on every request, so uses are recorded at most once every
+// accessTokenUseInterval, and each record is the root of its own entity
+// group so that it does not contend with transactions on the blog's posts.
+// Blog is the ID of the blog the token belongs to.
+type accessTokenUse struct {
+	Blog     string
+	LastUsed time.Time
+}
+
+func accessTokenUseKey(ctx context.Context, hash string) *datastore.Key {
+	return datastore.NewKey(ctx, accessTokenUseKind, BlogID(ctx)+" "+hash, 0, nil)
+}
+
+// NewAccessToken returns a new AccessToken for the Author and the token value
+// to be handed to the client.
+func NewAccessToken(name string, scope []string, author Author) (*AccessToken, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(b)
+
+	t := AccessToken{
+		Hash:    hashToken(token),
+		Name:    name,
+		Scope:   scope,
+		Author:  author,
+		Created: time.Now(),
+	}
+	return &t, token, nil
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// HasScope returns true if the token has been granted the supplied scope.
+func (t *AccessToken) HasScope(scope string) bool {
+	for i := range t.Scope {
+		if t.Scope[i] == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Save adds the AccessToken to the datastore.
+func (t *AccessToken) Save(ctx context.Context) (*datastore.Key, error) {
+	if t.Hash == "" {
+		return nil, errors.New("model: access token hash cannot be empty")
+	}
+	k := datastore.NewKey(ctx, accessTokenKind, t.Hash, 0, blogRootKey(ctx))
+	k, err := datastore.Put(ctx, k, t)
+	return k, err
+}
+
+// GetAccessToken returns the AccessToken matching the supplied token value
+// and records that it has been used, unless a use was recorded within the
+// last accessTokenUseInterval. Returns ErrorNoMatchingAccessToken if the
+// token is unknown.
+func GetAccessToken(ctx context.Context, token string) (*AccessToken, error) {
+	if token == "" {
+		return nil, ErrorNoMatchingAccessToken
+	}
+
+	t := new(AccessToken)
+	k := datastore.NewKey(ctx, accessTokenKind, hashToken(token), 0, blogRootKey(ctx))
+	err := datastore.Get(ctx, k, t)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u := new(accessTokenUse)
+	uk := accessTokenUseKey(ctx, t.Hash)
+	if err := datastore.Get(ctx, uk, u); err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	if now := time.Now(); now.Sub(u.LastUsed) >= accessTokenUseInterval {
+		u = &accessTokenUse{Blog: BlogID(ctx), LastUsed: now}
+		if _, err := datastore.Put(ctx, uk, u); err != nil {
+			return nil, err
+		}
+	}
+	if u.LastUsed.After(t.LastUsed) {
+		t.LastUsed = u.LastUsed
+	}
+
+	return t, nil
+}
+
+// GetAccessTokenByAuthor returns all AccessTokens issued to an Author.
+func GetAccessTokenByAuthor(ctx context.Context, author Author) ([]AccessToken, error) {
+	q := datastore.NewQuery(accessTokenKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Author.GoogleAccountID=", author.GoogleAccountID)
+
+	var tokens []AccessToken
+	if _, err := q.GetAll(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*datastore.Key, len(tokens))
+	for i := range tokens {
+		keys[i] = accessTokenUseKey(ctx, tokens[i].Hash)
+	}
+	uses := make([]accessTokenUse, len(keys))
+	err := datastore.GetMulti(ctx, keys, uses)
+	if me, ok := err.(appengine.MultiError); ok {
+		for i := range me {
+			if me[i] != nil && me[i] != datastore.ErrNoSuchEntity {
+				return nil, me[i]
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		if uses[i].LastUsed.After(tokens[i].LastUsed) {
+			tokens[i].LastUsed = uses[i].LastUsed
+		}
+	}
+	return tokens, nil
+}
+
+// DeleteAccessToken revokes the AccessToken with the supplied hash.
+func DeleteAccessToken(ctx context.Context, hash string) error {
+	k := datastore.NewKey(ctx, accessTokenKind, hash, 0, blogRootKey(ctx))
+	if err := datastore.Delete(ctx, k); err != nil {
+		return err
+	}
+	return datastore.Delete(ctx, accessTokenUseKey(ctx, hash))
+}
+
+// DeleteAllAccessToken deletes all AccessToken data.
+func DeleteAllAccessToken(ctx context.Context) error {
+	q := datastore.NewQuery(accessTokenKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := datastore.DeleteMulti(ctx, k); err != nil {
+		return err
+	}
+
+	q = datastore.NewQuery(accessTokenUseKind).Filter("Blog=", BlogID(ctx)).KeysOnly()
+	k, err = q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
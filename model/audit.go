@@ -50,6 +50,7 @@ func (a Audit) String() string {
 // GetAuditTail returns the last 100 audit events.
 func GetAuditTail(ctx context.Context) ([]Audit, error) {
 	q := datastore.NewQuery(auditKind).
+		Ancestor(blogRootKey(ctx)).
 		Order("-When").
 		Limit(100)
 	var evts []Audit
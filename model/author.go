@@ -2,6 +2,7 @@ package model
 
 import (
 	"errors"
+	"strings"
 
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
@@ -9,6 +10,19 @@ import (
 
 const authorKind = "Author"
 
+// Roles an Author can have on a blog, from most to least trusted. Owners
+// manage the blog and its authors, editors manage all of its content, authors
+// publish their own posts and contributors only write drafts of their own.
+const (
+	RoleOwner       = "owner"
+	RoleEditor      = "editor"
+	RoleAuthor      = "author"
+	RoleContributor = "contributor"
+)
+
+// Roles lists the roles an Author can have, from most to least trusted.
+var Roles = []string{RoleOwner, RoleEditor, RoleAuthor, RoleContributor}
+
 // Author represents a person who can write blog posts. Each Author is
 // associated with a Google user account.
 type Author struct {
@@ -16,37 +30,236 @@ type Author struct {
 	DisplayName     string
 	Email           string
 	GoogleAccountID string
+	Role            string `datastore:",noindex"`
+	Disabled        bool   `datastore:",noindex"`
+}
+
+// EffectiveRole returns the Author's role. Authors registered before roles
+// were introduced had full control of the blog, so are owners.
+func (a *Author) EffectiveRole() string {
+	if a.Role == "" {
+		return RoleOwner
+	}
+	return a.Role
 }
 
 // ErrorNoMatchingAuthor is returned when no Author entry matching the
 // supplied email address can be found in the datastore.
 var ErrorNoMatchingAuthor = errors.New("model: no author matching supplied email")
 
-// Save adds the Author to the datastore.
-// TODO: check for duplicates.
+// ErrorAuthorAlreadyExists is returned when saving an Author whose Google
+// account or email address is already registered with the blog.
+var ErrorAuthorAlreadyExists = errors.New("model: author already registered")
+
+// ErrorBlogHasAuthors is returned when registering the first Author of a
+// blog which already has one.
+var ErrorBlogHasAuthors = errors.New("model: blog already has authors")
+
+// NormalEmail returns an email address in the form Authors are stored and
+// compared in, so that addresses differing only in case or surrounding
+// space match.
+func NormalEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// authorsByEmail returns the Authors of the current blog matching the
+// supplied email address, and their keys. Authors are compared in memory,
+// as those registered before addresses were normalised may be stored in any
+// case.
+func authorsByEmail(ctx context.Context, email string) ([]Author, []*datastore.Key, error) {
+	var all []Author
+	keys, err := datastore.NewQuery(authorKind).Ancestor(blogRootKey(ctx)).GetAll(ctx, &all)
+	if err != nil {
+		return nil, nil, err
+	}
+	var authors []Author
+	var matched []*datastore.Key
+	for i := range all {
+		if NormalEmail(all[i].Email) == NormalEmail(email) {
+			authors = append(authors, all[i])
+			matched = append(matched, keys[i])
+		}
+	}
+	return authors, matched, nil
+}
+
+// Save adds the Author to the datastore. Returns ErrorAuthorAlreadyExists if
+// the Author's Google account or email address is already registered.
 func (a *Author) Save(ctx context.Context) (*datastore.Key, error) {
-	k := datastore.NewIncompleteKey(ctx, authorKind, blogRootKey(ctx))
-	k, err := datastore.Put(ctx, k, a)
+	return a.save(ctx, false)
+}
+
+// SaveFirst adds the Author to the datastore as the first of a new blog.
+// Returns ErrorBlogHasAuthors if the blog already has an Author, checked in
+// the same transaction as the Author is added so that only one of two
+// concurrent registrations succeeds.
+func (a *Author) SaveFirst(ctx context.Context) (*datastore.Key, error) {
+	return a.save(ctx, true)
+}
+
+func (a *Author) save(ctx context.Context, first bool) (*datastore.Key, error) {
+	a.Email = NormalEmail(a.Email)
+
+	var k *datastore.Key
+	err := datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		var existing []Author
+		q := datastore.NewQuery(authorKind).Ancestor(blogRootKey(tc))
+		if _, err := q.GetAll(tc, &existing); err != nil {
+			return err
+		}
+		if first && len(existing) > 0 {
+			return ErrorBlogHasAuthors
+		}
+		for i := range existing {
+			if existing[i].GoogleAccountID == a.GoogleAccountID ||
+				NormalEmail(existing[i].Email) == a.Email {
+				return ErrorAuthorAlreadyExists
+			}
+		}
+
+		var err error
+		k, err = datastore.Put(tc, datastore.NewIncompleteKey(tc, authorKind, blogRootKey(tc)), a)
+		return err
+	}, nil)
 	return k, err
 }
 
+// Update replaces the stored Author having the same email address. Returns
+// ErrorNoMatchingAuthor if there is no matching author.
+func (a *Author) Update(ctx context.Context) error {
+	a.Email = NormalEmail(a.Email)
+	_, keys, err := authorsByEmail(ctx, a.Email)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrorNoMatchingAuthor
+	}
+	authors := make([]Author, len(keys))
+	for i := range authors {
+		authors[i] = *a
+	}
+	_, err = datastore.PutMulti(ctx, keys, authors)
+	return err
+}
+
+// DeleteAuthor deletes the Author matching the supplied email address.
+// Returns ErrorNoMatchingAuthor if there is no matching author.
+func DeleteAuthor(ctx context.Context, email string) error {
+	_, keys, err := authorsByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrorNoMatchingAuthor
+	}
+	return datastore.DeleteMulti(ctx, keys)
+}
+
+// ReassignAuthor replaces the copy of one Author stored with every post
+// version, page version and image by another, returning the number of
+// entities changed. Reassigning an Author to an updated copy of itself
+// refreshes the copies.
+//
+// Entities are written in batches, so should a write fail the number
+// returned is of those already changed. Those still carry the old copy, so
+// calling ReassignAuthor again carries on where it stopped.
+func ReassignAuthor(ctx context.Context, from Author, to Author) (int, error) {
+	filter := "Author.GoogleAccountID="
+	n := 0
+
+	var posts []BlogPostVersion
+	q := datastore.NewQuery(blogPostVersionKind).Ancestor(blogRootKey(ctx)).Filter(filter, from.GoogleAccountID)
+	postKeys, err := q.GetAll(ctx, &posts)
+	if err != nil {
+		return n, err
+	}
+	for i := range posts {
+		posts[i].Author = to
+	}
+	err = batches(len(posts), func(i, j int) error {
+		if _, err := datastore.PutMulti(ctx, postKeys[i:j], posts[i:j]); err != nil {
+			return err
+		}
+		n += j - i
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	var pages []Page
+	q = datastore.NewQuery(pageKind).Ancestor(blogRootKey(ctx)).Filter(filter, from.GoogleAccountID)
+	pageKeys, err := q.GetAll(ctx, &pages)
+	if err != nil {
+		return n, err
+	}
+	for i := range pages {
+		pages[i].Author = to
+	}
+	err = batches(len(pages), func(i, j int) error {
+		if _, err := datastore.PutMulti(ctx, pageKeys[i:j], pages[i:j]); err != nil {
+			return err
+		}
+		n += j - i
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	var images []Image
+	q = datastore.NewQuery(imageKind).Ancestor(blogRootKey(ctx)).Filter(filter, from.GoogleAccountID)
+	imageKeys, err := q.GetAll(ctx, &images)
+	if err != nil {
+		return n, err
+	}
+	for i := range images {
+		images[i].Author = to
+	}
+	err = batches(len(images), func(i, j int) error {
+		if _, err := datastore.PutMulti(ctx, imageKeys[i:j], images[i:j]); err != nil {
+			return err
+		}
+		n += j - i
+		return nil
+	})
+	return n, err
+}
+
 // GetAuthorByEmail returns an Author from the datastore matching the supplied
 // email address. Returns ErrorNoMatchingAuthor if there is no matching author.
 func GetAuthorByEmail(ctx context.Context, email string) (*Author, error) {
-	query := datastore.NewQuery(authorKind).Ancestor(blogRootKey(ctx)).Filter("Email=", email)
-	var author = new(Author)
-	authorList := query.Run(ctx)
-	_, err := authorList.Next(author)
-	if err == datastore.Done {
+	authors, _, err := authorsByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if len(authors) == 0 {
 		return nil, ErrorNoMatchingAuthor
 	}
+	return &authors[0], nil
+}
 
-	return author, err
+// SetAuthorRole changes the role of the Author matching the supplied email
+// address. Returns ErrorNoMatchingAuthor if there is no matching author.
+func SetAuthorRole(ctx context.Context, email string, role string) error {
+	authors, keys, err := authorsByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if len(authors) == 0 {
+		return ErrorNoMatchingAuthor
+	}
+	for i := range authors {
+		authors[i].Role = role
+	}
+	_, err = datastore.PutMulti(ctx, keys, authors)
+	return err
 }
 
 // GetAllAuthor returns a slice representing all Authors in the datastore.
 func GetAllAuthor(ctx context.Context) ([]Author, error) {
-	query := datastore.NewQuery(authorKind)
+	query := datastore.NewQuery(authorKind).Ancestor(blogRootKey(ctx))
 	var authors []Author
 	_, err := query.GetAll(ctx, &authors)
 
@@ -55,7 +268,7 @@ func GetAllAuthor(ctx context.Context) ([]Author, error) {
 
 // DeleteAllAuthor deletes all Author data.
 func DeleteAllAuthor(ctx context.Context) error {
-	q := datastore.NewQuery(authorKind).KeysOnly()
+	q := datastore.NewQuery(authorKind).Ancestor(blogRootKey(ctx)).KeysOnly()
 	k, err := q.GetAll(ctx, nil)
 	if err != nil {
 		return err
@@ -66,7 +279,7 @@ func DeleteAllAuthor(ctx context.Context) error {
 
 // GetAuthorCount returns the number of registered authors.
 func GetAuthorCount(ctx context.Context) (int, error) {
-	q := datastore.NewQuery(authorKind).KeysOnly()
+	q := datastore.NewQuery(authorKind).Ancestor(blogRootKey(ctx)).KeysOnly()
 	k, err := q.GetAll(ctx, nil)
 	return len(k), err
 }
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const autosaveKind = "Autosave"
+
+// ErrorNoMatchingAutosave is returned when an author has no working copy of
+// a post.
+var ErrorNoMatchingAutosave = errors.New("model: no autosave matching supplied author and post")
+
+// Autosave is an author's working copy of a post, saved from the editor as
+// they type. It is kept apart from the post's versions, and replaced by each
+// autosave, until the author saves a version. PostID is empty for a new post.
+// BaseVersion is the version the working copy was started from, and is
+// unused for a new post. The fields are those of the editor form.
+//
+// Autosaves are written every few seconds while an author types, so each is
+// the root of its own entity group rather than a child of the blog, and does
+// not contend with transactions on the blog's posts. Blog is the ID of the
+// blog it belongs to.
+type Autosave struct {
+	Blog           string
+	AuthorID       string
+	PostID         string
+	BaseVersion    int    `datastore:",noindex"`
+	Title          string `datastore:",noindex"`
+	Slug           string `datastore:",noindex"`
+	BannerImageURL string `datastore:",noindex"`
+	BodyMarkdown   string `datastore:",noindex"`
+	CategoryList   string `datastore:",noindex"`
+	DatePublished  string `datastore:",noindex"`
+	CommentsClosed bool   `datastore:",noindex"`
+	Saved          time.Time
+}
+
+func autosaveKey(ctx context.Context, authorID string, postID string) *datastore.Key {
+	return datastore.NewKey(ctx, autosaveKind, BlogID(ctx)+" "+authorID+" "+postID, 0, nil)
+}
+
+// Save replaces the author's working copy of the post in the datastore.
+func (as *Autosave) Save(ctx context.Context) (*datastore.Key, error) {
+	if as.AuthorID == "" {
+		return nil, errors.New("model: autosave author cannot be empty")
+	}
+	as.Blog = BlogID(ctx)
+	as.Saved = time.Now()
+	return datastore.Put(ctx, autosaveKey(ctx, as.AuthorID, as.PostID), as)
+}
+
+// GetAutosave returns an author's working copy of a post, or of a new post
+// if postID is empty.
+func GetAutosave(ctx context.Context, authorID string, postID string) (*Autosave, error) {
+	as := new(Autosave)
+	err := datastore.Get(ctx, autosaveKey(ctx, authorID, postID), as)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingAutosave
+	}
+	if err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
+// DeleteAutosave discards an author's working copy of a post. Nothing is
+// changed if there is none.
+func DeleteAutosave(ctx context.Context, authorID string, postID string) error {
+	err := datastore.Delete(ctx, autosaveKey(ctx, authorID, postID))
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+// DeleteAutosaveByPostID discards every author's working copy of a post.
+func DeleteAutosaveByPostID(ctx context.Context, postID string) error {
+	q := datastore.NewQuery(autosaveKind).
+		Filter("Blog=", BlogID(ctx)).
+		Filter("PostID=", postID).
+		KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllAutosave deletes all Autosave data.
+func DeleteAllAutosave(ctx context.Context) error {
+	q := datastore.NewQuery(autosaveKind).Filter("Blog=", BlogID(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const blogKind = "Blog"
+
+// DefaultBlogID is the ID of the blog served on the base domain name from
+// app.yaml, which needs no Blog entity.
+const DefaultBlogID = "default"
+
+// ErrorNoMatchingBlog is returned when no Blog matching a supplied ID or host
+// name can be found in the datastore.
+var ErrorNoMatchingBlog = errors.New("model: no blog matching supplied ID or host")
+
+// ErrorBlogIDAlreadyExists is returned when saving a new Blog with the ID of
+// an existing one.
+var ErrorBlogIDAlreadyExists = errors.New("model: blog ID already exists")
+
+// ErrorBlogHostInUse is returned when saving a Blog with a host name which
+// already serves another blog.
+var ErrorBlogHostInUse = errors.New("model: host name already serves another blog")
+
+// Blog is one of the blogs hosted by the application. Its key is the
+// ancestor of all its posts, pages, authors and other entities, and it is
+// served on each of its Hosts, the first of which is its canonical domain.
+type Blog struct {
+	ID      string
+	Name    string `datastore:",noindex"`
+	Hosts   []string
+	Created time.Time
+}
+
+func blogKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, blogKind, id, 0, nil)
+}
+
+// Save adds or updates the Blog in the datastore. Host names are stored in
+// lower case. Returns ErrorBlogIDAlreadyExists if new is true and the ID is
+// taken, or ErrorBlogHostInUse if another blog has one of the host names.
+func (b *Blog) Save(ctx context.Context, new bool) (*datastore.Key, error) {
+	if b.ID == "" {
+		return nil, errors.New("model: blog ID cannot be empty")
+	}
+	for i := range b.Hosts {
+		b.Hosts[i] = strings.ToLower(strings.TrimSpace(b.Hosts[i]))
+	}
+
+	for _, host := range b.Hosts {
+		other, err := GetBlogByHost(ctx, host)
+		if err == nil && other.ID != b.ID {
+			return nil, ErrorBlogHostInUse
+		} else if err != nil && err != ErrorNoMatchingBlog {
+			return nil, err
+		}
+	}
+
+	k := blogKey(ctx, b.ID)
+	err := datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		if new {
+			err := datastore.Get(tc, k, &Blog{})
+			if err == nil {
+				return ErrorBlogIDAlreadyExists
+			} else if err != datastore.ErrNoSuchEntity {
+				return err
+			}
+		}
+		_, err := datastore.Put(tc, k, b)
+		return err
+	}, nil)
+	return k, err
+}
+
+// GetBlog returns the Blog with the supplied ID, or ErrorNoMatchingBlog.
+func GetBlog(ctx context.Context, id string) (*Blog, error) {
+	b := new(Blog)
+	err := datastore.Get(ctx, blogKey(ctx, id), b)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingBlog
+	}
+	return b, err
+}
+
+// GetBlogByHost returns the Blog served on the supplied host name, or
+// ErrorNoMatchingBlog. Blogs are not in an entity group, so a blog's hosts
+// may take a moment to be found after it is saved.
+func GetBlogByHost(ctx context.Context, host string) (*Blog, error) {
+	q := datastore.NewQuery(blogKind).
+		Filter("Hosts=", strings.ToLower(host)).
+		Limit(1)
+	var blogs []Blog
+	if _, err := q.GetAll(ctx, &blogs); err != nil {
+		return nil, err
+	}
+	if len(blogs) == 0 {
+		return nil, ErrorNoMatchingBlog
+	}
+	return &blogs[0], nil
+}
+
+// GetAllBlog returns all the Blogs which have been saved, ordered by ID.
+func GetAllBlog(ctx context.Context) ([]Blog, error) {
+	q := datastore.NewQuery(blogKind).Order("ID")
+	var blogs []Blog
+	_, err := q.GetAll(ctx, &blogs)
+	return blogs, err
+}
+
+// GetBlogIDsByAuthorEmail returns the IDs of the blogs with an Author
+// matching the supplied email address. Authors registered before addresses
+// were normalised are only found if stored as supplied.
+func GetBlogIDsByAuthorEmail(ctx context.Context, email string) ([]string, error) {
+	emails := []string{NormalEmail(email)}
+	if email != emails[0] {
+		emails = append(emails, email)
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, e := range emails {
+		q := datastore.NewQuery(authorKind).Filter("Email=", e).KeysOnly()
+		keys, err := q.GetAll(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if p := k.Parent(); p != nil && p.Kind() == blogKind && !seen[p.StringID()] {
+				seen[p.StringID()] = true
+				ids = append(ids, p.StringID())
+			}
+		}
+	}
+	return ids, nil
+}
@@ -2,21 +2,49 @@ package model
 
 import (
 	"errors"
+	"strings"
 	"time"
 
+	"goblogengine/retention"
+	"goblogengine/workflow"
+
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 )
 
 const blogPostVersionKind = "BlogPostVersion"
 
+// ErrorVersionConflict is returned when a version of a post is saved from a
+// version which is no longer the most recent.
+var ErrorVersionConflict = errors.New("model: a newer version of the post has been saved")
+
 // ErrorPostSlugAlreadyExists is returned when the chosen URL slug is already
 // associated with another post in the datastore.
 var ErrorPostSlugAlreadyExists = errors.New("model: url slug already in use")
 
+// ErrorNoMatchingPost is returned when no BlogPostVersion matching the supplied
+// URL slug or post ID can be found in the datastore.
+var ErrorNoMatchingPost = errors.New("model: no post matching supplied slug")
+
+// ErrorMultiplePublished is returned, and nothing is changed, when a change
+// would leave more than one version of a post published.
+var ErrorMultiplePublished = errors.New("model: more than one version of the post would be published")
+
+// ErrorScheduleInPast is returned when a version is scheduled to be published
+// at a time which has already passed.
+var ErrorScheduleInPast = errors.New("model: scheduled time has passed")
+
+// ErrorNotReviewer is returned, and nothing is changed, when an Author tries
+// to approve, or request changes to, a version in review which they may not
+// review.
+var ErrorNotReviewer = errors.New("model: author may not review this version")
+
 // BlogPostVersion represents a version of a blog post. A single post can have
 // many versions, but only one version can be published at a point in time.
+//
+// Each version has an editorial State from the workflow package, and only
+// moves between states as the workflow allows. Published is true exactly
+// when the State is published.
 type BlogPostVersion struct {
 	PostID         string
 	Slug           string
@@ -29,18 +57,65 @@ type BlogPostVersion struct {
 	Published      bool
 	Author         Author
 	Version        int
+	CommentsClosed bool
+	State          string
+	ReviewerEmail  string
+	ChangeNote     string `datastore:",noindex"`
+	Label          string `datastore:",noindex"`
+	Pinned         bool   `datastore:",noindex"`
+	Unpublished    time.Time
+}
+
+// EffectiveState returns the version's workflow state. Versions saved before
+// the workflow was introduced are drafts unless published.
+func (ver *BlogPostVersion) EffectiveState() string {
+	if ver.State != "" {
+		return ver.State
+	}
+	if ver.Published {
+		return workflow.Published
+	}
+	return workflow.Draft
+}
+
+// CanReview reports whether an Author may approve, or request changes to,
+// the version while it is in review. Nobody may review their own version,
+// and a version submitted to a reviewer may only be reviewed by them.
+func (ver *BlogPostVersion) CanReview(a *Author) bool {
+	if a == nil || a.GoogleAccountID == ver.Author.GoogleAccountID {
+		return false
+	}
+	return ver.ReviewerEmail == "" || NormalEmail(ver.ReviewerEmail) == NormalEmail(a.Email)
+}
+
+// Retained reports whether the version is kept whatever the retention
+// policy, because it is labelled, pinned, published or on its way to being
+// published.
+func (ver *BlogPostVersion) Retained() bool {
+	if ver.Pinned || ver.Label != "" {
+		return true
+	}
+	switch ver.EffectiveState() {
+	case workflow.Published, workflow.Scheduled, workflow.InReview, workflow.ChangesRequested:
+		return true
+	}
+	return false
 }
 
 // GetBlogPostBySlug returns a published BlogPostVersion matching the supplied
-// URL slug.
+// URL slug. Returns ErrorNoMatchingPost if no published version uses it.
 func GetBlogPostBySlug(ctx context.Context, slug string) (*BlogPostVersion, error) {
 	query := datastore.NewQuery(blogPostVersionKind).
+		Ancestor(blogRootKey(ctx)).
 		Filter("Slug=", slug).
 		Filter("Published=", true)
 
 	var post = new(BlogPostVersion)
 	postlist := query.Run(ctx)
 	_, err := postlist.Next(post)
+	if err == datastore.Done {
+		return nil, ErrorNoMatchingPost
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -48,12 +123,54 @@ func GetBlogPostBySlug(ctx context.Context, slug string) (*BlogPostVersion, erro
 	return post, nil
 }
 
-// GetBlogPostVersion returns a BlogPostVersion matching the supplied URL slug
+// GetPublishedBlogPostByID returns the published BlogPostVersion of the post
+// with the supplied ID. Returns ErrorNoMatchingPost if no version of the post
+// is published.
+func GetPublishedBlogPostByID(ctx context.Context, id string) (*BlogPostVersion, error) {
+	query := datastore.NewQuery(blogPostVersionKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", id).
+		Filter("Published=", true).
+		Limit(1)
+
+	var posts []BlogPostVersion
+	_, err := query.GetAll(ctx, &posts)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, ErrorNoMatchingPost
+	}
+
+	return &posts[0], nil
+}
+
+// GetLastUnpublishedBlogPostVersion returns the version of the post with the
+// supplied ID which was most recently unpublished. Returns
+// ErrorNoMatchingPost if no version of the post has been unpublished.
+func GetLastUnpublishedBlogPostVersion(ctx context.Context, id string) (*BlogPostVersion, error) {
+	versions, err := GetBlogPostVersionByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var last *BlogPostVersion
+	for i := range versions {
+		if !versions[i].Unpublished.IsZero() && (last == nil || versions[i].Unpublished.After(last.Unpublished)) {
+			last = &versions[i]
+		}
+	}
+	if last == nil {
+		return nil, ErrorNoMatchingPost
+	}
+	return last, nil
+}
+
+// GetBlogPostVersion returns a BlogPostVersion matching the supplied post ID
 // and version number.
-func GetBlogPostVersion(ctx context.Context, slug string, version int) (*BlogPostVersion, error) {
+func GetBlogPostVersion(ctx context.Context, id string, version int) (*BlogPostVersion, error) {
 	query := datastore.NewQuery(blogPostVersionKind).
 		Ancestor(blogRootKey(ctx)).
-		Filter("Slug=", slug).
+		Filter("PostID=", id).
 		Filter("Version=", version)
 
 	var post = new(BlogPostVersion)
@@ -66,81 +183,171 @@ func GetBlogPostVersion(ctx context.Context, slug string, version int) (*BlogPos
 	return post, nil
 }
 
+// GetLatestBlogPostVersion returns the most recent BlogPostVersion of the post
+// which uses, or has used, the supplied URL slug, whether or not it is
+// published. Returns ErrorNoMatchingPost if there is no such post.
+func GetLatestBlogPostVersion(ctx context.Context, slug string) (*BlogPostVersion, error) {
+	id, err := GetPostIDBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	return GetLatestBlogPostVersionByID(ctx, id)
+}
+
+// GetLatestBlogPostVersionByID returns the most recent BlogPostVersion of
+// the post with the supplied ID. Returns ErrorNoMatchingPost if there is no
+// such post.
+func GetLatestBlogPostVersionByID(ctx context.Context, id string) (*BlogPostVersion, error) {
+	query := datastore.NewQuery(blogPostVersionKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", id)
+
+	var posts []BlogPostVersion
+	_, err := query.GetAll(ctx, &posts)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, ErrorNoMatchingPost
+	}
+
+	latest := &posts[0]
+	for i := range posts {
+		if posts[i].Version > latest.Version {
+			latest = &posts[i]
+		}
+	}
+	return latest, nil
+}
+
 // Save adds the BlogPostVersion to the datastore.
 //
 // If new is true, an error is returned if there are existing posts with the
 // same tag or slug, otherwise a new version is added and the version number
 // is incremented.
 //
+// The version's slug is recorded in the post's slug history. An error is
+// returned if the slug is, or has been, used by a different post.
+//
 // If ver.Published is true, the inserted version is published and all
-// other versions of the post are un-published.
+// other versions of the post which are published or scheduled are returned
+// to approved. Otherwise the inserted version is a draft.
+//
+// The version, its categories and any change to the post's other versions
+// are saved in a single transaction.
 func (ver *BlogPostVersion) Save(ctx context.Context, new bool) (*datastore.Key, error) {
-	for i := range ver.Categories {
-		_, err := ver.Categories[i].Save(ctx)
-		if err != nil {
-			log.Errorf(ctx, "model: failed to add category: %v", err)
+	return ver.save(ctx, new, noBaseVersion, false)
+}
+
+// SaveFrom adds a new version of an existing post like Save, provided that
+// base is still the post's most recent version. If a newer version has been
+// saved since, ErrorVersionConflict is returned and nothing is saved.
+func (ver *BlogPostVersion) SaveFrom(ctx context.Context, base int) (*datastore.Key, error) {
+	return ver.save(ctx, false, base, false)
+}
+
+// SaveUnpublishedFrom adds a new draft version of an existing post like
+// SaveFrom, and unpublishes the post in the same transaction, so that the
+// post is never left unpublished without the new version.
+func (ver *BlogPostVersion) SaveUnpublishedFrom(ctx context.Context, base int) (*datastore.Key, error) {
+	ver.Published = false
+	return ver.save(ctx, false, base, true)
+}
+
+// namedCategories returns the categories which have a title and slug,
+// dropping the empty entries left by an empty category list.
+func namedCategories(cats []Category) []Category {
+	var named []Category
+	for _, c := range cats {
+		if strings.TrimSpace(c.Title) != "" && strings.TrimSpace(c.Slug) != "" {
+			named = append(named, c)
 		}
 	}
+	return named
+}
+
+// noBaseVersion is passed to save when a version is not saved from a known
+// base, as version numbers start at 0.
+const noBaseVersion = -1
+
+// save adds the BlogPostVersion to the datastore, checking that base is the
+// most recent version unless it is noBaseVersion. If unpublish is true the
+// post's published versions are returned to approved.
+func (ver *BlogPostVersion) save(ctx context.Context, new bool, base int, unpublish bool) (*datastore.Key, error) {
+	ver.State = workflow.Draft
+	if ver.Published {
+		ver.State = workflow.Published
+	}
+	ver.Categories = namedCategories(ver.Categories)
+	ver.Unpublished = time.Time{}
 
 	var newVersionKey *datastore.Key
 	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		for i := range ver.Categories {
+			if _, err := ver.Categories[i].Save(ctx); err != nil {
+				return err
+			}
+		}
+
 		if new {
-			q := datastore.NewQuery(blogPostVersionKind).
+			_, err := GetPostIDBySlug(ctx, ver.Slug)
+			if err == nil {
+				return ErrorPostSlugAlreadyExists
+			}
+			if err != ErrorNoMatchingPost {
+				return err
+			}
+		} else {
+			var versions []BlogPostVersion
+			query := datastore.NewQuery(blogPostVersionKind).
 				Ancestor(blogRootKey(ctx)).
-				Filter("Slug=", ver.Slug).
-				KeysOnly()
+				Filter("PostID=", ver.PostID)
 
-			k, err := q.GetAll(ctx, nil)
+			keys, err := query.GetAll(ctx, &versions)
 			if err != nil {
 				return err
 			}
-			if len(k) > 0 {
-				return ErrorPostSlugAlreadyExists
-			}
-		} else {
-			if ver.Published == true {
-				var versions []BlogPostVersion
-				query := datastore.NewQuery(blogPostVersionKind).
-					Ancestor(blogRootKey(ctx)).
-					Filter("Slug=", ver.Slug).
-					Filter("Published=", true)
-
-				keys, err := query.GetAll(ctx, &versions)
-				if err != nil {
-					return err
-				}
 
+			if len(versions) == 0 && base != noBaseVersion {
+				return ErrorNoMatchingPost
+			}
+			if len(versions) > 0 {
+				latest := versions[0].Version
 				for i := range versions {
-					versions[i].Published = false
+					if versions[i].Version > latest {
+						latest = versions[i].Version
+					}
+				}
+				if base != noBaseVersion && base != latest {
+					return ErrorVersionConflict
 				}
+				ver.Version = latest + 1
+			}
 
-				_, err = datastore.PutMulti(ctx, keys, versions)
-				if err != nil {
+			var demoted []BlogPostVersion
+			var demotedKeys []*datastore.Key
+			if ver.Published {
+				demoted, demotedKeys = supersede(versions, keys, -1)
+				if err := checkSinglePublished(append(versions, *ver)); err != nil {
 					return err
 				}
+			} else if unpublish {
+				demoted, demotedKeys = unpublished(versions, keys)
 			}
-
-			var versions []BlogPostVersion
-			query := datastore.NewQuery(blogPostVersionKind).
-				Ancestor(blogRootKey(ctx)).
-				Filter("Slug=", ver.Slug).
-				Order("-Version").
-				Limit(1)
-
-			_, err := query.GetAll(ctx, &versions)
+			_, err = datastore.PutMulti(ctx, demotedKeys, demoted)
 			if err != nil {
 				return err
 			}
+		}
 
-			if len(versions) > 0 {
-				ver.Version = versions[0].Version + 1
-			}
-
+		err := recordPostSlug(ctx, ver.Slug, ver.PostID)
+		if err != nil {
+			return err
 		}
 
 		// Put the new version
 		newVersionKey = datastore.NewIncompleteKey(ctx, blogPostVersionKind, blogRootKey(ctx))
-		_, err := datastore.Put(ctx, newVersionKey, ver)
+		_, err = datastore.Put(ctx, newVersionKey, ver)
 		if err != nil {
 			return err
 		}
@@ -152,11 +359,34 @@ func (ver *BlogPostVersion) Save(ctx context.Context, new bool) (*datastore.Key,
 	return newVersionKey, err
 }
 
+// RestoreBlogPostVersion adds a new draft version of a post which copies an
+// earlier version, saved by the supplied Author with the supplied change note.
+func RestoreBlogPostVersion(ctx context.Context, id string, version int, author Author, note string) (*BlogPostVersion, error) {
+	old, err := GetBlogPostVersion(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	ver := *old
+	ver.Published = false
+	ver.DateCreated = time.Now()
+	ver.Author = author
+	ver.ReviewerEmail = ""
+	ver.ChangeNote = note
+	ver.Label = ""
+	ver.Pinned = false
+	if _, err := ver.Save(ctx, false); err != nil {
+		return nil, err
+	}
+	return &ver, nil
+}
+
 // GetBlogPostLimit returns a slice of BlogPostVersion from offset to limit,
 // ordered by most recent first. If limit is < 1 offset is ignored and the
 // function returns all available posts.
 func GetBlogPostLimit(ctx context.Context, offset int, limit int) ([]BlogPostVersion, error) {
 	q := datastore.NewQuery(blogPostVersionKind).
+		Ancestor(blogRootKey(ctx)).
 		Filter("Published=", true).
 		Order("-DatePublished")
 
@@ -201,12 +431,12 @@ func GetAllBlogPost(ctx context.Context) ([]BlogPostVersion, error) {
 	return posts, nil
 }
 
-// GetBlogPostVersionBySlug returns a slice of BlogPostVersion representing
-// all versions of a given post.
-func GetBlogPostVersionBySlug(ctx context.Context, slug string) ([]BlogPostVersion, error) {
+// GetBlogPostVersionByID returns a slice of BlogPostVersion representing
+// all versions of the post with the supplied ID, whatever their slugs.
+func GetBlogPostVersionByID(ctx context.Context, id string) ([]BlogPostVersion, error) {
 	query := datastore.NewQuery(blogPostVersionKind).
 		Ancestor(blogRootKey(ctx)).
-		Filter("Slug=", slug)
+		Filter("PostID=", id)
 
 	var posts []BlogPostVersion
 	_, err := query.GetAll(ctx, &posts)
@@ -214,52 +444,345 @@ func GetBlogPostVersionBySlug(ctx context.Context, slug string) ([]BlogPostVersi
 	return posts, err
 }
 
-// UnpublishBlogPost unpublishes the currently published version of a blog post.
-func UnpublishBlogPost(ctx context.Context, id string) error {
-	q := datastore.NewQuery(blogPostVersionKind).
-		Filter("PostID=", id).
-		Filter("Published=", true)
+// supersede returns to approved the versions of a post, other than the one
+// with the supplied version number, which are published or scheduled, as
+// another version is about to be published. It returns the versions it
+// changed and their keys.
+func supersede(versions []BlogPostVersion, keys []*datastore.Key, version int) ([]BlogPostVersion, []*datastore.Key) {
+	var changed []BlogPostVersion
+	var changedKeys []*datastore.Key
+	for i := range versions {
+		state := versions[i].EffectiveState()
+		if versions[i].Version == version || (state != workflow.Published && state != workflow.Scheduled) {
+			continue
+		}
+		versions[i].Published = false
+		versions[i].State = workflow.Approved
+		changed = append(changed, versions[i])
+		changedKeys = append(changedKeys, keys[i])
+	}
+	return changed, changedKeys
+}
 
-	var posts []BlogPostVersion
-	k, err := q.GetAll(ctx, &posts)
-	if err != nil {
+// TransitionBlogPostVersion moves a version of a post to another workflow
+// state on behalf of actor, returning the version as moved and the state it
+// was in. An error from workflow.Check is returned if the workflow does not
+// allow the move, and ErrorNotReviewer if the move reviews the version and
+// actor may not review it. Publishing a version returns the post's other
+// published or scheduled versions to approved.
+func TransitionBlogPostVersion(ctx context.Context, id string, version int, to string, actor *Author) (*BlogPostVersion, string, error) {
+	return transitionBlogPostVersion(ctx, id, version, to, actor, nil)
+}
+
+// SubmitBlogPostVersion submits a version of a post for review by the Author
+// with the supplied email address, returning the version as submitted and
+// the state it was in.
+func SubmitBlogPostVersion(ctx context.Context, id string, version int, reviewerEmail string) (*BlogPostVersion, string, error) {
+	return transitionBlogPostVersion(ctx, id, version, workflow.InReview, nil, func(ver *BlogPostVersion) {
+		ver.ReviewerEmail = reviewerEmail
+	})
+}
+
+// ScheduleBlogPostVersion schedules a version of a post to be published at
+// the supplied time by PublishDueBlogPostVersions, returning the version as
+// scheduled and the state it was in. Returns ErrorScheduleInPast if the time
+// has passed.
+func ScheduleBlogPostVersion(ctx context.Context, id string, version int, at time.Time) (*BlogPostVersion, string, error) {
+	if !at.After(time.Now()) {
+		return nil, "", ErrorScheduleInPast
+	}
+	return transitionBlogPostVersion(ctx, id, version, workflow.Scheduled, nil, func(ver *BlogPostVersion) {
+		ver.DatePublished = at
+	})
+}
+
+// transitionBlogPostVersion moves a version of a post to another state in a
+// transaction, applying change, if not nil, to the version as it moves.
+// Moves out of review are checked against actor, which may be nil for moves
+// which do not review the version. Moves to or from published are refused
+// with ErrorMultiplePublished if they would leave more than one version of
+// the post published.
+func transitionBlogPostVersion(ctx context.Context, id string, version int, to string, actor *Author, change func(*BlogPostVersion)) (*BlogPostVersion, string, error) {
+	var moved BlogPostVersion
+	var from string
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		q := datastore.NewQuery(blogPostVersionKind).
+			Ancestor(blogRootKey(ctx)).
+			Filter("PostID=", id)
+
+		var posts []BlogPostVersion
+		keys, err := q.GetAll(ctx, &posts)
+		if err != nil {
+			return err
+		}
+
+		target := -1
+		for i := range posts {
+			if posts[i].Version == version {
+				target = i
+			}
+		}
+		if target < 0 {
+			return ErrorNoMatchingPost
+		}
+
+		from = posts[target].EffectiveState()
+		if err := workflow.Check(from, to); err != nil {
+			return err
+		}
+		if isReview(from, to) && !posts[target].CanReview(actor) {
+			return ErrorNotReviewer
+		}
+
+		var changed []BlogPostVersion
+		var changedKeys []*datastore.Key
+		if to == workflow.Published {
+			changed, changedKeys = supersede(posts, keys, version)
+		}
+		posts[target].State = to
+		posts[target].Published = to == workflow.Published
+		if change != nil {
+			change(&posts[target])
+		}
+		changed = append(changed, posts[target])
+		changedKeys = append(changedKeys, keys[target])
+
+		if from == workflow.Published || to == workflow.Published {
+			if err := checkSinglePublished(posts); err != nil {
+				return err
+			}
+		}
+		_, err = datastore.PutMulti(ctx, changedKeys, changed)
+		moved = posts[target]
 		return err
+	}, nil)
+	if err != nil {
+		return nil, from, err
 	}
+	return &moved, from, nil
+}
 
-	for i := range posts {
-		posts[i].Published = false
+// isReview reports whether a move between two states reviews a version.
+func isReview(from string, to string) bool {
+	return from == workflow.InReview && (to == workflow.ChangesRequested || to == workflow.Approved)
+}
+
+// UnpublishBlogPost unpublishes the currently published version of a blog
+// post, returning it to approved. Should more than one version be published,
+// all are unpublished. Nothing is changed if no version of the post is
+// published.
+func UnpublishBlogPost(ctx context.Context, id string) error {
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		q := datastore.NewQuery(blogPostVersionKind).
+			Ancestor(blogRootKey(ctx)).
+			Filter("PostID=", id)
+
+		var posts []BlogPostVersion
+		keys, err := q.GetAll(ctx, &posts)
+		if err != nil {
+			return err
+		}
+
+		changed, changedKeys := unpublished(posts, keys)
+		_, err = datastore.PutMulti(ctx, changedKeys, changed)
+		return err
+	}, nil)
+}
+
+// unpublished returns to approved the versions of a post which are
+// published, recording when they were unpublished, and returns those it
+// changed and their keys.
+func unpublished(versions []BlogPostVersion, keys []*datastore.Key) ([]BlogPostVersion, []*datastore.Key) {
+	var changed []BlogPostVersion
+	var changedKeys []*datastore.Key
+	now := time.Now()
+	for i := range versions {
+		if !isPublished(&versions[i]) {
+			continue
+		}
+		versions[i].Published = false
+		versions[i].State = workflow.Approved
+		versions[i].Unpublished = now
+		changed = append(changed, versions[i])
+		changedKeys = append(changedKeys, keys[i])
 	}
+	return changed, changedKeys
+}
 
-	_, err = datastore.PutMulti(ctx, k, posts)
+// PublishBlogPostVersion publishes a given BlogPostVersion, provided the
+// workflow allows it.
+func PublishBlogPostVersion(ctx context.Context, id string, version int) error {
+	_, _, err := TransitionBlogPostVersion(ctx, id, version, workflow.Published, nil)
 	return err
 }
 
-// PublishBlogPostVersion publishes a given BlogPostVersion.
-func PublishBlogPostVersion(ctx context.Context, id string, version int) error {
+// GetBlogPostVersionByState returns every BlogPostVersion in the supplied
+// workflow state. Versions saved before the workflow was introduced have no
+// stored state, so are not found.
+func GetBlogPostVersionByState(ctx context.Context, state string) ([]BlogPostVersion, error) {
 	q := datastore.NewQuery(blogPostVersionKind).
-		Filter("PostID=", id)
+		Ancestor(blogRootKey(ctx)).
+		Filter("State=", state)
 
 	var posts []BlogPostVersion
-	k, err := q.GetAll(ctx, &posts)
+	_, err := q.GetAll(ctx, &posts)
+	return posts, err
+}
+
+// PublishDueBlogPostVersions publishes every scheduled version whose
+// publication date is no later than now, returning those published.
+func PublishDueBlogPostVersions(ctx context.Context, now time.Time) ([]BlogPostVersion, error) {
+	scheduled, err := GetBlogPostVersionByState(ctx, workflow.Scheduled)
 	if err != nil {
+		return nil, err
+	}
+
+	var published []BlogPostVersion
+	for i := range scheduled {
+		if scheduled[i].DatePublished.After(now) {
+			continue
+		}
+		p, _, err := TransitionBlogPostVersion(ctx, scheduled[i].PostID, scheduled[i].Version, workflow.Published, nil)
+		if err != nil {
+			return published, err
+		}
+		published = append(published, *p)
+	}
+	return published, nil
+}
+
+// LabelBlogPostVersion sets the label of a version of a post and whether it
+// is pinned. Labelled and pinned versions are never pruned.
+func LabelBlogPostVersion(ctx context.Context, id string, version int, label string, pinned bool) (*BlogPostVersion, error) {
+	var ver BlogPostVersion
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		q := datastore.NewQuery(blogPostVersionKind).
+			Ancestor(blogRootKey(ctx)).
+			Filter("PostID=", id).
+			Filter("Version=", version)
+
+		var posts []BlogPostVersion
+		keys, err := q.GetAll(ctx, &posts)
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			return ErrorNoMatchingPost
+		}
+
+		ver = posts[0]
+		ver.Label = label
+		ver.Pinned = pinned
+		_, err = datastore.Put(ctx, keys[0], &ver)
 		return err
+	}, nil)
+	if err != nil {
+		return nil, err
 	}
+	return &ver, nil
+}
 
-	for i := range posts {
-		if posts[i].Version == version {
-			posts[i].Published = true
-		} else {
-			posts[i].Published = false
+// maxPrunedPerPost limits the versions of a post deleted at once, to stay
+// within the mutations allowed in a transaction. Any more are pruned the
+// next time.
+const maxPrunedPerPost = 500
+
+// prunable returns the versions of a single post which the policy does not
+// keep, and their keys.
+func prunable(policy retention.Policy, now time.Time, versions []BlogPostVersion, keys []*datastore.Key) ([]BlogPostVersion, []*datastore.Key) {
+	var rv []retention.Version
+	for i := range versions {
+		rv = append(rv, retention.Version{
+			Number:  versions[i].Version,
+			Created: versions[i].DateCreated,
+			Keep:    versions[i].Retained(),
+		})
+	}
+
+	var pruned []BlogPostVersion
+	var prunedKeys []*datastore.Key
+	for _, n := range policy.Prune(rv, now) {
+		for i := range versions {
+			if versions[i].Version == n {
+				pruned = append(pruned, versions[i])
+				prunedKeys = append(prunedKeys, keys[i])
+			}
 		}
 	}
+	return pruned, prunedKeys
+}
 
-	_, err = datastore.PutMulti(ctx, k, posts)
-	return err
+// PruneBlogPostVersions deletes the versions of every post which the
+// retention policy does not keep at the supplied time, returning them. If
+// dryRun is true nothing is deleted, and the versions which would be are
+// returned.
+func PruneBlogPostVersions(ctx context.Context, policy retention.Policy, now time.Time, dryRun bool) ([]BlogPostVersion, error) {
+	q := datastore.NewQuery(blogPostVersionKind).Ancestor(blogRootKey(ctx))
+
+	var all []BlogPostVersion
+	keys, err := q.GetAll(ctx, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	byPost := make(map[string][]int)
+	var ids []string
+	for i := range all {
+		if _, seen := byPost[all[i].PostID]; !seen {
+			ids = append(ids, all[i].PostID)
+		}
+		byPost[all[i].PostID] = append(byPost[all[i].PostID], i)
+	}
+
+	var pruned []BlogPostVersion
+	for _, id := range ids {
+		var versions []BlogPostVersion
+		var versionKeys []*datastore.Key
+		for _, i := range byPost[id] {
+			versions = append(versions, all[i])
+			versionKeys = append(versionKeys, keys[i])
+		}
+		p, _ := prunable(policy, now, versions, versionKeys)
+		if len(p) == 0 {
+			continue
+		}
+		if dryRun {
+			pruned = append(pruned, p...)
+			continue
+		}
+
+		// Decide again in a transaction, in case the post has changed.
+		var deleted []BlogPostVersion
+		err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+			q := datastore.NewQuery(blogPostVersionKind).
+				Ancestor(blogRootKey(ctx)).
+				Filter("PostID=", id)
+
+			var versions []BlogPostVersion
+			keys, err := q.GetAll(ctx, &versions)
+			if err != nil {
+				return err
+			}
+			p, pk := prunable(policy, now, versions, keys)
+			if len(p) > maxPrunedPerPost {
+				p, pk = p[:maxPrunedPerPost], pk[:maxPrunedPerPost]
+			}
+			deleted = p
+			return datastore.DeleteMulti(ctx, pk)
+		}, nil)
+		if err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, deleted...)
+	}
+	return pruned, nil
 }
 
-// DeleteBlogPost deletes all versions of a blog post.
+// DeleteBlogPost deletes all versions of a blog post, its slug history, its
+// comments, its reviews, its autosaves, its edit locks and its webmentions.
 func DeleteBlogPost(ctx context.Context, id string) error {
 	q := datastore.NewQuery(blogPostVersionKind).
+		Ancestor(blogRootKey(ctx)).
 		Filter("PostID=", id).
 		KeysOnly()
 
@@ -269,12 +792,46 @@ func DeleteBlogPost(ctx context.Context, id string) error {
 	}
 
 	err = datastore.DeleteMulti(ctx, k)
-	return err
+	if err != nil {
+		return err
+	}
+
+	err = DeletePostSlugByPostID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteCommentByPostID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteReviewByPostID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteAutosaveByPostID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteEditLockByPostID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = DeletePreviewLinkByPostID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return DeleteWebmentionByPostID(ctx, id)
 }
 
 // DeleteAllBlogPostVersion deletes all blog posts.
 func DeleteAllBlogPostVersion(ctx context.Context) error {
-	q := datastore.NewQuery(blogPostVersionKind).KeysOnly()
+	q := datastore.NewQuery(blogPostVersionKind).Ancestor(blogRootKey(ctx)).KeysOnly()
 	k, err := q.GetAll(ctx, nil)
 	datastore.DeleteMulti(ctx, k)
 	return err
@@ -282,7 +839,7 @@ func DeleteAllBlogPostVersion(ctx context.Context) error {
 
 // GetBlogPostVersionCount returns the total number of all BlogPostVersion.
 func GetBlogPostVersionCount(ctx context.Context) (int, error) {
-	q := datastore.NewQuery(blogPostVersionKind).KeysOnly()
+	q := datastore.NewQuery(blogPostVersionKind).Ancestor(blogRootKey(ctx)).KeysOnly()
 	k, err := q.GetAll(ctx, nil)
 	return len(k), err
 }
@@ -290,6 +847,7 @@ func GetBlogPostVersionCount(ctx context.Context) (int, error) {
 // GetBlogPostCount returns the number of published blog posts.
 func GetBlogPostCount(ctx context.Context) (int, error) {
 	q := datastore.NewQuery(blogPostVersionKind).
+		Ancestor(blogRootKey(ctx)).
 		Filter("Published=", true).
 		KeysOnly()
 	k, err := q.GetAll(ctx, nil)
@@ -299,6 +857,7 @@ func GetBlogPostCount(ctx context.Context) (int, error) {
 // GetBlogPostDraftCount returns the number of posts which are in a draft state.
 func GetBlogPostDraftCount(ctx context.Context) (int, error) {
 	query := datastore.NewQuery(blogPostVersionKind).
+		Ancestor(blogRootKey(ctx)).
 		Project("PostID").
 		Distinct().
 		Order("PostID").
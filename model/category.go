@@ -34,7 +34,7 @@ func (cat *Category) Save(ctx context.Context) (*datastore.Key, error) {
 		return nil, errors.New("Invalid category")
 	}
 
-	q := datastore.NewQuery(categoryKind).Filter("Title=", cat.Title).KeysOnly()
+	q := datastore.NewQuery(categoryKind).Ancestor(blogRootKey(ctx)).Filter("Title=", cat.Title).KeysOnly()
 	keys, err := q.GetAll(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -52,7 +52,7 @@ func (cat *Category) Save(ctx context.Context) (*datastore.Key, error) {
 // DeleteCategory deletes a category by Slug
 // TODO: remove category from posts as well
 func DeleteCategory(ctx context.Context, slug string) error {
-	q := datastore.NewQuery(categoryKind).Filter("Slug=", slug).KeysOnly()
+	q := datastore.NewQuery(categoryKind).Ancestor(blogRootKey(ctx)).Filter("Slug=", slug).KeysOnly()
 	keys, err := q.GetAll(ctx, nil)
 	if err != nil {
 		return err
@@ -70,7 +70,7 @@ func DeleteCategory(ctx context.Context, slug string) error {
 
 // DeleteAllCategory deletes all categories from the datastore.
 func DeleteAllCategory(ctx context.Context) error {
-	q := datastore.NewQuery(categoryKind).KeysOnly()
+	q := datastore.NewQuery(categoryKind).Ancestor(blogRootKey(ctx)).KeysOnly()
 	k, err := q.GetAll(ctx, nil)
 	if err != nil {
 		return err
@@ -81,7 +81,7 @@ func DeleteAllCategory(ctx context.Context) error {
 
 // GetCategoryCount returns the number of categories.
 func GetCategoryCount(ctx context.Context) (int, error) {
-	q := datastore.NewQuery(categoryKind).KeysOnly()
+	q := datastore.NewQuery(categoryKind).Ancestor(blogRootKey(ctx)).KeysOnly()
 	k, err := q.GetAll(ctx, nil)
 	return len(k), err
 }
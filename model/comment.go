@@ -0,0 +1,170 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const commentKind = "Comment"
+
+// Moderation states of a Comment. Only approved comments are displayed.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+	CommentSpam     = "spam"
+)
+
+// ErrorNoMatchingComment is returned when no Comment matching a supplied ID
+// can be found in the datastore.
+var ErrorNoMatchingComment = errors.New("model: no comment matching supplied ID")
+
+// Comment represents a reader's comment on a blog post.
+type Comment struct {
+	ID           string
+	PostID       string
+	PostSlug     string
+	PostTitle    string `datastore:",noindex"`
+	AuthorName   string `datastore:",noindex"`
+	AuthorEmail  string `datastore:",noindex"`
+	AuthorURL    string `datastore:",noindex"`
+	BodyMarkdown string `datastore:",noindex"`
+	Status       string
+	Created      time.Time
+	IPAddress    string `datastore:",noindex"`
+	UserAgent    string `datastore:",noindex"`
+}
+
+// NewComment returns a new pending Comment on a post with a random ID.
+func NewComment(post *BlogPostVersion) (*Comment, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	return &Comment{
+		ID:        id,
+		PostID:    post.PostID,
+		PostSlug:  post.Slug,
+		PostTitle: post.Title,
+		Status:    CommentPending,
+		Created:   time.Now(),
+	}, nil
+}
+
+// Save adds the Comment to the datastore.
+func (c *Comment) Save(ctx context.Context) (*datastore.Key, error) {
+	if c.ID == "" {
+		return nil, errors.New("model: comment ID cannot be empty")
+	}
+	k := datastore.NewKey(ctx, commentKind, c.ID, 0, blogRootKey(ctx))
+	return datastore.Put(ctx, k, c)
+}
+
+// GetComment returns the Comment with the supplied ID.
+func GetComment(ctx context.Context, id string) (*Comment, error) {
+	c := new(Comment)
+	k := datastore.NewKey(ctx, commentKind, id, 0, blogRootKey(ctx))
+	err := datastore.Get(ctx, k, c)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingComment
+	}
+	return c, err
+}
+
+// GetCommentByPostID returns the approved comments on a post, oldest first.
+func GetCommentByPostID(ctx context.Context, postID string) ([]Comment, error) {
+	q := datastore.NewQuery(commentKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		Filter("Status=", CommentApproved).
+		Order("Created")
+	var cs []Comment
+	_, err := q.GetAll(ctx, &cs)
+	return cs, err
+}
+
+// GetCommentCountByPostID returns the number of approved comments on a post.
+func GetCommentCountByPostID(ctx context.Context, postID string) (int, error) {
+	return datastore.NewQuery(commentKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		Filter("Status=", CommentApproved).
+		Count(ctx)
+}
+
+// GetCommentByStatus returns up to limit comments in a moderation state,
+// newest first. A negative limit returns all of them.
+func GetCommentByStatus(ctx context.Context, status string, limit int) ([]Comment, error) {
+	q := datastore.NewQuery(commentKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Status=", status).
+		Order("-Created").
+		Limit(limit)
+	var cs []Comment
+	_, err := q.GetAll(ctx, &cs)
+	return cs, err
+}
+
+// GetCommentCountByStatus returns the number of comments in a moderation
+// state.
+func GetCommentCountByStatus(ctx context.Context, status string) (int, error) {
+	return datastore.NewQuery(commentKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Status=", status).
+		Count(ctx)
+}
+
+// SetCommentStatus moves the comments with the supplied IDs to a moderation
+// state.
+func SetCommentStatus(ctx context.Context, ids []string, status string) error {
+	keys := make([]*datastore.Key, len(ids))
+	for i := range ids {
+		keys[i] = datastore.NewKey(ctx, commentKind, ids[i], 0, blogRootKey(ctx))
+	}
+
+	cs := make([]Comment, len(keys))
+	if err := datastore.GetMulti(ctx, keys, cs); err != nil {
+		return err
+	}
+	for i := range cs {
+		cs[i].Status = status
+	}
+
+	_, err := datastore.PutMulti(ctx, keys, cs)
+	return err
+}
+
+// DeleteComment deletes the comments with the supplied IDs.
+func DeleteComment(ctx context.Context, ids []string) error {
+	keys := make([]*datastore.Key, len(ids))
+	for i := range ids {
+		keys[i] = datastore.NewKey(ctx, commentKind, ids[i], 0, blogRootKey(ctx))
+	}
+	return datastore.DeleteMulti(ctx, keys)
+}
+
+// DeleteCommentByPostID deletes all comments on a post.
+func DeleteCommentByPostID(ctx context.Context, postID string) error {
+	q := datastore.NewQuery(commentKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllComment deletes all Comment data.
+func DeleteAllComment(ctx context.Context) error {
+	q := datastore.NewQuery(commentKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
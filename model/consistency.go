@@ -0,0 +1,155 @@
+package model
+
+import (
+	"goblogengine/workflow"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// PublishInconsistency describes a post which has more than one published
+// version, or a version whose Published flag disagrees with its State.
+// Published lists the versions which were published, and Kept is the one
+// left published by a repair.
+type PublishInconsistency struct {
+	PostID    string
+	Title     string
+	Published []int
+	Kept      int
+}
+
+// isPublished reports whether a version is published by either its flag or
+// its state.
+func isPublished(ver *BlogPostVersion) bool {
+	return ver.Published || ver.EffectiveState() == workflow.Published
+}
+
+// checkSinglePublished returns ErrorMultiplePublished if more than one of the
+// versions of a post is published.
+func checkSinglePublished(versions []BlogPostVersion) error {
+	n := 0
+	for i := range versions {
+		if isPublished(&versions[i]) {
+			n++
+		}
+	}
+	if n > 1 {
+		return ErrorMultiplePublished
+	}
+	return nil
+}
+
+// resolvePublished changes the versions of a post so that at most one is
+// published, with its flag and state in agreement, returning the indexes of
+// those it changed and of the one left published, or -1. The version left
+// published is the most recent whose Published flag is set, as that is the
+// one readers see, or otherwise the most recent in the published state. The
+// others are returned to approved.
+func resolvePublished(versions []BlogPostVersion) ([]int, int) {
+	keep := -1
+	for i := range versions {
+		if !isPublished(&versions[i]) {
+			continue
+		}
+		if keep < 0 || versions[i].Published && !versions[keep].Published ||
+			versions[i].Published == versions[keep].Published && versions[i].Version > versions[keep].Version {
+			keep = i
+		}
+	}
+
+	var changed []int
+	for i := range versions {
+		if !isPublished(&versions[i]) {
+			continue
+		}
+		if i == keep {
+			if versions[i].Published && versions[i].EffectiveState() == workflow.Published {
+				continue
+			}
+			versions[i].Published = true
+			versions[i].State = workflow.Published
+		} else {
+			versions[i].Published = false
+			versions[i].State = workflow.Approved
+		}
+		changed = append(changed, i)
+	}
+	return changed, keep
+}
+
+// CheckPublishedBlogPosts finds the posts which have more than one published
+// version, or a version whose Published flag disagrees with its State. If
+// repair is true each post is then corrected in a transaction, as described
+// by resolvePublished.
+func CheckPublishedBlogPosts(ctx context.Context, repair bool) ([]PublishInconsistency, error) {
+	q := datastore.NewQuery(blogPostVersionKind).Ancestor(blogRootKey(ctx))
+	var all []BlogPostVersion
+	if _, err := q.GetAll(ctx, &all); err != nil {
+		return nil, err
+	}
+
+	posts := make(map[string][]BlogPostVersion)
+	var ids []string
+	for i := range all {
+		id := all[i].PostID
+		if _, seen := posts[id]; !seen {
+			ids = append(ids, id)
+		}
+		posts[id] = append(posts[id], all[i])
+	}
+
+	var found []PublishInconsistency
+	for _, id := range ids {
+		versions := posts[id]
+		var published []int
+		for i := range versions {
+			if isPublished(&versions[i]) {
+				published = append(published, versions[i].Version)
+			}
+		}
+		changed, keep := resolvePublished(versions)
+		if len(changed) == 0 {
+			continue
+		}
+
+		pi := PublishInconsistency{
+			PostID:    id,
+			Title:     versions[keep].Title,
+			Published: published,
+			Kept:      versions[keep].Version,
+		}
+		if repair {
+			if err := repairPublished(ctx, id); err != nil {
+				return found, err
+			}
+		}
+		found = append(found, pi)
+	}
+	return found, nil
+}
+
+// repairPublished corrects the published versions of a post in a
+// transaction, reading them afresh.
+func repairPublished(ctx context.Context, id string) error {
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		q := datastore.NewQuery(blogPostVersionKind).
+			Ancestor(blogRootKey(ctx)).
+			Filter("PostID=", id)
+
+		var versions []BlogPostVersion
+		keys, err := q.GetAll(ctx, &versions)
+		if err != nil {
+			return err
+		}
+
+		changed, _ := resolvePublished(versions)
+		var changedVersions []BlogPostVersion
+		var changedKeys []*datastore.Key
+		for _, i := range changed {
+			changedVersions = append(changedVersions, versions[i])
+			changedKeys = append(changedKeys, keys[i])
+		}
+		_, err = datastore.PutMulti(ctx, changedKeys, changedVersions)
+		return err
+	}, nil)
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const editLockKind = "EditLock"
+
+// EditLockMaxAge is how long an EditLock lasts without a heartbeat from the
+// editor.
+const EditLockMaxAge = 2 * time.Minute
+
+// EditLock records that an author has a post open in the editor. Locks are
+// advisory: they tell other authors who else is editing, but prevent
+// nothing. The editor renews its lock with a heartbeat, and a lock expires
+// EditLockMaxAge after the last one.
+//
+// Heartbeats renew locks in transactions every few seconds, so each lock is
+// the root of its own entity group rather than a child of the blog, and does
+// not contend with transactions on the blog's posts. Blog is the ID of the
+// blog it belongs to.
+type EditLock struct {
+	Blog      string
+	PostID    string
+	AuthorID  string
+	Author    Author `datastore:",noindex"`
+	Opened    time.Time
+	Heartbeat time.Time
+}
+
+func editLockKey(ctx context.Context, authorID string, postID string) *datastore.Key {
+	return datastore.NewKey(ctx, editLockKind, BlogID(ctx)+" "+authorID+" "+postID, 0, nil)
+}
+
+// Expired returns true if the lock's last heartbeat was more than
+// EditLockMaxAge before now.
+func (l *EditLock) Expired(now time.Time) bool {
+	return now.Sub(l.Heartbeat) > EditLockMaxAge
+}
+
+// RenewEditLock takes or renews an author's lock on a post, returning it. A
+// lock which has expired is taken afresh.
+func RenewEditLock(ctx context.Context, postID string, author Author) (*EditLock, error) {
+	if author.GoogleAccountID == "" {
+		return nil, errors.New("model: edit lock author cannot be empty")
+	}
+
+	l := new(EditLock)
+	now := time.Now()
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		k := editLockKey(ctx, author.GoogleAccountID, postID)
+		err := datastore.Get(ctx, k, l)
+		if err == datastore.ErrNoSuchEntity || (err == nil && l.Expired(now)) {
+			*l = EditLock{Blog: BlogID(ctx), PostID: postID, AuthorID: author.GoogleAccountID, Opened: now}
+		} else if err != nil {
+			return err
+		}
+		l.Author = author
+		l.Heartbeat = now
+		_, err = datastore.Put(ctx, k, l)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// GetEditLockByPostID returns the unexpired locks on a post, oldest first.
+// As locks are not in the blog entity group, one just taken or released may
+// not be reflected at once.
+func GetEditLockByPostID(ctx context.Context, postID string) ([]EditLock, error) {
+	q := datastore.NewQuery(editLockKind).
+		Filter("Blog=", BlogID(ctx)).
+		Filter("PostID=", postID)
+
+	var all []EditLock
+	if _, err := q.GetAll(ctx, &all); err != nil {
+		return nil, err
+	}
+
+	var locks []EditLock
+	now := time.Now()
+	for i := range all {
+		if !all[i].Expired(now) {
+			locks = append(locks, all[i])
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].Opened.Before(locks[j].Opened)
+	})
+	return locks, nil
+}
+
+// DeleteEditLock releases an author's lock on a post. Nothing is changed if
+// there is none.
+func DeleteEditLock(ctx context.Context, authorID string, postID string) error {
+	err := datastore.Delete(ctx, editLockKey(ctx, authorID, postID))
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+// DeleteEditLockByPostID releases every lock on a post.
+func DeleteEditLockByPostID(ctx context.Context, postID string) error {
+	q := datastore.NewQuery(editLockKind).
+		Filter("Blog=", BlogID(ctx)).
+		Filter("PostID=", postID).
+		KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllEditLock deletes all EditLock data.
+func DeleteAllEditLock(ctx context.Context) error {
+	q := datastore.NewQuery(editLockKind).Filter("Blog=", BlogID(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
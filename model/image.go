@@ -37,7 +37,7 @@ func (i *Image) Save(ctx context.Context) error {
 
 // Delete deletes the Image from the datastore.
 func (i *Image) Delete(ctx context.Context) error {
-	q := datastore.NewQuery(imageKind).Filter("ID=", i.ID).KeysOnly()
+	q := datastore.NewQuery(imageKind).Ancestor(blogRootKey(ctx)).Filter("ID=", i.ID).KeysOnly()
 	keys, err := q.GetAll(ctx, nil)
 	if err != nil {
 		return err
@@ -52,7 +52,7 @@ func GetImageByID(ctx context.Context, id string) (*Image, error) {
 		return nil, errors.New("model: no image ID provided")
 	}
 
-	q := datastore.NewQuery(imageKind).Filter("ID=", id).Limit(1)
+	q := datastore.NewQuery(imageKind).Ancestor(blogRootKey(ctx)).Filter("ID=", id).Limit(1)
 	var imgs []Image
 	_, err := q.GetAll(ctx, &imgs)
 	if err != nil {
@@ -77,7 +77,7 @@ func GetAllImage(ctx context.Context) ([]Image, error) {
 
 // DeleteAllImage deletes all image metadata from the datastore.
 func DeleteAllImage(ctx context.Context) error {
-	q := datastore.NewQuery(imageKind).KeysOnly()
+	q := datastore.NewQuery(imageKind).Ancestor(blogRootKey(ctx)).KeysOnly()
 	keys, err := q.GetAll(ctx, nil)
 	if err != nil {
 		return err
@@ -88,7 +88,7 @@ func DeleteAllImage(ctx context.Context) error {
 
 // GetImageCount returns the number of images.
 func GetImageCount(ctx context.Context) (int, error) {
-	q := datastore.NewQuery(imageKind).KeysOnly()
+	q := datastore.NewQuery(imageKind).Ancestor(blogRootKey(ctx)).KeysOnly()
 	k, err := q.GetAll(ctx, nil)
 	return len(k), err
 }
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const invitationKind = "Invitation"
+
+// ErrorNoMatchingInvitation is returned when a supplied invitation token does
+// not match any Invitation in the datastore.
+var ErrorNoMatchingInvitation = errors.New("model: no invitation matching supplied value")
+
+// ErrorInvitationExpired is returned when a supplied invitation token matches
+// an Invitation which is no longer valid.
+var ErrorInvitationExpired = errors.New("model: invitation has expired")
+
+// Invitation represents an offer, made by an owner of the blog, for the holder
+// of a Google account with the given email address to register as an Author
+// with the given role. As with AccessTokens, only a hash of the token is
+// stored; the link containing it is shown to the owner once.
+type Invitation struct {
+	Hash    string
+	Email   string
+	Role    string `datastore:",noindex"`
+	Author  Author `datastore:",noindex"`
+	Created time.Time
+	Expires time.Time
+}
+
+// NewInvitation returns a new Invitation from the Author, valid for the
+// supplied duration, and the token to be sent to the invited person.
+func NewInvitation(email string, role string, valid time.Duration, author Author) (*Invitation, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(b)
+
+	now := time.Now()
+	i := Invitation{
+		Hash:    hashToken(token),
+		Email:   NormalEmail(email),
+		Role:    role,
+		Author:  author,
+		Created: now,
+		Expires: now.Add(valid),
+	}
+	return &i, token, nil
+}
+
+// Expired returns true if the Invitation can no longer be accepted.
+func (i *Invitation) Expired() bool {
+	return time.Now().After(i.Expires)
+}
+
+// Save adds the Invitation to the datastore.
+func (i *Invitation) Save(ctx context.Context) (*datastore.Key, error) {
+	if i.Hash == "" {
+		return nil, errors.New("model: invitation hash cannot be empty")
+	}
+	k := datastore.NewKey(ctx, invitationKind, i.Hash, 0, blogRootKey(ctx))
+	k, err := datastore.Put(ctx, k, i)
+	return k, err
+}
+
+// GetInvitation returns the Invitation matching the supplied token. Returns
+// ErrorNoMatchingInvitation if the token is unknown, or ErrorInvitationExpired
+// along with the Invitation if it has expired.
+func GetInvitation(ctx context.Context, token string) (*Invitation, error) {
+	if token == "" {
+		return nil, ErrorNoMatchingInvitation
+	}
+
+	i := new(Invitation)
+	k := datastore.NewKey(ctx, invitationKind, hashToken(token), 0, blogRootKey(ctx))
+	err := datastore.Get(ctx, k, i)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i.Expired() {
+		return i, ErrorInvitationExpired
+	}
+
+	return i, nil
+}
+
+// GetAllInvitation returns all Invitations, including expired ones, newest
+// first.
+func GetAllInvitation(ctx context.Context) ([]Invitation, error) {
+	q := datastore.NewQuery(invitationKind).Ancestor(blogRootKey(ctx)).Order("-Created")
+	var invitations []Invitation
+	_, err := q.GetAll(ctx, &invitations)
+	return invitations, err
+}
+
+// DeleteInvitation withdraws the Invitation with the supplied hash.
+func DeleteInvitation(ctx context.Context, hash string) error {
+	k := datastore.NewKey(ctx, invitationKind, hash, 0, blogRootKey(ctx))
+	return datastore.Delete(ctx, k)
+}
+
+// DeleteAllInvitation deletes all Invitation data.
+func DeleteAllInvitation(ctx context.Context) error {
+	q := datastore.NewQuery(invitationKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = datastore.DeleteMulti(ctx, k)
+	return err
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const menuItemKind = "MenuItem"
+
+// ErrorNoMatchingMenuItem is returned when no MenuItem matching a supplied ID
+// can be found in the datastore.
+var ErrorNoMatchingMenuItem = errors.New("model: no menu item matching supplied ID")
+
+// Kinds of link a MenuItem can make. The target of a page link is a page ID,
+// of a category link a category slug, and of a URL link the URL itself.
+const (
+	MenuItemPage     = "page"
+	MenuItemCategory = "category"
+	MenuItemURL      = "url"
+)
+
+// MenuItem is a link in the site navigation menu. Items with a ParentID
+// appear in a submenu of their parent.
+type MenuItem struct {
+	ID       string
+	ParentID string
+	Title    string `datastore:",noindex"`
+	Kind     string `datastore:",noindex"`
+	Target   string `datastore:",noindex"`
+	Position int    `datastore:",noindex"`
+	Created  time.Time
+}
+
+// NewMenuItem returns a new MenuItem with a random ID.
+func NewMenuItem(parentID string, title string, kind string, target string, position int) (*MenuItem, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	return &MenuItem{
+		ID:       id,
+		ParentID: parentID,
+		Title:    title,
+		Kind:     kind,
+		Target:   target,
+		Position: position,
+		Created:  time.Now(),
+	}, nil
+}
+
+func menuItemKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, menuItemKind, id, 0, blogRootKey(ctx))
+}
+
+// Save adds the MenuItem to the datastore.
+func (mi *MenuItem) Save(ctx context.Context) (*datastore.Key, error) {
+	if mi.ID == "" {
+		return nil, errors.New("model: menu item ID cannot be empty")
+	}
+	return datastore.Put(ctx, menuItemKey(ctx, mi.ID), mi)
+}
+
+// SaveMenuItems adds several menu items to the datastore at once.
+func SaveMenuItems(ctx context.Context, items []MenuItem) error {
+	keys := make([]*datastore.Key, len(items))
+	for i := range items {
+		keys[i] = menuItemKey(ctx, items[i].ID)
+	}
+	_, err := datastore.PutMulti(ctx, keys, items)
+	return err
+}
+
+// GetMenuItem returns the MenuItem with the supplied ID.
+func GetMenuItem(ctx context.Context, id string) (*MenuItem, error) {
+	mi := new(MenuItem)
+	err := datastore.Get(ctx, menuItemKey(ctx, id), mi)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingMenuItem
+	}
+	return mi, err
+}
+
+// GetAllMenuItem returns every MenuItem, in no particular order.
+func GetAllMenuItem(ctx context.Context) ([]MenuItem, error) {
+	q := datastore.NewQuery(menuItemKind).Ancestor(blogRootKey(ctx))
+	var items []MenuItem
+	_, err := q.GetAll(ctx, &items)
+	return items, err
+}
+
+// DeleteMenuItems deletes the menu items with the supplied IDs.
+func DeleteMenuItems(ctx context.Context, ids []string) error {
+	keys := make([]*datastore.Key, len(ids))
+	for i := range ids {
+		keys[i] = menuItemKey(ctx, ids[i])
+	}
+	return datastore.DeleteMulti(ctx, keys)
+}
+
+// DeleteAllMenuItem deletes all MenuItem data.
+func DeleteAllMenuItem(ctx context.Context) error {
+	q := datastore.NewQuery(menuItemKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
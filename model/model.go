@@ -6,10 +6,27 @@ import (
 	"google.golang.org/appengine/datastore"
 )
 
+type blogContextKey struct{}
+
+// WithBlog returns a copy of ctx in which the entities of the blog with the
+// supplied ID are read and written.
+func WithBlog(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, blogContextKey{}, id)
+}
+
+// BlogID returns the ID of the blog whose entities are read and written in
+// ctx, which is DefaultBlogID unless set by WithBlog.
+func BlogID(ctx context.Context) string {
+	if id, ok := ctx.Value(blogContextKey{}).(string); ok && id != "" {
+		return id
+	}
+	return DefaultBlogID
+}
+
 // blogRootKey returns a *datastore.Key which can be used as the ancestor for
-// all entities in the application to ensure strong consistency.
+// all entities of the current blog to ensure strong consistency.
 func blogRootKey(ctx context.Context) *datastore.Key {
-	return datastore.NewKey(ctx, "Blog", "default", 0, nil)
+	return datastore.NewKey(ctx, blogKind, BlogID(ctx), 0, nil)
 }
 
 // newIncompleteKeyMulti returns a slice of keys to be used in datastore.*Multi
@@ -22,3 +39,22 @@ func newIncompleteKeyMulti(ctx context.Context, kind string, parent *datastore.K
 	}
 	return keys
 }
+
+// maxBatchSize is the most entities which can be passed to a single
+// datastore.*Multi call.
+const maxBatchSize = 500
+
+// batches calls f with the bounds of successive batches of at most
+// maxBatchSize of n entities, stopping at the first error.
+func batches(n int, f func(i, j int) error) error {
+	for i := 0; i < n; i += maxBatchSize {
+		j := i + maxBatchSize
+		if j > n {
+			j = n
+		}
+		if err := f(i, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
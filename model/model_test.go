@@ -1,7 +1,17 @@
 package model
 
-import "testing"
-import "google.golang.org/appengine/aetest"
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"goblogengine/workflow"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/datastore"
+)
 
 func TestBlogRootKey(t *testing.T) {
 	ctx, done, err := aetest.NewContext()
@@ -16,3 +26,443 @@ func TestBlogRootKey(t *testing.T) {
 		t.Error("Root key is not root")
 	}
 }
+
+func TestResolvePublished(t *testing.T) {
+	tests := []struct {
+		name      string
+		versions  []BlogPostVersion
+		changed   int
+		keep      int
+		published []int
+	}{
+		{"none", []BlogPostVersion{
+			{Version: 1, State: workflow.Draft},
+			{Version: 2, State: workflow.Approved},
+		}, 0, -1, nil},
+		{"one", []BlogPostVersion{
+			{Version: 1, State: workflow.Approved},
+			{Version: 2, State: workflow.Published, Published: true},
+		}, 0, 1, []int{2}},
+		{"legacy", []BlogPostVersion{
+			{Version: 1, Published: true},
+			{Version: 2},
+		}, 0, 0, []int{1}},
+		{"two", []BlogPostVersion{
+			{Version: 1, State: workflow.Published, Published: true},
+			{Version: 2, State: workflow.Published, Published: true},
+			{Version: 3, State: workflow.Draft},
+		}, 1, 1, []int{2}},
+		{"flag wins over state", []BlogPostVersion{
+			{Version: 1, State: workflow.Published, Published: true},
+			{Version: 2, State: workflow.Published},
+		}, 1, 0, []int{1}},
+		{"state only", []BlogPostVersion{
+			{Version: 1, State: workflow.Approved},
+			{Version: 2, State: workflow.Published},
+		}, 1, 1, []int{2}},
+		{"flag only", []BlogPostVersion{
+			{Version: 1, State: workflow.Approved, Published: true},
+		}, 1, 0, []int{1}},
+	}
+	for _, test := range tests {
+		changed, keep := resolvePublished(test.versions)
+		if len(changed) != test.changed || keep != test.keep {
+			t.Errorf("%s: have %d changed keeping %d, need %d changed keeping %d",
+				test.name, len(changed), keep, test.changed, test.keep)
+		}
+		var published []int
+		for i := range test.versions {
+			if isPublished(&test.versions[i]) {
+				published = append(published, test.versions[i].Version)
+			}
+		}
+		if !reflect.DeepEqual(published, test.published) {
+			t.Errorf("%s: have versions %v published, need %v", test.name, published, test.published)
+		}
+		if err := checkSinglePublished(test.versions); err != nil {
+			t.Errorf("%s: %v after resolving", test.name, err)
+		}
+	}
+}
+
+// savePostVersions saves n draft versions of a new post.
+func savePostVersions(t *testing.T, ctx context.Context, id string, n int) {
+	for i := 0; i < n; i++ {
+		ver := &BlogPostVersion{
+			PostID:      id,
+			Slug:        id,
+			Title:       id,
+			DateCreated: time.Now(),
+			Version:     1,
+		}
+		if _, err := ver.Save(ctx, i == 0); err != nil {
+			t.Fatalf("Unable to save version %d: %v", i+1, err)
+		}
+	}
+}
+
+func publishedVersions(t *testing.T, ctx context.Context, id string) []int {
+	versions, err := GetBlogPostVersionByID(ctx, id)
+	if err != nil {
+		t.Fatalf("Unable to get versions: %v", err)
+	}
+	var published []int
+	for i := range versions {
+		if isPublished(&versions[i]) {
+			published = append(published, versions[i].Version)
+		}
+	}
+	return published
+}
+
+func TestConcurrentPublish(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	const n = 5
+	savePostVersions(t, ctx, "race", n)
+
+	// Publish every version at once, and publish a new version as they do.
+	// Transactions which lose the race fail, but may not leave a second
+	// version published.
+	var wg sync.WaitGroup
+	for v := 1; v <= n; v++ {
+		wg.Add(1)
+		go func(v int) {
+			defer wg.Done()
+			PublishBlogPostVersion(ctx, "race", v)
+		}(v)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ver := &BlogPostVersion{PostID: "race", Slug: "race", Title: "race", Published: true}
+		ver.Save(ctx, false)
+	}()
+	wg.Wait()
+
+	if published := publishedVersions(t, ctx, "race"); len(published) > 1 {
+		t.Errorf("Have versions %v published, need at most one", published)
+	}
+
+	if err := UnpublishBlogPost(ctx, "race"); err != nil {
+		t.Fatalf("Unable to unpublish: %v", err)
+	}
+	if published := publishedVersions(t, ctx, "race"); len(published) != 0 {
+		t.Errorf("Have versions %v published after unpublishing, need none", published)
+	}
+}
+
+func TestCheckPublishedBlogPosts(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	savePostVersions(t, ctx, "broken", 3)
+
+	// Publish two versions behind the model's back.
+	q := datastore.NewQuery(blogPostVersionKind).Ancestor(blogRootKey(ctx)).Filter("PostID=", "broken")
+	var versions []BlogPostVersion
+	keys, err := q.GetAll(ctx, &versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range versions {
+		if versions[i].Version != 2 {
+			versions[i].Published = true
+			versions[i].State = workflow.Published
+		}
+	}
+	if _, err := datastore.PutMulti(ctx, keys, versions); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := CheckPublishedBlogPosts(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || !reflect.DeepEqual(found[0].Published, []int{1, 3}) && !reflect.DeepEqual(found[0].Published, []int{3, 1}) || found[0].Kept != 3 {
+		t.Fatalf("Have %+v, need post broken with versions 1 and 3 published, keeping 3", found)
+	}
+	if published := publishedVersions(t, ctx, "broken"); len(published) != 2 {
+		t.Errorf("Have versions %v published after checking, need them unchanged", published)
+	}
+
+	if _, err := CheckPublishedBlogPosts(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if published := publishedVersions(t, ctx, "broken"); !reflect.DeepEqual(published, []int{3}) {
+		t.Errorf("Have versions %v published after repair, need [3]", published)
+	}
+	found, err = CheckPublishedBlogPosts(ctx, false)
+	if err != nil || len(found) != 0 {
+		t.Errorf("Have %+v, %v after repair, need nothing found", found, err)
+	}
+}
+
+func TestTrashBlogPost(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	savePostVersions(t, ctx, "trashed", 2)
+	author := Author{GoogleAccountID: "1", DisplayName: "Author"}
+
+	item, err := TrashBlogPost(ctx, "trashed", author)
+	if err != nil {
+		t.Fatalf("Unable to trash post: %v", err)
+	}
+	if _, err := GetLatestBlogPostVersionByID(ctx, "trashed"); err != ErrorNoMatchingPost {
+		t.Errorf("Have %v getting a trashed post, need ErrorNoMatchingPost", err)
+	}
+	if _, err := GetPostIDBySlug(ctx, "trashed"); err != ErrorNoMatchingPost {
+		t.Errorf("Have %v getting the slug of a trashed post, need ErrorNoMatchingPost", err)
+	}
+
+	if _, err := RestoreTrashItem(ctx, item.ID); err != nil {
+		t.Fatalf("Unable to restore post: %v", err)
+	}
+	versions, err := GetBlogPostVersionByID(ctx, "trashed")
+	if err != nil || len(versions) != 2 {
+		t.Errorf("Have %d versions, %v after restoring, need 2", len(versions), err)
+	}
+	if _, err := GetTrashItem(ctx, item.ID); err != ErrorNoMatchingTrashItem {
+		t.Errorf("Have %v getting a restored item, need ErrorNoMatchingTrashItem", err)
+	}
+
+	// A post cannot be restored once another has taken its slug.
+	item, err = TrashBlogPost(ctx, "trashed", author)
+	if err != nil {
+		t.Fatalf("Unable to trash post: %v", err)
+	}
+	savePostVersions(t, ctx, "other", 1)
+	other := &BlogPostVersion{PostID: "other", Slug: "trashed", Title: "other"}
+	if _, err := other.Save(ctx, false); err != nil {
+		t.Fatalf("Unable to take slug: %v", err)
+	}
+	if _, err := RestoreTrashItem(ctx, item.ID); err != ErrorTrashConflict {
+		t.Errorf("Have %v restoring a post whose slug is taken, need ErrorTrashConflict", err)
+	}
+
+	if err := PurgeTrashItem(ctx, item.ID); err != nil {
+		t.Fatalf("Unable to purge post: %v", err)
+	}
+	if _, err := GetTrashItem(ctx, item.ID); err != ErrorNoMatchingTrashItem {
+		t.Errorf("Have %v getting a purged item, need ErrorNoMatchingTrashItem", err)
+	}
+}
+
+func TestSaveFromFirstVersion(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	// The first version of a post is numbered 0, and edits from it are
+	// checked like any other.
+	first := &BlogPostVersion{PostID: "first", Slug: "first", Title: "first"}
+	if _, err := first.Save(ctx, true); err != nil {
+		t.Fatalf("Unable to save first version: %v", err)
+	}
+	mine := &BlogPostVersion{PostID: "first", Slug: "first", Title: "mine"}
+	if _, err := mine.SaveFrom(ctx, 0); err != nil {
+		t.Fatalf("Unable to save from version 0: %v", err)
+	}
+	theirs := &BlogPostVersion{PostID: "first", Slug: "first", Title: "theirs"}
+	if _, err := theirs.SaveFrom(ctx, 0); err != ErrorVersionConflict {
+		t.Errorf("Have %v saving from a superseded version 0, need ErrorVersionConflict", err)
+	}
+}
+
+func TestSaveUnpublishedFrom(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	savePostVersions(t, ctx, "unpub", 2)
+	if err := PublishBlogPostVersion(ctx, "unpub", 2); err != nil {
+		t.Fatalf("Unable to publish: %v", err)
+	}
+
+	ver := &BlogPostVersion{PostID: "unpub", Slug: "unpub", Title: "draft", Published: true}
+	if _, err := ver.SaveUnpublishedFrom(ctx, 1); err != ErrorVersionConflict {
+		t.Errorf("Have %v saving from a superseded version, need ErrorVersionConflict", err)
+	}
+	if published := publishedVersions(t, ctx, "unpub"); !reflect.DeepEqual(published, []int{2}) {
+		t.Errorf("Have versions %v published after a conflict, need [2]", published)
+	}
+
+	if _, err := ver.SaveUnpublishedFrom(ctx, 2); err != nil {
+		t.Fatalf("Unable to save: %v", err)
+	}
+	if published := publishedVersions(t, ctx, "unpub"); len(published) != 0 {
+		t.Errorf("Have versions %v published, need none", published)
+	}
+}
+
+func TestCanReview(t *testing.T) {
+	writer := &Author{GoogleAccountID: "1", Email: "writer@example.com"}
+	reviewer := &Author{GoogleAccountID: "2", Email: "reviewer@example.com"}
+	other := &Author{GoogleAccountID: "3", Email: "other@example.com"}
+
+	tests := []struct {
+		reviewerEmail string
+		actor         *Author
+		want          bool
+	}{
+		{"", nil, false},
+		{"", writer, false},
+		{"", other, true},
+		{"Reviewer@example.com", reviewer, true},
+		{"reviewer@example.com", other, false},
+		{"reviewer@example.com", writer, false},
+	}
+	for _, tt := range tests {
+		ver := &BlogPostVersion{Author: *writer, ReviewerEmail: tt.reviewerEmail}
+		if got := ver.CanReview(tt.actor); got != tt.want {
+			t.Errorf("CanReview(%v) with reviewer %q = %v, want %v", tt.actor, tt.reviewerEmail, got, tt.want)
+		}
+	}
+}
+
+func TestTransitionChecksReviewer(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	savePostVersions(t, ctx, "review", 1)
+	if _, _, err := SubmitBlogPostVersion(ctx, "review", 1, "reviewer@example.com"); err != nil {
+		t.Fatalf("Unable to submit: %v", err)
+	}
+
+	other := &Author{GoogleAccountID: "3", Email: "other@example.com"}
+	if _, _, err := TransitionBlogPostVersion(ctx, "review", 1, workflow.Approved, other); err != ErrorNotReviewer {
+		t.Errorf("Have %v approving as another editor, need ErrorNotReviewer", err)
+	}
+	if _, _, err := TransitionBlogPostVersion(ctx, "review", 1, workflow.Approved, nil); err != ErrorNotReviewer {
+		t.Errorf("Have %v approving with no author, need ErrorNotReviewer", err)
+	}
+
+	reviewer := &Author{GoogleAccountID: "2", Email: "reviewer@example.com"}
+	ver, _, err := TransitionBlogPostVersion(ctx, "review", 1, workflow.Approved, reviewer)
+	if err != nil {
+		t.Fatalf("Unable to approve as the reviewer: %v", err)
+	}
+	if ver.State != workflow.Approved {
+		t.Errorf("Have state %q, need %q", ver.State, workflow.Approved)
+	}
+}
+
+func TestSaveWithoutCategories(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	ver := &BlogPostVersion{PostID: "nocats", Slug: "nocats", Title: "nocats", DateCreated: time.Now()}
+	if _, err := ver.Save(ctx, true); err != nil {
+		t.Fatalf("Unable to save a version with no categories: %v", err)
+	}
+
+	ver = &BlogPostVersion{PostID: "nocats", Slug: "nocats", Title: "nocats", DateCreated: time.Now(),
+		Categories: []Category{{Title: " ", Slug: ""}}}
+	if _, err := ver.Save(ctx, false); err != nil {
+		t.Fatalf("Unable to save a version with an empty category: %v", err)
+	}
+	if len(ver.Categories) != 0 {
+		t.Errorf("Have categories %v, need none", ver.Categories)
+	}
+}
+
+func TestSaveFirstAuthor(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	first := &Author{GoogleAccountID: "1", Email: "first@example.com"}
+	if _, err := first.SaveFirst(ctx); err != nil {
+		t.Fatalf("Unable to save the first author: %v", err)
+	}
+	second := &Author{GoogleAccountID: "2", Email: "second@example.com"}
+	if _, err := second.SaveFirst(ctx); err != ErrorBlogHasAuthors {
+		t.Errorf("Have %v saving a second first author, need ErrorBlogHasAuthors", err)
+	}
+}
+
+func TestAuthorEmailCase(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	a := &Author{GoogleAccountID: "1", Email: " Mixed@Example.com"}
+	if _, err := a.Save(ctx); err != nil {
+		t.Fatalf("Unable to save author: %v", err)
+	}
+	if a.Email != "mixed@example.com" {
+		t.Errorf("Have email %q saved, need mixed@example.com", a.Email)
+	}
+	dup := &Author{GoogleAccountID: "2", Email: "MIXED@example.com"}
+	if _, err := dup.Save(ctx); err != ErrorAuthorAlreadyExists {
+		t.Errorf("Have %v saving the same email in another case, need ErrorAuthorAlreadyExists", err)
+	}
+
+	// Authors saved before emails were normalised keep their case.
+	legacy := &Author{GoogleAccountID: "3", Email: "Legacy@Example.com"}
+	k := datastore.NewIncompleteKey(ctx, authorKind, blogRootKey(ctx))
+	if _, err := datastore.Put(ctx, k, legacy); err != nil {
+		t.Fatalf("Unable to save legacy author: %v", err)
+	}
+	if err := SetAuthorRole(ctx, "legacy@example.com", RoleEditor); err != nil {
+		t.Errorf("Unable to set the role of a legacy author: %v", err)
+	}
+	if got, err := GetAuthorByEmail(ctx, "LEGACY@example.com"); err != nil || got.Role != RoleEditor {
+		t.Errorf("Have %+v, %v finding the legacy author, need an editor", got, err)
+	}
+	if err := DeleteAuthor(ctx, "legacy@example.com"); err != nil {
+		t.Errorf("Unable to delete a legacy author: %v", err)
+	}
+}
+
+func TestLastUnpublishedVersion(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	defer done()
+	if err != nil {
+		t.Fatalf("Unable to get AppEngine context for testing. Error: %s", err)
+	}
+
+	savePostVersions(t, ctx, "undelete", 3)
+	if _, err := GetLastUnpublishedBlogPostVersion(ctx, "undelete"); err != ErrorNoMatchingPost {
+		t.Errorf("Have %v before unpublishing, need ErrorNoMatchingPost", err)
+	}
+	if err := PublishBlogPostVersion(ctx, "undelete", 2); err != nil {
+		t.Fatalf("Unable to publish: %v", err)
+	}
+	if err := UnpublishBlogPost(ctx, "undelete"); err != nil {
+		t.Fatalf("Unable to unpublish: %v", err)
+	}
+
+	ver, err := GetLastUnpublishedBlogPostVersion(ctx, "undelete")
+	if err != nil {
+		t.Fatalf("Unable to find the unpublished version: %v", err)
+	}
+	if ver.Version != 2 {
+		t.Errorf("Have version %d unpublished, need 2", ver.Version)
+	}
+}
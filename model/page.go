@@ -0,0 +1,237 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const pageKind = "Page"
+
+// ErrorPageSlugAlreadyExists is returned when the chosen URL slug is already
+// associated with another page in the datastore.
+var ErrorPageSlugAlreadyExists = errors.New("model: page url slug already in use")
+
+// ErrorNoMatchingPage is returned when no Page matching the supplied URL slug
+// or page ID can be found in the datastore.
+var ErrorNoMatchingPage = errors.New("model: no page matching supplied slug")
+
+// Page represents a version of a static page, such as "About", which is not
+// part of the blog's stream of posts. Like posts, a page can have many
+// versions, but only one version can be published at a point in time.
+type Page struct {
+	PageID       string
+	Slug         string
+	Title        string
+	BodyMarkdown string `datastore:",noindex"`
+	DateCreated  time.Time
+	Published    bool
+	Author       Author
+	Version      int
+}
+
+// NewPageID returns a random ID for a new page.
+func NewPageID() (string, error) {
+	return newRandomID()
+}
+
+// GetPageBySlug returns the published Page matching the supplied URL slug.
+// Returns ErrorNoMatchingPage if there is no such page.
+func GetPageBySlug(ctx context.Context, slug string) (*Page, error) {
+	return getOnePage(ctx, datastore.NewQuery(pageKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Slug=", slug).
+		Filter("Published=", true))
+}
+
+// GetPublishedPageByID returns the published version of the page with the
+// supplied ID. Returns ErrorNoMatchingPage if no version is published.
+func GetPublishedPageByID(ctx context.Context, id string) (*Page, error) {
+	return getOnePage(ctx, datastore.NewQuery(pageKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PageID=", id).
+		Filter("Published=", true))
+}
+
+// GetPageVersion returns the Page matching the supplied page ID and version
+// number.
+func GetPageVersion(ctx context.Context, id string, version int) (*Page, error) {
+	return getOnePage(ctx, datastore.NewQuery(pageKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PageID=", id).
+		Filter("Version=", version))
+}
+
+// GetLatestPageVersionByID returns the most recent version of the page with
+// the supplied ID, whether or not it is published.
+func GetLatestPageVersionByID(ctx context.Context, id string) (*Page, error) {
+	return getOnePage(ctx, datastore.NewQuery(pageKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PageID=", id).
+		Order("-Version"))
+}
+
+// GetPageIDBySlug returns the ID of the page any version of which uses the
+// supplied URL slug. Returns ErrorNoMatchingPage if no page has used it.
+func GetPageIDBySlug(ctx context.Context, slug string) (string, error) {
+	p, err := getOnePage(ctx, datastore.NewQuery(pageKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Slug=", slug))
+	if err != nil {
+		return "", err
+	}
+	return p.PageID, nil
+}
+
+func getOnePage(ctx context.Context, q *datastore.Query) (*Page, error) {
+	var pages []Page
+	_, err := q.Limit(1).GetAll(ctx, &pages)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, ErrorNoMatchingPage
+	}
+	return &pages[0], nil
+}
+
+// GetPageVersionByID returns all versions of the page with the supplied ID.
+func GetPageVersionByID(ctx context.Context, id string) ([]Page, error) {
+	q := datastore.NewQuery(pageKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PageID=", id)
+	var pages []Page
+	_, err := q.GetAll(ctx, &pages)
+	return pages, err
+}
+
+// GetAllPage returns one Page for each distinct page in the datastore. If a
+// version of a page has been published, that version is returned, otherwise
+// the most recent draft is returned.
+func GetAllPage(ctx context.Context) ([]Page, error) {
+	q := datastore.NewQuery(pageKind).Ancestor(blogRootKey(ctx))
+	var versions []Page
+	if _, err := q.GetAll(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	var pages []Page
+	for _, v := range versions {
+		i, seen := index[v.PageID]
+		if !seen {
+			index[v.PageID] = len(pages)
+			pages = append(pages, v)
+			continue
+		}
+		current := pages[i]
+		if v.Published || (!current.Published && v.Version > current.Version) {
+			pages[i] = v
+		}
+	}
+	return pages, nil
+}
+
+// Save adds the Page to the datastore.
+//
+// If new is true, an error is returned if any page uses the same slug,
+// otherwise a new version is added and the version number is incremented. An
+// error is returned if the slug is used by a different page.
+//
+// If p.Published is true, the inserted version is published and all other
+// versions of the page are un-published.
+func (p *Page) Save(ctx context.Context, new bool) (*datastore.Key, error) {
+	var key *datastore.Key
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		owner, err := GetPageIDBySlug(ctx, p.Slug)
+		if err == nil && (new || owner != p.PageID) {
+			return ErrorPageSlugAlreadyExists
+		}
+		if err != nil && err != ErrorNoMatchingPage {
+			return err
+		}
+
+		if !new {
+			versions, err := GetPageVersionByID(ctx, p.PageID)
+			if err != nil {
+				return err
+			}
+			for i := range versions {
+				if versions[i].Version >= p.Version {
+					p.Version = versions[i].Version + 1
+				}
+			}
+			if p.Published {
+				if err := setPagePublished(ctx, p.PageID, -1); err != nil {
+					return err
+				}
+			}
+		}
+
+		key = datastore.NewIncompleteKey(ctx, pageKind, blogRootKey(ctx))
+		_, err = datastore.Put(ctx, key, p)
+		return err
+	}, nil)
+
+	return key, err
+}
+
+// setPagePublished publishes the given version of a page and unpublishes all
+// others. A version of -1 unpublishes every version. It must be called in a
+// transaction so that no other change to the page interleaves.
+func setPagePublished(ctx context.Context, id string, version int) error {
+	q := datastore.NewQuery(pageKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PageID=", id)
+
+	var pages []Page
+	k, err := q.GetAll(ctx, &pages)
+	if err != nil {
+		return err
+	}
+	for i := range pages {
+		pages[i].Published = pages[i].Version == version
+	}
+
+	_, err = datastore.PutMulti(ctx, k, pages)
+	return err
+}
+
+// PublishPageVersion publishes a given version of a page.
+func PublishPageVersion(ctx context.Context, id string, version int) error {
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		return setPagePublished(ctx, id, version)
+	}, nil)
+}
+
+// UnpublishPage unpublishes the currently published version of a page.
+func UnpublishPage(ctx context.Context, id string) error {
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		return setPagePublished(ctx, id, -1)
+	}, nil)
+}
+
+// DeletePage deletes all versions of a page.
+func DeletePage(ctx context.Context, id string) error {
+	q := datastore.NewQuery(pageKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PageID=", id).
+		KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllPage deletes all Page data.
+func DeleteAllPage(ctx context.Context) error {
+	q := datastore.NewQuery(pageKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const postSlugKind = "PostSlug"
+
+// PostSlug records that a URL slug has been used by a post. Every slug a post
+// has been saved with is kept, so that old links can be redirected to the
+// post's current slug, and no slug is ever reused by a different post.
+type PostSlug struct {
+	Slug    string
+	PostID  string
+	Created time.Time
+}
+
+func postSlugKey(ctx context.Context, slug string) *datastore.Key {
+	return datastore.NewKey(ctx, postSlugKind, slug, 0, blogRootKey(ctx))
+}
+
+// recordPostSlug claims a slug for a post. It returns
+// ErrorPostSlugAlreadyExists if the slug has been used by a different post.
+func recordPostSlug(ctx context.Context, slug string, postID string) error {
+	ps := new(PostSlug)
+	err := datastore.Get(ctx, postSlugKey(ctx, slug), ps)
+	if err == nil {
+		if ps.PostID != postID {
+			return ErrorPostSlugAlreadyExists
+		}
+		return nil
+	}
+	if err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	owner, err := getPostIDFromVersions(ctx, slug)
+	if err == nil && owner != postID {
+		return ErrorPostSlugAlreadyExists
+	}
+	if err != nil && err != ErrorNoMatchingPost {
+		return err
+	}
+
+	ps = &PostSlug{Slug: slug, PostID: postID, Created: time.Now()}
+	_, err = datastore.Put(ctx, postSlugKey(ctx, slug), ps)
+	return err
+}
+
+// GetPostIDBySlug returns the ID of the post which uses, or has used, the
+// supplied URL slug. Returns ErrorNoMatchingPost if no post has used it.
+func GetPostIDBySlug(ctx context.Context, slug string) (string, error) {
+	ps := new(PostSlug)
+	err := datastore.Get(ctx, postSlugKey(ctx, slug), ps)
+	if err == datastore.ErrNoSuchEntity {
+		return getPostIDFromVersions(ctx, slug)
+	}
+	if err != nil {
+		return "", err
+	}
+	return ps.PostID, nil
+}
+
+// getPostIDFromVersions finds the post with a version using the supplied
+// slug. Posts saved before slugs were recorded only have their slugs on their
+// versions.
+func getPostIDFromVersions(ctx context.Context, slug string) (string, error) {
+	q := datastore.NewQuery(blogPostVersionKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Slug=", slug).
+		Limit(1)
+	var posts []BlogPostVersion
+	if _, err := q.GetAll(ctx, &posts); err != nil {
+		return "", err
+	}
+	if len(posts) == 0 {
+		return "", ErrorNoMatchingPost
+	}
+	return posts[0].PostID, nil
+}
+
+// GetPostSlugByPostID returns every slug recorded for a post, oldest first.
+func GetPostSlugByPostID(ctx context.Context, postID string) ([]PostSlug, error) {
+	q := datastore.NewQuery(postSlugKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		Order("Created")
+	var slugs []PostSlug
+	_, err := q.GetAll(ctx, &slugs)
+	return slugs, err
+}
+
+// DeletePostSlugByPostID deletes the slug history of a post, releasing its
+// slugs for use by other posts.
+func DeletePostSlugByPostID(ctx context.Context, postID string) error {
+	q := datastore.NewQuery(postSlugKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllPostSlug deletes all PostSlug data.
+func DeleteAllPostSlug(ctx context.Context) error {
+	q := datastore.NewQuery(postSlugKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const previewLinkKind = "PreviewLink"
+
+// ErrorNoMatchingPreviewLink is returned when a preview link has been revoked
+// or never existed.
+var ErrorNoMatchingPreviewLink = errors.New("model: no preview link matching supplied ID")
+
+// PreviewLink allows anyone holding a signed link to preview a version of a
+// post without logging in, until it expires or is revoked. Revoking a link
+// deletes it; the signature of the link is checked by the blog package.
+type PreviewLink struct {
+	ID      string
+	PostID  string
+	Version int    `datastore:",noindex"`
+	Author  Author `datastore:",noindex"`
+	Created time.Time
+	Expires time.Time
+}
+
+// NewPreviewLink returns a new PreviewLink with a random ID for a version of a
+// post, valid for the supplied duration.
+func NewPreviewLink(postID string, version int, valid time.Duration, author Author) (*PreviewLink, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &PreviewLink{
+		ID:      id,
+		PostID:  postID,
+		Version: version,
+		Author:  author,
+		Created: now,
+		Expires: now.Add(valid),
+	}, nil
+}
+
+func previewLinkKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, previewLinkKind, id, 0, blogRootKey(ctx))
+}
+
+// Expired returns true if the link is no longer valid at now.
+func (l *PreviewLink) Expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// Save adds the PreviewLink to the datastore.
+func (l *PreviewLink) Save(ctx context.Context) (*datastore.Key, error) {
+	if l.ID == "" {
+		return nil, errors.New("model: preview link ID cannot be empty")
+	}
+	return datastore.Put(ctx, previewLinkKey(ctx, l.ID), l)
+}
+
+// GetPreviewLink returns the PreviewLink with the supplied ID, whether or not
+// it has expired. Returns ErrorNoMatchingPreviewLink if it has been revoked.
+func GetPreviewLink(ctx context.Context, id string) (*PreviewLink, error) {
+	if id == "" {
+		return nil, ErrorNoMatchingPreviewLink
+	}
+	l := new(PreviewLink)
+	err := datastore.Get(ctx, previewLinkKey(ctx, id), l)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingPreviewLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// GetPreviewLinkByPostID returns the links to preview versions of a post,
+// including expired ones, newest first.
+func GetPreviewLinkByPostID(ctx context.Context, postID string) ([]PreviewLink, error) {
+	q := datastore.NewQuery(previewLinkKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID)
+
+	var links []PreviewLink
+	if _, err := q.GetAll(ctx, &links); err != nil {
+		return nil, err
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Created.After(links[j].Created)
+	})
+	return links, nil
+}
+
+// DeletePreviewLink revokes the PreviewLink with the supplied ID.
+func DeletePreviewLink(ctx context.Context, id string) error {
+	return datastore.Delete(ctx, previewLinkKey(ctx, id))
+}
+
+// DeletePreviewLinkByPostID revokes every link to preview a post.
+func DeletePreviewLinkByPostID(ctx context.Context, postID string) error {
+	q := datastore.NewQuery(previewLinkKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllPreviewLink deletes all PreviewLink data.
+func DeleteAllPreviewLink(ctx context.Context) error {
+	q := datastore.NewQuery(previewLinkKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
@@ -0,0 +1,181 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const redirectKind = "Redirect"
+const redirectHitKind = "RedirectHit"
+
+// redirectHitShards is the number of RedirectHit counters over which the
+// hits of each Redirect are spread.
+const redirectHitShards = 10
+
+// ErrorNoMatchingRedirect is returned when no Redirect matching a supplied ID
+// can be found in the datastore.
+var ErrorNoMatchingRedirect = errors.New("model: no redirect matching supplied ID")
+
+// Redirect is a rule sending requests for paths which match no page to
+// another URL, or responding that they are gone.
+type Redirect struct {
+	ID      string
+	From    string `datastore:",noindex"`
+	To      string `datastore:",noindex"`
+	Status  int    `datastore:",noindex"`
+	Pattern bool   `datastore:",noindex"`
+	Created time.Time
+	Author  Author `datastore:",noindex"`
+}
+
+// RedirectHit counts the requests a Redirect has answered. A hit is
+// recorded on every such request, so the count is spread over several
+// shards, each the root of its own entity group rather than a child of the
+// blog, and counting contends neither with itself nor with transactions on
+// the blog's entities. Blog is the ID of the blog the Redirect belongs to.
+type RedirectHit struct {
+	Blog       string
+	RedirectID string
+	Hits       int       `datastore:",noindex"`
+	LastHit    time.Time `datastore:",noindex"`
+}
+
+// NewRedirect returns a new Redirect with a random ID.
+func NewRedirect(from string, to string, status int, pattern bool, author Author) (*Redirect, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	return &Redirect{
+		ID:      id,
+		From:    from,
+		To:      to,
+		Status:  status,
+		Pattern: pattern,
+		Created: time.Now(),
+		Author:  author,
+	}, nil
+}
+
+func redirectKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, redirectKind, id, 0, blogRootKey(ctx))
+}
+
+// Save adds the Redirect to the datastore.
+func (rd *Redirect) Save(ctx context.Context) (*datastore.Key, error) {
+	if rd.ID == "" {
+		return nil, errors.New("model: redirect ID cannot be empty")
+	}
+	return datastore.Put(ctx, redirectKey(ctx, rd.ID), rd)
+}
+
+// SaveRedirects adds several redirects to the datastore at once.
+func SaveRedirects(ctx context.Context, rds []Redirect) error {
+	keys := make([]*datastore.Key, len(rds))
+	for i := range rds {
+		keys[i] = redirectKey(ctx, rds[i].ID)
+	}
+	_, err := datastore.PutMulti(ctx, keys, rds)
+	return err
+}
+
+// GetRedirect returns the Redirect with the supplied ID.
+func GetRedirect(ctx context.Context, id string) (*Redirect, error) {
+	rd := new(Redirect)
+	err := datastore.Get(ctx, redirectKey(ctx, id), rd)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingRedirect
+	}
+	return rd, err
+}
+
+// GetAllRedirect returns every Redirect, oldest first.
+func GetAllRedirect(ctx context.Context) ([]Redirect, error) {
+	q := datastore.NewQuery(redirectKind).
+		Ancestor(blogRootKey(ctx)).
+		Order("Created")
+	var rds []Redirect
+	_, err := q.GetAll(ctx, &rds)
+	return rds, err
+}
+
+func redirectHitKey(ctx context.Context, id string, shard int) *datastore.Key {
+	name := fmt.Sprintf("%s %s %d", BlogID(ctx), id, shard)
+	return datastore.NewKey(ctx, redirectHitKind, name, 0, nil)
+}
+
+// RecordRedirectHit adds one to the hit count of a Redirect, in a shard
+// chosen at random, and records the time it was last used.
+func RecordRedirectHit(ctx context.Context, id string) error {
+	k := redirectHitKey(ctx, id, rand.Intn(redirectHitShards))
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		h := RedirectHit{Blog: BlogID(ctx), RedirectID: id}
+		if err := datastore.Get(ctx, k, &h); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		h.Hits++
+		h.LastHit = time.Now()
+		_, err := datastore.Put(ctx, k, &h)
+		return err
+	}, nil)
+}
+
+// GetAllRedirectHit returns the hit counts of the Redirects which have been
+// used, by Redirect ID, with their shards added together. Counts are
+// eventually consistent, so may not include the most recent hits.
+func GetAllRedirectHit(ctx context.Context) (map[string]RedirectHit, error) {
+	q := datastore.NewQuery(redirectHitKind).Filter("Blog=", BlogID(ctx))
+	var shards []RedirectHit
+	if _, err := q.GetAll(ctx, &shards); err != nil {
+		return nil, err
+	}
+
+	hits := make(map[string]RedirectHit)
+	for i := range shards {
+		h := hits[shards[i].RedirectID]
+		h.Blog = shards[i].Blog
+		h.RedirectID = shards[i].RedirectID
+		h.Hits += shards[i].Hits
+		if shards[i].LastHit.After(h.LastHit) {
+			h.LastHit = shards[i].LastHit
+		}
+		hits[h.RedirectID] = h
+	}
+	return hits, nil
+}
+
+// DeleteRedirect deletes the Redirect with the supplied ID and its hit
+// counts.
+func DeleteRedirect(ctx context.Context, id string) error {
+	keys := []*datastore.Key{redirectKey(ctx, id)}
+	for i := 0; i < redirectHitShards; i++ {
+		keys = append(keys, redirectHitKey(ctx, id, i))
+	}
+	return datastore.DeleteMulti(ctx, keys)
+}
+
+// DeleteAllRedirect deletes all Redirect data and hit counts.
+func DeleteAllRedirect(ctx context.Context) error {
+	q := datastore.NewQuery(redirectKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := datastore.DeleteMulti(ctx, k); err != nil {
+		return err
+	}
+
+	q = datastore.NewQuery(redirectHitKind).Filter("Blog=", BlogID(ctx)).KeysOnly()
+	k, err = q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return batches(len(k), func(i, j int) error {
+		return datastore.DeleteMulti(ctx, k[i:j])
+	})
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const reviewKind = "Review"
+
+// Review represents a comment made while reviewing a specific version of a
+// blog post. State is the workflow state the version was moved to along with
+// the comment, if any.
+type Review struct {
+	PostID       string
+	Version      int
+	Author       Author `datastore:",noindex"`
+	BodyMarkdown string `datastore:",noindex"`
+	State        string `datastore:",noindex"`
+	Created      time.Time
+}
+
+// NewReview returns a new Review of a post version by the Author.
+func NewReview(post *BlogPostVersion, body string, state string, author Author) *Review {
+	return &Review{
+		PostID:       post.PostID,
+		Version:      post.Version,
+		Author:       author,
+		BodyMarkdown: body,
+		State:        state,
+		Created:      time.Now(),
+	}
+}
+
+// Save adds the Review to the datastore.
+func (rv *Review) Save(ctx context.Context) (*datastore.Key, error) {
+	k := datastore.NewIncompleteKey(ctx, reviewKind, blogRootKey(ctx))
+	k, err := datastore.Put(ctx, k, rv)
+	return k, err
+}
+
+// GetReviewByPostID returns the Reviews of every version of a post, oldest
+// first.
+func GetReviewByPostID(ctx context.Context, postID string) ([]Review, error) {
+	q := datastore.NewQuery(reviewKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID)
+
+	var reviews []Review
+	_, err := q.GetAll(ctx, &reviews)
+	sort.Slice(reviews, func(i, j int) bool {
+		return reviews[i].Created.Before(reviews[j].Created)
+	})
+	return reviews, err
+}
+
+// DeleteReviewByPostID deletes the Reviews of every version of a post.
+func DeleteReviewByPostID(ctx context.Context, postID string) error {
+	q := datastore.NewQuery(reviewKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllReview deletes all Review data.
+func DeleteAllReview(ctx context.Context) error {
+	q := datastore.NewQuery(reviewKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
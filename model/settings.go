@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const settingsKind = "Settings"
+
+// Settings holds the site settings changed from the admin, which override
+// the values in app.yaml. Zero values are not overrides. Each blog has one
+// Settings entity.
+type Settings struct {
+	BlogName               string `datastore:",noindex"`
+	PostsPerPage           int    `datastore:",noindex"`
+	FeedSize               int    `datastore:",noindex"`
+	ExcerptCharLength      int    `datastore:",noindex"`
+	VersionsKeepLast       int    `datastore:",noindex"`
+	VersionsDailyAfterDays int    `datastore:",noindex"`
+	TrashDays              int    `datastore:",noindex"`
+	DateFormatForEditing   string `datastore:",noindex"`
+	DateFormatShort        string `datastore:",noindex"`
+	DateFormatFull         string `datastore:",noindex"`
+	Updated                time.Time
+	Author                 Author `datastore:",noindex"`
+}
+
+func settingsKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, settingsKind, "site", 0, blogRootKey(ctx))
+}
+
+// GetSettings returns the site settings. If none have been saved, the
+// returned Settings overrides nothing.
+func GetSettings(ctx context.Context) (*Settings, error) {
+	s := new(Settings)
+	err := datastore.Get(ctx, settingsKey(ctx), s)
+	if err == datastore.ErrNoSuchEntity {
+		return s, nil
+	}
+	return s, err
+}
+
+// Save replaces the site settings in the datastore.
+func (s *Settings) Save(ctx context.Context) (*datastore.Key, error) {
+	return datastore.Put(ctx, settingsKey(ctx), s)
+}
+
+// DeleteAllSettings deletes all Settings data.
+func DeleteAllSettings(ctx context.Context) error {
+	q := datastore.NewQuery(settingsKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const spamDomainKind = "SpamDomain"
+
+// SpamDomain counts how often a domain has been linked to by content which
+// a moderator confirmed as spam or ham.
+type SpamDomain struct {
+	Domain string
+	Spam   int `datastore:",noindex"`
+	Ham    int `datastore:",noindex"`
+}
+
+// GetSpamDomain returns the counts for a domain. A domain which has never
+// been recorded has zero counts.
+func GetSpamDomain(ctx context.Context, domain string) (*SpamDomain, error) {
+	d := new(SpamDomain)
+	k := datastore.NewKey(ctx, spamDomainKind, domain, 0, blogRootKey(ctx))
+	err := datastore.Get(ctx, k, d)
+	if err == datastore.ErrNoSuchEntity {
+		return &SpamDomain{Domain: domain}, nil
+	}
+	return d, err
+}
+
+// RecordSpamDomain adds one to the spam or ham count of each domain.
+func RecordSpamDomain(ctx context.Context, domains []string, spam bool) error {
+	return datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		keys := make([]*datastore.Key, len(domains))
+		ds := make([]SpamDomain, len(domains))
+		for i := range domains {
+			keys[i] = datastore.NewKey(ctx, spamDomainKind, domains[i], 0, blogRootKey(ctx))
+			d, err := GetSpamDomain(ctx, domains[i])
+			if err != nil {
+				return err
+			}
+			if spam {
+				d.Spam++
+			} else {
+				d.Ham++
+			}
+			ds[i] = *d
+		}
+		_, err := datastore.PutMulti(ctx, keys, ds)
+		return err
+	}, nil)
+}
+
+// DeleteAllSpamDomain deletes all SpamDomain data.
+func DeleteAllSpamDomain(ctx context.Context) error {
+	q := datastore.NewQuery(spamDomainKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
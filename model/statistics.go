@@ -16,6 +16,8 @@ type Statistics struct {
 	CategoryCount int
 	ImageCount    int
 
+	PendingCommentCount int
+
 	Generated time.Time
 }
 
@@ -54,6 +56,11 @@ func generateStatistics(ctx context.Context) (*Statistics, error) {
 		return nil, err
 	}
 
+	pendingCommentCount, err := GetCommentCountByStatus(ctx, CommentPending)
+	if err != nil {
+		return nil, err
+	}
+
 	s := Statistics{
 		PostCount:     postCount,
 		DraftCount:    draftCount,
@@ -61,7 +68,10 @@ func generateStatistics(ctx context.Context) (*Statistics, error) {
 		AuthorCount:   authorCount,
 		CategoryCount: categoryCount,
 		ImageCount:    imageCount,
-		Generated:     time.Now(),
+
+		PendingCommentCount: pendingCommentCount,
+
+		Generated: time.Now(),
 	}
 
 	return &s, nil
@@ -70,6 +80,7 @@ func generateStatistics(ctx context.Context) (*Statistics, error) {
 // GetStatistics retrieves cached statistics and regenerates them if necessary.
 func GetStatistics(ctx context.Context) (*Statistics, error) {
 	q := datastore.NewQuery(statisticsKind).
+		Ancestor(blogRootKey(ctx)).
 		Order("-Generated").
 		Limit(1)
 
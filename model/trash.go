@@ -0,0 +1,377 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const trashItemKind = "TrashItem"
+
+// Types of TrashItem.
+const (
+	TrashTypePost     = "post"
+	TrashTypeCategory = "category"
+	TrashTypeImages   = "images"
+)
+
+// ErrorNoMatchingTrashItem is returned when an item is not in the trash,
+// because it has been restored or purged or never existed.
+var ErrorNoMatchingTrashItem = errors.New("model: no trash item matching supplied ID")
+
+// ErrorNoMatchingCategory is returned when no Category matches the supplied
+// slug.
+var ErrorNoMatchingCategory = errors.New("model: no category matching supplied slug")
+
+// ErrorTrashConflict is returned when an item cannot be restored because its
+// URL slug or title has since been taken.
+var ErrorTrashConflict = errors.New("model: a restored item's slug or title is now in use")
+
+// trashedKinds are the kinds which are moved to the trash, in the order they
+// are moved back.
+var trashedKinds = []string{
+	blogPostVersionKind,
+	postSlugKind,
+	commentKind,
+	reviewKind,
+	webmentionKind,
+	categoryKind,
+	imageKind,
+}
+
+// TrashItem is something deleted from the blog which can be restored until
+// it is purged. The deleted entities are kept as children of the TrashItem,
+// under kinds prefixed with "Trash" so that no query of the blog finds them,
+// and keep the names and IDs of their keys. Title describes the item, and
+// ItemID is the post ID or category slug.
+type TrashItem struct {
+	ID      string
+	Type    string
+	Title   string `datastore:",noindex"`
+	ItemID  string
+	Author  Author `datastore:",noindex"`
+	Deleted time.Time
+}
+
+func trashItemKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, trashItemKind, id, 0, blogRootKey(ctx))
+}
+
+// trashKind returns the kind under which entities of kind are kept in the
+// trash.
+func trashKind(kind string) string {
+	return "Trash" + kind
+}
+
+// moveEntities moves the entities found by q to toKind under parent, keeping
+// the names and IDs of their keys, and returns how many were moved. Callers
+// move everything in an item in a single transaction, so that an item is
+// never left partly moved.
+func moveEntities(ctx context.Context, q *datastore.Query, toKind string, parent *datastore.Key) (int, error) {
+	var entities []datastore.PropertyList
+	keys, err := q.GetAll(ctx, &entities)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	moved := make([]*datastore.Key, len(keys))
+	for i, k := range keys {
+		moved[i] = datastore.NewKey(ctx, toKind, k.StringID(), k.IntID(), parent)
+	}
+	err = batches(len(keys), func(i, j int) error {
+		if _, err := datastore.PutMulti(ctx, moved[i:j], entities[i:j]); err != nil {
+			return err
+		}
+		return datastore.DeleteMulti(ctx, keys[i:j])
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// newTrashItem saves a new TrashItem, as part of the transaction which moves
+// things to it.
+func newTrashItem(ctx context.Context, itemType, title, itemID string, author Author) (*TrashItem, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	t := &TrashItem{
+		ID:      id,
+		Type:    itemType,
+		Title:   title,
+		ItemID:  itemID,
+		Author:  author,
+		Deleted: time.Now(),
+	}
+	if _, err := datastore.Put(ctx, trashItemKey(ctx, id), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TrashBlogPost moves all versions of a blog post, its slug history, its
+// comments, its reviews and its webmentions to the trash in a single
+// transaction. Its autosaves, edit locks and preview links are then deleted.
+func TrashBlogPost(ctx context.Context, id string, author Author) (*TrashItem, error) {
+	var t *TrashItem
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		post, err := GetLatestBlogPostVersionByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		t, err = newTrashItem(ctx, TrashTypePost, post.Title, id, author)
+		if err != nil {
+			return err
+		}
+
+		parent := trashItemKey(ctx, t.ID)
+		for _, kind := range []string{blogPostVersionKind, postSlugKind, commentKind, reviewKind, webmentionKind} {
+			q := datastore.NewQuery(kind).
+				Ancestor(blogRootKey(ctx)).
+				Filter("PostID=", id)
+			if _, err := moveEntities(ctx, q, trashKind(kind), parent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := DeleteAutosaveByPostID(ctx, id); err != nil {
+		return nil, err
+	}
+	if err := DeleteEditLockByPostID(ctx, id); err != nil {
+		return nil, err
+	}
+	return t, DeletePreviewLinkByPostID(ctx, id)
+}
+
+// TrashCategory moves the category with the supplied slug to the trash.
+// Posts keep their copies of the category. Returns ErrorNoMatchingCategory if
+// there is no such category.
+func TrashCategory(ctx context.Context, slug string, author Author) (*TrashItem, error) {
+	var t *TrashItem
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		q := datastore.NewQuery(categoryKind).Ancestor(blogRootKey(ctx)).Filter("Slug=", slug)
+		var cats []Category
+		if _, err := q.GetAll(ctx, &cats); err != nil {
+			return err
+		}
+		if len(cats) == 0 {
+			return ErrorNoMatchingCategory
+		}
+
+		var err error
+		t, err = newTrashItem(ctx, TrashTypeCategory, cats[0].Title, slug, author)
+		if err != nil {
+			return err
+		}
+		_, err = moveEntities(ctx, q, trashKind(categoryKind), trashItemKey(ctx, t.ID))
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TrashImage moves an image's metadata to the trash. The image itself is
+// left in Cloud Storage until the trash is purged.
+func TrashImage(ctx context.Context, img *Image, author Author) (*TrashItem, error) {
+	title := img.Filename
+	if title == "" {
+		title = img.ID
+	}
+	var t *TrashItem
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		t, err = newTrashItem(ctx, TrashTypeImages, title, img.ID, author)
+		if err != nil {
+			return err
+		}
+		q := datastore.NewQuery(imageKind).Ancestor(blogRootKey(ctx)).Filter("ID=", img.ID)
+		_, err = moveEntities(ctx, q, trashKind(imageKind), trashItemKey(ctx, t.ID))
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TrashAllImage moves the metadata of every image to the trash as one item,
+// returning it and the number of images moved. Nothing is moved, and the item
+// is nil, if there are no images.
+func TrashAllImage(ctx context.Context, author Author) (*TrashItem, int, error) {
+	var t *TrashItem
+	var n int
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		q := datastore.NewQuery(imageKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+		keys, err := q.GetAll(ctx, nil)
+		if err != nil || len(keys) == 0 {
+			t, n = nil, 0
+			return err
+		}
+
+		t, err = newTrashItem(ctx, TrashTypeImages, fmt.Sprintf("All %d images", len(keys)), "", author)
+		if err != nil {
+			return err
+		}
+		n, err = moveEntities(ctx, datastore.NewQuery(imageKind).Ancestor(blogRootKey(ctx)),
+			trashKind(imageKind), trashItemKey(ctx, t.ID))
+		return err
+	}, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, n, nil
+}
+
+// GetTrashItem returns the TrashItem with the supplied ID. Returns
+// ErrorNoMatchingTrashItem if there is none.
+func GetTrashItem(ctx context.Context, id string) (*TrashItem, error) {
+	if id == "" {
+		return nil, ErrorNoMatchingTrashItem
+	}
+	t := new(TrashItem)
+	err := datastore.Get(ctx, trashItemKey(ctx, id), t)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingTrashItem
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetAllTrashItem returns everything in the trash, most recently deleted
+// first.
+func GetAllTrashItem(ctx context.Context) ([]TrashItem, error) {
+	q := datastore.NewQuery(trashItemKind).Ancestor(blogRootKey(ctx))
+	var items []TrashItem
+	if _, err := q.GetAll(ctx, &items); err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted.After(items[j].Deleted)
+	})
+	return items, nil
+}
+
+// GetTrashedImage returns the metadata of the images in a TrashItem, so that
+// they can be deleted from Cloud Storage before it is purged.
+func GetTrashedImage(ctx context.Context, id string) ([]Image, error) {
+	q := datastore.NewQuery(trashKind(imageKind)).Ancestor(trashItemKey(ctx, id))
+	var imgs []Image
+	_, err := q.GetAll(ctx, &imgs)
+	return imgs, err
+}
+
+// restorable returns ErrorTrashConflict if a post's slugs or a category's
+// slug or title have been taken since it was moved to the trash.
+func restorable(ctx context.Context, parent *datastore.Key) error {
+	var slugs []PostSlug
+	q := datastore.NewQuery(trashKind(postSlugKind)).Ancestor(parent)
+	if _, err := q.GetAll(ctx, &slugs); err != nil {
+		return err
+	}
+	for i := range slugs {
+		err := datastore.Get(ctx, postSlugKey(ctx, slugs[i].Slug), new(PostSlug))
+		if err == nil {
+			return ErrorTrashConflict
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+	}
+
+	var cats []Category
+	q = datastore.NewQuery(trashKind(categoryKind)).Ancestor(parent)
+	if _, err := q.GetAll(ctx, &cats); err != nil {
+		return err
+	}
+	for i := range cats {
+		for filter, value := range map[string]string{"Slug=": cats[i].Slug, "Title=": cats[i].Title} {
+			n, err := datastore.NewQuery(categoryKind).
+				Ancestor(blogRootKey(ctx)).
+				Filter(filter, value).
+				Count(ctx)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				return ErrorTrashConflict
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreTrashItem moves the entities in a TrashItem back into the blog and
+// removes it from the trash, in a single transaction. Returns
+// ErrorTrashConflict, restoring nothing, if a slug or title it used has since
+// been taken.
+func RestoreTrashItem(ctx context.Context, id string) (*TrashItem, error) {
+	var t *TrashItem
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		t, err = GetTrashItem(ctx, id)
+		if err != nil {
+			return err
+		}
+		parent := trashItemKey(ctx, id)
+		if err := restorable(ctx, parent); err != nil {
+			return err
+		}
+
+		for _, kind := range trashedKinds {
+			q := datastore.NewQuery(trashKind(kind)).Ancestor(parent)
+			if _, err := moveEntities(ctx, q, kind, blogRootKey(ctx)); err != nil {
+				return err
+			}
+		}
+		return datastore.Delete(ctx, parent)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// PurgeTrashItem permanently deletes a TrashItem and everything in it. The
+// images of an item must be deleted from Cloud Storage first.
+func PurgeTrashItem(ctx context.Context, id string) error {
+	q := datastore.NewQuery("").Ancestor(trashItemKey(ctx, id)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllTrash deletes all TrashItem data and everything in the trash.
+func DeleteAllTrash(ctx context.Context) error {
+	kinds := []string{trashItemKind}
+	for _, kind := range trashedKinds {
+		kinds = append(kinds, trashKind(kind))
+	}
+	for _, kind := range kinds {
+		q := datastore.NewQuery(kind).Ancestor(blogRootKey(ctx)).KeysOnly()
+		k, err := q.GetAll(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := datastore.DeleteMulti(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
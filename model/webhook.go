@@ -0,0 +1,200 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	webhookKind         = "Webhook"
+	webhookDeliveryKind = "WebhookDelivery"
+)
+
+// ErrorNoMatchingWebhook is returned when no Webhook or WebhookDelivery
+// matching a supplied ID can be found in the datastore.
+var ErrorNoMatchingWebhook = errors.New("model: no webhook matching supplied ID")
+
+// Webhook represents a URL which is notified when one of the selected
+// events occurs. Requests are signed with the Secret.
+type Webhook struct {
+	ID      string
+	URL     string
+	Secret  string `datastore:",noindex"`
+	Events  []string
+	Created time.Time
+	Author  Author
+}
+
+// WebhookDelivery records a notification sent to a Webhook and the result of
+// the most recent attempt to deliver it.
+type WebhookDelivery struct {
+	ID          string
+	HookID      string
+	URL         string
+	Event       string
+	Payload     string `datastore:",noindex"`
+	Created     time.Time
+	Attempts    int
+	LastAttempt time.Time
+	StatusCode  int
+	Error       string `datastore:",noindex"`
+	Delivered   bool
+}
+
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewWebhook returns a new Webhook for the URL with a random ID and secret.
+func NewWebhook(url string, events []string, author Author) (*Webhook, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	return &Webhook{
+		ID:      id,
+		URL:     url,
+		Secret:  secret,
+		Events:  events,
+		Created: time.Now(),
+		Author:  author,
+	}, nil
+}
+
+// Save adds the Webhook to the datastore.
+func (h *Webhook) Save(ctx context.Context) (*datastore.Key, error) {
+	if h.ID == "" {
+		return nil, errors.New("model: webhook ID cannot be empty")
+	}
+	k := datastore.NewKey(ctx, webhookKind, h.ID, 0, blogRootKey(ctx))
+	return datastore.Put(ctx, k, h)
+}
+
+// GetWebhook returns the Webhook with the supplied ID.
+func GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	h := new(Webhook)
+	k := datastore.NewKey(ctx, webhookKind, id, 0, blogRootKey(ctx))
+	err := datastore.Get(ctx, k, h)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingWebhook
+	}
+	return h, err
+}
+
+// GetAllWebhook returns all Webhooks.
+func GetAllWebhook(ctx context.Context) ([]Webhook, error) {
+	q := datastore.NewQuery(webhookKind).Ancestor(blogRootKey(ctx))
+	var hooks []Webhook
+	_, err := q.GetAll(ctx, &hooks)
+	return hooks, err
+}
+
+// GetWebhookByEvent returns the Webhooks subscribed to an event.
+func GetWebhookByEvent(ctx context.Context, event string) ([]Webhook, error) {
+	q := datastore.NewQuery(webhookKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Events=", event)
+	var hooks []Webhook
+	_, err := q.GetAll(ctx, &hooks)
+	return hooks, err
+}
+
+// DeleteWebhook deletes the Webhook with the supplied ID. Its delivery
+// history is kept.
+func DeleteWebhook(ctx context.Context, id string) error {
+	k := datastore.NewKey(ctx, webhookKind, id, 0, blogRootKey(ctx))
+	return datastore.Delete(ctx, k)
+}
+
+// DeleteAllWebhook deletes all Webhook data.
+func DeleteAllWebhook(ctx context.Context) error {
+	q := datastore.NewQuery(webhookKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// NewWebhookDelivery returns a new, unattempted delivery of a payload to a
+// Webhook.
+func NewWebhookDelivery(h *Webhook, event string, payload []byte) (*WebhookDelivery, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookDelivery{
+		ID:      id,
+		HookID:  h.ID,
+		URL:     h.URL,
+		Event:   event,
+		Payload: string(payload),
+		Created: time.Now(),
+	}, nil
+}
+
+// RecordAttempt updates the delivery with the result of an attempt.
+func (d *WebhookDelivery) RecordAttempt(statusCode int, err error) {
+	d.Attempts++
+	d.LastAttempt = time.Now()
+	d.StatusCode = statusCode
+	d.Delivered = err == nil
+	d.Error = ""
+	if err != nil {
+		d.Error = err.Error()
+	}
+}
+
+// Save adds the WebhookDelivery to the datastore.
+func (d *WebhookDelivery) Save(ctx context.Context) (*datastore.Key, error) {
+	if d.ID == "" {
+		return nil, errors.New("model: webhook delivery ID cannot be empty")
+	}
+	k := datastore.NewKey(ctx, webhookDeliveryKind, d.ID, 0, blogRootKey(ctx))
+	return datastore.Put(ctx, k, d)
+}
+
+// GetWebhookDelivery returns the WebhookDelivery with the supplied ID.
+func GetWebhookDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	d := new(WebhookDelivery)
+	k := datastore.NewKey(ctx, webhookDeliveryKind, id, 0, blogRootKey(ctx))
+	err := datastore.Get(ctx, k, d)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingWebhook
+	}
+	return d, err
+}
+
+// GetWebhookDeliveryTail returns the last 100 webhook deliveries.
+func GetWebhookDeliveryTail(ctx context.Context) ([]WebhookDelivery, error) {
+	q := datastore.NewQuery(webhookDeliveryKind).
+		Ancestor(blogRootKey(ctx)).
+		Order("-Created").
+		Limit(100)
+	var ds []WebhookDelivery
+	_, err := q.GetAll(ctx, &ds)
+	return ds, err
+}
+
+// DeleteAllWebhookDelivery deletes all WebhookDelivery data.
+func DeleteAllWebhookDelivery(ctx context.Context) error {
+	q := datastore.NewQuery(webhookDeliveryKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const webmentionKind = "Webmention"
+
+// Verification states of a Webmention. Only verified mentions are displayed.
+const (
+	WebmentionPending  = "pending"
+	WebmentionVerified = "verified"
+	WebmentionRejected = "rejected"
+	WebmentionSpam     = "spam"
+)
+
+// ErrorNoMatchingWebmention is returned when no Webmention matching a
+// supplied ID can be found in the datastore.
+var ErrorNoMatchingWebmention = errors.New("model: no webmention matching supplied ID")
+
+// Webmention represents a notification that another page links to a post.
+type Webmention struct {
+	ID        string
+	PostID    string
+	PostSlug  string
+	Source    string `datastore:",noindex"`
+	Target    string `datastore:",noindex"`
+	Title     string `datastore:",noindex"`
+	Status    string
+	Received  time.Time
+	Verified  time.Time
+	IPAddress string `datastore:",noindex"`
+}
+
+// NewWebmention returns a new pending Webmention from source to a post. The
+// ID is derived from the source and target so that repeated notifications
+// replace the earlier mention.
+func NewWebmention(post *BlogPostVersion, source string, target string) *Webmention {
+	return &Webmention{
+		ID:       webmentionID(source, target),
+		PostID:   post.PostID,
+		PostSlug: post.Slug,
+		Source:   source,
+		Target:   target,
+		Status:   WebmentionPending,
+		Received: time.Now(),
+	}
+}
+
+func webmentionID(source string, target string) string {
+	h := sha1.Sum([]byte(source + "\n" + target))
+	return hex.EncodeToString(h[:])
+}
+
+// Save adds the Webmention to the datastore, replacing any earlier mention
+// with the same source and target.
+func (m *Webmention) Save(ctx context.Context) (*datastore.Key, error) {
+	if m.ID == "" {
+		return nil, errors.New("model: webmention ID cannot be empty")
+	}
+	k := datastore.NewKey(ctx, webmentionKind, m.ID, 0, blogRootKey(ctx))
+	return datastore.Put(ctx, k, m)
+}
+
+// GetWebmention returns the Webmention with the supplied ID.
+func GetWebmention(ctx context.Context, id string) (*Webmention, error) {
+	m := new(Webmention)
+	k := datastore.NewKey(ctx, webmentionKind, id, 0, blogRootKey(ctx))
+	err := datastore.Get(ctx, k, m)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrorNoMatchingWebmention
+	}
+	return m, err
+}
+
+// GetWebmentionByPostID returns the verified mentions of a post, oldest
+// first.
+func GetWebmentionByPostID(ctx context.Context, postID string) ([]Webmention, error) {
+	q := datastore.NewQuery(webmentionKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		Filter("Status=", WebmentionVerified).
+		Order("Received")
+	var ms []Webmention
+	_, err := q.GetAll(ctx, &ms)
+	return ms, err
+}
+
+// GetWebmentionByStatus returns up to limit mentions in a verification
+// state, newest first.
+func GetWebmentionByStatus(ctx context.Context, status string, limit int) ([]Webmention, error) {
+	q := datastore.NewQuery(webmentionKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Status=", status).
+		Order("-Received").
+		Limit(limit)
+	var ms []Webmention
+	_, err := q.GetAll(ctx, &ms)
+	return ms, err
+}
+
+// GetWebmentionCountByStatus returns the number of mentions in a
+// verification state.
+func GetWebmentionCountByStatus(ctx context.Context, status string) (int, error) {
+	return datastore.NewQuery(webmentionKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("Status=", status).
+		Count(ctx)
+}
+
+// SetWebmentionStatus moves the mentions with the supplied IDs to a
+// verification state.
+func SetWebmentionStatus(ctx context.Context, ids []string, status string) error {
+	keys := make([]*datastore.Key, len(ids))
+	for i := range ids {
+		keys[i] = datastore.NewKey(ctx, webmentionKind, ids[i], 0, blogRootKey(ctx))
+	}
+
+	ms := make([]Webmention, len(keys))
+	if err := datastore.GetMulti(ctx, keys, ms); err != nil {
+		return err
+	}
+	for i := range ms {
+		ms[i].Status = status
+	}
+
+	_, err := datastore.PutMulti(ctx, keys, ms)
+	return err
+}
+
+// DeleteWebmention deletes the mentions with the supplied IDs.
+func DeleteWebmention(ctx context.Context, ids []string) error {
+	keys := make([]*datastore.Key, len(ids))
+	for i := range ids {
+		keys[i] = datastore.NewKey(ctx, webmentionKind, ids[i], 0, blogRootKey(ctx))
+	}
+	return datastore.DeleteMulti(ctx, keys)
+}
+
+// DeleteWebmentionByPostID deletes all mentions of a post.
+func DeleteWebmentionByPostID(ctx context.Context, postID string) error {
+	q := datastore.NewQuery(webmentionKind).
+		Ancestor(blogRootKey(ctx)).
+		Filter("PostID=", postID).
+		KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
+
+// DeleteAllWebmention deletes all Webmention data.
+func DeleteAllWebmention(ctx context.Context) error {
+	q := datastore.NewQuery(webmentionKind).Ancestor(blogRootKey(ctx)).KeysOnly()
+	k, err := q.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(ctx, k)
+}
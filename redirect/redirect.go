@@ -0,0 +1,175 @@
+// Package redirect matches request paths against redirect rules.
+//
+// An exact rule matches a single path. A pattern rule is a regular expression
+// matched against the whole path, and its target may refer to submatches as
+// $1, $2 or ${name}.
+package redirect
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rule describes a redirect from one or more paths.
+type Rule struct {
+	ID      string
+	From    string
+	To      string
+	Status  int
+	Pattern bool
+}
+
+// Validate returns an error describing the first problem with a rule.
+func (r *Rule) Validate() error {
+	switch r.Status {
+	case http.StatusMovedPermanently, http.StatusFound:
+		if r.To == "" {
+			return fmt.Errorf("redirect: %s needs a target", r.From)
+		}
+	case http.StatusGone:
+	default:
+		return fmt.Errorf("redirect: status %d is not 301, 302 or 410", r.Status)
+	}
+
+	if r.Pattern {
+		if _, err := regexp.Compile(anchor(r.From)); err != nil {
+			return fmt.Errorf("redirect: invalid pattern %s: %v", r.From, err)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(r.From, "/") {
+		return fmt.Errorf("redirect: %s must start with /", r.From)
+	}
+	return nil
+}
+
+// anchor makes a pattern match the whole of a path.
+func anchor(pattern string) string {
+	return "^(?:" + strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$") + ")$"
+}
+
+type compiled struct {
+	rule Rule
+	re   *regexp.Regexp
+}
+
+// Set is a compiled group of rules. Exact rules are checked before pattern
+// rules, which are checked in the order supplied.
+type Set struct {
+	exact    map[string]Rule
+	patterns []compiled
+}
+
+// Compile validates and compiles rules into a Set.
+func Compile(rules []Rule) (*Set, error) {
+	s := &Set{exact: make(map[string]Rule)}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		if !r.Pattern {
+			if _, dup := s.exact[r.From]; !dup {
+				s.exact[r.From] = r
+			}
+			continue
+		}
+		s.patterns = append(s.patterns, compiled{rule: r, re: regexp.MustCompile(anchor(r.From))})
+	}
+	return s, nil
+}
+
+// Match returns the rule matching path and the location to redirect to, with
+// any submatches of a pattern rule expanded.
+func (s *Set) Match(path string) (Rule, string, bool) {
+	if r, ok := s.exact[path]; ok {
+		return r, r.To, true
+	}
+	for _, c := range s.patterns {
+		m := c.re.FindStringSubmatchIndex(path)
+		if m == nil {
+			continue
+		}
+		to := string(c.re.ExpandString(nil, c.rule.To, path, m))
+		return c.rule, to, true
+	}
+	return Rule{}, "", false
+}
+
+// ErrorEmptyCSV is returned by ParseCSV when there are no rules to import.
+var ErrorEmptyCSV = errors.New("redirect: no rules found")
+
+// ParseCSV reads rules from CSV with the columns from, to, status and type.
+// Status defaults to 301 and type, "exact" or "pattern", to exact. A first
+// line starting with "from" is treated as a header and skipped.
+func ParseCSV(in io.Reader) ([]Rule, error) {
+	cr := csv.NewReader(in)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	var rules []Rule
+	for first := true; ; first = false {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(strings.TrimSpace(rec[0]), "from") {
+			continue
+		}
+		if len(rec) == 1 && strings.TrimSpace(rec[0]) == "" {
+			continue
+		}
+
+		r, err := parseRecord(rec)
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rules = append(rules, r)
+	}
+
+	if len(rules) == 0 {
+		return nil, ErrorEmptyCSV
+	}
+	return rules, nil
+}
+
+func parseRecord(rec []string) (Rule, error) {
+	for len(rec) < 4 {
+		rec = append(rec, "")
+	}
+	if len(rec) > 4 {
+		return Rule{}, fmt.Errorf("redirect: %d columns, need at most 4", len(rec))
+	}
+
+	r := Rule{
+		From:   strings.TrimSpace(rec[0]),
+		To:     strings.TrimSpace(rec[1]),
+		Status: http.StatusMovedPermanently,
+	}
+	if s := strings.TrimSpace(rec[2]); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return Rule{}, fmt.Errorf("redirect: invalid status %q", s)
+		}
+		r.Status = n
+	}
+	switch t := strings.ToLower(strings.TrimSpace(rec[3])); t {
+	case "", "exact":
+	case "pattern":
+		r.Pattern = true
+	default:
+		return Rule{}, fmt.Errorf("redirect: invalid type %q", t)
+	}
+
+	return r, r.Validate()
+}
@@ -0,0 +1,104 @@
+package redirect_test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"goblogengine/redirect"
+)
+
+func TestMatch(t *testing.T) {
+	s, err := redirect.Compile([]redirect.Rule{
+		{ID: "1", From: "/2014/05/some-title.html", To: "/post/renamed", Status: 301},
+		{ID: "2", From: `/(\d{4})/\d\d/(.*)\.html`, To: "/post/$2", Status: 301, Pattern: true},
+		{ID: "3", From: `^/tag/(?P<tag>[a-z]+)$`, To: "/category/${tag}", Status: 302, Pattern: true},
+		{ID: "4", From: "/guestbook.html", Status: 410},
+	})
+	if err != nil {
+		t.Fatalf("Compile failed: %s", err)
+	}
+
+	tests := []struct {
+		path string
+		id   string
+		to   string
+	}{
+		{"/2014/05/some-title.html", "1", "/post/renamed"},
+		{"/2015/11/another-one.html", "2", "/post/another-one"},
+		{"/tag/golang", "3", "/category/golang"},
+		{"/guestbook.html", "4", ""},
+		{"/x/2015/11/another-one.html", "", ""},
+		{"/tag/golang/feed", "", ""},
+		{"/post/renamed", "", ""},
+	}
+
+	for _, tt := range tests {
+		r, to, ok := s.Match(tt.path)
+		if ok != (tt.id != "") || r.ID != tt.id || to != tt.to {
+			t.Errorf("Match(%s): have %s %q %v, need %s %q", tt.path, r.ID, to, ok, tt.id, tt.to)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		rule  redirect.Rule
+		valid bool
+	}{
+		{redirect.Rule{From: "/a", To: "/b", Status: 301}, true},
+		{redirect.Rule{From: "/a", Status: 410}, true},
+		{redirect.Rule{From: "/a", Status: 301}, false},
+		{redirect.Rule{From: "/a", To: "/b", Status: 307}, false},
+		{redirect.Rule{From: "a", To: "/b", Status: 301}, false},
+		{redirect.Rule{From: "/(a", To: "/b", Status: 301, Pattern: true}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v): have error %v, need valid %v", tt.rule, err, tt.valid)
+		}
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	f, err := os.Open("testdata/legacy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	have, err := redirect.ParseCSV(f)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %s", err)
+	}
+	need := []redirect.Rule{
+		{From: "/2014/05/some-title.html", To: "/post/some-title", Status: 301},
+		{From: "/about.html", To: "/page/about", Status: 302},
+		{From: `/2014/(?P<month>\d\d)/(.*)\.html`, To: "/post/$2", Status: 301, Pattern: true},
+		{From: "/guestbook.html", Status: 410},
+	}
+	if !reflect.DeepEqual(have, need) {
+		t.Errorf("Have rules %+v, need %+v", have, need)
+	}
+}
+
+func TestParseCSVErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		need string
+	}{
+		{"", "no rules"},
+		{"/a,/b\n/c,/d,308\n", "line 2"},
+		{"/a,/b,301,regex\n", "invalid type"},
+		{"/a,/b,301,exact,extra\n", "columns"},
+	}
+
+	for _, tt := range tests {
+		_, err := redirect.ParseCSV(strings.NewReader(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.need) {
+			t.Errorf("ParseCSV(%q): have error %v, need %q", tt.in, err, tt.need)
+		}
+	}
+}
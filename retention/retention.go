@@ -0,0 +1,62 @@
+// Package retention decides which saved versions of a post are pruned.
+//
+// Every version saved in the last DailyAfter is kept, and of older versions
+// only the last saved each day. The KeepLast most recent versions are kept
+// whatever their age, as are versions marked Keep, such as those which are
+// published or labelled.
+package retention
+
+import (
+	"sort"
+	"time"
+)
+
+// Policy is a version retention policy.
+type Policy struct {
+	// KeepLast is the number of most recent versions always kept.
+	KeepLast int
+	// DailyAfter is the age after which versions are thinned to one a day.
+	DailyAfter time.Duration
+}
+
+// Version describes a saved version of a post.
+type Version struct {
+	Number  int
+	Created time.Time
+	Keep    bool
+}
+
+// Days returns a policy which thins versions to one a day after the supplied
+// number of days.
+func Days(keepLast int, days int) Policy {
+	return Policy{KeepLast: keepLast, DailyAfter: time.Duration(days) * 24 * time.Hour}
+}
+
+// Prune returns the numbers of the versions of a single post which the policy
+// does not keep at the supplied time, in ascending order. Days are counted in
+// UTC.
+func (p Policy) Prune(versions []Version, now time.Time) []int {
+	sorted := append([]Version(nil), versions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].Created.Equal(sorted[j].Created) {
+			return sorted[i].Created.After(sorted[j].Created)
+		}
+		return sorted[i].Number > sorted[j].Number
+	})
+
+	cutoff := now.Add(-p.DailyAfter)
+	lastOfDay := make(map[string]bool)
+	var pruned []int
+	for i, v := range sorted {
+		day := v.Created.UTC().Format("2006-01-02")
+		first := !lastOfDay[day]
+		lastOfDay[day] = true
+
+		if i < p.KeepLast || v.Keep || !v.Created.Before(cutoff) || first {
+			continue
+		}
+		pruned = append(pruned, v.Number)
+	}
+	sort.Ints(pruned)
+	return pruned
+}
@@ -0,0 +1,73 @@
+package retention_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"goblogengine/retention"
+)
+
+var now = time.Date(2018, time.March, 31, 12, 0, 0, 0, time.UTC)
+
+func daysAgo(days int, hour int) time.Time {
+	d := now.AddDate(0, 0, -days)
+	return time.Date(d.Year(), d.Month(), d.Day(), hour, 0, 0, 0, time.UTC)
+}
+
+func TestPrune(t *testing.T) {
+	versions := []retention.Version{
+		{Number: 1, Created: daysAgo(100, 9)},
+		{Number: 2, Created: daysAgo(100, 10)},
+		{Number: 3, Created: daysAgo(100, 11)},
+		{Number: 4, Created: daysAgo(60, 9), Keep: true},
+		{Number: 5, Created: daysAgo(60, 10)},
+		{Number: 6, Created: daysAgo(40, 9)},
+		{Number: 7, Created: daysAgo(10, 9)},
+		{Number: 8, Created: daysAgo(10, 10)},
+		{Number: 9, Created: daysAgo(1, 9)},
+		{Number: 10, Created: daysAgo(0, 9)},
+	}
+
+	tests := []struct {
+		policy retention.Policy
+		need   []int
+	}{
+		// Everything older than 30 days is thinned to one a day, except the
+		// pinned version 4.
+		{retention.Days(2, 30), []int{1, 2}},
+		// Every version is within the last 365 days.
+		{retention.Days(2, 365), nil},
+		// Keeping the last 9 leaves only version 1.
+		{retention.Days(9, 30), []int{1}},
+		// With no recent window and nothing kept by count, every day still
+		// keeps its last version.
+		{retention.Days(0, 0), []int{1, 2, 7}},
+	}
+	for _, tt := range tests {
+		have := tt.policy.Prune(versions, now)
+		if !reflect.DeepEqual(have, tt.need) {
+			t.Errorf("%+v: have %v, need %v", tt.policy, have, tt.need)
+		}
+	}
+}
+
+func TestPruneKeepsLastOfDay(t *testing.T) {
+	// Version 5 and version 4 have the same time, and the higher numbered
+	// one is treated as the later.
+	versions := []retention.Version{
+		{Number: 4, Created: daysAgo(50, 9)},
+		{Number: 5, Created: daysAgo(50, 9)},
+		{Number: 3, Created: daysAgo(50, 8)},
+	}
+	have := retention.Days(0, 30).Prune(versions, now)
+	if need := []int{3, 4}; !reflect.DeepEqual(have, need) {
+		t.Errorf("have %v, need %v", have, need)
+	}
+}
+
+func TestPruneEmpty(t *testing.T) {
+	if have := retention.Days(5, 30).Prune(nil, now); have != nil {
+		t.Errorf("have %v, need nil", have)
+	}
+}
@@ -0,0 +1,134 @@
+// Package sanitize removes unsafe markup from HTML written by readers, such as
+// comments rendered from Markdown. Only a small set of formatting elements is
+// kept; everything else is stripped, leaving its text.
+package sanitize
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags are kept, without attributes except where listed in
+// allowedAttrs.
+var allowedTags = map[string]bool{
+	"a": true, "b": true, "blockquote": true, "br": true, "code": true,
+	"del": true, "em": true, "hr": true, "i": true, "li": true, "ol": true,
+	"p": true, "pre": true, "strong": true, "ul": true,
+}
+
+var allowedAttrs = map[string]map[string]bool{
+	"a": {"href": true, "title": true},
+}
+
+// voidTags have no end tag.
+var voidTags = map[string]bool{"br": true, "hr": true}
+
+// droppedTags are removed along with their content.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true,
+	"embed": true, "textarea": true, "title": true, "noscript": true,
+}
+
+var allowedSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// HTML returns a sanitized copy of s. Elements are balanced so that the
+// result can be embedded in a page without affecting the markup around it.
+// Links are marked rel="nofollow ugc".
+func HTML(s string) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var buf bytes.Buffer
+	var open []string
+	dropping := 0
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			for i := len(open) - 1; i >= 0; i-- {
+				buf.WriteString("</" + open[i] + ">")
+			}
+			return buf.String()
+
+		case html.TextToken:
+			if dropping == 0 {
+				buf.WriteString(html.EscapeString(string(z.Text())))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			if droppedTags[t.Data] {
+				if tt == html.StartTagToken {
+					dropping++
+				}
+				continue
+			}
+			if dropping > 0 || !allowedTags[t.Data] {
+				continue
+			}
+			writeStartTag(&buf, t)
+			if !voidTags[t.Data] {
+				if tt == html.SelfClosingTagToken {
+					buf.WriteString("</" + t.Data + ">")
+				} else {
+					open = append(open, t.Data)
+				}
+			}
+
+		case html.EndTagToken:
+			t := z.Token()
+			if droppedTags[t.Data] {
+				if dropping > 0 {
+					dropping--
+				}
+				continue
+			}
+			if dropping > 0 {
+				continue
+			}
+			// Close the most recent matching element and anything left
+			// open inside it; end tags with no open element are ignored
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == t.Data {
+					for j := len(open) - 1; j >= i; j-- {
+						buf.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+}
+
+func writeStartTag(buf *bytes.Buffer, t html.Token) {
+	buf.WriteString("<" + t.Data)
+	for _, a := range t.Attr {
+		if !allowedAttrs[t.Data][a.Key] {
+			continue
+		}
+		if a.Key == "href" && !safeURL(a.Val) {
+			continue
+		}
+		buf.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+	}
+	if t.Data == "a" {
+		buf.WriteString(` rel="nofollow ugc"`)
+	}
+	buf.WriteString(">")
+}
+
+// safeURL reports whether a link target uses an allowed scheme or is
+// relative.
+func safeURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		return u.Opaque == ""
+	}
+	return allowedSchemes[strings.ToLower(u.Scheme)]
+}
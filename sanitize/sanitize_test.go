@@ -0,0 +1,46 @@
+package sanitize_test
+
+import (
+	"testing"
+
+	"goblogengine/sanitize"
+)
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		need string
+	}{
+		{"plain text", "hello", "hello"},
+		{"text is escaped", "a &lt; b &amp; c", "a &lt; b &amp; c"},
+		{"formatting kept", "<p>Some <em>nice</em> <strong>text</strong></p>",
+			"<p>Some <em>nice</em> <strong>text</strong></p>"},
+		{"attributes removed", `<p class="x" onclick="evil()">hi</p>`, "<p>hi</p>"},
+		{"unknown tags stripped", "<div><span>hi</span></div>", "hi"},
+		{"script removed with content", "a<script>alert(1)</script>b", "ab"},
+		{"style removed with content", "<style>p{}</style><p>x</p>", "<p>x</p>"},
+		{"links marked", `<a href="https://example.com/">x</a>`,
+			`<a href="https://example.com/" rel="nofollow ugc">x</a>`},
+		{"javascript links dropped", `<a href="javascript:alert(1)">x</a>`,
+			`<a rel="nofollow ugc">x</a>`},
+		{"mixed case scheme dropped", `<a href="JavaScript:alert(1)">x</a>`,
+			`<a rel="nofollow ugc">x</a>`},
+		{"relative links kept", `<a href="/post/x">x</a>`,
+			`<a href="/post/x" rel="nofollow ugc">x</a>`},
+		{"attribute values escaped", `<a href="/x?a=1&amp;b=&quot;2&quot;">x</a>`,
+			`<a href="/x?a=1&amp;b=&#34;2&#34;" rel="nofollow ugc">x</a>`},
+		{"unclosed tags closed", "<p><em>hi", "<p><em>hi</em></p>"},
+		{"stray end tags ignored", "hi</p></div>", "hi"},
+		{"misnested tags closed", "<p><em>a</p>b</em>", "<p><em>a</em></p>b"},
+		{"void tags", "a<br>b<hr/>", "a<br>b<hr>"},
+		{"images removed", `<img src="x" onerror="evil()">`, ""},
+		{"comments removed", "a<!-- hidden -->b", "ab"},
+	}
+
+	for _, tt := range tests {
+		if have := sanitize.HTML(tt.in); have != tt.need {
+			t.Errorf("%s: have %q, need %q", tt.name, have, tt.need)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package signedlink signs and verifies links which are valid until an
+// expiry time, so that a link can be checked before anything is looked up.
+// A link is an ID, the expiry in Unix seconds and a signature of both:
+//
+//	/preview/<id>/<expires>/<signature>
+//
+// Changing the key invalidates every link signed with it.
+package signedlink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrInvalid is returned by Verify when a signature does not match the ID and
+// expiry.
+var ErrInvalid = errors.New("signedlink: invalid signature")
+
+// ErrExpired is returned by Verify when a correctly signed link has expired.
+var ErrExpired = errors.New("signedlink: link has expired")
+
+// Sign returns the signature of a link to id which expires at expires, in
+// Unix seconds. The signature is safe to use in a URL path.
+func Sign(key string, id string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a link to id which expires at expires, in
+// Unix seconds. It returns ErrInvalid if the signature does not match, or
+// ErrExpired if the link has expired at now.
+func Verify(key string, id string, expires int64, signature string, now time.Time) error {
+	if !hmac.Equal([]byte(Sign(key, id, expires)), []byte(signature)) {
+		return ErrInvalid
+	}
+	if now.Unix() >= expires {
+		return ErrExpired
+	}
+	return nil
+}
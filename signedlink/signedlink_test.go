@@ -0,0 +1,50 @@
+package signedlink_test
+
+import (
+	"testing"
+	"time"
+
+	"goblogengine/signedlink"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour).Unix()
+	sig := signedlink.Sign("key", "abc", expires)
+
+	if sig != signedlink.Sign("key", "abc", expires) {
+		t.Error("Sign is not deterministic")
+	}
+	if err := signedlink.Verify("key", "abc", expires, sig, now); err != nil {
+		t.Errorf("Verify failed for a valid link: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		id      string
+		expires int64
+		sig     string
+		now     time.Time
+		need    error
+	}{
+		{"wrong key", "other", "abc", expires, sig, now, signedlink.ErrInvalid},
+		{"changed ID", "key", "abd", expires, sig, now, signedlink.ErrInvalid},
+		{"extended expiry", "key", "abc", expires + 1, sig, now, signedlink.ErrInvalid},
+		{"empty signature", "key", "abc", expires, "", now, signedlink.ErrInvalid},
+		{"at expiry", "key", "abc", expires, sig, time.Unix(expires, 0), signedlink.ErrExpired},
+		{"after expiry", "key", "abc", expires, sig, now.Add(2 * time.Hour), signedlink.ErrExpired},
+	}
+	for _, test := range tests {
+		if err := signedlink.Verify(test.key, test.id, test.expires, test.sig, test.now); err != test.need {
+			t.Errorf("%s: have %v, need %v", test.name, err, test.need)
+		}
+	}
+}
+
+func TestSignSeparatesFields(t *testing.T) {
+	// "a1" expiring at 2 must not sign the same as "a" expiring at 12.
+	if signedlink.Sign("key", "a1", 2) == signedlink.Sign("key", "a", 12) {
+		t.Error("Signatures of different links are equal")
+	}
+}
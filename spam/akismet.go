@@ -0,0 +1,90 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultAkismetEndpoint is the base URL of the Akismet REST API.
+const DefaultAkismetEndpoint = "https://rest.akismet.com/1.1"
+
+// Akismet is a Classifier which uses an Akismet-compatible service.
+type Akismet struct {
+	HTTPClient *http.Client
+
+	// Endpoint is the base URL of the service, DefaultAkismetEndpoint if
+	// empty.
+	Endpoint string
+
+	// Key is the API key and Blog the URL of the site it was issued for.
+	Key  string
+	Blog string
+}
+
+// Check asks the service whether an item is spam.
+func (a *Akismet) Check(ctx context.Context, item *Item) (Result, error) {
+	body, err := a.call("comment-check", item)
+	if err != nil {
+		return Result{}, err
+	}
+	switch body {
+	case "true":
+		return Result{Spam: true, Reasons: []string{"akismet"}}, nil
+	case "false":
+		return Result{}, nil
+	}
+	return Result{}, fmt.Errorf("spam: unexpected akismet response %q", body)
+}
+
+// Train reports an item as missed spam or a false positive.
+func (a *Akismet) Train(ctx context.Context, item *Item, spam bool) error {
+	method := "submit-ham"
+	if spam {
+		method = "submit-spam"
+	}
+	_, err := a.call(method, item)
+	return err
+}
+
+// call posts an item to an API method and returns the response body.
+func (a *Akismet) call(method string, item *Item) (string, error) {
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultAkismetEndpoint
+	}
+
+	resp, err := a.HTTPClient.PostForm(endpoint+"/"+method, url.Values{
+		"api_key":              {a.Key},
+		"blog":                 {a.Blog},
+		"user_ip":              {item.IPAddress},
+		"user_agent":           {item.UserAgent},
+		"referrer":             {item.Referrer},
+		"permalink":            {item.Permalink},
+		"comment_type":         {item.Kind},
+		"comment_author":       {item.AuthorName},
+		"comment_author_email": {item.AuthorEmail},
+		"comment_author_url":   {item.AuthorURL},
+		"comment_content":      {item.Content},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("spam: akismet %s returned %s", method, resp.Status)
+	}
+	if help := resp.Header.Get("X-akismet-debug-help"); help != "" {
+		return "", fmt.Errorf("spam: akismet %s failed: %s", method, help)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,198 @@
+package spam
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scores added by each of the Scorer's rules.
+const (
+	ScoreHoneypot      = 10
+	ScoreTooFast       = 5
+	ScoreExtraLink     = 2
+	ScoreMostlyLinks   = 3
+	ScoreBlockedTerm   = 5
+	ScoreBlockedDomain = 5
+	ScoreRateLimited   = 5
+	ScoreSpamDomain    = 3
+	ScoreHamDomain     = -2
+)
+
+// DefaultThreshold is the score at which a new Scorer considers items spam.
+const DefaultThreshold = 5
+
+// Reputation records how often links to a domain have been confirmed as spam
+// or ham by a moderator.
+type Reputation interface {
+	Get(ctx context.Context, domain string) (spam int, ham int, err error)
+	Record(ctx context.Context, domains []string, spam bool) error
+}
+
+// Scorer is a Classifier which adds up scores from simple rules. An item is
+// spam if its score reaches Threshold.
+type Scorer struct {
+	// BlockedTerms are matched case insensitively against the item's
+	// content and author details.
+	BlockedTerms []string
+
+	// BlockedDomains match links to the domain and any of its subdomains.
+	BlockedDomains []string
+
+	// MaxLinks is the number of links allowed before each extra link scores.
+	MaxLinks int
+
+	// MinFormAge is the shortest time a reader could plausibly take to fill
+	// in a form.
+	MinFormAge time.Duration
+
+	// MaxRecent is the number of recent items allowed from one IP address.
+	MaxRecent int
+
+	Threshold int
+
+	// Reputation is optional. When set, the scorer learns which domains are
+	// linked to by spam from Train.
+	Reputation Reputation
+}
+
+// NewScorer returns a Scorer with default limits and no blocklists.
+func NewScorer() *Scorer {
+	return &Scorer{
+		MaxLinks:   2,
+		MinFormAge: 3 * time.Second,
+		MaxRecent:  5,
+		Threshold:  DefaultThreshold,
+	}
+}
+
+// Check scores an item against each rule.
+func (s *Scorer) Check(ctx context.Context, item *Item) (Result, error) {
+	var res Result
+
+	if item.FromForm {
+		if item.Honeypot != "" {
+			res.add(ScoreHoneypot, "hidden field filled in")
+		}
+		if item.FormAge < s.MinFormAge {
+			res.add(ScoreTooFast, fmt.Sprintf("submitted after %s", item.FormAge))
+		}
+	}
+
+	ls := links(item.Content)
+	if extra := len(ls) - s.MaxLinks; extra > 0 {
+		res.add(extra*ScoreExtraLink, fmt.Sprintf("%d links", len(ls)))
+	}
+	if linkLen(ls)*2 > len(strings.TrimSpace(item.Content)) {
+		res.add(ScoreMostlyLinks, "content is mostly links")
+	}
+
+	text := strings.ToLower(strings.Join([]string{
+		item.AuthorName, item.AuthorEmail, item.AuthorURL, item.Content}, " "))
+	for _, t := range s.BlockedTerms {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && strings.Contains(text, t) {
+			res.add(ScoreBlockedTerm, fmt.Sprintf("blocked term %q", t))
+		}
+	}
+
+	ds := domains(item)
+	if i := strings.LastIndex(item.AuthorEmail, "@"); i >= 0 {
+		ds = append(ds, strings.ToLower(item.AuthorEmail[i+1:]))
+	}
+	for _, d := range ds {
+		if b := s.blockedDomain(d); b != "" {
+			res.add(ScoreBlockedDomain, fmt.Sprintf("blocked domain %s", b))
+		}
+	}
+
+	if s.MaxRecent > 0 && item.Recent > s.MaxRecent {
+		res.add(ScoreRateLimited, fmt.Sprintf("%d recent submissions from %s",
+			item.Recent, item.IPAddress))
+	}
+
+	if s.Reputation != nil {
+		for _, d := range domains(item) {
+			spam, ham, err := s.Reputation.Get(ctx, d)
+			if err != nil {
+				return res, err
+			}
+			switch {
+			case spam > ham:
+				res.add(ScoreSpamDomain, fmt.Sprintf("%s linked to by spam", d))
+			case ham > spam:
+				res.add(ScoreHamDomain, fmt.Sprintf("%s linked to by ham", d))
+			}
+		}
+	}
+
+	res.Spam = res.Score >= s.Threshold
+	return res, nil
+}
+
+// Train records the domains linked to from an item in the scorer's
+// Reputation. It does nothing if there is no Reputation.
+func (s *Scorer) Train(ctx context.Context, item *Item, spam bool) error {
+	if s.Reputation == nil {
+		return nil
+	}
+	ds := domains(item)
+	if len(ds) == 0 {
+		return nil
+	}
+	return s.Reputation.Record(ctx, ds, spam)
+}
+
+// blockedDomain returns the blocklist entry matching d, if any.
+func (s *Scorer) blockedDomain(d string) string {
+	for _, b := range s.BlockedDomains {
+		b = strings.ToLower(strings.TrimSpace(b))
+		if b != "" && (d == b || strings.HasSuffix(d, "."+b)) {
+			return b
+		}
+	}
+	return ""
+}
+
+func linkLen(ls []string) int {
+	n := 0
+	for _, l := range ls {
+		n += len(l)
+	}
+	return n
+}
+
+// MemReputation is a Reputation held in memory.
+type MemReputation struct {
+	mu     sync.Mutex
+	counts map[string][2]int
+}
+
+// Get returns the spam and ham counts for a domain.
+func (m *MemReputation) Get(ctx context.Context, domain string) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.counts[domain]
+	return c[0], c[1], nil
+}
+
+// Record adds one to the spam or ham count of each domain.
+func (m *MemReputation) Record(ctx context.Context, domains []string, spam bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts == nil {
+		m.counts = make(map[string][2]int)
+	}
+	for _, d := range domains {
+		c := m.counts[d]
+		if spam {
+			c[0]++
+		} else {
+			c[1]++
+		}
+		m.counts[d] = c
+	}
+	return nil
+}
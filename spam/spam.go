@@ -0,0 +1,139 @@
+// Package spam classifies content submitted by readers, such as comments and
+// webmentions, as spam or ham.
+//
+// A Scorer applies local heuristics and can learn from moderation decisions
+// through a Reputation store. An Akismet client checks items against an
+// Akismet-compatible service. Either, or both combined with Multi, can be
+// used as a Classifier.
+package spam
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Kinds of submitted item.
+const (
+	KindComment    = "comment"
+	KindWebmention = "webmention"
+)
+
+// Item describes a submission to be classified.
+type Item struct {
+	Kind        string
+	AuthorName  string
+	AuthorEmail string
+	AuthorURL   string
+	Content     string
+	Permalink   string
+	IPAddress   string
+	UserAgent   string
+	Referrer    string
+
+	// FromForm is set when the item was submitted through a form on the site.
+	// Honeypot is the value of a field hidden from readers, which only bots
+	// fill in, and FormAge is the time between the form being displayed and
+	// submitted.
+	FromForm bool
+	Honeypot string
+	FormAge  time.Duration
+
+	// Recent is the number of items recently submitted from IPAddress,
+	// including this one.
+	Recent int
+}
+
+// Result is the outcome of classifying an Item.
+type Result struct {
+	Spam    bool
+	Score   int
+	Reasons []string
+}
+
+func (r *Result) add(score int, reason string) {
+	r.Score += score
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// Classifier decides whether an Item is spam, and learns from items which a
+// moderator has confirmed to be spam or ham.
+type Classifier interface {
+	Check(ctx context.Context, item *Item) (Result, error)
+	Train(ctx context.Context, item *Item, spam bool) error
+}
+
+// Multi combines several classifiers. An item is spam if any of them says
+// so, and training is passed on to all of them.
+type Multi []Classifier
+
+// Check returns the combined result of every classifier which succeeded,
+// along with the first error encountered.
+func (m Multi) Check(ctx context.Context, item *Item) (Result, error) {
+	var res Result
+	var first error
+	for _, c := range m {
+		r, err := c.Check(ctx, item)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		res.Spam = res.Spam || r.Spam
+		res.Score += r.Score
+		res.Reasons = append(res.Reasons, r.Reasons...)
+	}
+	return res, first
+}
+
+// Train trains every classifier, returning the first error encountered.
+func (m Multi) Train(ctx context.Context, item *Item, spam bool) error {
+	var first error
+	for _, c := range m {
+		if err := c.Train(ctx, item, spam); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+var linkPattern = regexp.MustCompile(`(?i)https?://[^\s"'<>()\[\]]+`)
+
+// links returns the URLs found in s.
+func links(s string) []string {
+	return linkPattern.FindAllString(s, -1)
+}
+
+// domain returns the lower case host name of a URL without any port or
+// leading "www.", or an empty string if it has none.
+func domain(rawurl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// domains returns the distinct domains linked to from an item, including the
+// author's website.
+func domains(item *Item) []string {
+	urls := links(item.Content)
+	if item.AuthorURL != "" {
+		urls = append(urls, item.AuthorURL)
+	}
+
+	seen := make(map[string]bool)
+	var ds []string
+	for _, u := range urls {
+		d := domain(u)
+		if d == "" || seen[d] {
+			continue
+		}
+		seen[d] = true
+		ds = append(ds, d)
+	}
+	return ds
+}
@@ -0,0 +1,176 @@
+package spam_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"goblogengine/spam"
+)
+
+// ham returns an item which the default scorer should accept.
+func ham() *spam.Item {
+	return &spam.Item{
+		Kind:       spam.KindComment,
+		AuthorName: "Reader",
+		AuthorURL:  "https://reader.example/",
+		Content:    "Thanks, this was really useful. I wrote more about it at https://reader.example/post",
+		IPAddress:  "192.0.2.1",
+		FromForm:   true,
+		FormAge:    time.Minute,
+		Recent:     1,
+	}
+}
+
+func TestScorer(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*spam.Item)
+		spam   bool
+	}{
+		{"ham", func(i *spam.Item) {}, false},
+		{"honeypot", func(i *spam.Item) { i.Honeypot = "x" }, true},
+		{"too fast", func(i *spam.Item) { i.FormAge = time.Second }, true},
+		{"timing ignored without form", func(i *spam.Item) {
+			i.FromForm, i.FormAge = false, 0
+		}, false},
+		{"many links", func(i *spam.Item) {
+			i.Content = "Great post! " + strings.Repeat("see http://a.example/x and ", 4)
+		}, true},
+		{"only links", func(i *spam.Item) {
+			i.Content = "http://a.example/cheap-pills http://b.example/"
+		}, false},
+		{"blocked term", func(i *spam.Item) { i.Content += " Buy CHEAP pills now" }, true},
+		{"blocked domain", func(i *spam.Item) { i.AuthorURL = "http://shop.spam.example/" }, true},
+		{"blocked email domain", func(i *spam.Item) { i.AuthorEmail = "x@spam.example" }, true},
+		{"unrelated domain suffix", func(i *spam.Item) { i.AuthorURL = "http://notspam.example/" }, false},
+		{"rate limited", func(i *spam.Item) { i.Recent = 6 }, true},
+	}
+
+	s := spam.NewScorer()
+	s.BlockedTerms = []string{"", "cheap pills"}
+	s.BlockedDomains = []string{"spam.example"}
+
+	for _, tt := range tests {
+		item := ham()
+		tt.modify(item)
+		res, err := s.Check(context.Background(), item)
+		if err != nil {
+			t.Fatalf("%s: Check failed: %s", tt.name, err)
+		}
+		if res.Spam != tt.spam {
+			t.Errorf("%s: have spam %v, need %v (score %d, %v)",
+				tt.name, res.Spam, tt.spam, res.Score, res.Reasons)
+		}
+	}
+}
+
+func TestScorerTrain(t *testing.T) {
+	s := spam.NewScorer()
+	s.Threshold = 3
+	s.Reputation = new(spam.MemReputation)
+	ctx := context.Background()
+
+	item := ham()
+	item.AuthorURL = "http://seo.example/"
+	item.Content = "Nice post, I have a related offer at http://seo.example/offer"
+
+	if res, _ := s.Check(ctx, item); res.Spam {
+		t.Fatalf("Untrained item is spam: %v", res.Reasons)
+	}
+	if err := s.Train(ctx, item, true); err != nil {
+		t.Fatalf("Train failed: %s", err)
+	}
+
+	other := ham()
+	other.Content = "Also see https://www.seo.example/"
+	if res, _ := s.Check(ctx, other); !res.Spam {
+		t.Errorf("Item linking to trained spam domain is not spam: %v", res.Reasons)
+	}
+
+	s.Train(ctx, other, false)
+	s.Train(ctx, other, false)
+	if res, _ := s.Check(ctx, other); res.Spam {
+		t.Errorf("Item is still spam after training ham: %v", res.Reasons)
+	}
+}
+
+func TestAkismet(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		calls = append(calls, r.URL.Path)
+		if r.PostFormValue("api_key") != "key" {
+			w.Header().Set("X-akismet-debug-help", "invalid key")
+			fmt.Fprint(w, "invalid")
+			return
+		}
+		switch r.URL.Path {
+		case "/comment-check":
+			fmt.Fprint(w, r.PostFormValue("comment_author") == "viagra-test-123")
+		default:
+			fmt.Fprint(w, "Thanks for making the web a better place.")
+		}
+	}))
+	defer srv.Close()
+
+	a := &spam.Akismet{HTTPClient: srv.Client(), Endpoint: srv.URL, Key: "key", Blog: "http://blog.example"}
+	ctx := context.Background()
+
+	item := ham()
+	if res, err := a.Check(ctx, item); err != nil || res.Spam {
+		t.Errorf("Have spam %v and error %v for ham", res.Spam, err)
+	}
+	item.AuthorName = "viagra-test-123"
+	if res, err := a.Check(ctx, item); err != nil || !res.Spam {
+		t.Errorf("Have spam %v and error %v for spam", res.Spam, err)
+	}
+	if err := a.Train(ctx, item, true); err != nil {
+		t.Errorf("Train spam failed: %s", err)
+	}
+	if err := a.Train(ctx, item, false); err != nil {
+		t.Errorf("Train ham failed: %s", err)
+	}
+	need := "/comment-check /comment-check /submit-spam /submit-ham"
+	if have := strings.Join(calls, " "); have != need {
+		t.Errorf("Have calls %s, need %s", have, need)
+	}
+
+	a.Key = "wrong"
+	if _, err := a.Check(ctx, item); err == nil {
+		t.Error("Check with an invalid key succeeded, need error")
+	}
+}
+
+type failing struct{}
+
+func (failing) Check(ctx context.Context, item *spam.Item) (spam.Result, error) {
+	return spam.Result{}, errors.New("unavailable")
+}
+
+func (failing) Train(ctx context.Context, item *spam.Item, spam bool) error {
+	return errors.New("unavailable")
+}
+
+func TestMulti(t *testing.T) {
+	ctx := context.Background()
+	item := ham()
+	item.Honeypot = "x"
+
+	m := spam.Multi{failing{}, spam.NewScorer()}
+	res, err := m.Check(ctx, item)
+	if err == nil {
+		t.Error("Multi hid a classifier error")
+	}
+	if !res.Spam {
+		t.Errorf("Multi ignored a spam result: %+v", res)
+	}
+	if err := m.Train(ctx, item, true); err == nil {
+		t.Error("Multi hid a training error")
+	}
+}
@@ -40,7 +40,10 @@ type Info struct {
 	Data       interface{}
 	flashes    []string // TODO: severity level
 	user       interface{}
+	menu       interface{}
+	undo       interface{}
 	dateFormat string
+	blogName   string
 
 	base      string
 	templates []string
@@ -62,6 +65,9 @@ type viewModel struct {
 	Flashes    []string
 	Data       interface{}
 	DateFormat string
+	BlogName   string
+	Menu       interface{}
+	Undo       interface{}
 }
 
 // *****************************************************************************
@@ -89,11 +95,26 @@ func (v *Info) SetUser(u interface{}) {
 	v.user = u
 }
 
+// SetMenu sets the site navigation menu for the view data.
+func (v *Info) SetMenu(m interface{}) {
+	v.menu = m
+}
+
+// SetUndo sets a flash message offering to undo an action for the view data.
+func (v *Info) SetUndo(u interface{}) {
+	v.undo = u
+}
+
 // SetDateFormat sets the date format for the view.
 func (v *Info) SetDateFormat(f string) {
 	v.dateFormat = f
 }
 
+// SetBlogName sets the name of the blog for the view.
+func (v *Info) SetBlogName(n string) {
+	v.blogName = n
+}
+
 // Base sets the new base template instead of reading from
 // Template.Root of the config file.
 func (v *Info) Base(base string) *Info {
@@ -179,6 +200,9 @@ func (v *Info) Render(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		PageName:   pageName,
 		Flashes:    v.flashes,
 		DateFormat: v.dateFormat,
+		BlogName:   v.blogName,
+		Menu:       v.menu,
+		Undo:       v.undo,
 	}
 
 	// Render the output to a buffer, check for errors, render buffer to screen
@@ -0,0 +1,185 @@
+// Package webhook signs and delivers notifications of content changes to
+// URLs registered by an administrator. Deliveries which fail are retried
+// with exponential backoff via a Queue.
+//
+// Each request is a JSON encoded Payload POSTed with the headers:
+//
+//	X-GoBlogEngine-Event: the event name
+//	X-GoBlogEngine-Delivery: the delivery ID, which is the same for retries
+//	X-GoBlogEngine-Signature: sha256=<hex HMAC-SHA256 of the body>
+//
+// Receivers should verify the signature with the secret shown in admin.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Events which can trigger a webhook.
+const (
+	EventPostPublished   = "post.published"
+	EventPostUnpublished = "post.unpublished"
+	EventPostDeleted     = "post.deleted"
+	EventVersionCreated  = "post.version_created"
+	EventImageUploaded   = "image.uploaded"
+	EventCategoryChanged = "category.changed"
+)
+
+// AllEvents lists every event in the order they are shown in admin.
+var AllEvents = []string{
+	EventPostPublished,
+	EventPostUnpublished,
+	EventPostDeleted,
+	EventVersionCreated,
+	EventImageUploaded,
+	EventCategoryChanged,
+}
+
+// Request headers set on every delivery.
+const (
+	HeaderEvent     = "X-GoBlogEngine-Event"
+	HeaderDelivery  = "X-GoBlogEngine-Delivery"
+	HeaderSignature = "X-GoBlogEngine-Signature"
+)
+
+// MaxAttempts is the number of times a delivery is attempted before it is
+// abandoned.
+const MaxAttempts = 8
+
+// Payload is the body of a webhook request.
+type Payload struct {
+	Event    string            `json:"event"`
+	Occurred time.Time         `json:"occurred"`
+	Data     map[string]string `json:"data"`
+}
+
+// NewPayload returns the JSON encoded Payload for an event.
+func NewPayload(event string, data map[string]string) ([]byte, error) {
+	return json.Marshal(Payload{
+		Event:    event,
+		Occurred: time.Now().UTC(),
+		Data:     data,
+	})
+}
+
+// Sign returns the signature header value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of body.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Backoff returns the delay before the retry which follows a failed attempt.
+// Attempts are numbered from 1; the delay starts at 30 seconds and doubles
+// up to a maximum of two hours.
+func Backoff(attempt int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempt && d < 2*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 2*time.Hour {
+		d = 2 * time.Hour
+	}
+	return d
+}
+
+// Delivery is a payload to be sent to a webhook URL.
+type Delivery struct {
+	ID     string
+	URL    string
+	Secret string
+	Event  string
+	Body   []byte
+}
+
+// Send POSTs the delivery and returns the response status code. An error is
+// returned if the request fails or the response status is not 2xx.
+func Send(client *http.Client, d Delivery) (int, error) {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoBlogEngine-Webhook")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, d.ID)
+	req.Header.Set(HeaderSignature, Sign(d.Secret, d.Body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook: %s returned %s", d.URL, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Task identifies an attempt at a delivery.
+type Task struct {
+	DeliveryID string
+	Attempt    int
+}
+
+// Queue schedules tasks to run after a delay.
+type Queue interface {
+	Add(ctx context.Context, t Task, delay time.Duration) error
+}
+
+// Attempt sends a delivery. If it fails and attempts remain, a retry is
+// added to the queue after the backoff delay. The status code and error
+// from Send are returned so that the caller can record the attempt.
+func Attempt(ctx context.Context, client *http.Client, q Queue, d Delivery, attempt int) (int, error) {
+	status, err := Send(client, d)
+	if err != nil && attempt < MaxAttempts {
+		next := Task{DeliveryID: d.ID, Attempt: attempt + 1}
+		if qerr := q.Add(ctx, next, Backoff(attempt)); qerr != nil {
+			return status, fmt.Errorf("%v; unable to queue retry: %v", err, qerr)
+		}
+	}
+	return status, err
+}
+
+// MemQueue is a Queue held in memory, for use where a task queue service is
+// not available.
+type MemQueue struct {
+	Tasks  []Task
+	Delays []time.Duration
+}
+
+// Add appends a task to the queue.
+func (q *MemQueue) Add(ctx context.Context, t Task, delay time.Duration) error {
+	q.Tasks = append(q.Tasks, t)
+	q.Delays = append(q.Delays, delay)
+	return nil
+}
+
+// Next removes and returns the first task in the queue, ignoring its delay.
+// It returns false if the queue is empty.
+func (q *MemQueue) Next() (Task, bool) {
+	if len(q.Tasks) == 0 {
+		return Task{}, false
+	}
+	t := q.Tasks[0]
+	q.Tasks = q.Tasks[1:]
+	q.Delays = q.Delays[1:]
+	return t, true
+}
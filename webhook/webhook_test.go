@@ -0,0 +1,164 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"goblogengine/webhook"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"event":"post.published"}`)
+	sig := webhook.Sign("secret", body)
+
+	// Computed with: printf '%s' "$body" | openssl dgst -sha256 -hmac secret
+	need := "sha256=7ed90df2252e27588d6f3be4a105569d03af742342edbc76d53781ef4b375a95"
+	if sig != need {
+		t.Errorf("Have signature %s, need %s", sig, need)
+	}
+	if !webhook.Verify("secret", body, sig) {
+		t.Error("Verify failed for a valid signature")
+	}
+	if webhook.Verify("other", body, sig) {
+		t.Error("Verify succeeded with the wrong secret")
+	}
+	if webhook.Verify("secret", append(body, ' '), sig) {
+		t.Error("Verify succeeded for a modified body")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		need    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{8, 64 * time.Minute},
+		{20, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if have := webhook.Backoff(tt.attempt); have != tt.need {
+			t.Errorf("Backoff(%d): have %s, need %s", tt.attempt, have, tt.need)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	body, err := webhook.NewPayload(webhook.EventPostPublished,
+		map[string]string{"slug": "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	d := webhook.Delivery{
+		ID:     "d1",
+		URL:    srv.URL,
+		Secret: "secret",
+		Event:  webhook.EventPostPublished,
+		Body:   body,
+	}
+	status, err := webhook.Send(srv.Client(), d)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Have status %d error %v, need 200", status, err)
+	}
+
+	if have := got.Header.Get(webhook.HeaderEvent); have != webhook.EventPostPublished {
+		t.Errorf("Have event header %s", have)
+	}
+	if have := got.Header.Get(webhook.HeaderDelivery); have != "d1" {
+		t.Errorf("Have delivery header %s, need d1", have)
+	}
+	if !webhook.Verify("secret", gotBody, got.Header.Get(webhook.HeaderSignature)) {
+		t.Error("Signature header does not verify")
+	}
+
+	var p webhook.Payload
+	if err := json.Unmarshal(gotBody, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Event != webhook.EventPostPublished || p.Data["slug"] != "hello" {
+		t.Errorf("Have payload %+v", p)
+	}
+}
+
+func TestAttemptRetries(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	q := new(webhook.MemQueue)
+	d := webhook.Delivery{ID: "d1", URL: srv.URL, Secret: "s", Body: []byte("{}")}
+
+	status, err := webhook.Attempt(ctx, srv.Client(), q, d, 1)
+	if err == nil || status != http.StatusServiceUnavailable {
+		t.Fatalf("Have status %d error %v, need 503 and an error", status, err)
+	}
+	if len(q.Delays) != 1 || q.Delays[0] != webhook.Backoff(1) {
+		t.Fatalf("Have delays %v, need one retry after %s", q.Delays, webhook.Backoff(1))
+	}
+
+	for {
+		task, ok := q.Next()
+		if !ok {
+			break
+		}
+		if task.DeliveryID != "d1" {
+			t.Fatalf("Have delivery %s, need d1", task.DeliveryID)
+		}
+		status, err = webhook.Attempt(ctx, srv.Client(), q, d, task.Attempt)
+	}
+
+	if err != nil || status != http.StatusOK {
+		t.Errorf("Have status %d error %v after retries, need 200", status, err)
+	}
+	if calls != 3 {
+		t.Errorf("Have %d calls, need 3", calls)
+	}
+}
+
+func TestAttemptGivesUp(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	q := new(webhook.MemQueue)
+	d := webhook.Delivery{ID: "d1", URL: srv.URL, Secret: "s", Body: []byte("{}")}
+
+	webhook.Attempt(ctx, srv.Client(), q, d, 1)
+	for {
+		task, ok := q.Next()
+		if !ok {
+			break
+		}
+		webhook.Attempt(ctx, srv.Client(), q, d, task.Attempt)
+	}
+
+	if calls != webhook.MaxAttempts {
+		t.Errorf("Have %d calls, need %d", calls, webhook.MaxAttempts)
+	}
+}
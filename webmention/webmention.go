@@ -0,0 +1,295 @@
+// Package webmention sends and verifies Webmentions, notifications that one
+// page links to another.
+//
+// See https://www.w3.org/TR/webmention/.
+package webmention
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxBody is the largest response body read when discovering endpoints or
+// verifying sources.
+const maxBody = 1 << 20
+
+// ErrorNoEndpoint is returned when a target does not advertise a Webmention
+// endpoint.
+var ErrorNoEndpoint = errors.New("webmention: no endpoint found")
+
+// ErrorSourceGone is returned when the source of a mention has been deleted.
+var ErrorSourceGone = errors.New("webmention: source has been deleted")
+
+// ErrorNoLink is returned when the source of a mention does not link to the
+// target.
+var ErrorNoLink = errors.New("webmention: source does not link to target")
+
+// Client sends and verifies Webmentions using an HTTP client supplied by the
+// caller.
+type Client struct {
+	HTTPClient *http.Client
+}
+
+// Source describes a verified mention source.
+type Source struct {
+	URL   string
+	Title string
+}
+
+// Discover returns the Webmention endpoint advertised by target, either in
+// a Link header or in a link or a element with rel="webmention".
+func (c *Client) Discover(target string) (string, error) {
+	resp, err := c.HTTPClient.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("webmention: %s returned %s", target, resp.Status)
+	}
+
+	// Relative endpoints are resolved against the final URL after redirects
+	base := resp.Request.URL
+
+	for _, h := range resp.Header["Link"] {
+		if href, ok := linkHeaderEndpoint(h); ok {
+			return resolve(base, href)
+		}
+	}
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return "", ErrorNoEndpoint
+	}
+
+	var endpoint string
+	var found bool
+	walk(io.LimitReader(resp.Body, maxBody), func(t html.Token) bool {
+		if t.Data != "link" && t.Data != "a" {
+			return true
+		}
+		if !hasRel(attr(t, "rel"), "webmention") {
+			return true
+		}
+		endpoint, found = attr(t, "href"), true
+		return false
+	})
+	if !found {
+		return "", ErrorNoEndpoint
+	}
+	return resolve(base, endpoint)
+}
+
+// Send notifies endpoint that source links to target.
+func (c *Client) Send(endpoint, source, target string) error {
+	resp, err := c.HTTPClient.PostForm(endpoint, url.Values{
+		"source": {source},
+		"target": {target},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webmention: %s returned %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// Notify discovers the endpoint for target and sends it a Webmention. It
+// returns ErrorNoEndpoint if target does not accept Webmentions.
+func (c *Client) Notify(source, target string) error {
+	endpoint, err := c.Discover(target)
+	if err != nil {
+		return err
+	}
+	return c.Send(endpoint, source, target)
+}
+
+// Verify fetches source and checks that it links to target. It returns
+// ErrorSourceGone if the source has been deleted and ErrorNoLink if it no
+// longer links to the target.
+func (c *Client) Verify(source, target string) (*Source, error) {
+	tu, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	tu.Fragment = ""
+	target = tu.String()
+
+	resp, err := c.HTTPClient.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, ErrorSourceGone
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("webmention: %s returned %s", source, resp.Status)
+	}
+
+	base := resp.Request.URL
+	s := &Source{URL: source}
+	var linked, inTitle bool
+	walk(io.LimitReader(resp.Body, maxBody), func(t html.Token) bool {
+		switch t.Type {
+		case html.TextToken:
+			if inTitle && s.Title == "" {
+				s.Title = strings.TrimSpace(t.Data)
+			}
+			return true
+		case html.EndTagToken:
+			if t.Data == "title" {
+				inTitle = false
+			}
+			return true
+		}
+
+		var ref string
+		switch t.Data {
+		case "title":
+			inTitle = true
+		case "a", "link":
+			ref = attr(t, "href")
+		case "img", "video", "audio", "source":
+			ref = attr(t, "src")
+		}
+		if ref != "" {
+			if u, err := resolve(base, ref); err == nil && u == target {
+				linked = true
+			}
+		}
+		return true
+	})
+
+	if !linked {
+		return nil, ErrorNoLink
+	}
+	return s, nil
+}
+
+// Links returns the distinct absolute http and https URLs linked to from an
+// HTML fragment, resolving relative links against base.
+func Links(fragment string, base string) []string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var links []string
+	walk(strings.NewReader(fragment), func(t html.Token) bool {
+		var ref string
+		switch t.Data {
+		case "a":
+			ref = attr(t, "href")
+		case "img":
+			ref = attr(t, "src")
+		}
+		if ref == "" {
+			return true
+		}
+		u, err := resolve(b, ref)
+		if err != nil || seen[u] || !strings.HasPrefix(u, "http") {
+			return true
+		}
+		seen[u] = true
+		links = append(links, u)
+		return true
+	})
+	return links
+}
+
+// ParseRequest returns the source and target of a received Webmention. The
+// target must be an http or https URL on host, and the source must be a
+// different http or https URL.
+func ParseRequest(r *http.Request, host string) (source string, target string, err error) {
+	source = r.PostFormValue("source")
+	target = r.PostFormValue("target")
+
+	su, err := url.Parse(source)
+	if err != nil || (su.Scheme != "http" && su.Scheme != "https") || su.Host == "" {
+		return "", "", errors.New("webmention: source must be an http or https URL")
+	}
+	tu, err := url.Parse(target)
+	if err != nil || (tu.Scheme != "http" && tu.Scheme != "https") {
+		return "", "", errors.New("webmention: target must be an http or https URL")
+	}
+	if !strings.EqualFold(tu.Host, host) {
+		return "", "", errors.New("webmention: target is not on this site")
+	}
+	if source == target {
+		return "", "", errors.New("webmention: source and target must differ")
+	}
+	return source, target, nil
+}
+
+// walk calls fn for each token in an HTML document until fn returns false.
+func walk(r io.Reader, fn func(html.Token) bool) {
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if !fn(z.Token()) {
+			return
+		}
+	}
+}
+
+func attr(t html.Token, key string) string {
+	for _, a := range t.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasRel(rel string, value string) bool {
+	for _, r := range strings.Fields(rel) {
+		if strings.EqualFold(r, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkHeaderEndpoint returns the URL of the first link in an HTTP Link
+// header value with rel="webmention".
+func linkHeaderEndpoint(h string) (string, bool) {
+	for _, link := range strings.Split(h, ",") {
+		parts := strings.Split(link, ";")
+		href := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(href, "<") || !strings.HasSuffix(href, ">") {
+			continue
+		}
+		for _, p := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "rel") &&
+				hasRel(strings.Trim(kv[1], `"`), "webmention") {
+				return href[1 : len(href)-1], true
+			}
+		}
+	}
+	return "", false
+}
+
+func resolve(base *url.URL, ref string) (string, error) {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", err
+	}
+	u.Fragment = ""
+	return u.String(), nil
+}
@@ -0,0 +1,187 @@
+package webmention_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"goblogengine/webmention"
+)
+
+// newServer returns a test server which answers every request with the
+// supplied handler, and a client configured to use it.
+func newServer(t *testing.T, h http.HandlerFunc) (*httptest.Server, *webmention.Client) {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv, &webmention.Client{HTTPClient: srv.Client()}
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		need   string
+	}{
+		{"link header", `</hook>; rel="webmention"`, "", "/hook"},
+		{"link header absolute", `<http://example.com/hook>; rel=webmention`, "",
+			"http://example.com/hook"},
+		{"link header among others", `</a>; rel="other", </hook>; rel="webmention"`, "", "/hook"},
+		{"link element", "", `<html><head><link rel="webmention" href="/hook"></head></html>`, "/hook"},
+		{"a element", "", `<p><a href="hook" rel="me webmention">x</a></p>`, "/post/hook"},
+		{"empty href is the page", "", `<link rel="webmention" href="">`, "/post/x"},
+		{"header preferred", `</first>; rel="webmention"`, `<link rel="webmention" href="/second">`, "/first"},
+	}
+
+	for _, tt := range tests {
+		srv, c := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if tt.header != "" {
+				w.Header().Set("Link", tt.header)
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, tt.body)
+		})
+
+		have, err := c.Discover(srv.URL + "/post/x")
+		if err != nil {
+			t.Errorf("%s: Discover failed: %s", tt.name, err)
+			continue
+		}
+		need := tt.need
+		if strings.HasPrefix(need, "/") {
+			need = srv.URL + need
+		}
+		if have != need {
+			t.Errorf("%s: have endpoint %s, need %s", tt.name, have, need)
+		}
+	}
+}
+
+func TestDiscoverNone(t *testing.T) {
+	srv, c := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<link rel="pingback" href="/xmlrpc">`)
+	})
+
+	if _, err := c.Discover(srv.URL); err != webmention.ErrorNoEndpoint {
+		t.Errorf("Have error %v, need ErrorNoEndpoint", err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var got url.Values
+	srv, c := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/post/x":
+			w.Header().Set("Link", `</hook>; rel="webmention"`)
+		case "/hook":
+			r.ParseForm()
+			got = r.PostForm
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})
+
+	if err := c.Notify("http://blog.example/post/a", srv.URL+"/post/x"); err != nil {
+		t.Fatalf("Notify failed: %s", err)
+	}
+	need := url.Values{
+		"source": {"http://blog.example/post/a"},
+		"target": {srv.URL + "/post/x"},
+	}
+	if !reflect.DeepEqual(got, need) {
+		t.Errorf("Have form %v, need %v", got, need)
+	}
+}
+
+func TestSendRejected(t *testing.T) {
+	srv, c := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	if err := c.Send(srv.URL, "http://a.example/", "http://b.example/"); err == nil {
+		t.Error("Send succeeded for a 400 response, need error")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	target := "http://blog.example/post/hello"
+	pages := map[string]string{
+		"/linked":   `<html><head><title> A reply </title></head><body><a href="` + target + `#top">reply</a></body></html>`,
+		"/image":    `<img src="` + target + `">`,
+		"/unlinked": `<a href="http://blog.example/post/other">other</a>`,
+		"/text":     target,
+	}
+	srv, c := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		fmt.Fprint(w, pages[r.URL.Path])
+	})
+
+	s, err := c.Verify(srv.URL+"/linked", target)
+	if err != nil {
+		t.Fatalf("Verify failed: %s", err)
+	}
+	if s.Title != "A reply" || s.URL != srv.URL+"/linked" {
+		t.Errorf("Have source %+v", s)
+	}
+
+	if _, err := c.Verify(srv.URL+"/image", target); err != nil {
+		t.Errorf("Verify of image link failed: %s", err)
+	}
+	for _, p := range []string{"/unlinked", "/text"} {
+		if _, err := c.Verify(srv.URL+p, target); err != webmention.ErrorNoLink {
+			t.Errorf("%s: have error %v, need ErrorNoLink", p, err)
+		}
+	}
+	if _, err := c.Verify(srv.URL+"/gone", target); err != webmention.ErrorSourceGone {
+		t.Errorf("Have error %v, need ErrorSourceGone", err)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	body := `<p>See <a href="https://example.com/a">a</a>, <a href="/post/b">b</a>,
+		<a href="https://example.com/a#again">a again</a>, <a href="mailto:x@example.com">mail</a>
+		and <img src="http://img.example/c.jpg"></p>`
+
+	need := []string{
+		"https://example.com/a",
+		"http://blog.example/post/b",
+		"http://img.example/c.jpg",
+	}
+	if have := webmention.Links(body, "http://blog.example/post/x"); !reflect.DeepEqual(have, need) {
+		t.Errorf("Have links %v, need %v", have, need)
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		source string
+		target string
+		valid  bool
+	}{
+		{"http://a.example/reply", "http://blog.example/post/x", true},
+		{"https://a.example/reply", "https://BLOG.example/post/x", true},
+		{"", "http://blog.example/post/x", false},
+		{"ftp://a.example/reply", "http://blog.example/post/x", false},
+		{"http://a.example/reply", "http://other.example/post/x", false},
+		{"http://blog.example/post/x", "http://blog.example/post/x", false},
+	}
+
+	for _, tt := range tests {
+		form := url.Values{"source": {tt.source}, "target": {tt.target}}
+		r := httptest.NewRequest("POST", "/webmention", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		_, _, err := webmention.ParseRequest(r, "blog.example")
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseRequest(%s, %s): have error %v, need valid %v",
+				tt.source, tt.target, err, tt.valid)
+		}
+	}
+}
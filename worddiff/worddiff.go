@@ -0,0 +1,238 @@
+// Package worddiff finds the differences between two texts word by word,
+// for showing what changed between versions of a post.
+//
+// Texts are split into words, runs of whitespace and single punctuation
+// characters, and compared with Myers' O(ND) algorithm, so that the result is
+// a shortest edit script. Texts which differ by more than MaxEdits tokens are
+// reported as wholly replaced.
+package worddiff
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxEdits is the largest number of inserted and deleted tokens searched for
+// before two texts are treated as entirely different. The search keeps about
+// MaxEdits squared ints to find its way back, so this bounds a diff to
+// around 8MB.
+const MaxEdits = 1000
+
+// Kind says whether a run of text is in both texts or only one of them.
+type Kind int
+
+// Kinds of Op.
+const (
+	Equal Kind = iota
+	Insert
+	Delete
+)
+
+// Op is a run of text which is in both texts, only in the new text
+// (Insert) or only in the old text (Delete).
+type Op struct {
+	Kind Kind
+	Text string
+}
+
+// Split returns the tokens a text is compared by: words, runs of whitespace
+// and single other characters. Joining the tokens gives back the text.
+func Split(s string) []string {
+	var tokens []string
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		n := size
+		if class := classOf(r); class != other {
+			for n < len(s) {
+				r, size := utf8.DecodeRuneInString(s[n:])
+				if classOf(r) != class {
+					break
+				}
+				n += size
+			}
+		}
+		tokens = append(tokens, s[:n])
+		s = s[n:]
+	}
+	return tokens
+}
+
+const (
+	other = iota
+	word
+	space
+)
+
+func classOf(r rune) int {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'':
+		return word
+	case unicode.IsSpace(r):
+		return space
+	}
+	return other
+}
+
+// Words returns the operations which turn the old text into the new one,
+// with adjacent tokens of the same kind joined. Deletions come before
+// insertions where both replace the same text.
+func Words(old, new string) []Op {
+	return join(Tokens(Split(old), Split(new)))
+}
+
+// Tokens returns one operation for each token of the shortest edit script
+// turning the old tokens into the new ones.
+func Tokens(a, b []string) []Op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []Op
+	for _, t := range a[:prefix] {
+		ops = append(ops, Op{Equal, t})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, t := range a[len(a)-suffix:] {
+		ops = append(ops, Op{Equal, t})
+	}
+	return ops
+}
+
+// myers returns the shortest edit script between a and b, or if it needs
+// more than MaxEdits insertions and deletions, the deletion of a and the
+// insertion of b.
+func myers(a, b []string) []Op {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > MaxEdits {
+		max = MaxEdits
+	}
+
+	// v[k] is the furthest x reached on diagonal k = x - y. trace[d] keeps
+	// the diagonals -d-1 to d+1 of v as they were before step d, offset by
+	// d+1, for finding the path back.
+	off := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+
+	var ops []Op
+	for _, t := range a {
+		ops = append(ops, Op{Delete, t})
+	}
+	for _, t := range b {
+		ops = append(ops, Op{Insert, t})
+	}
+	return ops
+}
+
+// backtrack follows the path found by myers back from the end of both
+// texts, returning the operations in order.
+func backtrack(a, b []string, trace [][]int) []Op {
+	var ops []Op
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, Op{Equal, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, Op{Insert, b[y-1]})
+		} else {
+			ops = append(ops, Op{Delete, a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, Op{Equal, a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// join merges adjacent operations of the same kind, and moves deletions
+// before insertions within each run of changes.
+func join(ops []Op) []Op {
+	var joined []Op
+	var del, ins string
+	flush := func() {
+		if del != "" {
+			joined = append(joined, Op{Delete, del})
+		}
+		if ins != "" {
+			joined = append(joined, Op{Insert, ins})
+		}
+		del, ins = "", ""
+	}
+	for _, op := range ops {
+		switch op.Kind {
+		case Delete:
+			del += op.Text
+		case Insert:
+			ins += op.Text
+		default:
+			flush()
+			if n := len(joined); n > 0 && joined[n-1].Kind == Equal {
+				joined[n-1].Text += op.Text
+			} else {
+				joined = append(joined, op)
+			}
+		}
+	}
+	flush()
+	return joined
+}
+
+// Changed returns true if any operation is an insertion or deletion.
+func Changed(ops []Op) bool {
+	for _, op := range ops {
+		if op.Kind != Equal {
+			return true
+		}
+	}
+	return false
+}
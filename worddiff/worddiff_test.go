@@ -0,0 +1,117 @@
+package worddiff_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"goblogengine/worddiff"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		in   string
+		need []string
+	}{
+		{"", nil},
+		{"one", []string{"one"}},
+		{"one two", []string{"one", " ", "two"}},
+		{"don't  stop.", []string{"don't", "  ", "stop", "."}},
+		{"**bold**", []string{"*", "*", "bold", "*", "*"}},
+		{"café\nnaïve", []string{"café", "\n", "naïve"}},
+	}
+	for _, tt := range tests {
+		have := worddiff.Split(tt.in)
+		if !reflect.DeepEqual(have, tt.need) {
+			t.Errorf("Split(%q): have %q, need %q", tt.in, have, tt.need)
+		}
+		if joined := strings.Join(have, ""); joined != tt.in {
+			t.Errorf("Split(%q) joins to %q", tt.in, joined)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	eq := func(s string) worddiff.Op { return worddiff.Op{Kind: worddiff.Equal, Text: s} }
+	ins := func(s string) worddiff.Op { return worddiff.Op{Kind: worddiff.Insert, Text: s} }
+	del := func(s string) worddiff.Op { return worddiff.Op{Kind: worddiff.Delete, Text: s} }
+
+	tests := []struct {
+		old, new string
+		need     []worddiff.Op
+	}{
+		{"", "", nil},
+		{"same text", "same text", []worddiff.Op{eq("same text")}},
+		{"", "new", []worddiff.Op{ins("new")}},
+		{"old", "", []worddiff.Op{del("old")}},
+		{"the quick fox", "the slow fox", []worddiff.Op{eq("the "), del("quick"), ins("slow"), eq(" fox")}},
+		{"a b c", "a c", []worddiff.Op{eq("a "), del("b "), eq("c")}},
+		{"a c", "a b c", []worddiff.Op{eq("a "), ins("b "), eq("c")}},
+		{"one two three", "zero one three four", []worddiff.Op{
+			ins("zero "), eq("one "), del("two "), eq("three"), ins(" four")}},
+	}
+	for _, tt := range tests {
+		have := worddiff.Words(tt.old, tt.new)
+		if !reflect.DeepEqual(have, tt.need) {
+			t.Errorf("Words(%q, %q): have %v, need %v", tt.old, tt.new, have, tt.need)
+		}
+	}
+}
+
+// TestWordsRebuild checks that the old and new texts can be rebuilt from the
+// operations, and that nothing more is changed than the words which differ.
+func TestWordsRebuild(t *testing.T) {
+	old := "It was the best of times, it was the worst of times, it was the age of wisdom."
+	new := "It was the best of days, it was the worst of days; it was an age of wisdom!"
+
+	ops := worddiff.Words(old, new)
+	var rebuiltOld, rebuiltNew string
+	changed := 0
+	for _, op := range ops {
+		if op.Kind != worddiff.Insert {
+			rebuiltOld += op.Text
+		}
+		if op.Kind != worddiff.Delete {
+			rebuiltNew += op.Text
+		}
+		if op.Kind != worddiff.Equal {
+			changed++
+		}
+	}
+	if rebuiltOld != old {
+		t.Errorf("old text rebuilt as %q", rebuiltOld)
+	}
+	if rebuiltNew != new {
+		t.Errorf("new text rebuilt as %q", rebuiltNew)
+	}
+	// times/days, "times,"/"days;", the/an and ./! each as a deletion and
+	// an insertion.
+	if changed != 8 {
+		t.Errorf("%d changed runs, need 8: %v", changed, ops)
+	}
+}
+
+func TestWordsTooManyEdits(t *testing.T) {
+	old := strings.Repeat("a ", worddiff.MaxEdits)
+	new := strings.Repeat("b ", worddiff.MaxEdits)
+
+	ops := worddiff.Words("x "+old, "x "+new)
+	need := []worddiff.Op{
+		{Kind: worddiff.Equal, Text: "x "},
+		{Kind: worddiff.Delete, Text: strings.TrimSuffix(old, " ")},
+		{Kind: worddiff.Insert, Text: strings.TrimSuffix(new, " ")},
+		{Kind: worddiff.Equal, Text: " "},
+	}
+	if !reflect.DeepEqual(ops, need) {
+		t.Errorf("have %d operations, need a whole replacement", len(ops))
+	}
+}
+
+func TestChanged(t *testing.T) {
+	if worddiff.Changed(worddiff.Words("same", "same")) {
+		t.Error("Changed is true for equal texts")
+	}
+	if !worddiff.Changed(worddiff.Words("same", "different")) {
+		t.Error("Changed is false for different texts")
+	}
+}
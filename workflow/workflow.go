@@ -0,0 +1,168 @@
+// Package workflow defines the editorial states a version of a post moves
+// through on its way to being published, which moves between them are
+// allowed, and who is told when a version moves.
+//
+// A version starts as a draft. Its author submits it for review, and a
+// reviewer either requests changes, which the author makes in a new version,
+// or approves it. An approved version is published, either immediately or at
+// a scheduled time. Authors whose role allows them to publish may publish or
+// schedule their own drafts without review.
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// States a post version can be in.
+const (
+	Draft            = "draft"
+	InReview         = "in_review"
+	ChangesRequested = "changes_requested"
+	Approved         = "approved"
+	Scheduled        = "scheduled"
+	Published        = "published"
+)
+
+// States lists every state in the order a version usually moves through
+// them.
+var States = []string{Draft, InReview, ChangesRequested, Approved, Scheduled, Published}
+
+var labels = map[string]string{
+	Draft:            "Draft",
+	InReview:         "In review",
+	ChangesRequested: "Changes requested",
+	Approved:         "Approved",
+	Scheduled:        "Scheduled",
+	Published:        "Published",
+}
+
+var transitions = map[string][]string{
+	Draft:            {InReview, Scheduled, Published},
+	InReview:         {ChangesRequested, Approved, Draft},
+	ChangesRequested: {InReview, Draft},
+	Approved:         {Scheduled, Published, Draft},
+	Scheduled:        {Published, Approved},
+	Published:        {Approved},
+}
+
+// Label returns the name of a state for display.
+func Label(state string) string {
+	if l, ok := labels[state]; ok {
+		return l
+	}
+	return state
+}
+
+// Valid returns true if state is one of the known states.
+func Valid(state string) bool {
+	_, ok := transitions[state]
+	return ok
+}
+
+// Next returns the states a version in the supplied state can move to.
+func Next(from string) []string {
+	return transitions[from]
+}
+
+// CanTransition returns true if a version may move from one state to
+// another.
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError is returned when a version cannot move between two states.
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("workflow: a version which is %s cannot become %s",
+		strings.ToLower(Label(e.From)), strings.ToLower(Label(e.To)))
+}
+
+// Check returns a *TransitionError if a version may not move from one state
+// to another.
+func Check(from, to string) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+	return nil
+}
+
+// Event describes a version moving between states.
+type Event struct {
+	PostID  string
+	Version int
+	Title   string
+	URL     string
+	From    string
+	To      string
+	Comment string
+
+	// ActorName and ActorEmail identify who moved the version.
+	ActorName  string
+	ActorEmail string
+
+	// AuthorEmail is the version's author, and ReviewerEmail the author
+	// asked to review it, if any.
+	AuthorEmail   string
+	ReviewerEmail string
+}
+
+// Recipients returns the email addresses of those who should be told about
+// the event: the reviewer when a version is submitted for review, and the
+// author when it is reviewed, scheduled or published by someone else.
+// Nobody is told about their own actions.
+func (e Event) Recipients() []string {
+	var to string
+	switch e.To {
+	case InReview:
+		to = e.ReviewerEmail
+	case ChangesRequested, Approved, Scheduled, Published:
+		to = e.AuthorEmail
+	}
+	if to == "" || strings.EqualFold(to, e.ActorEmail) {
+		return nil
+	}
+	return []string{to}
+}
+
+// Notifier tells people about events.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Multi combines several notifiers. Every notifier is told about each event,
+// and the first error encountered is returned.
+type Multi []Notifier
+
+// Notify passes the event on to every notifier.
+func (m Multi) Notify(ctx context.Context, e Event) error {
+	var first error
+	for _, n := range m {
+		if err := n.Notify(ctx, e); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// MemNotifier records events in memory, for use where no notification
+// service is available.
+type MemNotifier struct {
+	Events []Event
+}
+
+// Notify appends the event to those recorded.
+func (n *MemNotifier) Notify(ctx context.Context, e Event) error {
+	n.Events = append(n.Events, e)
+	return nil
+}
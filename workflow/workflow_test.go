@@ -0,0 +1,120 @@
+package workflow_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"goblogengine/workflow"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		need     bool
+	}{
+		{workflow.Draft, workflow.InReview, true},
+		{workflow.Draft, workflow.Published, true},
+		{workflow.Draft, workflow.Approved, false},
+		{workflow.InReview, workflow.Approved, true},
+		{workflow.InReview, workflow.ChangesRequested, true},
+		{workflow.InReview, workflow.Published, false},
+		{workflow.ChangesRequested, workflow.InReview, true},
+		{workflow.ChangesRequested, workflow.Published, false},
+		{workflow.Approved, workflow.Scheduled, true},
+		{workflow.Scheduled, workflow.Published, true},
+		{workflow.Published, workflow.Approved, true},
+		{workflow.Published, workflow.Draft, false},
+		{"unknown", workflow.Draft, false},
+	}
+	for _, tt := range tests {
+		if have := workflow.CanTransition(tt.from, tt.to); have != tt.need {
+			t.Errorf("CanTransition(%s, %s): have %v, need %v", tt.from, tt.to, have, tt.need)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := workflow.Check(workflow.Approved, workflow.Published); err != nil {
+		t.Errorf("Check refused a valid transition: %v", err)
+	}
+
+	err := workflow.Check(workflow.InReview, workflow.Published)
+	var te *workflow.TransitionError
+	if !errors.As(err, &te) {
+		t.Fatalf("Check returned %v, need a *TransitionError", err)
+	}
+	if te.From != workflow.InReview || te.To != workflow.Published {
+		t.Errorf("TransitionError has From %s To %s", te.From, te.To)
+	}
+	need := "workflow: a version which is in review cannot become published"
+	if err.Error() != need {
+		t.Errorf("Have message %q, need %q", err.Error(), need)
+	}
+}
+
+func TestStatesHaveTransitions(t *testing.T) {
+	for _, s := range workflow.States {
+		if !workflow.Valid(s) {
+			t.Errorf("State %s is not valid", s)
+		}
+		if len(workflow.Next(s)) == 0 {
+			t.Errorf("State %s has no transitions", s)
+		}
+		for _, next := range workflow.Next(s) {
+			if !workflow.Valid(next) {
+				t.Errorf("State %s moves to unknown state %s", s, next)
+			}
+		}
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	base := workflow.Event{
+		ActorEmail:    "editor@example.com",
+		AuthorEmail:   "author@example.com",
+		ReviewerEmail: "reviewer@example.com",
+	}
+	tests := []struct {
+		name  string
+		to    string
+		actor string
+		need  []string
+	}{
+		{"submitted", workflow.InReview, "author@example.com", []string{"reviewer@example.com"}},
+		{"approved", workflow.Approved, "editor@example.com", []string{"author@example.com"}},
+		{"changes", workflow.ChangesRequested, "editor@example.com", []string{"author@example.com"}},
+		{"own publish", workflow.Published, "Author@Example.com", nil},
+		{"withdrawn", workflow.Draft, "author@example.com", nil},
+	}
+	for _, tt := range tests {
+		e := base
+		e.To = tt.to
+		e.ActorEmail = tt.actor
+		if have := e.Recipients(); !reflect.DeepEqual(have, tt.need) {
+			t.Errorf("%s: have recipients %v, need %v", tt.name, have, tt.need)
+		}
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, e workflow.Event) error {
+	return errors.New("failed")
+}
+
+func TestMulti(t *testing.T) {
+	a, b := new(workflow.MemNotifier), new(workflow.MemNotifier)
+	m := workflow.Multi{a, failingNotifier{}, b}
+
+	e := workflow.Event{PostID: "p", Version: 2, To: workflow.Approved}
+	if err := m.Notify(context.Background(), e); err == nil {
+		t.Error("Multi did not return the failure")
+	}
+	for _, n := range []*workflow.MemNotifier{a, b} {
+		if len(n.Events) != 1 || n.Events[0] != e {
+			t.Errorf("Notifier was given %v, need %v", n.Events, e)
+		}
+	}
+}
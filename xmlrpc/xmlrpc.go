@@ -0,0 +1,298 @@
+// Package xmlrpc decodes XML-RPC method calls and encodes responses. It
+// supports the subset of the specification used by blog editing clients.
+//
+// See http://xmlrpc.com/spec.md.
+package xmlrpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date formats accepted for dateTime.iso8601 values. The first is used when
+// encoding.
+var dateFormats = []string{
+	"20060102T15:04:05",
+	"20060102T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+}
+
+// Fault represents an XML-RPC fault response.
+type Fault struct {
+	Code   int
+	String string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("xmlrpc: fault %d: %s", f.Code, f.String)
+}
+
+// Faultf returns a Fault with a formatted message.
+func Faultf(code int, format string, a ...interface{}) *Fault {
+	return &Fault{Code: code, String: fmt.Sprintf(format, a...)}
+}
+
+// MethodCall represents a decoded XML-RPC request. Parameters are decoded to
+// string, int, bool, float64, time.Time, []byte, []interface{} or
+// map[string]interface{}.
+type MethodCall struct {
+	MethodName string
+	Params     []interface{}
+}
+
+type xMethodCall struct {
+	MethodName string   `xml:"methodName"`
+	Params     []xValue `xml:"params>param>value"`
+}
+
+type xValue struct {
+	String   *string  `xml:"string"`
+	Int      *string  `xml:"int"`
+	I4       *string  `xml:"i4"`
+	Boolean  *string  `xml:"boolean"`
+	Double   *string  `xml:"double"`
+	DateTime *string  `xml:"dateTime.iso8601"`
+	Base64   *string  `xml:"base64"`
+	Struct   *xStruct `xml:"struct"`
+	Array    *xArray  `xml:"array"`
+	Text     string   `xml:",chardata"`
+}
+
+type xStruct struct {
+	Members []xMember `xml:"member"`
+}
+
+type xMember struct {
+	Name  string `xml:"name"`
+	Value xValue `xml:"value"`
+}
+
+type xArray struct {
+	Values []xValue `xml:"data>value"`
+}
+
+// ParseMethodCall decodes an XML-RPC method call.
+func ParseMethodCall(r io.Reader) (*MethodCall, error) {
+	var x xMethodCall
+	if err := xml.NewDecoder(r).Decode(&x); err != nil {
+		return nil, fmt.Errorf("xmlrpc: invalid method call: %v", err)
+	}
+	if x.MethodName == "" {
+		return nil, fmt.Errorf("xmlrpc: missing method name")
+	}
+
+	c := &MethodCall{MethodName: x.MethodName}
+	for i := range x.Params {
+		v, err := x.Params[i].decode()
+		if err != nil {
+			return nil, fmt.Errorf("xmlrpc: param %d: %v", i, err)
+		}
+		c.Params = append(c.Params, v)
+	}
+	return c, nil
+}
+
+func (x *xValue) decode() (interface{}, error) {
+	switch {
+	case x.String != nil:
+		return *x.String, nil
+	case x.Int != nil:
+		return strconv.Atoi(strings.TrimSpace(*x.Int))
+	case x.I4 != nil:
+		return strconv.Atoi(strings.TrimSpace(*x.I4))
+	case x.Boolean != nil:
+		return strings.TrimSpace(*x.Boolean) == "1", nil
+	case x.Double != nil:
+		return strconv.ParseFloat(strings.TrimSpace(*x.Double), 64)
+	case x.DateTime != nil:
+		return parseDate(strings.TrimSpace(*x.DateTime))
+	case x.Base64 != nil:
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(*x.Base64))
+	case x.Struct != nil:
+		m := make(map[string]interface{})
+		for i := range x.Struct.Members {
+			v, err := x.Struct.Members[i].Value.decode()
+			if err != nil {
+				return nil, err
+			}
+			m[x.Struct.Members[i].Name] = v
+		}
+		return m, nil
+	case x.Array != nil:
+		a := []interface{}{}
+		for i := range x.Array.Values {
+			v, err := x.Array.Values[i].decode()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	}
+	// A value with no type is a string
+	return x.Text, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, f := range dateFormats {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %s", s)
+}
+
+// String returns parameter i as a string. Integers are converted, since some
+// clients send IDs as either type.
+func (c *MethodCall) String(i int) (string, error) {
+	if i >= len(c.Params) {
+		return "", Faultf(400, "missing parameter %d", i)
+	}
+	switch v := c.Params[i].(type) {
+	case string:
+		return v, nil
+	case int:
+		return strconv.Itoa(v), nil
+	}
+	return "", Faultf(400, "parameter %d must be a string", i)
+}
+
+// Int returns parameter i as an int.
+func (c *MethodCall) Int(i int) (int, error) {
+	if i >= len(c.Params) {
+		return 0, Faultf(400, "missing parameter %d", i)
+	}
+	v, ok := c.Params[i].(int)
+	if !ok {
+		return 0, Faultf(400, "parameter %d must be an int", i)
+	}
+	return v, nil
+}
+
+// Bool returns parameter i as a bool. A missing parameter is false.
+func (c *MethodCall) Bool(i int) (bool, error) {
+	if i >= len(c.Params) {
+		return false, nil
+	}
+	v, ok := c.Params[i].(bool)
+	if !ok {
+		return false, Faultf(400, "parameter %d must be a boolean", i)
+	}
+	return v, nil
+}
+
+// Struct returns parameter i as a struct.
+func (c *MethodCall) Struct(i int) (map[string]interface{}, error) {
+	if i >= len(c.Params) {
+		return nil, Faultf(400, "missing parameter %d", i)
+	}
+	v, ok := c.Params[i].(map[string]interface{})
+	if !ok {
+		return nil, Faultf(400, "parameter %d must be a struct", i)
+	}
+	return v, nil
+}
+
+// MarshalResponse encodes v as the single parameter of a method response.
+func MarshalResponse(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<methodResponse><params><param>")
+	if err := writeValue(&buf, v); err != nil {
+		return nil, err
+	}
+	buf.WriteString("</param></params></methodResponse>")
+	return buf.Bytes(), nil
+}
+
+// MarshalFault encodes a fault response.
+func MarshalFault(f *Fault) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<methodResponse><fault>")
+	writeValue(&buf, map[string]interface{}{
+		"faultCode":   f.Code,
+		"faultString": f.String,
+	})
+	buf.WriteString("</fault></methodResponse>")
+	return buf.Bytes()
+}
+
+func writeValue(buf *bytes.Buffer, v interface{}) error {
+	buf.WriteString("<value>")
+	switch val := v.(type) {
+	case string:
+		buf.WriteString("<string>")
+		xml.EscapeText(buf, []byte(val))
+		buf.WriteString("</string>")
+	case int:
+		fmt.Fprintf(buf, "<int>%d</int>", val)
+	case bool:
+		if val {
+			buf.WriteString("<boolean>1</boolean>")
+		} else {
+			buf.WriteString("<boolean>0</boolean>")
+		}
+	case float64:
+		fmt.Fprintf(buf, "<double>%s</double>",
+			strconv.FormatFloat(val, 'f', -1, 64))
+	case time.Time:
+		fmt.Fprintf(buf, "<dateTime.iso8601>%s</dateTime.iso8601>",
+			val.UTC().Format(dateFormats[0]))
+	case []byte:
+		fmt.Fprintf(buf, "<base64>%s</base64>",
+			base64.StdEncoding.EncodeToString(val))
+	case []string:
+		buf.WriteString("<array><data>")
+		for i := range val {
+			writeValue(buf, val[i])
+		}
+		buf.WriteString("</data></array>")
+	case []interface{}:
+		buf.WriteString("<array><data>")
+		for i := range val {
+			if err := writeValue(buf, val[i]); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</data></array>")
+	case []map[string]interface{}:
+		buf.WriteString("<array><data>")
+		for i := range val {
+			if err := writeValue(buf, val[i]); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</data></array>")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("<struct>")
+		for _, k := range keys {
+			buf.WriteString("<member><name>")
+			xml.EscapeText(buf, []byte(k))
+			buf.WriteString("</name>")
+			if err := writeValue(buf, val[k]); err != nil {
+				return err
+			}
+			buf.WriteString("</member>")
+		}
+		buf.WriteString("</struct>")
+	default:
+		return fmt.Errorf("xmlrpc: unsupported type %T", v)
+	}
+	buf.WriteString("</value>")
+	return nil
+}
@@ -0,0 +1,158 @@
+package xmlrpc_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"goblogengine/xmlrpc"
+)
+
+// readCall parses a recorded method call from the testdata directory.
+func readCall(t *testing.T, name string) *xmlrpc.MethodCall {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c, err := xmlrpc.ParseMethodCall(f)
+	if err != nil {
+		t.Fatalf("Unable to parse recorded call %s: %s", name, err)
+	}
+	return c
+}
+
+func TestParseNewPost(t *testing.T) {
+	c := readCall(t, "newpost.xml")
+
+	if c.MethodName != "metaWeblog.newPost" {
+		t.Errorf("Have method %s, need metaWeblog.newPost", c.MethodName)
+	}
+	// An untyped value is a string
+	if have, _ := c.String(1); have != "author@example.com" {
+		t.Errorf("Have username %s, need author@example.com", have)
+	}
+	if have, _ := c.String(2); have != "secret" {
+		t.Errorf("Have password %s, need secret", have)
+	}
+	if have, _ := c.Bool(4); !have {
+		t.Error("Have publish false, need true")
+	}
+
+	s, err := c.Struct(3)
+	if err != nil {
+		t.Fatalf("Struct failed: %s", err)
+	}
+	if have := s["title"]; have != "Hello & welcome" {
+		t.Errorf("Have title %v, need Hello & welcome", have)
+	}
+	need := time.Date(2017, 1, 2, 15, 4, 5, 0, time.UTC)
+	if have, _ := s["dateCreated"].(time.Time); !have.Equal(need) {
+		t.Errorf("Have dateCreated %v, need %v", have, need)
+	}
+	cats := []interface{}{"foo", "bar baz"}
+	if have := s["categories"]; !reflect.DeepEqual(have, cats) {
+		t.Errorf("Have categories %v, need %v", have, cats)
+	}
+}
+
+func TestParseNewMediaObject(t *testing.T) {
+	c := readCall(t, "newmediaobject.xml")
+
+	s, err := c.Struct(3)
+	if err != nil {
+		t.Fatalf("Struct failed: %s", err)
+	}
+	if have, _ := s["bits"].([]byte); string(have) != "hello" {
+		t.Errorf("Have bits %q, need hello", have)
+	}
+}
+
+func TestParamTypes(t *testing.T) {
+	c := readCall(t, "getrecentposts.xml")
+
+	// Integer IDs are accepted as strings
+	if have, err := c.String(0); err != nil || have != "1" {
+		t.Errorf("Have blogid %s (%v), need 1", have, err)
+	}
+	if have, err := c.Int(3); err != nil || have != 10 {
+		t.Errorf("Have numberOfPosts %d (%v), need 10", have, err)
+	}
+	if _, err := c.Struct(3); err == nil {
+		t.Error("Struct of an int succeeded, need fault")
+	}
+	if _, err := c.String(4); err == nil {
+		t.Error("String of a missing param succeeded, need fault")
+	}
+	if have, err := c.Bool(4); err != nil || have {
+		t.Errorf("Have missing bool %v (%v), need false", have, err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, body := range []string{
+		"not xml",
+		"<methodCall><params/></methodCall>",
+		"<methodCall><methodName>x</methodName><params><param><value><int>x</int></value></param></params></methodCall>",
+	} {
+		if _, err := xmlrpc.ParseMethodCall(strings.NewReader(body)); err == nil {
+			t.Errorf("ParseMethodCall(%q) succeeded, need error", body)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	date := time.Date(2017, 1, 2, 15, 4, 5, 0, time.UTC)
+	v := map[string]interface{}{
+		"title":       "<Hello>",
+		"count":       3,
+		"published":   true,
+		"dateCreated": date,
+		"bits":        []byte("hello"),
+		"categories":  []interface{}{"foo", "bar"},
+	}
+
+	b, err := xmlrpc.MarshalResponse(v)
+	if err != nil {
+		t.Fatalf("MarshalResponse failed: %s", err)
+	}
+
+	// Wrap the response parameters in a method call so they can be parsed
+	body := strings.Replace(string(b), "<methodResponse>",
+		"<methodCall><methodName>x</methodName>", 1)
+	body = strings.Replace(body, "</methodResponse>", "</methodCall>", 1)
+
+	c, err := xmlrpc.ParseMethodCall(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseMethodCall failed: %s\n%s", err, body)
+	}
+	have, err := c.Struct(0)
+	if err != nil {
+		t.Fatalf("Struct failed: %s", err)
+	}
+	if !reflect.DeepEqual(have, v) {
+		t.Errorf("Have %v, need %v", have, v)
+	}
+}
+
+func TestMarshalFault(t *testing.T) {
+	b := xmlrpc.MarshalFault(xmlrpc.Faultf(403, "bad %s", "password"))
+
+	need := "<methodResponse><fault><value><struct>" +
+		"<member><name>faultCode</name><value><int>403</int></value></member>" +
+		"<member><name>faultString</name><value><string>bad password</string></value></member>" +
+		"</struct></value></fault></methodResponse>"
+	if !strings.HasSuffix(string(b), need) {
+		t.Errorf("Have %s, need %s", b, need)
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	if _, err := xmlrpc.MarshalResponse(struct{}{}); err == nil {
+		t.Error("MarshalResponse of a struct succeeded, need error")
+	}
+}